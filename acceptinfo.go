@@ -0,0 +1,83 @@
+package conn
+
+import (
+	"context"
+	"time"
+
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	peer "github.com/libp2p/go-libp2p-peer"
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// AcceptInfo is an accepted conn, along with how it was set up.
+type AcceptInfo struct {
+	Conn transport.Conn
+
+	// Accepted is when the transport listener accepted the raw conn, and
+	// HandshakeDuration how long it took from there to complete the
+	// handshake.
+	Accepted          time.Time
+	HandshakeDuration time.Duration
+
+	// Security and Preamble are what the conn negotiated, see Negotiated.
+	Security string
+	Preamble string
+
+	// Protector is the one of the listener's protectors the remote is in
+	// the private network of, or nil outside of private networks.
+	Protector ipnet.Protector
+
+	// ClaimedPeer is the identity the remote presented, and VerifiedPeer
+	// the same once authenticated, by secio or a trusted transport.
+	// Over PlaintextIdentityTag, the remote proves nothing, and only
+	// ClaimedPeer is set. Over NoEncryptionTag, neither is.
+	ClaimedPeer  peer.ID
+	VerifiedPeer peer.ID
+}
+
+// ListenerAcceptInfo is implemented by listeners that can tell how the
+// conns they return were set up.
+type ListenerAcceptInfo interface {
+	// AcceptInfo is like AcceptContext, returning the setup of the conn
+	// along with it.
+	AcceptInfo(ctx context.Context) (AcceptInfo, error)
+}
+
+func (l *listener) AcceptInfo(ctx context.Context) (AcceptInfo, error) {
+	c, err := l.AcceptContext(ctx)
+	if err != nil {
+		return AcceptInfo{}, err
+	}
+	return acceptInfoOf(c), nil
+}
+
+// acceptInfoOf returns the setup of an accepted conn.
+func acceptInfoOf(c transport.Conn) AcceptInfo {
+	info := AcceptInfo{Conn: c}
+
+	var single *singleConn
+	switch c := c.(type) {
+	case *secureConn:
+		single, _ = c.insecure.(*singleConn)
+		info.ClaimedPeer = c.RemotePeer()
+		info.VerifiedPeer = c.RemotePeer()
+	case *singleConn:
+		single = c
+		info.ClaimedPeer = c.remote
+		if c.trusted {
+			info.VerifiedPeer = c.remote
+		}
+	}
+	if single != nil {
+		info.Accepted = single.accepted
+		info.Protector = single.protector
+	}
+	if n, ok := c.(Negotiated); ok {
+		info.Security = n.NegotiatedSecurity()
+		info.Preamble = n.NegotiatedPreamble()
+	}
+	if h, ok := c.(HandshakeInfo); ok && !info.Accepted.IsZero() {
+		info.HandshakeDuration = h.HandshakeResult().Completed.Sub(info.Accepted)
+	}
+	return info
+}
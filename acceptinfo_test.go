@@ -0,0 +1,58 @@
+package conn
+
+import (
+	"context"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestAcceptInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, proto := range []string{SecioTag, PlaintextIdentityTag} {
+		p1 := tu.RandPeerNetParamsOrFatal(t)
+		p2 := tu.RandPeerNetParamsOrFatal(t)
+
+		l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l1.(ListenerSecurityProtocols).SetSecurityProtocols([]string{proto}); err != nil {
+			t.Fatal(err)
+		}
+
+		d := NewDialer(p2.ID, p2.PrivKey, nil)
+		d.SecurityProtocols = []string{proto}
+		c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := l1.(ListenerAcceptInfo).AcceptInfo(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Security != proto || info.Preamble != PreambleMultistream || info.Protector != nil {
+			t.Fatalf("%s: unexpected negotiation %+v", proto, info)
+		}
+		if info.Accepted.IsZero() || info.HandshakeDuration <= 0 {
+			t.Fatalf("%s: unexpected timings %+v", proto, info)
+		}
+		if info.ClaimedPeer != p2.ID {
+			t.Fatalf("%s: expected %s to be claimed, got %s", proto, p2.ID, info.ClaimedPeer)
+		}
+		verified := p2.ID
+		if proto == PlaintextIdentityTag {
+			verified = ""
+		}
+		if info.VerifiedPeer != verified {
+			t.Fatalf("%s: expected %q to be verified, got %q", proto, verified, info.VerifiedPeer)
+		}
+
+		info.Conn.Close()
+		c.Close()
+		l1.Close()
+	}
+}
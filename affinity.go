@@ -0,0 +1,39 @@
+package conn
+
+import (
+	"context"
+)
+
+type affinityKey struct{}
+
+// WithAffinity tags the dials made with ctx with an affinity key, such as
+// the backend shard the conn is meant for, so that the layers above can
+// route requests to the conns of the right shard. The key shows in the
+// connDial and connLifetime events, and on the conns: see AffinityInfo.
+func WithAffinity(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityKey{}, key)
+}
+
+func affinityFrom(ctx context.Context) string {
+	key, _ := ctx.Value(affinityKey{}).(string)
+	return key
+}
+
+// AffinityInfo is implemented by the conns returned by Dial and Accept.
+// Accepted conns have no affinity key.
+type AffinityInfo interface {
+	// Affinity returns the affinity key the conn was dialed with, if
+	// any. See WithAffinity.
+	Affinity() string
+}
+
+func (c *singleConn) Affinity() string {
+	return c.affinity
+}
+
+func (c *secureConn) Affinity() string {
+	if ai, ok := c.insecure.(AffinityInfo); ok {
+		return ai.Affinity()
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package conn
+
+import (
+	"context"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestDialAffinity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	accepted := make(chan string, 1)
+	go func() {
+		c, err := l1.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		accepted <- c.(AffinityInfo).Affinity()
+	}()
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	c, err := d.Dial(WithAffinity(ctx, "shard-3"), l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if key := c.(AffinityInfo).Affinity(); key != "shard-3" {
+		t.Fatalf("dialed conn has affinity %q", key)
+	}
+	if key := <-accepted; key != "" {
+		t.Fatalf("accepted conn has affinity %q", key)
+	}
+}
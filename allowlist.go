@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ErrPeerNotAllowed is matched by the errors of inbound conns closed
+// because their remote isn't in the listener's PeerAllowlist.
+var ErrPeerNotAllowed = errors.New("peer not allowed")
+
+// PeerAllowlist is a set of peer IDs. It is safe for concurrent use, and
+// may change while listeners use it.
+type PeerAllowlist struct {
+	mu    sync.RWMutex
+	peers map[peer.ID]struct{}
+}
+
+// NewPeerAllowlist returns an allowlist of the given peers.
+func NewPeerAllowlist(peers ...peer.ID) *PeerAllowlist {
+	a := &PeerAllowlist{}
+	for _, p := range peers {
+		a.Add(p)
+	}
+	return a
+}
+
+// Add adds p to the allowlist.
+func (a *PeerAllowlist) Add(p peer.ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.peers == nil {
+		a.peers = make(map[peer.ID]struct{})
+	}
+	a.peers[p] = struct{}{}
+}
+
+// Remove removes p from the allowlist. Conns from p already accepted
+// stay open.
+func (a *PeerAllowlist) Remove(p peer.ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.peers, p)
+}
+
+// Allowed tells whether p is in the allowlist.
+func (a *PeerAllowlist) Allowed(p peer.ID) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.peers[p]
+	return ok
+}
+
+// ListenerPeerAllowlist is implemented by listeners that can accept conns
+// from some peers only.
+type ListenerPeerAllowlist interface {
+	// SetPeerAllowlist restricts the listener to conns from the peers of
+	// a. Conns proving any other identity, or none, like the ones secured
+	// with NoEncryptionTag, are closed once their handshake is done,
+	// before anything else is exchanged, and fail with an error matching
+	// ErrPeerNotAllowed. A nil a lifts the restriction. It must be called
+	// before any call to Accept.
+	SetPeerAllowlist(a *PeerAllowlist)
+
+	// PeersRejected returns how many conns were closed for their remote
+	// not being allowed.
+	PeersRejected() uint64
+}
+
+func (l *listener) SetPeerAllowlist(a *PeerAllowlist) {
+	l.allowlist = a
+}
+
+func (l *listener) PeersRejected() uint64 {
+	return atomic.LoadUint64(&l.peersRejected)
+}
+
+// checkPeerAllowed fails if the listener doesn't take conns from remote,
+// "" if the conn proved no identity.
+func (l *listener) checkPeerAllowed(remote peer.ID) error {
+	if l.allowlist == nil || (remote != "" && l.allowlist.Allowed(remote)) {
+		return nil
+	}
+	atomic.AddUint64(&l.peersRejected, 1)
+	if remote == "" {
+		return &Error{Kind: ErrPeerNotAllowed, Err: errors.New("remote proved no identity")}
+	}
+	return &Error{Kind: ErrPeerNotAllowed, Err: fmt.Errorf("%s isn't in the allowlist", remote)}
+}
@@ -0,0 +1,72 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestPeerAllowlist(t *testing.T) {
+	a := NewPeerAllowlist("a", "b")
+	a.Remove("b")
+	if !a.Allowed("a") || a.Allowed("b") || a.Allowed("c") {
+		t.Fatal("unexpected allowlist")
+	}
+
+	l := &listener{allowlist: a}
+	if err := l.checkPeerAllowed("a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []peer.ID{"b", ""} {
+		if err := l.checkPeerAllowed(p); !errors.Is(err, ErrPeerNotAllowed) {
+			t.Fatalf("%q shouldn't be allowed, got %v", p, err)
+		}
+	}
+	if n := l.PeersRejected(); n != 2 {
+		t.Fatalf("expected 2 rejected conns, got %d", n)
+	}
+}
+
+func TestListenerPeerAllowlist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	p3 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	l1.(ListenerPeerAllowlist).SetPeerAllowlist(NewPeerAllowlist(p2.ID))
+	go echoListen(ctx, l1)
+
+	d2 := NewDialer(p2.ID, p2.PrivKey, nil)
+	d2.AddDialer(dialer(t, p2.Addr))
+	c, err := d2.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	d3 := NewDialer(p3.ID, p3.PrivKey, nil)
+	d3.AddDialer(dialer(t, p3.Addr))
+	c, err = d3.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err == nil {
+		// the listener closes the conn once the handshake is done.
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err == nil {
+			t.Fatal("conns from peers not allowed should be closed")
+		}
+		c.Close()
+	}
+	if n := l1.(ListenerPeerAllowlist).PeersRejected(); n != 1 {
+		t.Fatalf("expected 1 rejected conn, got %d", n)
+	}
+}
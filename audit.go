@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"errors"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AuditRecord describes a failed upgrade of a conn, inbound or outbound,
+// for security teams to investigate probing.
+type AuditRecord struct {
+	Time time.Time
+	// Inbound is set for accepted conns, unset for dialed ones.
+	Inbound bool
+	// Addr is the remote address of the conn.
+	Addr ma.Multiaddr
+	// Expected is the peer dialed, if known.
+	Expected peer.ID
+	// Purpose is the label of the dial, if any. See WithPurpose.
+	Purpose string
+	// Claimed is the peer the remote proved to be, if it got that far.
+	Claimed peer.ID
+	// Stage is how far the upgrade got.
+	Stage Stage
+	// Class is the kind of failure, see ErrorClass.
+	Class string
+}
+
+// AuditSink receives an AuditRecord for every failed upgrade. Audit is
+// called from the goroutine of the dial or handshake, maybe concurrently,
+// and must not block.
+type AuditSink interface {
+	Audit(r AuditRecord)
+}
+
+// ListenerAudit is implemented by listeners that can audit the inbound
+// conns that fail to upgrade.
+type ListenerAudit interface {
+	// SetAuditSink makes the listener send an AuditRecord to s for every
+	// inbound conn that fails to upgrade. It must be called before any
+	// call to Accept.
+	SetAuditSink(s AuditSink)
+}
+
+func (l *listener) SetAuditSink(s AuditSink) {
+	l.audit = s
+}
+
+// ErrorClass classifies an error from Dial or a handshake, for
+// AuditRecord.Class: "timeout", "negotiation", "pnet-mismatch", "garbage-"
+// followed by the GarbageClass, "peer-mismatch", "key-mismatch",
+// "untrusted" or, for anything else, "other".
+func ErrorClass(err error) string {
+	var ge *garbageError
+	switch {
+	case errors.Is(err, ErrHandshakeTimeout):
+		return "timeout"
+	case errors.As(err, &ge):
+		return "garbage-" + ge.class.String()
+	case errors.Is(err, ErrPNetFingerprintMismatch):
+		return "pnet-mismatch"
+	case errors.Is(err, ErrProtocolNegotiationFailed):
+		// including private network key mismatches.
+		return "negotiation"
+	case errors.Is(err, ErrPeerIDMismatch):
+		return "peer-mismatch"
+	case errors.Is(err, ErrPublicKeyMismatch):
+		return "key-mismatch"
+	case errors.Is(err, ErrUntrustedConn):
+		return "untrusted"
+	default:
+		return "other"
+	}
+}
+
+// auditRecord returns the record of an upgrade that failed with err.
+func auditRecord(inbound bool, raddr ma.Multiaddr, expected peer.ID, stage Stage, err error) AuditRecord {
+	r := AuditRecord{
+		Time:     time.Now(),
+		Inbound:  inbound,
+		Addr:     raddr,
+		Expected: expected,
+		Stage:    stage,
+		Class:    ErrorClass(err),
+	}
+	var merr *MisdialError
+	if errors.As(err, &merr) && merr.Err == nil {
+		r.Claimed = merr.Actual
+	}
+	return r
+}
@@ -0,0 +1,45 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		err   error
+		class string
+	}{
+		{&Error{Kind: ErrHandshakeTimeout, Err: errors.New("i/o timeout")}, "timeout"},
+		{&StageTimeoutError{Stage: StageSelect, Err: errors.New("i/o timeout")}, "timeout"},
+		{&Error{Kind: ErrProtocolNegotiationFailed, Err: &garbageError{class: GarbageHTTP}}, "garbage-http"},
+		{&Error{Kind: ErrProtocolNegotiationFailed, Err: errors.New("protocol not supported")}, "negotiation"},
+		{&Error{Kind: ErrPNetFingerprintMismatch}, "pnet-mismatch"},
+		{&MisdialError{Expected: "a", Actual: "b"}, "peer-mismatch"},
+		{fmt.Errorf("upgrading: %w", ErrUntrustedConn), "untrusted"},
+		{errors.New("connection reset by peer"), "other"},
+	}
+	for _, c := range cases {
+		if class := ErrorClass(c.err); class != c.class {
+			t.Errorf("ErrorClass(%v) = %s, expected %s", c.err, class, c.class)
+		}
+	}
+}
+
+func TestAuditRecordClaimed(t *testing.T) {
+	expected, claimed := peer.ID("expected"), peer.ID("claimed")
+
+	r := auditRecord(false, nil, expected, StageSecure, &MisdialError{Expected: expected, Actual: claimed})
+	if r.Claimed != claimed || r.Expected != expected || r.Class != "peer-mismatch" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	// a dial failing where another peer answered before claims nothing.
+	r = auditRecord(false, nil, expected, StageProtect, &MisdialError{Expected: expected, Actual: claimed, Err: errors.New("refused")})
+	if r.Claimed != "" {
+		t.Fatalf("unexpected claimed peer %s", r.Claimed)
+	}
+}
@@ -0,0 +1,128 @@
+package conn
+
+import (
+	"fmt"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// AcceptBacklog is how many connections that completed their handshake a
+// listener holds until Accept picks them up. What happens to connections
+// beyond that is decided by AcceptOverflow. Listeners pick up both values
+// when they are created.
+var AcceptBacklog = 32
+
+// AcceptOverflow is what listeners do with new connections when their
+// backlog is full.
+var AcceptOverflow = OverflowBlock
+
+// OverflowPolicy tells a listener what to do with a connection that
+// completed its handshake while the accept backlog is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock holds the connection, and its handshake goroutine,
+	// until there is room in the backlog.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest closes the new connection.
+	OverflowDropNewest
+	// OverflowDropOldest closes the connection that has been waiting
+	// the longest, and queues the new one instead.
+	OverflowDropOldest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// MarshalText encodes p as its name, for config files.
+func (p OverflowPolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+		return []byte(p.String()), nil
+	}
+	return nil, fmt.Errorf("invalid overflow policy %d", int(p))
+}
+
+// UnmarshalText decodes a policy name, as returned by String.
+func (p *OverflowPolicy) UnmarshalText(text []byte) error {
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropNewest, OverflowDropOldest} {
+		if string(text) == policy.String() {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid overflow policy %q", text)
+}
+
+// enqueue queues c for Accept, according to the listener's overflow
+// policy. c is closed if it doesn't make it.
+func (l *listener) enqueue(c transport.Conn) {
+	switch {
+	case l.overflow == OverflowBlock:
+		select {
+		case <-l.proc.Closing():
+			c.Close()
+		case l.incoming <- connErr{conn: c}:
+		}
+		return
+
+	// there is nothing to drop from an unbuffered backlog.
+	case l.overflow == OverflowDropOldest && cap(l.incoming) > 0:
+		for {
+			select {
+			case <-l.proc.Closing():
+				c.Close()
+				return
+			case l.incoming <- connErr{conn: c}:
+				return
+			default:
+			}
+
+			select {
+			case old := <-l.incoming:
+				l.dropped(old.conn)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case <-l.proc.Closing():
+			c.Close()
+		case l.incoming <- connErr{conn: c}:
+		default:
+			l.dropped(c)
+		}
+	}
+}
+
+func (l *listener) dropped(c transport.Conn) {
+	if c == nil {
+		return
+	}
+	log.Event(l.ctx, "connAcceptOverflow", l, backlogLoggable{c, l.overflow})
+	l.history.addConn("acceptDropped", connIDOf(c), remotePeer(c), c.RemoteMultiaddr(), nil)
+	c.Close()
+}
+
+type backlogLoggable struct {
+	conn   transport.Conn
+	policy OverflowPolicy
+}
+
+func (b backlogLoggable) Loggable() map[string]interface{} {
+	return map[string]interface{}{
+		"remoteAddr": b.conn.RemoteMultiaddr(),
+		"policy":     b.policy.String(),
+	}
+}
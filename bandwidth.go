@@ -0,0 +1,44 @@
+package conn
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// BandwidthReporter is told about the traffic of every conn it is set on,
+// so that hosts can account for it per peer. It is called synchronously
+// on every read and write, and must be safe for concurrent use.
+//
+// Secure conns report the application bytes they carry; the secio
+// handshake and framing overhead is not accounted for.
+type BandwidthReporter interface {
+	LogSentBytes(n int64, p peer.ID)
+	LogRecvBytes(n int64, p peer.ID)
+}
+
+func (c *singleConn) reportSent(n int) {
+	c.stats.sent(n)
+	if c.reporter != nil && n > 0 {
+		c.reporter.LogSentBytes(int64(n), c.remote)
+	}
+}
+
+func (c *singleConn) reportRecv(n int) {
+	c.stats.recv(n)
+	if c.reporter != nil && n > 0 {
+		c.reporter.LogRecvBytes(int64(n), c.remote)
+	}
+}
+
+func (c *secureConn) reportSent(n int) {
+	c.stats.sent(n)
+	if c.reporter != nil && n > 0 {
+		c.reporter.LogSentBytes(int64(n), c.RemotePeer())
+	}
+}
+
+func (c *secureConn) reportRecv(n int) {
+	c.stats.recv(n)
+	if c.reporter != nil && n > 0 {
+		c.reporter.LogRecvBytes(int64(n), c.RemotePeer())
+	}
+}
@@ -0,0 +1,81 @@
+package conn
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	tu "github.com/libp2p/go-testutil"
+)
+
+type countingReporter struct {
+	mu         sync.Mutex
+	sent, recv map[peer.ID]int64
+}
+
+func newCountingReporter() *countingReporter {
+	return &countingReporter{sent: make(map[peer.ID]int64), recv: make(map[peer.ID]int64)}
+}
+
+func (r *countingReporter) LogSentBytes(n int64, p peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent[p] += n
+}
+
+func (r *countingReporter) LogRecvBytes(n int64, p peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recv[p] += n
+}
+
+func TestBandwidthReporter(t *testing.T) {
+	for _, secure := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		p1 := tu.RandPeerNetParamsOrFatal(t)
+		p2 := tu.RandPeerNetParamsOrFatal(t)
+		key1, key2 := p1.PrivKey, p2.PrivKey
+		if !secure {
+			key1, key2 = nil, nil
+		}
+
+		l1, err := Listen(ctx, p1.Addr, p1.ID, key1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l1.Close()
+		lrep := newCountingReporter()
+		l1.(ListenerBandwidthReporter).SetBandwidthReporter(lrep)
+		go echoListen(ctx, l1)
+
+		drep := newCountingReporter()
+		d := NewDialer(p2.ID, key2, nil)
+		d.Reporter = drep
+		d.AddDialer(dialer(t, p2.Addr))
+
+		c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testOneSendRecv(t, c, c)
+		c.Close()
+
+		// a message of 5 bytes, length prefixed, went there and back.
+		drep.mu.Lock()
+		if drep.sent[p1.ID] != 9 || drep.recv[p1.ID] != 9 {
+			t.Errorf("secure %t: dialer reported %d bytes sent and %d received", secure, drep.sent[p1.ID], drep.recv[p1.ID])
+		}
+		drep.mu.Unlock()
+
+		if secure {
+			lrep.mu.Lock()
+			if lrep.recv[p2.ID] != 9 {
+				t.Errorf("listener reported %d bytes received from %s", lrep.recv[p2.ID], p2.ID)
+			}
+			lrep.mu.Unlock()
+		}
+	}
+}
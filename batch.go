@@ -0,0 +1,138 @@
+package conn
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteBatching makes secure conns gather small writes into fewer secio
+// frames, each of which costs a length prefix and a MAC. Writes return
+// once buffered; their bytes are sent when Size of them are pending,
+// Delay after the first of them, or on Flush or Close. A write that
+// fails makes every later one, and Flush, fail with its error.
+//
+// It has no effect on insecure conns, nor in message mode, where frames
+// are messages.
+type WriteBatching struct {
+	// Size is how many bytes to gather at most. Writes at least as long
+	// are sent right away, after the pending ones. Zero means 16KB.
+	Size int
+
+	// Delay is how long bytes may wait for more. Zero means 1ms.
+	Delay time.Duration
+}
+
+const (
+	defaultBatchSize  = 16 * 1024
+	defaultBatchDelay = time.Millisecond
+)
+
+// Flusher is implemented by the conns returned by Dial and Accept.
+type Flusher interface {
+	// Flush sends the bytes of the writes batched so far, see
+	// WriteBatching. It does nothing when there are none.
+	Flush() error
+}
+
+// batchWriter batches writes to write, along the lines of a WriteBatching.
+type batchWriter struct {
+	write func([]byte) (int, error)
+	size  int
+	delay time.Duration
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	err   error
+}
+
+func newBatchWriter(b *WriteBatching, write func([]byte) (int, error)) *batchWriter {
+	bw := &batchWriter{write: write, size: b.Size, delay: b.Delay}
+	if bw.size <= 0 {
+		bw.size = defaultBatchSize
+	}
+	if bw.delay <= 0 {
+		bw.delay = defaultBatchDelay
+	}
+	return bw
+}
+
+func (b *batchWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if len(b.buf)+len(p) > b.size {
+		if err := b.flushLocked(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) >= b.size {
+		n, err := b.write(p)
+		if err != nil {
+			b.err = err
+		}
+		return n, err
+	}
+
+	b.buf = append(b.buf, p...)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.delay, func() { b.Flush() })
+	}
+	return len(p), nil
+}
+
+func (b *batchWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flushLocked()
+}
+
+func (b *batchWriter) flushLocked() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if b.err != nil || len(b.buf) == 0 {
+		return b.err
+	}
+	_, err := b.write(b.buf)
+	b.buf = b.buf[:0]
+	if err != nil {
+		b.err = err
+	}
+	return err
+}
+
+func (c *secureConn) Flush() error {
+	if c.batch == nil {
+		return nil
+	}
+	return c.batch.Flush()
+}
+
+func (c *singleConn) Flush() error {
+	return nil
+}
+
+// setWriteBatching makes c batch its writes, if b is set.
+func (c *secureConn) setWriteBatching(b *WriteBatching) {
+	if b != nil && !c.messageMode {
+		c.batch = newBatchWriter(b, c.writeFrames)
+	}
+}
+
+// ListenerWriteBatching is implemented by listeners that can batch the
+// writes of the secure conns they accept.
+type ListenerWriteBatching interface {
+	// SetWriteBatching makes accepted secure conns batch their writes,
+	// like Dialer.WriteBatching. It must be called before any call to
+	// Accept.
+	SetWriteBatching(b *WriteBatching)
+}
+
+func (l *listener) SetWriteBatching(b *WriteBatching) {
+	l.batching = b
+}
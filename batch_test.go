@@ -0,0 +1,135 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// frameRecorder records the writes it gets, as frames.
+type frameRecorder struct {
+	mu     sync.Mutex
+	frames []string
+	err    error
+}
+
+func (r *frameRecorder) write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.frames = append(r.frames, string(b))
+	return len(b), nil
+}
+
+func (r *frameRecorder) written() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.frames...)
+}
+
+func TestBatchWriter(t *testing.T) {
+	rec := &frameRecorder{}
+	bw := newBatchWriter(&WriteBatching{Size: 8, Delay: time.Hour}, rec.write)
+
+	for _, s := range []string{"ab", "cd", "ef"} {
+		if n, err := bw.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("write returned %d, %v", n, err)
+		}
+	}
+	if f := rec.written(); len(f) != 0 {
+		t.Fatalf("sent %q before the batch was full", f)
+	}
+
+	// the pending bytes go first, and long writes right after.
+	bw.Write([]byte("ghi"))
+	bw.Write([]byte("0123456789"))
+	expected := []string{"abcdef", "ghi", "0123456789"}
+	if f := rec.written(); len(f) != len(expected) || f[0] != expected[0] || f[1] != expected[1] || f[2] != expected[2] {
+		t.Fatalf("sent %q, expected %q", f, expected)
+	}
+
+	bw.Write([]byte("j"))
+	if err := bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if f := rec.written(); len(f) != 4 || f[3] != "j" {
+		t.Fatalf("flush sent %q", f)
+	}
+	if err := bw.Flush(); err != nil || len(rec.written()) != 4 {
+		t.Fatal("empty flush sent a frame")
+	}
+}
+
+func TestBatchWriterDelay(t *testing.T) {
+	rec := &frameRecorder{}
+	bw := newBatchWriter(&WriteBatching{Size: 1024, Delay: 10 * time.Millisecond}, rec.write)
+	bw.Write([]byte("a"))
+	bw.Write([]byte("b"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.written()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch not sent after its delay")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if f := rec.written(); len(f) != 1 || f[0] != "ab" {
+		t.Fatalf("sent %q", f)
+	}
+}
+
+func TestBatchWriterError(t *testing.T) {
+	broken := errors.New("broken pipe")
+	rec := &frameRecorder{err: broken}
+	bw := newBatchWriter(&WriteBatching{Size: 1024, Delay: time.Hour}, rec.write)
+
+	if _, err := bw.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.Flush(); err != broken {
+		t.Fatalf("flush returned %v", err)
+	}
+	if _, err := bw.Write([]byte("b")); err != broken {
+		t.Fatalf("write after failure returned %v", err)
+	}
+}
+
+// halfCloseConn records its half close with the frames written before.
+type halfCloseConn struct {
+	pipeConn
+	rec *frameRecorder
+}
+
+func (c halfCloseConn) CloseWrite() error {
+	c.rec.write([]byte("FIN"))
+	return nil
+}
+
+func (c halfCloseConn) CloseRead() error {
+	return nil
+}
+
+func TestBatchedCloseWrite(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	rec := &frameRecorder{}
+	insecure := newSingleConn(context.Background(), "a", "b", halfCloseConn{pipeConn{a}, rec})
+	defer insecure.Close()
+	c := &secureConn{insecure: insecure}
+	c.batch = newBatchWriter(&WriteBatching{Size: 64, Delay: time.Hour}, rec.write)
+
+	if _, err := c.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if f := rec.written(); len(f) != 2 || f[0] != "bye" || f[1] != "FIN" {
+		t.Fatalf("expected the batched write before the half close, got %q", f)
+	}
+}
@@ -0,0 +1,261 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// CircuitState is the state of a circuit of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets dials through, tracking their failure rate.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails dials right away, until the cooldown is over.
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial dial through, which decides
+	// whether the circuit closes or opens again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreaker fails dials fast towards destinations that keep failing,
+// so that a whole subnet going dark doesn't turn into a storm of dial
+// timeouts. Dials are grouped into circuits by Key. Once at least
+// MinRequests dials of a circuit were made within Window, and the share of
+// them which failed reaches FailureRate, the circuit opens: its dials fail
+// with ErrDialBackoff for Cooldown, after which one trial dial is let
+// through to decide whether to close the circuit again.
+//
+// Zero fields take the defaults listed below. A CircuitBreaker must not be
+// copied or modified once in use.
+type CircuitBreaker struct {
+	// Key maps a dial to its circuit. Defaults to IPPrefixKey(24, 48).
+	Key func(raddr ma.Multiaddr, remote peer.ID) string
+
+	// MinRequests defaults to 10.
+	MinRequests int
+	// FailureRate defaults to 0.5.
+	FailureRate float64
+	// Window defaults to one minute.
+	Window time.Duration
+	// Cooldown defaults to 30 seconds.
+	Cooldown time.Duration
+
+	// OnStateChange, if set, is called whenever a circuit changes state,
+	// outside of the breaker's lock.
+	OnStateChange func(key string, from, to CircuitState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state     CircuitState
+	since     time.Time // start of the window, or when it opened
+	requests  int
+	failures  int
+	trialDial bool // a half-open trial is in flight
+}
+
+// State returns the state of the circuit with the given key.
+func (cb *CircuitBreaker) State(key string) CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if c, ok := cb.circuits[key]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+func (cb *CircuitBreaker) key(raddr ma.Multiaddr, remote peer.ID) string {
+	if cb.Key != nil {
+		return cb.Key(raddr, remote)
+	}
+	return IPPrefixKey(24, 48)(raddr, remote)
+}
+
+// allow reports whether a dial to the circuit with the given key may
+// proceed. Allowed dials must be followed by a call to done.
+func (cb *CircuitBreaker) allow(ctx context.Context, key string) bool {
+	cb.mu.Lock()
+	c := cb.circuit(key)
+	now := time.Now()
+
+	from := c.state
+	switch c.state {
+	case CircuitOpen:
+		if now.Sub(c.since) < orDefault(cb.Cooldown, 30*time.Second) {
+			cb.mu.Unlock()
+			return false
+		}
+		c.state = CircuitHalfOpen
+		c.trialDial = true
+	case CircuitHalfOpen:
+		if c.trialDial {
+			cb.mu.Unlock()
+			return false
+		}
+		c.trialDial = true
+	}
+	to := c.state
+	cb.mu.Unlock()
+
+	cb.changed(ctx, key, from, to)
+	return true
+}
+
+// blocks reports whether allow would refuse a dial to the circuit with
+// the given key, without letting a trial dial through.
+func (cb *CircuitBreaker) blocks(key string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c, ok := cb.circuits[key]
+	if !ok {
+		return false
+	}
+	switch c.state {
+	case CircuitOpen:
+		return time.Since(c.since) < orDefault(cb.Cooldown, 30*time.Second)
+	case CircuitHalfOpen:
+		return c.trialDial
+	}
+	return false
+}
+
+// done records the outcome of a dial let through by allow.
+func (cb *CircuitBreaker) done(ctx context.Context, key string, failed bool) {
+	cb.mu.Lock()
+	c := cb.circuit(key)
+	now := time.Now()
+
+	from := c.state
+	switch c.state {
+	case CircuitHalfOpen:
+		c.trialDial = false
+		if failed {
+			c.state = CircuitOpen
+		} else {
+			c.state = CircuitClosed
+		}
+		c.since = now
+		c.requests, c.failures = 0, 0
+	case CircuitClosed:
+		if now.Sub(c.since) > orDefault(cb.Window, time.Minute) {
+			c.since = now
+			c.requests, c.failures = 0, 0
+		}
+		c.requests++
+		if failed {
+			c.failures++
+		}
+
+		minRequests := cb.MinRequests
+		if minRequests <= 0 {
+			minRequests = 10
+		}
+		rate := cb.FailureRate
+		if rate <= 0 {
+			rate = 0.5
+		}
+		if c.requests >= minRequests && float64(c.failures) >= rate*float64(c.requests) {
+			c.state = CircuitOpen
+			c.since = now
+		}
+	}
+	to := c.state
+	if to == CircuitClosed && c.requests == 0 {
+		delete(cb.circuits, key)
+	}
+	cb.mu.Unlock()
+
+	cb.changed(ctx, key, from, to)
+}
+
+// circuit returns the circuit for key, creating it. cb.mu must be held.
+func (cb *CircuitBreaker) circuit(key string) *circuit {
+	if cb.circuits == nil {
+		cb.circuits = make(map[string]*circuit)
+	}
+	c, ok := cb.circuits[key]
+	if !ok {
+		c = &circuit{since: time.Now()}
+		cb.circuits[key] = c
+	}
+	return c
+}
+
+func (cb *CircuitBreaker) changed(ctx context.Context, key string, from, to CircuitState) {
+	if from == to {
+		return
+	}
+
+	log.Event(ctx, "connDialCircuitState", circuitLoggable{key, from, to})
+	if cb.OnStateChange != nil {
+		cb.OnStateChange(key, from, to)
+	}
+}
+
+type circuitLoggable struct {
+	key      string
+	from, to CircuitState
+}
+
+func (l circuitLoggable) Loggable() map[string]interface{} {
+	return map[string]interface{}{
+		"circuit": l.key,
+		"from":    l.from.String(),
+		"to":      l.to.String(),
+	}
+}
+
+// IPPrefixKey returns a CircuitBreaker key function grouping dials by the
+// IPv4 /v4bits or IPv6 /v6bits network of their destination. Dials to
+// addresses without an IP are grouped by peer.
+func IPPrefixKey(v4bits, v6bits int) func(ma.Multiaddr, peer.ID) string {
+	return func(raddr ma.Multiaddr, remote peer.ID) string {
+		if v, err := raddr.ValueForProtocol(ma.P_IP4); err == nil {
+			if ip := net.ParseIP(v); ip != nil {
+				return (&net.IPNet{IP: ip.Mask(net.CIDRMask(v4bits, 32)), Mask: net.CIDRMask(v4bits, 32)}).String()
+			}
+		}
+		if v, err := raddr.ValueForProtocol(ma.P_IP6); err == nil {
+			if ip := net.ParseIP(v); ip != nil {
+				return (&net.IPNet{IP: ip.Mask(net.CIDRMask(v6bits, 128)), Mask: net.CIDRMask(v6bits, 128)}).String()
+			}
+		}
+		return PeerKey(raddr, remote)
+	}
+}
+
+// PeerKey is a CircuitBreaker key function grouping dials by peer.
+func PeerKey(raddr ma.Multiaddr, remote peer.ID) string {
+	return "peer:" + remote.Pretty()
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
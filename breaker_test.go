@@ -0,0 +1,145 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	ctx := context.Background()
+
+	var changes []CircuitState
+	cb := &CircuitBreaker{
+		MinRequests: 4,
+		FailureRate: 0.5,
+		Cooldown:    50 * time.Millisecond,
+		OnStateChange: func(key string, from, to CircuitState) {
+			changes = append(changes, to)
+		},
+	}
+
+	for i := 0; i < 4; i++ {
+		if !cb.allow(ctx, "k") {
+			t.Fatal("closed circuit should allow dials")
+		}
+		cb.done(ctx, "k", i%2 == 0)
+	}
+	if cb.State("k") != CircuitOpen {
+		t.Fatal("circuit should open once the failure rate is reached, is: ", cb.State("k"))
+	}
+	if cb.allow(ctx, "k") {
+		t.Fatal("open circuit should fail dials")
+	}
+	if cb.State("other") != CircuitClosed || !cb.allow(ctx, "other") {
+		t.Fatal("other circuits should not be affected")
+	}
+	cb.done(ctx, "other", false)
+
+	time.Sleep(60 * time.Millisecond)
+	if !cb.allow(ctx, "k") {
+		t.Fatal("circuit should allow a trial dial after the cooldown")
+	}
+	if cb.allow(ctx, "k") {
+		t.Fatal("only one trial dial should be let through")
+	}
+	cb.done(ctx, "k", true)
+	if cb.State("k") != CircuitOpen {
+		t.Fatal("failed trial should reopen the circuit")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !cb.allow(ctx, "k") {
+		t.Fatal("circuit should allow a trial dial after the cooldown")
+	}
+	cb.done(ctx, "k", false)
+	if cb.State("k") != CircuitClosed {
+		t.Fatal("successful trial should close the circuit")
+	}
+
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(changes) != len(expected) {
+		t.Fatal("unexpected state changes: ", changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatal("unexpected state changes: ", changes)
+		}
+	}
+}
+
+func TestIPPrefixKey(t *testing.T) {
+	key := IPPrefixKey(24, 48)
+	p := tu.RandPeerIDFatal(t)
+
+	a := key(ma.StringCast("/ip4/10.1.2.3/tcp/4001"), p)
+	b := key(ma.StringCast("/ip4/10.1.2.200/tcp/5001"), p)
+	c := key(ma.StringCast("/ip4/10.1.3.3/tcp/4001"), p)
+	if a != b || a == c {
+		t.Fatalf("unexpected ipv4 keys: %s %s %s", a, b, c)
+	}
+
+	a = key(ma.StringCast("/ip6/2001:db8:1::1/tcp/4001"), p)
+	b = key(ma.StringCast("/ip6/2001:db8:1:ffff::1/tcp/4001"), p)
+	if a != b {
+		t.Fatalf("unexpected ipv6 keys: %s %s", a, b)
+	}
+}
+
+func TestDialCircuitOpens(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// find an address nobody listens on.
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr, err := manet.FromNetAddr(nl.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nl.Close()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.Breaker = &CircuitBreaker{MinRequests: 2, Cooldown: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if _, err := d.Dial(ctx, raddr, p1.ID); err == nil || errors.Is(err, ErrDialBackoff) {
+			t.Fatal("expected a plain dial failure, got: ", err)
+		}
+	}
+
+	if _, err := d.Dial(ctx, raddr, p1.ID); !errors.Is(err, ErrDialBackoff) {
+		t.Fatal("expected the circuit to be open, got: ", err)
+	}
+}
+
+// Canceled dials say nothing about the destination, even when the
+// cancellation comes wrapped.
+func TestDialCircuitIgnoresCanceled(t *testing.T) {
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(&failingDialer{err: fmt.Errorf("dial aborted: %w", context.Canceled)})
+	d.Breaker = &CircuitBreaker{MinRequests: 1, Cooldown: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if _, err := d.Dial(context.Background(), p1.Addr, p1.ID); !errors.Is(err, context.Canceled) {
+			t.Fatal("expected the dial to be canceled, got: ", err)
+		}
+	}
+	if s := d.Breaker.State(d.Breaker.key(p1.Addr, p1.ID)); s != CircuitClosed {
+		t.Fatal("canceled dials opened the circuit: ", s)
+	}
+}
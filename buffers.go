@@ -0,0 +1,107 @@
+package conn
+
+import (
+	"fmt"
+	"net"
+)
+
+// BufferSizes tunes the buffers of conns, for bulk transfers or for
+// devices with many conns and little memory. Zero fields keep the
+// defaults.
+type BufferSizes struct {
+	// SocketRead and SocketWrite are the sizes of the kernel buffers of
+	// the socket, SO_RCVBUF and SO_SNDBUF. They only apply to transports
+	// whose conns give access to their socket: *net.TCPConn or the like,
+	// or a conn with a NetConn method returning one.
+	SocketRead  int `json:"socketRead,omitempty" yaml:"socketRead,omitempty"`
+	SocketWrite int `json:"socketWrite,omitempty" yaml:"socketWrite,omitempty"`
+
+	// WriteChunk overrides MaxWriteChunk. On secure conns, it is also the
+	// largest frame written, so the largest buffer secio allocates for
+	// the frames we send, on both ends.
+	WriteChunk int `json:"writeChunk,omitempty" yaml:"writeChunk,omitempty"`
+}
+
+// Validate checks the sizes, without applying them.
+func (b *BufferSizes) Validate() error {
+	if b.SocketRead < 0 || b.SocketWrite < 0 {
+		return fmt.Errorf("invalid socket buffer sizes %d/%d", b.SocketRead, b.SocketWrite)
+	}
+	if b.WriteChunk < 0 {
+		return fmt.Errorf("invalid write chunk size %d", b.WriteChunk)
+	}
+	return nil
+}
+
+// writeChunk returns the write chunk size of conns with b.
+func (b *BufferSizes) writeChunk() int {
+	if b == nil {
+		return 0
+	}
+	return b.WriteChunk
+}
+
+// resolveWriteChunk returns the write chunk size n, or MaxWriteChunk if
+// zero.
+func resolveWriteChunk(n int) int {
+	if n > 0 {
+		return n
+	}
+	return MaxWriteChunk
+}
+
+type socketBuffers interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// setSocketBuffers sets the socket buffers of c, as configured by b, if c
+// gives access to its socket. It reports whether it did.
+func setSocketBuffers(c net.Conn, b *BufferSizes) (bool, error) {
+	if b == nil || (b.SocketRead == 0 && b.SocketWrite == 0) {
+		return false, nil
+	}
+	s, ok := findSocket(c, func(c net.Conn) bool {
+		_, ok := c.(socketBuffers)
+		return ok
+	}).(socketBuffers)
+	if !ok {
+		return false, nil
+	}
+	if b.SocketRead > 0 {
+		if err := s.SetReadBuffer(b.SocketRead); err != nil {
+			return false, err
+		}
+	}
+	if b.SocketWrite > 0 {
+		if err := s.SetWriteBuffer(b.SocketWrite); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// findSocket returns the first of c and the conns it wraps, as told by
+// their NetConn method, that is a socket, or nil.
+func findSocket(c net.Conn, isSocket func(net.Conn) bool) net.Conn {
+	for !isSocket(c) {
+		nc, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		c = nc.NetConn()
+	}
+	return c
+}
+
+// ListenerBufferSizes is implemented by listeners that can tune the
+// buffers of the conns they accept.
+type ListenerBufferSizes interface {
+	// SetBufferSizes sets the buffer sizes of accepted conns, like
+	// Dialer.BufferSizes. It must be called before any call to Accept.
+	SetBufferSizes(b *BufferSizes)
+}
+
+func (l *listener) SetBufferSizes(b *BufferSizes) {
+	l.buffers = b
+}
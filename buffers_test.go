@@ -0,0 +1,62 @@
+package conn
+
+import (
+	"net"
+	"testing"
+)
+
+// hiddenConn hides the socket of the conn it wraps, but for NetConn.
+type hiddenConn struct {
+	net.Conn
+}
+
+func (c hiddenConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func TestSetSocketBuffers(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	b := &BufferSizes{SocketRead: 1 << 20, SocketWrite: 1 << 20}
+	for _, nc := range []net.Conn{c, hiddenConn{c}} {
+		if set, err := setSocketBuffers(nc, b); !set || err != nil {
+			t.Fatalf("%T: socket buffers not set: %v", nc, err)
+		}
+	}
+
+	// without access to the socket, nothing happens.
+	a, p := net.Pipe()
+	defer a.Close()
+	defer p.Close()
+	if set, err := setSocketBuffers(a, b); set || err != nil {
+		t.Fatalf("pipe: set %t, %v", set, err)
+	}
+	if set, _ := setSocketBuffers(c, &BufferSizes{WriteChunk: 1024}); set {
+		t.Fatal("socket buffers set without sizes")
+	}
+}
+
+func TestResolveWriteChunk(t *testing.T) {
+	var b *BufferSizes
+	if n := resolveWriteChunk(b.writeChunk()); n != MaxWriteChunk {
+		t.Fatalf("default chunk %d", n)
+	}
+	b = &BufferSizes{WriteChunk: 4096}
+	if n := resolveWriteChunk(b.writeChunk()); n != 4096 {
+		t.Fatalf("chunk %d", n)
+	}
+}
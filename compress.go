@@ -0,0 +1,245 @@
+package conn
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// NoCompressionTag is the multistream tag of conns that don't compress.
+// Dialers and listeners with compressions always support it too, so
+// that peers with no compression in common still connect.
+const NoCompressionTag = "/libp2p/no-compression/1.0.0"
+
+// Compression compresses the secio frames of secure conns. It is
+// negotiated with its Tag once the handshake is done, see
+// Dialer.Compression.
+//
+// Frames are compressed one by one, without any state carried from one
+// to the next, so a Compression is a pair of functions, like the ones of
+// the snappy and zstd packages. The package provides DeflateCompression.
+type Compression interface {
+	// Tag is the multistream protocol ID of the compression.
+	Tag() string
+	// Compress appends src, compressed, to dst.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends src, decompressed, to dst. It fails rather than
+	// yield more than max bytes.
+	Decompress(dst, src []byte, max int) ([]byte, error)
+}
+
+// CompressionInfo is implemented by the conns returned by Dial and
+// Accept.
+type CompressionInfo interface {
+	// Compression is the Tag of the compression of the conn, or "" if it
+	// doesn't compress.
+	Compression() string
+}
+
+func (c *singleConn) Compression() string {
+	return ""
+}
+
+func (c *secureConn) Compression() string {
+	if c.comp == nil {
+		return ""
+	}
+	return c.comp.Tag()
+}
+
+// ListenerCompression is implemented by listeners that can compress the
+// conns they accept.
+type ListenerCompression interface {
+	// SetCompression sets the compressions accepted conns may select,
+	// like Dialer.Compression. It must be called before any call to
+	// Accept.
+	SetCompression(cs []Compression)
+}
+
+func (l *listener) SetCompression(cs []Compression) {
+	l.compression = cs
+}
+
+// compressionFor returns the compressions to negotiate, none in message
+// mode, which keeps frames as they are written.
+func compressionFor(cs []Compression, messageMode bool) []Compression {
+	if messageMode {
+		return nil
+	}
+	return cs
+}
+
+// negotiateCompression selects the compression of c among cs, and
+// NoCompressionTag, as the initiator, or answers the selection of the
+// remote.
+func negotiateCompression(c *secureConn, cs []Compression, initiator bool) error {
+	var tag string
+	var err error
+	if initiator {
+		protos := make([]string, 0, len(cs)+1)
+		for _, comp := range cs {
+			protos = append(protos, comp.Tag())
+		}
+		tag, err = msmux.SelectOneOf(append(protos, NoCompressionTag), c)
+	} else {
+		mux := msmux.NewMultistreamMuxer()
+		for _, comp := range cs {
+			mux.AddHandler(comp.Tag(), nil)
+		}
+		mux.AddHandler(NoCompressionTag, nil)
+		tag, _, err = mux.Negotiate(c)
+	}
+	if err != nil {
+		return fmt.Errorf("negotiating compression: %s", err)
+	}
+
+	for _, comp := range cs {
+		if comp.Tag() == tag {
+			c.comp = comp
+			break
+		}
+	}
+	return nil
+}
+
+// The first byte of compressed conn frames tells how the rest is encoded.
+const (
+	frameStored     byte = 0
+	frameCompressed byte = 1
+)
+
+// compressedFrames writes each Write as one compressed secio frame, or
+// as is, when compression doesn't make it smaller.
+type compressedFrames struct {
+	c *secureConn
+}
+
+func (w compressedFrames) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf := msgPool.Get(len(p) + 1)
+	defer msgPool.Put(buf)
+
+	frame, err := w.c.comp.Compress(append(buf[:0], frameCompressed), p)
+	if err != nil {
+		return 0, err
+	}
+	if len(frame) > len(p) {
+		frame = append(append(buf[:0], frameStored), p...)
+	}
+	if _, err := w.c.secure.ReadWriter().Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// decompress returns the data of frame, decompressed if need be, into a
+// buffer reused until the next call. frameMu must be held.
+func (c *secureConn) decompress(frame []byte) ([]byte, error) {
+	if c.comp == nil {
+		return frame, nil
+	}
+	if len(frame) == 0 {
+		return nil, fmt.Errorf("empty %s frame", c.comp.Tag())
+	}
+	switch frame[0] {
+	case frameStored:
+		return frame[1:], nil
+	case frameCompressed:
+		data, err := c.comp.Decompress(c.decompressed[:0], frame[1:], resolveMaxMessageSize(c.msgFramer.max))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing %s frame: %s", c.comp.Tag(), err)
+		}
+		if cap(data) <= maxReadBuffer {
+			c.decompressed = data[:0]
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("invalid %s frame encoding %d", c.comp.Tag(), frame[0])
+}
+
+// builtinCompressions returns the compressions of the package with the
+// given tags, at their default settings.
+func builtinCompressions(tags []string) ([]Compression, error) {
+	var cs []Compression
+	for _, tag := range tags {
+		switch tag {
+		case DeflateTag:
+			c, _ := DeflateCompression(flate.DefaultCompression)
+			cs = append(cs, c)
+		default:
+			return nil, fmt.Errorf("unknown compression %q", tag)
+		}
+	}
+	return cs, nil
+}
+
+// DeflateTag is the tag of DeflateCompression.
+const DeflateTag = "/libp2p/deflate/1.0.0"
+
+// DeflateCompression returns a Compression with compress/flate at the
+// given level, as with flate.NewWriter.
+func DeflateCompression(level int) (Compression, error) {
+	if _, err := flate.NewWriter(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+	return &deflate{level: level}, nil
+}
+
+type deflate struct {
+	level   int
+	writers sync.Pool // *flate.Writer
+	readers sync.Pool // io.ReadCloser, a flate.Resetter
+}
+
+func (d *deflate) Tag() string {
+	return DeflateTag
+}
+
+func (d *deflate) Compress(dst, src []byte) ([]byte, error) {
+	b := bytes.NewBuffer(dst)
+	w, _ := d.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(b, d.level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(b)
+	}
+	defer d.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (d *deflate) Decompress(dst, src []byte, max int) ([]byte, error) {
+	r, _ := d.readers.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReader(bytes.NewReader(src))
+	} else if err := r.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+		return nil, err
+	}
+	defer d.readers.Put(r)
+
+	b := bytes.NewBuffer(dst)
+	n, err := b.ReadFrom(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(max) {
+		return nil, &MessageTooLargeError{Size: uint64(n), Max: max}
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,122 @@
+package conn
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestDeflateRoundTrip(t *testing.T) {
+	c, err := DeflateCompression(flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeflateCompression(42); err == nil {
+		t.Fatal("expected an invalid level to fail")
+	}
+
+	data := bytes.Repeat([]byte(`{"key": "value"}`), 1000)
+	for i := 0; i < 3; i++ {
+		z, err := c.Compress([]byte("prefix"), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(z, []byte("prefix")) || len(z) >= len(data)/10 {
+			t.Fatalf("unexpected compressed frame of %d bytes", len(z))
+		}
+		out, err := c.Decompress(nil, z[len("prefix"):], len(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatal("round trip mismatch")
+		}
+	}
+}
+
+func TestDeflateBomb(t *testing.T) {
+	c, _ := DeflateCompression(flate.DefaultCompression)
+	z, err := c.Compress(nil, make([]byte, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mte *MessageTooLargeError
+	if _, err := c.Decompress(nil, z, 1024); !errors.As(err, &mte) {
+		t.Fatal("expected decompression past the limit to fail, got ", err)
+	}
+}
+
+func TestBuiltinCompressions(t *testing.T) {
+	cs, err := builtinCompressions([]string{DeflateTag})
+	if err != nil || len(cs) != 1 || cs[0].Tag() != DeflateTag {
+		t.Fatal("unexpected compressions: ", cs, err)
+	}
+	if _, err := builtinCompressions([]string{"/snappy"}); err == nil {
+		t.Fatal("expected unknown compressions to fail")
+	}
+}
+
+func TestDialCompressed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deflate, _ := DeflateCompression(flate.DefaultCompression)
+	for _, tc := range []struct {
+		listener []Compression
+		expected string
+	}{
+		{[]Compression{deflate}, DeflateTag},
+		{[]Compression{}, ""},
+	} {
+		p1 := tu.RandPeerNetParamsOrFatal(t)
+		p2 := tu.RandPeerNetParamsOrFatal(t)
+
+		l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the stub has the listener negotiate, even without deflate.
+		l1.(ListenerCompression).SetCompression(append(tc.listener, &stubCompression{}))
+		go echoListen(ctx, l1)
+
+		d := NewDialer(p2.ID, p2.PrivKey, nil)
+		d.Compression = []Compression{deflate}
+		c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag := c.(CompressionInfo).Compression(); tag != tc.expected {
+			t.Fatalf("expected compression %q, got %q", tc.expected, tag)
+		}
+
+		data := bytes.Repeat([]byte("compressible "), 10000)
+		go c.Write(data)
+		got := make([]byte, len(data))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatal("echo mismatch")
+		}
+		c.Close()
+		l1.Close()
+	}
+}
+
+// stubCompression is a compression the dialers of the tests don't know.
+type stubCompression struct{}
+
+func (*stubCompression) Tag() string { return "/stub/1.0.0" }
+
+func (*stubCompression) Compress(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+func (*stubCompression) Decompress(dst, src []byte, max int) ([]byte, error) {
+	return append(dst, src...), nil
+}
@@ -0,0 +1,638 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	ci "github.com/libp2p/go-libp2p-crypto"
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	peer "github.com/libp2p/go-libp2p-peer"
+	transport "github.com/libp2p/go-libp2p-transport"
+	filter "github.com/libp2p/go-maddr-filter"
+)
+
+// Duration is a time.Duration written as a string, like "1m30s", in
+// config files. NoTimeout is written "none".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	if time.Duration(d) == NoTimeout {
+		return "none"
+	}
+	return time.Duration(d).String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	if string(text) == "none" {
+		*d = Duration(NoTimeout)
+		return nil
+	}
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// UnmarshalYAML is for YAML decoders which don't use UnmarshalText.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// DialerConfig is the configuration of a Dialer, as read from a config
+// file. Zero values keep the Dialer's defaults. See NewDialerFromConfig.
+type DialerConfig struct {
+	// Timeout is Dialer.Timeout.
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// IdleTimeout is Dialer.IdleTimeout.
+	IdleTimeout Duration `json:"idleTimeout,omitempty" yaml:"idleTimeout,omitempty"`
+
+	// MessageMode is Dialer.MessageMode.
+	MessageMode bool `json:"messageMode,omitempty" yaml:"messageMode,omitempty"`
+	// Optimistic is Dialer.Optimistic.
+	Optimistic bool `json:"optimistic,omitempty" yaml:"optimistic,omitempty"`
+	// SecurityProtocols is Dialer.SecurityProtocols.
+	SecurityProtocols []string `json:"securityProtocols,omitempty" yaml:"securityProtocols,omitempty"`
+	// Coalesce is Dialer.Coalesce.
+	Coalesce bool `json:"coalesce,omitempty" yaml:"coalesce,omitempty"`
+	// MaxMessageSize is Dialer.MaxMessageSize.
+	MaxMessageSize int `json:"maxMessageSize,omitempty" yaml:"maxMessageSize,omitempty"`
+	// TrustedTransport is Dialer.TrustedTransport.
+	TrustedTransport bool `json:"trustedTransport,omitempty" yaml:"trustedTransport,omitempty"`
+	// ExchangeObservedAddrs is Dialer.ExchangeObservedAddrs.
+	ExchangeObservedAddrs bool `json:"exchangeObservedAddrs,omitempty" yaml:"exchangeObservedAddrs,omitempty"`
+	// ChannelBinding is Dialer.ChannelBinding.
+	ChannelBinding bool `json:"channelBinding,omitempty" yaml:"channelBinding,omitempty"`
+	// MaxDialsPerPeer and MaxDialsPerAddr are the Dialer's.
+	MaxDialsPerPeer int `json:"maxDialsPerPeer,omitempty" yaml:"maxDialsPerPeer,omitempty"`
+	MaxDialsPerAddr int `json:"maxDialsPerAddr,omitempty" yaml:"maxDialsPerAddr,omitempty"`
+	// PNetFingerprint is Dialer.PNetFingerprint.
+	PNetFingerprint bool `json:"pnetFingerprint,omitempty" yaml:"pnetFingerprint,omitempty"`
+
+	// BlockedRanges are networks, in CIDR notation, never to dial.
+	BlockedRanges []string `json:"blockedRanges,omitempty" yaml:"blockedRanges,omitempty"`
+	// BlockPrivateRanges blocks the networks of NewPrivateRangeFilters.
+	BlockPrivateRanges bool `json:"blockPrivateRanges,omitempty" yaml:"blockPrivateRanges,omitempty"`
+
+	// Breaker, if set, configures Dialer.Breaker.
+	Breaker *BreakerConfig `json:"breaker,omitempty" yaml:"breaker,omitempty"`
+
+	// WriteLimit, if set, configures Dialer.Limiter.
+	WriteLimit *WriteLimitConfig `json:"writeLimit,omitempty" yaml:"writeLimit,omitempty"`
+	// WriteBatching, if set, configures Dialer.WriteBatching.
+	WriteBatching *WriteBatchingConfig `json:"writeBatching,omitempty" yaml:"writeBatching,omitempty"`
+	// BufferSizes is Dialer.BufferSizes.
+	BufferSizes *BufferSizes `json:"bufferSizes,omitempty" yaml:"bufferSizes,omitempty"`
+	// TCPOptions, if set, configures Dialer.TCPOptions.
+	TCPOptions *TCPOptionsConfig `json:"tcpOptions,omitempty" yaml:"tcpOptions,omitempty"`
+	// HandshakePool, if set, configures Dialer.HandshakePool.
+	HandshakePool *HandshakePoolConfig `json:"handshakePool,omitempty" yaml:"handshakePool,omitempty"`
+	// DialThrottle, if set, configures Dialer.DialThrottle.
+	DialThrottle *DialThrottleConfig `json:"dialThrottle,omitempty" yaml:"dialThrottle,omitempty"`
+	// Compression are the tags of the Dialer.Compression, among the
+	// built-in ones: only DeflateTag, for now.
+	Compression []string `json:"compression,omitempty" yaml:"compression,omitempty"`
+}
+
+// BreakerConfig configures a CircuitBreaker. Zero values take the
+// CircuitBreaker defaults.
+type BreakerConfig struct {
+	MinRequests int      `json:"minRequests,omitempty" yaml:"minRequests,omitempty"`
+	FailureRate float64  `json:"failureRate,omitempty" yaml:"failureRate,omitempty"`
+	Window      Duration `json:"window,omitempty" yaml:"window,omitempty"`
+	Cooldown    Duration `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+
+	// Key is how dials are grouped into circuits: "ip-prefix", the
+	// default, or "peer".
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// IPv4PrefixBits and IPv6PrefixBits size the prefixes of the
+	// "ip-prefix" key. They default to 24 and 48.
+	IPv4PrefixBits int `json:"ipv4PrefixBits,omitempty" yaml:"ipv4PrefixBits,omitempty"`
+	IPv6PrefixBits int `json:"ipv6PrefixBits,omitempty" yaml:"ipv6PrefixBits,omitempty"`
+}
+
+// WriteLimitConfig configures a WriteLimiter.
+type WriteLimitConfig struct {
+	// Rate is in bytes per second.
+	Rate    int `json:"rate" yaml:"rate"`
+	Quantum int `json:"quantum,omitempty" yaml:"quantum,omitempty"`
+}
+
+// WriteBatchingConfig configures a WriteBatching.
+type WriteBatchingConfig struct {
+	Size  int      `json:"size,omitempty" yaml:"size,omitempty"`
+	Delay Duration `json:"delay,omitempty" yaml:"delay,omitempty"`
+}
+
+// TCPOptionsConfig configures TCPOptions.
+type TCPOptionsConfig struct {
+	NoDelay     *bool    `json:"noDelay,omitempty" yaml:"noDelay,omitempty"`
+	KeepAlive   Duration `json:"keepAlive,omitempty" yaml:"keepAlive,omitempty"`
+	Linger      *int     `json:"linger,omitempty" yaml:"linger,omitempty"`
+	UserTimeout Duration `json:"userTimeout,omitempty" yaml:"userTimeout,omitempty"`
+}
+
+// HandshakePoolConfig configures a HandshakePool.
+type HandshakePoolConfig struct {
+	// Workers defaults to DefaultHandshakeWorkers.
+	Workers int `json:"workers,omitempty" yaml:"workers,omitempty"`
+	// Hold defaults to DefaultHandshakeHold.
+	Hold Duration `json:"hold,omitempty" yaml:"hold,omitempty"`
+}
+
+// DialThrottleConfig configures a DialThrottle.
+type DialThrottleConfig struct {
+	// Limit defaults to DefaultDialThrottleLimit.
+	Limit int `json:"limit,omitempty" yaml:"limit,omitempty"`
+}
+
+// ListenerConfig is the configuration of a listener, as read from a
+// config file. Zero values keep the package defaults. See
+// WrapTransportListenerFromConfig.
+type ListenerConfig struct {
+	// AcceptTimeout, if set, overrides AcceptTimeout.
+	AcceptTimeout Duration `json:"acceptTimeout,omitempty" yaml:"acceptTimeout,omitempty"`
+	// PreambleTimeout, if set, overrides PreambleTimeout.
+	PreambleTimeout Duration `json:"preambleTimeout,omitempty" yaml:"preambleTimeout,omitempty"`
+	// IdleTimeout is as with SetIdleTimeout.
+	IdleTimeout Duration `json:"idleTimeout,omitempty" yaml:"idleTimeout,omitempty"`
+	// ProtectTimeout, SelectTimeout and SecureTimeout, if set, override
+	// the package variables of the same names.
+	ProtectTimeout Duration `json:"protectTimeout,omitempty" yaml:"protectTimeout,omitempty"`
+	SelectTimeout  Duration `json:"selectTimeout,omitempty" yaml:"selectTimeout,omitempty"`
+	SecureTimeout  Duration `json:"secureTimeout,omitempty" yaml:"secureTimeout,omitempty"`
+	// AcceptBacklog, if set, overrides AcceptBacklog.
+	AcceptBacklog *int `json:"acceptBacklog,omitempty" yaml:"acceptBacklog,omitempty"`
+	// AcceptOverflow, if set, overrides AcceptOverflow.
+	AcceptOverflow *OverflowPolicy `json:"acceptOverflow,omitempty" yaml:"acceptOverflow,omitempty"`
+	// ReplayWindow, if set, overrides ReplayWindow. Zero disables replay
+	// protection.
+	ReplayWindow *Duration `json:"replayWindow,omitempty" yaml:"replayWindow,omitempty"`
+
+	// MessageMode is as with SetMessageMode.
+	MessageMode bool `json:"messageMode,omitempty" yaml:"messageMode,omitempty"`
+	// MaxMessageSize is as with SetMaxMessageSize.
+	MaxMessageSize int `json:"maxMessageSize,omitempty" yaml:"maxMessageSize,omitempty"`
+	// TrustedTransport is as with SetTrustedTransport.
+	TrustedTransport bool `json:"trustedTransport,omitempty" yaml:"trustedTransport,omitempty"`
+	// ExchangeObservedAddrs is as with SetObservedAddrExchange.
+	ExchangeObservedAddrs bool `json:"exchangeObservedAddrs,omitempty" yaml:"exchangeObservedAddrs,omitempty"`
+	// ChannelBinding is as with SetChannelBinding.
+	ChannelBinding bool `json:"channelBinding,omitempty" yaml:"channelBinding,omitempty"`
+	// PNetFingerprint is as with SetPNetFingerprint.
+	PNetFingerprint bool `json:"pnetFingerprint,omitempty" yaml:"pnetFingerprint,omitempty"`
+	// SecurityProtocols, if set, is as with SetSecurityProtocols.
+	SecurityProtocols []string `json:"securityProtocols,omitempty" yaml:"securityProtocols,omitempty"`
+
+	// BlockedRanges are networks, in CIDR notation, to refuse
+	// connections from.
+	BlockedRanges []string `json:"blockedRanges,omitempty" yaml:"blockedRanges,omitempty"`
+	// BlockPrivateRanges refuses connections from the networks of
+	// NewPrivateRangeFilters.
+	BlockPrivateRanges bool `json:"blockPrivateRanges,omitempty" yaml:"blockPrivateRanges,omitempty"`
+	// AllowedRanges, if set, are the only networks, in CIDR notation, to
+	// accept connections from, as with SetAddrAllowlist.
+	AllowedRanges []string `json:"allowedRanges,omitempty" yaml:"allowedRanges,omitempty"`
+	// AllowedPeers, if set, are the only peers, base58 encoded, to
+	// accept connections from, as with SetPeerAllowlist.
+	AllowedPeers []string `json:"allowedPeers,omitempty" yaml:"allowedPeers,omitempty"`
+
+	// WriteLimit, if set, is as with SetWriteLimiter.
+	WriteLimit *WriteLimitConfig `json:"writeLimit,omitempty" yaml:"writeLimit,omitempty"`
+	// WriteBatching, if set, is as with SetWriteBatching.
+	WriteBatching *WriteBatchingConfig `json:"writeBatching,omitempty" yaml:"writeBatching,omitempty"`
+	// BufferSizes is as with SetBufferSizes.
+	BufferSizes *BufferSizes `json:"bufferSizes,omitempty" yaml:"bufferSizes,omitempty"`
+	// TCPOptions, if set, is as with SetTCPOptions.
+	TCPOptions *TCPOptionsConfig `json:"tcpOptions,omitempty" yaml:"tcpOptions,omitempty"`
+	// HandshakePool, if set, is as with SetHandshakePool.
+	HandshakePool *HandshakePoolConfig `json:"handshakePool,omitempty" yaml:"handshakePool,omitempty"`
+	// Compression are the tags of the compressions to use as with
+	// SetCompression, like DialerConfig.Compression.
+	Compression []string `json:"compression,omitempty" yaml:"compression,omitempty"`
+}
+
+// Validate checks the configuration, without building anything.
+func (c *DialerConfig) Validate() error {
+	if _, err := resolveTimeout(time.Duration(c.Timeout)); err != nil {
+		return fmt.Errorf("invalid dialer timeout %s: %w", c.Timeout, err)
+	}
+	if c.IdleTimeout < 0 {
+		return fmt.Errorf("invalid idle timeout %s", c.IdleTimeout)
+	}
+	if c.MaxMessageSize < 0 {
+		return fmt.Errorf("invalid maximum message size %d", c.MaxMessageSize)
+	}
+	if c.MaxDialsPerPeer < 0 || c.MaxDialsPerAddr < 0 {
+		return fmt.Errorf("invalid dial limits %d per peer, %d per address", c.MaxDialsPerPeer, c.MaxDialsPerAddr)
+	}
+	if _, err := parseRanges(c.BlockedRanges, "blocked"); err != nil {
+		return err
+	}
+	if _, err := builtinCompressions(c.Compression); err != nil {
+		return err
+	}
+	if c.Breaker != nil {
+		if err := c.Breaker.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.WriteLimit != nil {
+		if err := c.WriteLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.WriteBatching != nil {
+		if err := c.WriteBatching.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.BufferSizes != nil {
+		if err := c.BufferSizes.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.TCPOptions != nil {
+		if err := c.TCPOptions.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.HandshakePool != nil {
+		if err := c.HandshakePool.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.DialThrottle != nil {
+		return c.DialThrottle.Validate()
+	}
+	return nil
+}
+
+// Validate checks the configuration, without building anything.
+func (c *BreakerConfig) Validate() error {
+	switch {
+	case c.MinRequests < 0:
+		return fmt.Errorf("invalid breaker minimum requests %d", c.MinRequests)
+	case c.FailureRate < 0 || c.FailureRate > 1:
+		return fmt.Errorf("invalid breaker failure rate %g: must be between 0 and 1", c.FailureRate)
+	case c.Window < 0 || c.Cooldown < 0:
+		return fmt.Errorf("invalid breaker window %s or cooldown %s", c.Window, c.Cooldown)
+	case c.Key != "" && c.Key != "ip-prefix" && c.Key != "peer":
+		return fmt.Errorf("invalid breaker key %q: must be ip-prefix or peer", c.Key)
+	case c.IPv4PrefixBits < 0 || c.IPv4PrefixBits > 32:
+		return fmt.Errorf("invalid breaker IPv4 prefix length %d", c.IPv4PrefixBits)
+	case c.IPv6PrefixBits < 0 || c.IPv6PrefixBits > 128:
+		return fmt.Errorf("invalid breaker IPv6 prefix length %d", c.IPv6PrefixBits)
+	}
+	return nil
+}
+
+// Validate checks the configuration, without building anything.
+func (c *WriteLimitConfig) Validate() error {
+	if c.Rate <= 0 {
+		return fmt.Errorf("invalid write rate %d: must be positive", c.Rate)
+	}
+	if c.Quantum < 0 {
+		return fmt.Errorf("invalid write quantum %d", c.Quantum)
+	}
+	return nil
+}
+
+// Validate checks the configuration, without building anything.
+func (c *WriteBatchingConfig) Validate() error {
+	if c.Size < 0 {
+		return fmt.Errorf("invalid write batch size %d", c.Size)
+	}
+	if c.Delay < 0 {
+		return fmt.Errorf("invalid write batch delay %s", c.Delay)
+	}
+	return nil
+}
+
+// build returns the WriteBatching c configures.
+func (c *WriteBatchingConfig) build() *WriteBatching {
+	return &WriteBatching{Size: c.Size, Delay: time.Duration(c.Delay)}
+}
+
+// Validate checks the configuration, without building anything.
+func (c *TCPOptionsConfig) Validate() error {
+	if c.UserTimeout < 0 {
+		return fmt.Errorf("invalid TCP user timeout %s", c.UserTimeout)
+	}
+	return nil
+}
+
+// build returns the TCPOptions c configures.
+func (c *TCPOptionsConfig) build() *TCPOptions {
+	return &TCPOptions{
+		NoDelay:     c.NoDelay,
+		KeepAlive:   time.Duration(c.KeepAlive),
+		Linger:      c.Linger,
+		UserTimeout: time.Duration(c.UserTimeout),
+	}
+}
+
+// Validate checks the configuration, without building anything.
+func (c *HandshakePoolConfig) Validate() error {
+	if c.Workers < 0 {
+		return fmt.Errorf("invalid handshake workers %d", c.Workers)
+	}
+	if _, err := resolveTimeout(time.Duration(c.Hold)); err != nil {
+		return fmt.Errorf("invalid handshake hold %s: %w", c.Hold, err)
+	}
+	return nil
+}
+
+func (c *HandshakePoolConfig) build() *HandshakePool {
+	p := NewHandshakePool(c.Workers)
+	p.Hold = time.Duration(c.Hold)
+	return p
+}
+
+// Validate checks the configuration, without building anything.
+func (c *DialThrottleConfig) Validate() error {
+	if c.Limit < 0 {
+		return fmt.Errorf("invalid dial throttle limit %d", c.Limit)
+	}
+	return nil
+}
+
+// Validate checks the configuration, without building anything.
+func (c *ListenerConfig) Validate() error {
+	if _, err := resolveTimeout(time.Duration(c.AcceptTimeout)); err != nil {
+		return fmt.Errorf("invalid accept timeout %s: %w", c.AcceptTimeout, err)
+	}
+	if _, err := resolveTimeout(time.Duration(c.PreambleTimeout)); err != nil {
+		return fmt.Errorf("invalid preamble timeout %s: %w", c.PreambleTimeout, err)
+	}
+	if _, err := resolveTimeout(time.Duration(c.ProtectTimeout)); err != nil {
+		return fmt.Errorf("invalid protect timeout %s: %w", c.ProtectTimeout, err)
+	}
+	if _, err := resolveTimeout(time.Duration(c.SelectTimeout)); err != nil {
+		return fmt.Errorf("invalid select timeout %s: %w", c.SelectTimeout, err)
+	}
+	if _, err := resolveTimeout(time.Duration(c.SecureTimeout)); err != nil {
+		return fmt.Errorf("invalid secure timeout %s: %w", c.SecureTimeout, err)
+	}
+	if c.IdleTimeout < 0 {
+		return fmt.Errorf("invalid idle timeout %s", c.IdleTimeout)
+	}
+	if c.MaxMessageSize < 0 {
+		return fmt.Errorf("invalid maximum message size %d", c.MaxMessageSize)
+	}
+	if c.AcceptBacklog != nil && *c.AcceptBacklog < 0 {
+		return fmt.Errorf("invalid accept backlog %d", *c.AcceptBacklog)
+	}
+	if c.AcceptOverflow != nil {
+		if _, err := c.AcceptOverflow.MarshalText(); err != nil {
+			return err
+		}
+	}
+	if c.ReplayWindow != nil && *c.ReplayWindow < 0 {
+		return fmt.Errorf("invalid replay window %s", *c.ReplayWindow)
+	}
+	if _, err := parseRanges(c.BlockedRanges, "blocked"); err != nil {
+		return err
+	}
+	if _, err := parseRanges(c.AllowedRanges, "allowed"); err != nil {
+		return err
+	}
+	if _, err := parsePeers(c.AllowedPeers); err != nil {
+		return err
+	}
+	if _, err := builtinCompressions(c.Compression); err != nil {
+		return err
+	}
+	if c.WriteLimit != nil {
+		if err := c.WriteLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.WriteBatching != nil {
+		if err := c.WriteBatching.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.BufferSizes != nil {
+		if err := c.BufferSizes.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.TCPOptions != nil {
+		if err := c.TCPOptions.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.HandshakePool != nil {
+		return c.HandshakePool.Validate()
+	}
+	return nil
+}
+
+// NewDialerFromConfig is NewDialer, configured with c. Transport dialers
+// and the Protector still have to be set up before dialing.
+func NewDialerFromConfig(p peer.ID, pk ci.PrivKey, wrap ConnWrapper, c DialerConfig) (*Dialer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.SecurityProtocols != nil {
+		if err := checkSecurityProtocols(c.SecurityProtocols, pk); err != nil {
+			return nil, err
+		}
+	}
+
+	d := NewDialer(p, pk, wrap)
+	d.Timeout = time.Duration(c.Timeout)
+	d.IdleTimeout = time.Duration(c.IdleTimeout)
+	d.MessageMode = c.MessageMode
+	d.Optimistic = c.Optimistic
+	d.SecurityProtocols = c.SecurityProtocols
+	d.Coalesce = c.Coalesce
+	d.TrustedTransport = c.TrustedTransport
+	d.MaxMessageSize = c.MaxMessageSize
+	d.ExchangeObservedAddrs = c.ExchangeObservedAddrs
+	d.ChannelBinding = c.ChannelBinding
+	d.MaxDialsPerPeer = c.MaxDialsPerPeer
+	d.MaxDialsPerAddr = c.MaxDialsPerAddr
+	d.PNetFingerprint = c.PNetFingerprint
+	d.Filters, _ = rangeFilters(c.BlockedRanges, c.BlockPrivateRanges)
+	if b := c.Breaker; b != nil {
+		d.Breaker = &CircuitBreaker{
+			MinRequests: b.MinRequests,
+			FailureRate: b.FailureRate,
+			Window:      time.Duration(b.Window),
+			Cooldown:    time.Duration(b.Cooldown),
+			Key:         IPPrefixKey(orDefaultInt(b.IPv4PrefixBits, 24), orDefaultInt(b.IPv6PrefixBits, 48)),
+		}
+		if b.Key == "peer" {
+			d.Breaker.Key = PeerKey
+		}
+	}
+	if c.WriteLimit != nil {
+		d.Limiter = &WriteLimiter{Rate: c.WriteLimit.Rate, Quantum: c.WriteLimit.Quantum}
+	}
+	if c.WriteBatching != nil {
+		d.WriteBatching = c.WriteBatching.build()
+	}
+	d.BufferSizes = c.BufferSizes
+	if c.TCPOptions != nil {
+		d.TCPOptions = c.TCPOptions.build()
+	}
+	if c.HandshakePool != nil {
+		d.HandshakePool = c.HandshakePool.build()
+	}
+	if c.DialThrottle != nil {
+		d.DialThrottle = NewDialThrottle(c.DialThrottle.Limit)
+	}
+	d.Compression, _ = builtinCompressions(c.Compression)
+	return d, nil
+}
+
+// WrapTransportListenerFromConfig is WrapTransportListenerWithProtectors,
+// configured with c.
+func WrapTransportListenerFromConfig(ctx context.Context, ml transport.Listener, local peer.ID,
+	sk ci.PrivKey, protecs []ipnet.Protector, c ListenerConfig) (iconn.Listener, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.SecurityProtocols != nil {
+		if err := checkSecurityProtocols(c.SecurityProtocols, sk); err != nil {
+			return nil, err
+		}
+	}
+
+	params := defaultListenerParams()
+	if c.AcceptTimeout != 0 {
+		params.acceptTimeout = time.Duration(c.AcceptTimeout)
+	}
+	if c.PreambleTimeout != 0 {
+		params.preambleTimeout = time.Duration(c.PreambleTimeout)
+	}
+	if c.ProtectTimeout != 0 {
+		params.stages.protect = time.Duration(c.ProtectTimeout)
+	}
+	if c.SelectTimeout != 0 {
+		params.stages.sel = time.Duration(c.SelectTimeout)
+	}
+	if c.SecureTimeout != 0 {
+		params.stages.secure = time.Duration(c.SecureTimeout)
+	}
+	if c.AcceptBacklog != nil {
+		params.backlog = *c.AcceptBacklog
+	}
+	if c.AcceptOverflow != nil {
+		params.overflow = *c.AcceptOverflow
+	}
+	if c.ReplayWindow != nil {
+		params.replayWindow = time.Duration(*c.ReplayWindow)
+	}
+
+	l, err := wrapTransportListener(ctx, ml, local, sk, protecs, params)
+	if err != nil {
+		return nil, err
+	}
+	l.messageMode = c.MessageMode
+	l.trusted = c.TrustedTransport
+	l.maxMsg = c.MaxMessageSize
+	l.exchangeObserved = c.ExchangeObservedAddrs
+	l.channelBinding = c.ChannelBinding
+	l.fingerprint = c.PNetFingerprint
+	l.idleTimeout = time.Duration(c.IdleTimeout)
+	if c.SecurityProtocols != nil {
+		l.mux = newSecurityMuxer(c.SecurityProtocols)
+	}
+	l.filters, _ = rangeFilters(c.BlockedRanges, c.BlockPrivateRanges)
+	if c.AllowedRanges != nil {
+		allowed, _ := parseRanges(c.AllowedRanges, "allowed")
+		l.allowed = filter.NewFilters()
+		for _, ipnet := range allowed {
+			l.allowed.AddDialFilter(ipnet)
+		}
+	}
+	if c.AllowedPeers != nil {
+		peers, _ := parsePeers(c.AllowedPeers)
+		l.allowlist = NewPeerAllowlist(peers...)
+	}
+	if c.WriteLimit != nil {
+		l.limiter = &WriteLimiter{Rate: c.WriteLimit.Rate, Quantum: c.WriteLimit.Quantum}
+	}
+	if c.WriteBatching != nil {
+		l.batching = c.WriteBatching.build()
+	}
+	l.buffers = c.BufferSizes
+	if c.TCPOptions != nil {
+		l.tcpOpts = c.TCPOptions.build()
+	}
+	if c.HandshakePool != nil {
+		l.hsPool = c.HandshakePool.build()
+	}
+	l.compression, _ = builtinCompressions(c.Compression)
+	return l, nil
+}
+
+// rangeFilters returns filters blocking the given CIDRs, and the private
+// ranges if private is set, or nil if there is nothing to block.
+func rangeFilters(cidrs []string, private bool) (*filter.Filters, error) {
+	if len(cidrs) == 0 && !private {
+		return nil, nil
+	}
+
+	blocked, err := parseRanges(cidrs, "blocked")
+	if err != nil {
+		return nil, err
+	}
+
+	fs := filter.NewFilters()
+	if private {
+		fs = NewPrivateRangeFilters()
+	}
+	for _, ipnet := range blocked {
+		fs.AddDialFilter(ipnet)
+	}
+	return fs, nil
+}
+
+func parseRanges(cidrs []string, what string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s range: %w", what, err)
+		}
+		ranges = append(ranges, ipnet)
+	}
+	return ranges, nil
+}
+
+func parsePeers(ids []string) ([]peer.ID, error) {
+	var peers []peer.ID
+	for _, s := range ids {
+		p, err := peer.IDB58Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed peer %q: %w", s, err)
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+func orDefaultInt(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
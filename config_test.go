@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestDialerConfig(t *testing.T) {
+	var c DialerConfig
+	err := json.Unmarshal([]byte(`{
+		"timeout": "15s",
+		"blockedRanges": ["203.0.113.0/24"],
+		"breaker": {"key": "peer", "cooldown": "1m"},
+		"writeLimit": {"rate": 1048576}
+	}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := tu.RandPeerNetParamsOrFatal(t)
+	d, err := NewDialerFromConfig(p.ID, p.PrivKey, nil, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Timeout != 15*time.Second || d.Breaker.Cooldown != time.Minute || d.Limiter.Rate != 1<<20 {
+		t.Fatalf("config not applied: %+v", d)
+	}
+	if d.Filters == nil {
+		t.Fatal("blocked ranges not applied")
+	}
+
+	out, err := json.Marshal(DialerConfig{Timeout: Duration(NoTimeout)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"timeout":"none"}` {
+		t.Fatalf("unexpected encoding %s", out)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	backlog := -1
+	overflow := OverflowPolicy(42)
+	for _, tc := range []struct {
+		c   interface{ Validate() error }
+		err string
+	}{
+		{&DialerConfig{Timeout: Duration(-2)}, "invalid dialer timeout"},
+		{&ListenerConfig{IdleTimeout: Duration(-time.Second)}, "invalid idle timeout"},
+		{&ListenerConfig{ProtectTimeout: Duration(-2)}, "invalid protect timeout"},
+		{&ListenerConfig{SelectTimeout: Duration(-2)}, "invalid select timeout"},
+		{&ListenerConfig{SecureTimeout: Duration(-2)}, "invalid secure timeout"},
+		{&DialerConfig{BlockedRanges: []string{"10.0.0.0"}}, "invalid blocked range"},
+		{&ListenerConfig{AllowedRanges: []string{"10.0.0.0/33"}}, "invalid allowed range"},
+		{&ListenerConfig{AllowedPeers: []string{"0"}}, "invalid allowed peer"},
+		{&DialerConfig{Breaker: &BreakerConfig{FailureRate: 2}}, "invalid breaker failure rate"},
+		{&DialerConfig{Breaker: &BreakerConfig{Key: "asn"}}, "invalid breaker key"},
+		{&DialerConfig{WriteLimit: &WriteLimitConfig{}}, "invalid write rate"},
+		{&DialerConfig{WriteBatching: &WriteBatchingConfig{Size: -1}}, "invalid write batch size"},
+		{&ListenerConfig{WriteBatching: &WriteBatchingConfig{Delay: Duration(-1)}}, "invalid write batch delay"},
+		{&DialerConfig{BufferSizes: &BufferSizes{SocketRead: -1}}, "invalid socket buffer sizes"},
+		{&ListenerConfig{BufferSizes: &BufferSizes{WriteChunk: -1}}, "invalid write chunk size"},
+		{&DialerConfig{TCPOptions: &TCPOptionsConfig{UserTimeout: Duration(-1)}}, "invalid TCP user timeout"},
+		{&DialerConfig{HandshakePool: &HandshakePoolConfig{Workers: -1}}, "invalid handshake workers"},
+		{&ListenerConfig{HandshakePool: &HandshakePoolConfig{Workers: -1}}, "invalid handshake workers"},
+		{&ListenerConfig{HandshakePool: &HandshakePoolConfig{Hold: Duration(-5)}}, "invalid handshake hold"},
+		{&DialerConfig{MaxDialsPerPeer: -1}, "invalid dial limits"},
+		{&DialerConfig{DialThrottle: &DialThrottleConfig{Limit: -1}}, "invalid dial throttle limit"},
+		{&DialerConfig{Compression: []string{"/snappy"}}, "unknown compression"},
+		{&ListenerConfig{AcceptBacklog: &backlog}, "invalid accept backlog"},
+		{&ListenerConfig{AcceptOverflow: &overflow}, "invalid overflow policy"},
+	} {
+		err := tc.c.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%+v: expected %q, got %v", tc.c, tc.err, err)
+		}
+	}
+
+	var c ListenerConfig
+	if err := json.Unmarshal([]byte(`{"acceptOverflow": "drop-oldest", "replayWindow": "0s"}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if *c.AcceptOverflow != OverflowDropOldest || *c.ReplayWindow != 0 {
+		t.Fatalf("unexpected listener config %+v", c)
+	}
+}
@@ -2,14 +2,18 @@ package conn
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	logging "github.com/ipfs/go-log"
 	ic "github.com/libp2p/go-libp2p-crypto"
 	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
 	lgbl "github.com/libp2p/go-libp2p-loggables"
 	peer "github.com/libp2p/go-libp2p-peer"
 	tpt "github.com/libp2p/go-libp2p-transport"
@@ -19,6 +23,12 @@ import (
 
 var log = logging.Logger("conn")
 
+// MaxWriteChunk is the largest slice of a Write call handed to the
+// underlying connection at once. When a write deadline is set, it is
+// refreshed before every chunk, so large writes only time out when they
+// stop making progress.
+var MaxWriteChunk = 256 * 1024
+
 // ReleaseBuffer puts the given byte array back into the appropriate
 // global buffer pool based on its capacity.
 func ReleaseBuffer(b []byte) {
@@ -26,28 +36,126 @@ func ReleaseBuffer(b []byte) {
 	mpool.ByteSlicePool.Put(uint32(cap(b)), b)
 }
 
+// ErrHalfCloseUnsupported is returned by CloseRead and CloseWrite when the
+// underlying transport connection can't be half-closed.
+var ErrHalfCloseUnsupported = errors.New("underlying connection doesn't support half-close")
+
+// errConnClosed is returned by reads and writes waiting on a limiter, or
+// on a read in the background, when their conn is closed.
+var errConnClosed = errors.New("use of closed conn")
+
+// openConns counts the conns created by this package that have not been
+// closed yet.
+var openConns int64
+
+// OpenConns returns how many conns created by this package are still
+// open, to check for leaks. See conntesting.AssertNoConnLeaks.
+func OpenConns() int {
+	return int(atomic.LoadInt64(&openConns))
+}
+
+// HalfCloser is implemented by conns that can shut down a single direction,
+// like net.TCPConn.
+type HalfCloser interface {
+	// CloseWrite shuts down the writing side; the remote reads EOF.
+	CloseWrite() error
+	// CloseRead shuts down the reading side.
+	CloseRead() error
+}
+
 // singleConn represents a single connection to another Peer (IPFS Node).
 type singleConn struct {
+	id     uint64 // see ConnID
 	local  peer.ID
 	remote peer.ID
 	maconn tpt.Conn
 
+	established time.Time
+	affinity    string
+	purpose     string
+
+	// accepted is when the transport listener returned maconn, on
+	// inbound conns, and protector the one of its protectors it matched.
+	accepted  time.Time
+	protector ipnet.Protector
+
+	// passthrough is set when maconn comes straight from the transport,
+	// with no protector or wrapper transforming the bytes.
+	passthrough bool
+
+	// trusted is set when the transport authenticated remote, with
+	// remoteKey, if it knows it.
+	trusted   bool
+	remoteKey ic.PubKey
+
+	// identified is set when the remote sent its key in plaintext, see
+	// PlaintextIdentityTag.
+	identified bool
+
+	writeTimeout int64 // time.Duration, accessed atomically
+	writeChunk   int   // MaxWriteChunk if zero
+
+	limiter       *WriteLimiter
+	limitKey      atomic.Value // string
+	writeDeadline writeDeadline
+	reporter      BandwidthReporter
+	stats         connStats
+
+	// idleClosed is set, atomically, once the conn is closed for being
+	// idle, see watchIdle.
+	idleClosed int32
+
+	tags
+	observed
+	rttEstimator
+	negotiated
+
+	msgFramer
+
+	// scope holds the resources of the conn, released on Close.
+	scope ResourceScope
+
+	// ctx is canceled on Close, see ConnContext.
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	eventMu sync.Mutex
 	event   io.Closer
 }
 
 // newConn constructs a new connection
-func newSingleConn(ctx context.Context, local, remote peer.ID, maconn tpt.Conn) iconn.Conn {
+func newSingleConn(ctx context.Context, local, remote peer.ID, maconn tpt.Conn) *singleConn {
 	ml := lgbl.Dial("conn", local, remote, maconn.LocalMultiaddr(), maconn.RemoteMultiaddr())
+	affinity := affinityFrom(ctx)
+	if affinity != "" {
+		ml["affinity"] = affinity
+	}
+	purpose := purposeFrom(ctx)
+	if purpose != "" {
+		ml["purpose"] = purpose
+	}
+
+	id := connIDFrom(ctx)
+	ml["conn"] = id
 
 	conn := &singleConn{
+		id:     id,
 		local:  local,
 		remote: remote,
 		maconn: maconn,
 		event:  log.EventBegin(ctx, "connLifetime", ml),
+
+		established: time.Now(),
+		affinity:    affinity,
+		purpose:     purpose,
 	}
+	conn.msgFramer.rw = conn
+	conn.ctx, conn.cancel = context.WithCancel(valuesOnly{ctx})
+	conn.bindLifetime(ctx)
+	atomic.AddInt64(&openConns, 1)
+	trackConn(conn)
 
-	log.Debugf("newSingleConn %p: %v to %v", conn, local, remote)
+	log.Debugf("newSingleConn %d: %v to %v", id, local, remote)
 	return conn
 }
 
@@ -57,7 +165,13 @@ func (c *singleConn) Close() error {
 	if c.event != nil {
 		evt := c.event
 		c.event = nil
+		atomic.AddInt64(&openConns, -1)
+		untrackConn(c)
 		defer evt.Close()
+		if c.scope != nil {
+			defer c.scope.Done()
+		}
+		defer c.cancel()
 	}
 	c.eventMu.Unlock()
 
@@ -71,15 +185,15 @@ func (c *singleConn) ID() string {
 }
 
 func (c *singleConn) String() string {
-	return iconn.String(c, "singleConn")
+	return describeConn("singleConn", c)
 }
 
 func (c *singleConn) LocalAddr() net.Addr {
-	return c.maconn.LocalAddr()
+	return netAddr(c.maconn.LocalAddr(), c.maconn.LocalMultiaddr())
 }
 
 func (c *singleConn) RemoteAddr() net.Addr {
-	return c.maconn.RemoteAddr()
+	return netAddr(c.maconn.RemoteAddr(), c.maconn.RemoteMultiaddr())
 }
 
 func (c *singleConn) LocalPrivateKey() ic.PrivKey {
@@ -87,10 +201,12 @@ func (c *singleConn) LocalPrivateKey() ic.PrivKey {
 }
 
 func (c *singleConn) RemotePublicKey() ic.PubKey {
-	return nil
+	return c.remoteKey
 }
 
 func (c *singleConn) SetDeadline(t time.Time) error {
+	setWriteTimeout(&c.writeTimeout, t)
+	c.writeDeadline.store(t)
 	return c.maconn.SetDeadline(t)
 }
 func (c *singleConn) SetReadDeadline(t time.Time) error {
@@ -98,6 +214,8 @@ func (c *singleConn) SetReadDeadline(t time.Time) error {
 }
 
 func (c *singleConn) SetWriteDeadline(t time.Time) error {
+	setWriteTimeout(&c.writeTimeout, t)
+	c.writeDeadline.store(t)
 	return c.maconn.SetWriteDeadline(t)
 }
 
@@ -125,12 +243,170 @@ func (c *singleConn) RemotePeer() peer.ID {
 	return c.remote
 }
 
+// CloseWrite shuts down the writing side of the underlying connection.
+func (c *singleConn) CloseWrite() error {
+	hc, ok := unwrapConn(c.maconn, isHalfCloser).(HalfCloser)
+	if !ok {
+		return ErrHalfCloseUnsupported
+	}
+	return hc.CloseWrite()
+}
+
+// CloseRead shuts down the reading side of the underlying connection.
+func (c *singleConn) CloseRead() error {
+	hc, ok := unwrapConn(c.maconn, isHalfCloser).(HalfCloser)
+	if !ok {
+		return ErrHalfCloseUnsupported
+	}
+	return hc.CloseRead()
+}
+
+func isHalfCloser(c net.Conn) bool {
+	_, ok := c.(HalfCloser)
+	return ok
+}
+
+// unwrapConn returns the first conn, starting at c and descending through
+// embedded net.Conn fields (transports and manet wrap the raw socket like
+// this), for which match returns true. It returns nil if there is none.
+func unwrapConn(c net.Conn, match func(net.Conn) bool) net.Conn {
+	connType := reflect.TypeOf((*net.Conn)(nil)).Elem()
+	for c != nil {
+		if match(c) {
+			return c
+		}
+
+		v := reflect.ValueOf(c)
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return nil
+		}
+
+		var inner net.Conn
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if !v.Type().Field(i).Anonymous || !f.CanInterface() || !f.Type().Implements(connType) {
+				continue
+			}
+			if nc, ok := f.Interface().(net.Conn); ok {
+				inner = nc
+				break
+			}
+		}
+		c = inner
+	}
+	return nil
+}
+
 // Read reads data, net.Conn style
 func (c *singleConn) Read(buf []byte) (int, error) {
-	return c.maconn.Read(buf)
+	n, err := c.maconn.Read(buf)
+	c.reportRecv(n)
+	return n, c.idleErr(err)
 }
 
 // Write writes data, net.Conn style
 func (c *singleConn) Write(buf []byte) (int, error) {
-	return c.maconn.Write(buf)
+	var n int
+	var err error
+	if c.limiter != nil {
+		n, err = c.limiter.write(c.limitKey.Load().(string), c.write, buf, &c.writeDeadline, c.ctx.Done())
+	} else {
+		n, err = c.write(buf)
+	}
+	c.reportSent(n)
+	return n, c.idleErr(err)
+}
+
+func (c *singleConn) write(buf []byte) (int, error) {
+	chunk := resolveWriteChunk(c.writeChunk)
+	if len(buf) <= chunk {
+		return c.maconn.Write(buf)
+	}
+	return writeChunked(c.maconn, c.maconn.SetWriteDeadline, &c.writeTimeout, chunk, buf)
+}
+
+// ReadFrom implements io.ReaderFrom. On passthrough conns, the copy is
+// handed to the underlying socket, so the kernel can splice or sendfile
+// instead of copying through userspace. Rate limited conns can't do that.
+func (c *singleConn) ReadFrom(r io.Reader) (int64, error) {
+	if c.passthrough && c.limiter == nil {
+		if rf, ok := unwrapConn(c.maconn, isReaderFrom).(io.ReaderFrom); ok {
+			n, err := rf.ReadFrom(r)
+			c.reportSent(int(n))
+			return n, err
+		}
+	}
+	buf := msgPool.Get(copyBufferSize)
+	defer msgPool.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{c}, r, buf)
+}
+
+// WriteTo implements io.WriterTo, like ReadFrom.
+func (c *singleConn) WriteTo(w io.Writer) (int64, error) {
+	if c.passthrough {
+		if wt, ok := unwrapConn(c.maconn, isWriterTo).(io.WriterTo); ok {
+			n, err := wt.WriteTo(w)
+			c.reportRecv(int(n))
+			return n, err
+		}
+	}
+	buf := msgPool.Get(copyBufferSize)
+	defer msgPool.Put(buf)
+	return io.CopyBuffer(w, struct{ io.Reader }{c}, buf)
+}
+
+// copyBufferSize is the size of the pooled buffers of ReadFrom and
+// WriteTo, when they copy through userspace.
+const copyBufferSize = 32 * 1024
+
+func isReaderFrom(c net.Conn) bool {
+	_, ok := c.(io.ReaderFrom)
+	return ok
+}
+
+func isWriterTo(c net.Conn) bool {
+	_, ok := c.(io.WriterTo)
+	return ok
+}
+
+// setWriteTimeout records the write deadline t as a duration from now,
+// so it can be reapplied to every chunk of a large write.
+func setWriteTimeout(timeout *int64, t time.Time) {
+	var d time.Duration
+	if !t.IsZero() {
+		d = time.Until(t)
+		if d <= 0 {
+			d = -1 // already expired, never refresh it.
+		}
+	}
+	atomic.StoreInt64(timeout, int64(d))
+}
+
+// writeChunked writes buf to w in chunks of at most max bytes.
+// If a write deadline is in effect, it is pushed forward before each chunk.
+func writeChunked(w io.Writer, setDeadline func(time.Time) error, timeout *int64, max int, buf []byte) (int, error) {
+	var written int
+	for len(buf) > 0 {
+		chunk := buf
+		if len(chunk) > max {
+			chunk = chunk[:max]
+		}
+
+		if d := time.Duration(atomic.LoadInt64(timeout)); d > 0 {
+			if err := setDeadline(time.Now().Add(d)); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		buf = buf[n:]
+	}
+	return written, nil
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"runtime"
 	"sync"
 	"testing"
@@ -139,3 +141,120 @@ func TestCloseLeak(t *testing.T) {
 		t.Fatal("leaking goroutines:", ngr)
 	}
 }
+
+func TestLargeWriteRefreshesDeadline(t *testing.T) {
+	defer func(old int) {
+		MaxWriteChunk = old
+	}(MaxWriteChunk)
+	MaxWriteChunk = 64 * 1024
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c1, c2, _, _ := setupSingleConn(t, ctx)
+	defer c1.Close()
+	defer c2.Close()
+
+	// read slowly, so the whole write takes much longer than the deadline,
+	// while every single chunk makes it through in time.
+	data := bytes.Repeat([]byte{'a'}, 128*MaxWriteChunk)
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, MaxWriteChunk)
+		var total int
+		for total < len(data) {
+			n, err := c2.Read(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			total += n
+			time.Sleep(time.Millisecond)
+		}
+		done <- nil
+	}()
+
+	if err := c1.SetWriteDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c1.Write(data)
+	if err != nil {
+		t.Fatalf("large write failed after %d bytes: %s", n, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testHalfCloseEcho(t *testing.T, c1, c2 iconn.Conn) {
+	done := make(chan error, 1)
+	go func() {
+		// echo until EOF, then signal we are done too.
+		if _, err := io.Copy(c2, c2); err != nil {
+			done <- err
+			return
+		}
+		done <- c2.(HalfCloser).CloseWrite()
+	}()
+
+	m := []byte("hello")
+	if _, err := c1.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.(HalfCloser).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadAll(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m, out) {
+		t.Fatalf("echo mismatch: %s != %s", m, out)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c1, c2, _, _ := setupSingleConn(t, ctx)
+	defer c1.Close()
+	defer c2.Close()
+
+	testHalfCloseEcho(t, c1, c2)
+}
+
+func TestReadFromWriteTo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c1, c2, _, _ := setupSingleConn(t, ctx)
+	defer c1.Close()
+	defer c2.Close()
+
+	if !c1.(*singleConn).passthrough || !c2.(*singleConn).passthrough {
+		t.Fatal("unwrapped conns should be passthrough")
+	}
+
+	data := bytes.Repeat([]byte("beep"), 100000)
+	done := make(chan error, 1)
+	go func() {
+		if _, err := c1.(io.ReaderFrom).ReadFrom(bytes.NewReader(data)); err != nil {
+			done <- err
+			return
+		}
+		done <- c1.(HalfCloser).CloseWrite()
+	}()
+
+	var out bytes.Buffer
+	if _, err := c2.(io.WriterTo).WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, out.Bytes()) {
+		t.Fatal("data mangled in transit")
+	}
+}
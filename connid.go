@@ -0,0 +1,69 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+)
+
+// connIDs numbers the conns, dialed or accepted, from 1.
+var connIDs uint64
+
+func nextConnID() uint64 {
+	return atomic.AddUint64(&connIDs, 1)
+}
+
+type connIDKey struct{}
+
+// withConnIDValue makes the conn created with ctx take id, picked before
+// the upgrade so that its log lines have it too.
+func withConnIDValue(ctx context.Context, id uint64) context.Context {
+	return context.WithValue(ctx, connIDKey{}, id)
+}
+
+// connIDFrom returns the ID set on ctx, or the next one.
+func connIDFrom(ctx context.Context) uint64 {
+	if id, ok := ctx.Value(connIDKey{}).(uint64); ok {
+		return id
+	}
+	return nextConnID()
+}
+
+// ConnIDInfo is implemented by the conns returned by Dial and Accept.
+type ConnIDInfo interface {
+	// ConnID returns the number of the conn, unique to the process, and
+	// greater than the ones of the conns the process started upgrading
+	// before it. The log lines about the conn start with "conn <ID>: ",
+	// and its RecentEvents carry it, as do the debug console and String.
+	ConnID() uint64
+}
+
+func (c *singleConn) ConnID() uint64 {
+	return c.id
+}
+
+func (c *secureConn) ConnID() uint64 {
+	return connIDOf(c.insecure)
+}
+
+// connIDOf returns the ID of c, or zero if it has none.
+func connIDOf(c interface{}) uint64 {
+	if ci, ok := c.(ConnIDInfo); ok {
+		return ci.ConnID()
+	}
+	return 0
+}
+
+// describeConn describes c as
+//
+//	<singleConn 42 outbound QmLocal (/ip4/...) <-> QmRemote (/ip4/...)>
+func describeConn(typ string, c iconn.Conn) string {
+	dir := "outbound"
+	if st, ok := c.(StatInfo); ok && st.Stat().Inbound {
+		dir = "inbound"
+	}
+	return fmt.Sprintf("<%s %d %s %s (%s) <-> %s (%s)>", typ, connIDOf(c), dir,
+		c.LocalPeer(), c.LocalMultiaddr(), c.RemotePeer(), c.RemoteMultiaddr())
+}
@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnID(t *testing.T) {
+	ctx := context.Background()
+	a, b := net.Pipe()
+	c1 := newSingleConn(ctx, "a", "b", pipeConn{a})
+	c2 := newSingleConn(ctx, "b", "a", pipeConn{b})
+	c2.accepted = time.Now()
+	defer c1.Close()
+	defer c2.Close()
+
+	if c1.ConnID() == 0 || c2.ConnID() <= c1.ConnID() {
+		t.Fatalf("expected increasing IDs, got %d then %d", c1.ConnID(), c2.ConnID())
+	}
+	for _, c := range []struct {
+		conn *singleConn
+		dir  string
+	}{{c1, "outbound"}, {c2, "inbound"}} {
+		s := c.conn.String()
+		for _, want := range []string{fmt.Sprintf("singleConn %d %s", c.conn.id, c.dir), c.conn.local.String(), c.conn.remote.String()} {
+			if !strings.Contains(s, want) {
+				t.Errorf("%q should mention %q", s, want)
+			}
+		}
+	}
+
+	// the ID picked for the upgrade sticks.
+	id := nextConnID()
+	x, y := net.Pipe()
+	defer y.Close()
+	c3 := newSingleConn(withConnIDValue(ctx, id), "a", "b", pipeConn{x})
+	defer c3.Close()
+	if c3.ConnID() != id {
+		t.Fatalf("expected ID %d, got %d", id, c3.ConnID())
+	}
+}
+
+func TestConnIDEvents(t *testing.T) {
+	l := &listener{history: newEventRing(1)}
+	l.history.addConn("accept", 42, "a", nil, nil)
+	it := l.RecentEvents(-1)
+	if !it.Next() || it.Event().ConnID != 42 {
+		t.Fatal("expected the event of conn 42")
+	}
+}
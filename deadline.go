@@ -0,0 +1,133 @@
+package conn
+
+import (
+	"net"
+	"time"
+)
+
+// errDeadline is returned by secureConn reads when the read deadline
+// passed. It is a net.Error, like the ones of the underlying conns.
+var errDeadline net.Error = deadlineError{}
+
+type deadlineError struct{}
+
+func (deadlineError) Error() string   { return "i/o timeout" }
+func (deadlineError) Timeout() bool   { return true }
+func (deadlineError) Temporary() bool { return true }
+
+// secureRead is the outcome of a read from the secio session.
+type secureRead struct {
+	data []byte
+	err  error
+}
+
+// setReadDeadline records the read deadline of a secureConn. It is not
+// set on the underlying conn: a read timing out there would cut a frame
+// in half, and nothing could be decrypted after that. Reads that miss
+// the deadline go on in the background instead, and their result is
+// returned by the next read, until Close ends them. See
+// readWithDeadline.
+func (c *secureConn) setReadDeadline(t time.Time) {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	c.readDeadline = t
+	if c.deadlineSet != nil {
+		close(c.deadlineSet)
+	}
+	c.deadlineSet = make(chan struct{})
+}
+
+// deadline returns the read deadline, and a channel closed when it
+// changes. The channel is nil if no deadline was ever set.
+func (c *secureConn) deadline() (time.Time, chan struct{}) {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	return c.readDeadline, c.deadlineSet
+}
+
+// usesDeadlines tells whether a read deadline was ever set.
+func (c *secureConn) usesDeadlines() bool {
+	_, changed := c.deadline()
+	return changed != nil
+}
+
+// readWithDeadline runs read, unless one is still running from a
+// previous call, and waits for it until the read deadline. Once a
+// deadline was set, reads run in the background even without one, so
+// that setting another one applies to the reads in progress. Reads must
+// be serialized by the caller. Once c is closed, it returns
+// errConnClosed.
+func (c *secureConn) readWithDeadline(read func() ([]byte, error)) ([]byte, error) {
+	dl, changed := c.deadline()
+	if c.pending == nil {
+		if changed == nil {
+			return read()
+		}
+
+		if c.results == nil {
+			c.results = make(chan secureRead, 1)
+		}
+		pending := c.results
+		c.pending = pending
+		go func() {
+			data, err := read()
+			pending <- secureRead{data, err}
+		}()
+	}
+
+	for {
+		var expired <-chan time.Time
+		if !dl.IsZero() {
+			d := time.Until(dl)
+			if d <= 0 {
+				return nil, errDeadline
+			}
+			expired = c.armReadTimer(d)
+		}
+
+		select {
+		case r := <-c.pending:
+			c.pending = nil
+			c.stopReadTimer()
+			return r.data, r.err
+		case <-expired:
+		case <-changed:
+			c.stopReadTimer()
+		case <-c.done:
+			c.stopReadTimer()
+			return nil, errConnClosed
+		}
+		dl, changed = c.deadline()
+	}
+}
+
+// endBackgroundRead makes a read running in the background, if any,
+// return, by expiring the read deadline of the underlying conn. It is
+// only used on Close: the frame being read is lost.
+func (c *secureConn) endBackgroundRead() {
+	if c.usesDeadlines() {
+		c.insecure.SetReadDeadline(time.Now())
+	}
+}
+
+// armReadTimer sets the read timer of c to fire after d, and returns its
+// channel. The timer is reused across reads, which are serialized.
+func (c *secureConn) armReadTimer(d time.Duration) <-chan time.Time {
+	if c.readTimer == nil {
+		c.readTimer = time.NewTimer(d)
+	} else {
+		c.readTimer.Reset(d)
+	}
+	return c.readTimer.C
+}
+
+// stopReadTimer stops the read timer, if running, and drains it.
+func (c *secureConn) stopReadTimer() {
+	if c.readTimer != nil && !c.readTimer.Stop() {
+		select {
+		case <-c.readTimer.C:
+		default:
+		}
+	}
+}
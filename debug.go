@@ -0,0 +1,283 @@
+//go:build conndebug
+// +build conndebug
+
+package conn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tpt "github.com/libp2p/go-libp2p-transport"
+)
+
+// debugConns holds every open singleConn, by ConnID.
+var debugConns = struct {
+	sync.Mutex
+	conns map[uint64]*debugConn
+}{conns: make(map[uint64]*debugConn)}
+
+// debugConn sits between a singleConn and its transport conn, to count
+// traffic and let the console pause it. Zero-copy ReadFrom and WriteTo on
+// passthrough conns go around it, so they are neither counted nor paused.
+type debugConn struct {
+	tpt.Conn
+
+	id      uint64 // ConnID of conn
+	conn    *singleConn
+	created time.Time
+
+	read    int64 // accessed atomically
+	written int64 // accessed atomically
+
+	mu      sync.Mutex
+	resumed chan struct{} // nil unless paused
+}
+
+func trackConn(c *singleConn) {
+	dc := &debugConn{Conn: c.maconn, conn: c, created: time.Now()}
+	c.maconn = dc
+
+	debugConns.Lock()
+	dc.id = c.id
+	debugConns.conns[dc.id] = dc
+	debugConns.Unlock()
+}
+
+func untrackConn(c *singleConn) {
+	dc, ok := c.maconn.(*debugConn)
+	if !ok {
+		return
+	}
+	debugConns.Lock()
+	delete(debugConns.conns, dc.id)
+	debugConns.Unlock()
+	dc.resume()
+}
+
+func (dc *debugConn) pause() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.resumed == nil {
+		dc.resumed = make(chan struct{})
+	}
+}
+
+func (dc *debugConn) resume() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.resumed != nil {
+		close(dc.resumed)
+		dc.resumed = nil
+	}
+}
+
+func (dc *debugConn) paused() bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.resumed != nil
+}
+
+func (dc *debugConn) wait() {
+	dc.mu.Lock()
+	ch := dc.resumed
+	dc.mu.Unlock()
+	if ch != nil {
+		<-ch
+	}
+}
+
+func (dc *debugConn) Read(b []byte) (int, error) {
+	dc.wait()
+	n, err := dc.Conn.Read(b)
+	atomic.AddInt64(&dc.read, int64(n))
+	return n, err
+}
+
+func (dc *debugConn) Write(b []byte) (int, error) {
+	dc.wait()
+	n, err := dc.Conn.Write(b)
+	atomic.AddInt64(&dc.written, int64(n))
+	return n, err
+}
+
+func (dc *debugConn) summary() string {
+	c := dc.conn
+	s := fmt.Sprintf("%d\t%s -> %s\t%s -> %s\t%s", dc.id, c.local, c.remote,
+		dc.LocalMultiaddr(), dc.RemoteMultiaddr(), time.Since(dc.created).Round(time.Second))
+	if c.affinity != "" {
+		s += "\taffinity=" + c.affinity
+	}
+	if c.purpose != "" {
+		s += "\tpurpose=" + c.purpose
+	}
+	if dc.paused() {
+		s += "\tpaused"
+	}
+	return s
+}
+
+func (dc *debugConn) dump(w io.Writer) {
+	c := dc.conn
+	fmt.Fprintf(w, "id:            %d\n", dc.id)
+	fmt.Fprintf(w, "local peer:    %s\n", c.local)
+	fmt.Fprintf(w, "remote peer:   %s\n", c.remote)
+	fmt.Fprintf(w, "local addr:    %s\n", dc.LocalMultiaddr())
+	fmt.Fprintf(w, "remote addr:   %s\n", dc.RemoteMultiaddr())
+	fmt.Fprintf(w, "transport:     %T\n", dc.Conn)
+	fmt.Fprintf(w, "affinity:      %s\n", c.affinity)
+	fmt.Fprintf(w, "purpose:       %s\n", c.purpose)
+	fmt.Fprintf(w, "passthrough:   %t\n", c.passthrough)
+	fmt.Fprintf(w, "write timeout: %s\n", time.Duration(atomic.LoadInt64(&c.writeTimeout)))
+	fmt.Fprintf(w, "bytes read:    %d\n", atomic.LoadInt64(&dc.read))
+	fmt.Fprintf(w, "bytes written: %d\n", atomic.LoadInt64(&dc.written))
+	fmt.Fprintf(w, "age:           %s\n", time.Since(dc.created).Round(time.Millisecond))
+	fmt.Fprintf(w, "paused:        %t\n", dc.paused())
+}
+
+const debugConsoleHelp = `commands:
+  list          list open conns
+  dump <id>     show the state of a conn
+  pause <id>    block reads and writes on a conn
+  resume <id>   unblock a paused conn
+  close <id>    force-close a conn
+  latency <id> [<delay> <jitter>]
+                show or set the latency of a conn wrapped by a Latency
+  help          show this message
+  quit          end the session
+`
+
+// ServeDebugConsole serves an interactive debug console on a unix socket
+// at path, until the returned Closer is closed. Attach to it with, e.g.:
+//
+//	socat - UNIX-CONNECT:/tmp/conn.sock
+//
+// It is only available in builds with the conndebug tag.
+func ServeDebugConsole(path string) (io.Closer, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go debugSession(c)
+		}
+	}()
+	return l, nil
+}
+
+func debugSession(c net.Conn) {
+	defer c.Close()
+
+	fmt.Fprint(c, "> ")
+	s := bufio.NewScanner(c)
+	for s.Scan() {
+		args := strings.Fields(s.Text())
+		if len(args) > 0 {
+			if args[0] == "quit" || args[0] == "exit" {
+				return
+			}
+			debugCommand(c, args)
+		}
+		fmt.Fprint(c, "> ")
+	}
+}
+
+func debugCommand(w io.Writer, args []string) {
+	switch args[0] {
+	case "help":
+		fmt.Fprint(w, debugConsoleHelp)
+		return
+	case "list", "ls":
+		debugConns.Lock()
+		conns := make([]*debugConn, 0, len(debugConns.conns))
+		for _, dc := range debugConns.conns {
+			conns = append(conns, dc)
+		}
+		debugConns.Unlock()
+
+		sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+		for _, dc := range conns {
+			fmt.Fprintln(w, dc.summary())
+		}
+		fmt.Fprintf(w, "%d open conns\n", len(conns))
+		return
+	case "dump", "pause", "resume", "close", "latency":
+	default:
+		fmt.Fprintf(w, "unknown command %q, try help\n", args[0])
+		return
+	}
+
+	if len(args) != 2 && !(args[0] == "latency" && len(args) == 4) {
+		fmt.Fprintf(w, "usage: %s <id>\n", args[0])
+		return
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		fmt.Fprintf(w, "bad conn id %q\n", args[1])
+		return
+	}
+	debugConns.Lock()
+	dc := debugConns.conns[id]
+	debugConns.Unlock()
+	if dc == nil {
+		fmt.Fprintf(w, "no open conn %d\n", id)
+		return
+	}
+
+	switch args[0] {
+	case "dump":
+		dc.dump(w)
+	case "pause":
+		dc.pause()
+		fmt.Fprintf(w, "paused %d\n", id)
+	case "resume":
+		dc.resume()
+		fmt.Fprintf(w, "resumed %d\n", id)
+	case "close":
+		err := dc.conn.Close()
+		if err != nil {
+			fmt.Fprintf(w, "closed %d: %s\n", id, err)
+			return
+		}
+		fmt.Fprintf(w, "closed %d\n", id)
+	case "latency":
+		debugLatency(w, dc, args[2:])
+	}
+}
+
+func debugLatency(w io.Writer, dc *debugConn, args []string) {
+	lc, ok := dc.Conn.(*LatencyConn)
+	if !ok {
+		fmt.Fprintf(w, "conn %d has no latency\n", dc.id)
+		return
+	}
+	lat := lc.Latency()
+	if len(args) == 2 {
+		delay, err := time.ParseDuration(args[0])
+		if err != nil {
+			fmt.Fprintf(w, "bad delay %q\n", args[0])
+			return
+		}
+		jitter, err := time.ParseDuration(args[1])
+		if err != nil {
+			fmt.Fprintf(w, "bad jitter %q\n", args[1])
+			return
+		}
+		lat.Set(delay, jitter)
+	}
+	delay, jitter := lat.Get()
+	fmt.Fprintf(w, "latency %d: %s ± %s\n", dc.id, delay, jitter)
+}
@@ -0,0 +1,88 @@
+//go:build conndebug
+// +build conndebug
+
+package conn
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugConsole(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "conndebug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	console, err := ServeDebugConsole(filepath.Join(dir, "conn.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer console.Close()
+
+	c1, c2, _, _ := setupSingleConn(t, ctx)
+	defer c2.Close()
+	id := c1.(*singleConn).maconn.(*debugConn).id
+
+	sess, err := net.Dial("unix", filepath.Join(dir, "conn.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	r := bufio.NewReader(sess)
+	run := func(cmd string) string {
+		if cmd != "" {
+			fmt.Fprintln(sess, cmd)
+		}
+		var out strings.Builder
+		for !strings.HasSuffix(out.String(), "> ") {
+			b, err := r.ReadByte()
+			if err != nil {
+				t.Fatal(err)
+			}
+			out.WriteByte(b)
+		}
+		return out.String()
+	}
+	run("") // prompt
+
+	if out := run("list"); !strings.Contains(out, fmt.Sprintf("%d\t%s", id, c1.LocalPeer())) {
+		t.Fatalf("conn %d not listed:\n%s", id, out)
+	}
+
+	run(fmt.Sprintf("pause %d", id))
+	done := make(chan error, 1)
+	go func() {
+		_, err := c1.Write([]byte("hello"))
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("write went through a paused conn")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if out := run(fmt.Sprintf("dump %d", id)); !strings.Contains(out, "paused:        true") {
+		t.Fatalf("dump doesn't show the conn paused:\n%s", out)
+	}
+	run(fmt.Sprintf("resume %d", id))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	run(fmt.Sprintf("close %d", id))
+	if out := run("list"); strings.Contains(out, fmt.Sprintf("%d\t", id)) {
+		t.Fatalf("closed conn %d still listed:\n%s", id, out)
+	}
+}
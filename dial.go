@@ -2,9 +2,12 @@ package conn
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	addrutil "github.com/libp2p/go-addr-util"
@@ -14,15 +17,16 @@ import (
 	lgbl "github.com/libp2p/go-libp2p-loggables"
 	peer "github.com/libp2p/go-libp2p-peer"
 	transport "github.com/libp2p/go-libp2p-transport"
+	filter "github.com/libp2p/go-maddr-filter"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr-net"
-	msmux "github.com/multiformats/go-multistream"
 )
 
 // DialTimeout is the maximum duration a Dial is allowed to take.
 // This includes the time between dialing the raw network connection,
 // protocol selection as well the handshake, if applicable.
-var DialTimeout = 60 * time.Second
+// Zero means the default of 60 seconds, and NoTimeout disables it.
+var DialTimeout = defaultTimeout
 
 // Dialer is an object with a peer identity that can open connections.
 //
@@ -34,8 +38,15 @@ type Dialer struct {
 	// LocalAddrs is a set of local addresses to use.
 	//LocalAddrs []ma.Multiaddr
 
+	// ListenAddrs are the addresses the local peer listens on, as
+	// reported by its listeners, which the Dialer refuses to dial, whether
+	// given or resolved. See ErrDialToSelf.
+	ListenAddrs []ma.Multiaddr
+
 	// Dialers are the sub-dialers usable by this dialer,
 	// selected in order based on the address being dialed.
+	// Once the Dialer is in use, they must only change through
+	// AddDialer and RemoveDialer, which are safe for concurrent use.
 	Dialers []transport.Dialer
 
 	// PrivateKey used to initialize a secure connection.
@@ -47,10 +58,198 @@ type Dialer struct {
 	// Can be nil, then dialer is in public network.
 	Protector ipnet.Protector
 
+	// RotatedProtectors are other protectors for the same private network,
+	// typically using the previous key while the network rotates to the
+	// one of Protector. Dials to a peer start with the protector that
+	// last worked with it, and fall back on the others when protocol
+	// negotiation fails.
+	RotatedProtectors []ipnet.Protector
+
 	// Wrapper to wrap the raw connection. Can be nil.
 	Wrapper ConnWrapper
 
-	fallback transport.Dialer
+	// Timeout overrides DialTimeout for this dialer, if non-zero. Sub-dialers
+	// added WithDialTimeout override it in turn.
+	Timeout time.Duration
+
+	// Resolver resolves /dns, /dns4, /dns6 and /dnsaddr addresses before
+	// dialing; each address they stand for is dialed in turn until one
+	// works. Nil means net.DefaultResolver.
+	Resolver Resolver
+
+	// ResolverCacheTTL is how long lookups are reused. Zero means a
+	// minute, and a negative value disables caching.
+	ResolverCacheTTL time.Duration
+
+	// Filters, if set, refuses dials to the addresses it blocks, before
+	// and after resolution.
+	// See NewPrivateRangeFilters.
+	Filters *filter.Filters
+
+	// Breaker, if set, fails dials fast to destinations that keep
+	// failing. See CircuitBreaker.
+	Breaker *CircuitBreaker
+
+	// Limiter, if set, rate limits and schedules the writes of dialed
+	// conns. It may be shared with other Dialers and listeners.
+	Limiter *WriteLimiter
+
+	// MessageLimiter, if set, caps the rate at which secure dialed conns
+	// receive frames. It may be shared with other Dialers and listeners.
+	MessageLimiter *MessageLimiter
+
+	// HandshakePool, if set, bounds how many secio handshakes of dialed
+	// conns run at once. It may be shared with other Dialers and
+	// listeners.
+	HandshakePool *HandshakePool
+
+	// DialThrottle, if set, bounds how many transport dials of the
+	// Dialer are outstanding at once. It may be shared with other
+	// Dialers.
+	DialThrottle *DialThrottle
+
+	// Reporter, if set, is told about the traffic of dialed conns.
+	Reporter BandwidthReporter
+
+	// Upgrader, if set, has custom stages for dialed conns to go through.
+	// It may be shared with other Dialers and listeners.
+	Upgrader *Upgrader
+
+	// IdleTimeout, if positive, closes dialed conns once they have read
+	// and written nothing for that long, after which their reads and
+	// writes fail with errors matching ErrIdleTimeout. Conns waiting in
+	// the Pool count as idle.
+	IdleTimeout time.Duration
+
+	// MessageMode makes secure conns preserve message boundaries: each
+	// Write is sent as exactly one secio frame, and each Read returns
+	// exactly one frame, or io.ErrShortBuffer if it doesn't fit.
+	// It has no effect on insecure conns.
+	MessageMode bool
+
+	// WriteBatching, if set, makes secure conns batch small writes into
+	// fewer secio frames, for chatty protocols. See WriteBatching.
+	WriteBatching *WriteBatching
+
+	// Compression, if set, are the compressions secure conns propose once
+	// the handshake is done, in order of preference; the first one the
+	// remote supports compresses each frame, see CompressionInfo. It
+	// costs a round trip. Conns in MessageMode only propose
+	// NoCompressionTag. Listeners must be set up alike, with
+	// ListenerCompression, though with compressions of their own.
+	Compression []Compression
+
+	// Pool, if set, makes Dial and DialWithTimeout return a conn to the
+	// peer from the pool when there is one, instead of dialing. See
+	// ConnPool.
+	Pool *ConnPool
+
+	// Coalesce makes concurrent calls to Dial and DialWithTimeout for the
+	// same peer and address share a single dial: they all return the same
+	// conn, or error. Callers then share the conn, and must agree on
+	// which of them closes it. The timeout and context of the first call
+	// apply to the shared dial.
+	Coalesce bool
+
+	// MaxDialsPerPeer, if positive, is how many dials to a peer may be in
+	// flight at once, over all its addresses, and MaxDialsPerAddr how
+	// many to one of its addresses. Dials beyond them wait their turn,
+	// within their own timeouts. This keeps a DialAddrs over a long
+	// address list, or racing callers, from opening dozens of sockets to
+	// one peer. Dials to an unknown peer only count per address.
+	MaxDialsPerPeer int
+	MaxDialsPerAddr int
+
+	// SecurityProtocols are the security protocols to propose, in order of
+	// preference, SecioTag, NoEncryptionTag or PlaintextIdentityTag; the
+	// first one the remote supports is used, see HandshakeResult and
+	// Downgrades. Nil means secio, or plaintext without a PrivateKey.
+	SecurityProtocols []string
+
+	// Optimistic makes secure dials select secio without waiting for the
+	// listener to agree, sending the selection along with the first
+	// handshake message. This saves a round trip per dial, and works
+	// with any listener; when the listener doesn't support secio, the
+	// dial fails as it otherwise would, only later. It has no effect on
+	// insecure dials, but those of DialWithEarlyData, nor on
+	// DialSimOpen.
+	Optimistic bool
+
+	// TrustedTransport is set when the transports guarantee the identity
+	// of the remote peer themselves, like a WireGuard tunnel keyed to it.
+	// Conns then skip protocol selection and the secio handshake, and
+	// take their remote peer from the transport conn, which must
+	// implement TrustedConn. Filters, circuit breaking, limits and events
+	// apply as usual. Listeners must be set up alike, with
+	// ListenerTrustedTransport. Conns of SecureCapable transports are
+	// always treated so.
+	TrustedTransport bool
+
+	// ExchangeObservedAddrs makes conns tell the remote the address they
+	// see it at, once set up, and learn the one it sees us at, see
+	// ObservedAddrs. It costs a round trip. Listeners must be set up
+	// alike, with ListenerObservedAddrs.
+	ExchangeObservedAddrs bool
+
+	// ChannelBinding makes secure conns agree on a secret once the
+	// handshake is done, for ExportKeyingMaterial. The secret is
+	// exchanged over the secure channel, not derived from the secrets of
+	// the secio handshake. It costs a round trip, whose bytes count in
+	// the Stat of the conn. Listeners must be set up alike, with
+	// ListenerChannelBinding.
+	ChannelBinding bool
+
+	// PNetFingerprint makes protected conns check, before anything else,
+	// that both ends are in the same private network, so that dials with
+	// the wrong key fail right away with ErrPNetFingerprintMismatch
+	// instead of on garbled protocol selection. Listeners must be set up
+	// alike, with ListenerPNetFingerprint. Dials to listeners with several
+	// protectors need it, unless Optimistic, see
+	// WrapTransportListenerWithProtectors.
+	PNetFingerprint bool
+
+	// MaxMessageSize, if set, overrides MaxMessageSize for the conns of
+	// the Dialer: longer messages, and secio frames carrying more, fail
+	// with a MessageTooLargeError, from the handshake on, before
+	// anything is allocated for them. Peers writing chunks larger than
+	// that, see MaxWriteChunk, break their conns.
+	MaxMessageSize int
+
+	// ResourceManager, if set, reserves the resources of every dial
+	// before it starts, which fails with ErrResourceLimit if it can't.
+	// They are released when the conn is closed, or the dial failed.
+	ResourceManager ResourceManager
+
+	// Logger, if set, is what the Dialer logs with, instead of the
+	// package logger.
+	Logger Logger
+
+	// Audit, if set, receives an AuditRecord for every dial that failed
+	// once the transport connected.
+	Audit AuditSink
+
+	// Ranker, if set, is how DialAddrs orders addresses, instead of
+	// DefaultRanker.
+	Ranker AddressRanker
+
+	// BufferSizes, if set, tunes the buffers of the Dialer's conns.
+	BufferSizes *BufferSizes
+
+	// TCPOptions, if set, are socket options for the Dialer's TCP conns.
+	TCPOptions *TCPOptions
+
+	fallback  transport.Dialer
+	dialersMu sync.RWMutex // guards Dialers
+
+	misdials       misdialCache
+	protectorHints protectorHints
+	dnsCache       dnsCache
+	flights        dialFlights
+	downgrades     downgrades
+	history        eventRing
+	dials          dialRegistry
+	peerDials      dialSlots
+	addrDials      dialSlots
 }
 
 // NewDialer creates a new Dialer object.
@@ -73,129 +272,481 @@ func (d *Dialer) String() string {
 
 // Dial connects to a peer over a particular address.
 // The remote peer ID is only verified if secure connections are in use.
+// An empty remote takes whichever peer the handshake proves, as reported
+// by RemotePeer, for when only the address is known; it stays empty on
+// insecure conns.
 // It returns once the connection is established, the protocol negotiated,
-// and the handshake complete (if applicable).
-func (d *Dialer) Dial(ctx context.Context, raddr ma.Multiaddr, remote peer.ID) (c iconn.Conn, err error) {
-	deadline := time.Now().Add(DialTimeout)
-	ctx, cancel := context.WithDeadline(ctx, deadline)
+// and the handshake complete (if applicable). The context only covers
+// this setup, unless it comes from WithConnLifetime.
+func (d *Dialer) Dial(ctx context.Context, raddr ma.Multiaddr, remote peer.ID) (iconn.Conn, error) {
+	return d.DialWithTimeout(ctx, raddr, remote, 0)
+}
+
+// DialWithTimeout is like Dial, but timeout, if non-zero, overrides the
+// Dialer's timeout for this call only.
+func (d *Dialer) DialWithTimeout(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, timeout time.Duration) (iconn.Conn, error) {
+	if d.Pool != nil && remote != "" {
+		if err := d.checkDial(ctx, raddr, remote); err != nil {
+			return nil, err
+		}
+		if d.Breaker != nil {
+			if key := d.Breaker.key(raddr, remote); d.Breaker.blocks(key) {
+				return nil, &Error{Kind: ErrDialBackoff, Err: fmt.Errorf("circuit %s is open", key)}
+			}
+		}
+		if c := d.Pool.get(remote); c != nil {
+			log.Event(ctx, "connDialPooled", lgbl.Dial("conn", d.LocalPeer, remote, nil, raddr))
+			d.history.addEvent(ConnEvent{Type: "dialPooled", Remote: remote, Addr: raddr, Purpose: purposeFrom(ctx)})
+			return c, nil
+		}
+	}
+
+	dial := func() (iconn.Conn, error) {
+		return d.dial(ctx, raddr, remote, dialOpts{timeout: timeout, protecs: d.protectorsFor(remote)})
+	}
+	if !d.Coalesce {
+		return dial()
+	}
+
+	c, shared, err := d.flights.do(ctx, string(remote)+" "+raddr.String(), dial)
+	if shared {
+		log.Event(ctx, "connDialCoalesced", lgbl.Dial("conn", d.LocalPeer, remote, nil, raddr))
+		d.history.addEvent(ConnEvent{Type: "dialCoalesced", Remote: remote, Addr: raddr, Purpose: purposeFrom(ctx), Err: err})
+	}
+	return c, err
+}
+
+// DialWithProtector is like Dial, but protects the connection with protec
+// instead of the Dialer's Protector and RotatedProtectors. This lets a node
+// that bridges several private networks pick the network per destination.
+// A nil protec dials outside of any private network, which fails if
+// ipnet.ForcePrivateNetwork is set.
+func (d *Dialer) DialWithProtector(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, protec ipnet.Protector) (iconn.Conn, error) {
+	return d.dial(ctx, raddr, remote, dialOpts{protecs: []ipnet.Protector{protec}})
+}
+
+// DialSimOpen is like Dial, for when remote is dialing us at the same time,
+// as in TCP hole punching. The transport must dial from the address we
+// listen on, so that both dials end up as one simultaneously opened
+// connection, on which both sides believe they are the dialer. Because
+// multistream needs exactly one initiator, the peer with the smaller ID
+// takes that role, and the other one answers protocol selection like a
+// listener would. The secio handshake is symmetric, and needs no roles.
+//
+// Both peers must call DialSimOpen, and remote must be known.
+func (d *Dialer) DialSimOpen(ctx context.Context, raddr ma.Multiaddr, remote peer.ID) (iconn.Conn, error) {
+	if remote == "" || remote == d.LocalPeer {
+		return nil, fmt.Errorf("simultaneous open needs a remote peer other than ourselves, got %q", remote)
+	}
+	return d.dial(ctx, raddr, remote, dialOpts{
+		protecs:   d.protectorsFor(remote),
+		responder: d.LocalPeer > remote,
+	})
+}
+
+// dialOpts are the per-call parameters of a dial.
+type dialOpts struct {
+	// timeout overrides the Dialer's, if non-zero.
+	timeout time.Duration
+
+	// protecs are tried in order until protocol negotiation succeeds.
+	// There must be at least one, which may be nil.
+	protecs []ipnet.Protector
+
+	// responder makes us answer protocol selection instead of starting it.
+	responder bool
+
+	// key, if set, is the public key the remote must prove.
+	key ci.PubKey
+
+	// early is written to the conn before it is returned.
+	early []byte
+}
+
+// dial dials raddr with the given options.
+func (d *Dialer) dial(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, opts dialOpts) (c iconn.Conn, err error) {
+	protecs := opts.protecs
+	dflt, err := resolveTimeout(d.Timeout, DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := resolveTimeout(opts.timeout, d.subDialersTimeout(raddr, dflt), dflt)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
 	defer cancel()
+	defer d.dials.add(cancel)()
 
 	logdial := lgbl.Dial("conn", d.LocalPeer, remote, nil, raddr)
 	logdial["encrypted"] = (d.PrivateKey != nil) // log wether this will be an encrypted dial or not.
-	logdial["inPrivNet"] = (d.Protector != nil)
+	logdial["inPrivNet"] = (protecs[0] != nil)
+	if key := affinityFrom(ctx); key != "" {
+		logdial["affinity"] = key
+	}
+	purpose := purposeFrom(ctx)
+	if purpose != "" {
+		logdial["purpose"] = purpose
+	}
 
 	defer log.EventBegin(ctx, "connDial", logdial).Done()
 
-	if d.Protector == nil && ipnet.ForcePrivateNetwork {
-		log.Error("tried to dial with no Private Network Protector but usage" +
+	ctx, endSpan := startSpan(ctx, "conn.dial", map[string]interface{}{
+		"peer":    remote.Pretty(),
+		"address": raddr.String(),
+	})
+	defer func() { endSpan(err) }()
+
+	if protecs[0] == nil && ipnet.ForcePrivateNetwork {
+		d.logger().Errorf("tried to dial with no Private Network Protector but usage" +
 			" of Private Networks is forced by the enviroment")
-		return nil, ipnet.ErrNotInPrivateNetwork
+		return nil, ErrProtectorRequired
 	}
 
 	defer func() {
 		if err != nil {
+			err = d.misdials.annotate(raddr, remote, err)
 			logdial["error"] = err.Error()
 			logdial["dial"] = "failure"
 		}
+		actual := remote
+		if actual == "" {
+			actual = remotePeer(c)
+		}
+		d.history.addEvent(ConnEvent{Type: "dial", Remote: actual, Addr: raddr, Purpose: purpose, Err: err, ConnID: connIDOf(c)})
 	}()
 
-	maconn, err := d.rawConnDial(ctx, raddr, remote)
+	if err := d.checkDial(ctx, raddr, remote); err != nil {
+		return nil, err
+	}
+
+	release, err := d.acquireDialSlots(ctx, raddr, remote)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	defer func() {
-		if err != nil {
-			maconn.Close()
+	if d.Breaker != nil {
+		key := d.Breaker.key(raddr, remote)
+		if !d.Breaker.allow(ctx, key) {
+			return nil, &Error{Kind: ErrDialBackoff, Err: fmt.Errorf("circuit %s is open", key)}
 		}
-	}()
+		defer func() {
+			d.Breaker.done(ctx, key, err != nil && !errors.Is(err, context.Canceled))
+		}()
+	}
 
-	if d.Protector != nil {
-		maconn, err = d.Protector.Protect(maconn)
-		if err != nil {
+	for i, protec := range protecs {
+		c, err = d.dialWith(ctx, raddr, remote, protec, opts)
+		if err == nil {
+			if len(protecs) > 1 {
+				d.protectorHints.set(remote, protec)
+			}
+			break
+		}
+
+		// a private network key mismatch shows up as a failed
+		// fingerprint check or, without one, a failed negotiation. Try
+		// the next key, if any.
+		if ctx.Err() != nil || !(errors.Is(err, ErrProtocolNegotiationFailed) || errors.Is(err, ErrPNetFingerprintMismatch)) {
+			break
+		}
+		d.logger().Debugf("dial to %s at %s failed with protector %d: %s", remote, raddr, i, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.early) > 0 {
+		if err = writeEarly(ctx, c, opts.early); err != nil {
+			c.Close()
 			return nil, err
 		}
 	}
 
-	if d.Wrapper != nil {
-		maconn = d.Wrapper(maconn)
+	watchIdle(c, d.IdleTimeout)
+	logdial["dial"] = "success"
+	return c, nil
+}
+
+// checkDial fails dials the Dialer refuses outright: to itself, and to
+// filtered addresses.
+func (d *Dialer) checkDial(ctx context.Context, raddr ma.Multiaddr, remote peer.ID) error {
+	if err := d.checkSelfPeer(remote); err != nil {
+		return err
+	}
+	if err := d.checkSelfAddr(raddr); err != nil {
+		return err
+	}
+	if d.Filters != nil && d.Filters.AddrBlocked(raddr) {
+		log.Event(ctx, "connDialFiltered", lgbl.Dial("conn", d.LocalPeer, remote, nil, raddr))
+		return &Error{Kind: ErrAddrFiltered, Err: fmt.Errorf("refusing to dial %s", raddr)}
 	}
+	return nil
+}
 
-	cryptoProtoChoice := SecioTag
-	if !iconn.EncryptConnections || d.PrivateKey == nil {
-		cryptoProtoChoice = NoEncryptionTag
+// dialWith dials raddr once, protecting the raw connection with protec
+// (if not nil), and performs protocol selection and the handshake.
+func (d *Dialer) dialWith(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, protec ipnet.Protector, opts dialOpts) (iconn.Conn, error) {
+	scope, err := reserveConn(d.ResourceManager, false, raddr)
+	if err != nil {
+		return nil, err
+	}
+	sctx, endSpan := startSpan(ctx, "conn.dial.transport", nil)
+	maconn, err := d.rawConnDial(sctx, raddr, remote, opts.timeout)
+	endSpan(err)
+	if err != nil {
+		scope.Done()
+		return nil, err
 	}
+	return d.upgrade(ctx, maconn, raddr, remote, protec, scope, opts)
+}
 
-	selectResult := make(chan error, 1)
-	go func() {
-		selectResult <- msmux.SelectProtoOrFail(cryptoProtoChoice, maconn)
+// upgrade performs protection, protocol selection and the handshake on
+// maconn, a conn to remote at raddr, released with scope, with the
+// Upgrader. maconn is closed if it fails.
+func (d *Dialer) upgrade(ctx context.Context, maconn transport.Conn, raddr ma.Multiaddr, remote peer.ID, protec ipnet.Protector, scope ResourceScope, opts dialOpts) (c iconn.Conn, err error) {
+	id := nextConnID()
+	ctx = withConnIDValue(ctx, id)
+	lg := withConnID(d.logger(), id)
+	lg.Debugf("dialed %s at %s", remote, raddr)
+	tuneSocket(maconn, d.BufferSizes, d.TCPOptions, lg)
+
+	at := StageProtect
+	defer func() {
+		if err != nil {
+			lg.Debugf("upgrade failed at the %s stage: %s", at, err)
+			maconn.Close()
+			scope.Done()
+			var merr *MisdialError
+			if errors.As(err, &merr) {
+				d.misdials.add(merr)
+			}
+			if d.Audit != nil && !errors.Is(err, context.Canceled) {
+				r := auditRecord(false, raddr, remote, at, err)
+				r.Purpose = purposeFrom(ctx)
+				d.Audit.Audit(r)
+			}
+		}
 	}()
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case err = <-selectResult:
+
+	p := &upgradeParams{
+		responder:        opts.responder,
+		local:            d.LocalPeer,
+		sk:               d.PrivateKey,
+		remote:           remote,
+		raddr:            raddr,
+		fingerprint:      d.PNetFingerprint,
+		wrapper:          d.Wrapper,
+		trusted:          d.TrustedTransport,
+		stages:           currentStageTimeouts(),
+		hsPool:           d.HandshakePool,
+		scope:            scope,
+		maxMsg:           d.MaxMessageSize,
+		buffers:          d.BufferSizes,
+		limiter:          d.Limiter,
+		msgLimiter:       d.MessageLimiter,
+		reporter:         d.Reporter,
+		messageMode:      d.MessageMode,
+		batching:         d.WriteBatching,
+		compression:      d.Compression,
+		exchangeObserved: d.ExchangeObservedAddrs,
+		channelBinding:   d.ChannelBinding,
+		logger:           lg,
+		span:             "conn.dial",
+		vet: func(actual peer.ID, key ci.PubKey, proto string) error {
+			// if the connection is not to whom we thought it would be...
+			if remote == "" {
+				// any identity will do, but ours.
+				if err := d.checkSelfPeer(actual); err != nil {
+					return err
+				}
+			} else if actual != remote {
+				return &MisdialError{
+					Addr:      raddr,
+					Expected:  remote,
+					Actual:    actual,
+					ActualKey: key,
+					Seen:      time.Now(),
+				}
+			}
+			if proto != NoEncryptionTag {
+				d.misdials.remove(raddr)
+			}
+			return checkExpectedKey(opts.key, key, proto)
+		},
+	}
+	if protec != nil {
+		p.protecs = []ipnet.Protector{protec}
+		p.protect = func(c transport.Conn) (transport.Conn, ipnet.Protector, bool, error) {
+			pc, err := protec.Protect(c)
+			if err == nil && d.PNetFingerprint {
+				err = checkFingerprint(pc)
+			}
+			return pc, protec, d.PNetFingerprint, err
+		}
+	}
+	if !securedByTransport(maconn, d.TrustedTransport) {
+		protos, err := d.securityProtocols()
 		if err != nil {
 			return nil, err
 		}
+		p.protos = protos
+		if opts.responder {
+			p.mux = newSecurityMuxer(protos)
+		}
+		p.optimistic = d.Optimistic && !opts.responder && len(protos) == 1 &&
+			(protos[0] == SecioTag || protos[0] == NoEncryptionTag && len(opts.early) > 0)
+		p.selected = func(proto string) {
+			d.noteSecurity(ctx, raddr, remote, protos, proto)
+		}
 	}
 
-	c = newSingleConn(ctx, d.LocalPeer, remote, maconn)
-	if d.PrivateKey == nil || !iconn.EncryptConnections {
-		log.Warning("dialer %s dialing INSECURELY %s at %s!", d, remote, raddr)
-		return c, nil
-	}
-
-	c2, err := newSecureConn(ctx, d.PrivateKey, c)
+	tc, err := d.Upgrader.upgrade(ctx, maconn, p, &at)
 	if err != nil {
-		c.Close()
 		return nil, err
 	}
-
-	// if the connection is not to whom we thought it would be...
-	connRemote := c2.RemotePeer()
-	if connRemote != remote {
-		c2.Close()
-		return nil, fmt.Errorf("misdial to %s through %s (got %s): %s", remote, raddr, connRemote, err)
+	c, ok := tc.(iconn.Conn)
+	if !ok {
+		tc.Close()
+		return nil, fmt.Errorf("%s stage: dialed conns must remain iconn.Conns", at)
 	}
+	return c, nil
+}
 
-	logdial["dial"] = "success"
-	return c2, nil
+// AddDialer adds a sub-dialer usable by this dialer, configured with
+// opts, like WithDialTimeout.
+// Dials go to the sub-dialers that can dial the address, see CanDialer.
+// If several can, they are tried in turn until one connects: by
+// preference, see WithPreference, then in the order they were added.
+func (d *Dialer) AddDialer(pd transport.Dialer, opts ...SubDialerOption) {
+	d.dialersMu.Lock()
+	defer d.dialersMu.Unlock()
+	d.Dialers = append(d.Dialers, newSubDialer(pd, opts))
+}
+
+// RemoveDialer removes pd, a sub-dialer added with AddDialer or set in
+// Dialers, and reports whether it was there. Dials already through it
+// aren't affected, and the conns, backoffs and pool of the Dialer stay.
+func (d *Dialer) RemoveDialer(pd transport.Dialer) bool {
+	d.dialersMu.Lock()
+	defer d.dialersMu.Unlock()
+	for i, sd := range d.Dialers {
+		if unwrapSubDialer(sd) == pd {
+			d.Dialers = append(d.Dialers[:i:i], d.Dialers[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
 
-// AddDialer adds a sub-dialer usable by this dialer.
-// Dialers added first will be selected first, based on the address.
-func (d *Dialer) AddDialer(pd transport.Dialer) {
-	d.Dialers = append(d.Dialers, pd)
+// ListDialers returns the sub-dialers of the Dialer, in the order they
+// are selected in, as they were given to AddDialer.
+func (d *Dialer) ListDialers() []transport.Dialer {
+	d.dialersMu.RLock()
+	defer d.dialersMu.RUnlock()
+	pds := make([]transport.Dialer, len(d.Dialers))
+	for i, sd := range d.Dialers {
+		pds[i] = unwrapSubDialer(sd)
+	}
+	return pds
 }
 
-// returns dialer that can dial the given address
+// subDialerForAddr returns the preferred sub-dialer that can dial raddr,
+// or nil if none can.
 func (d *Dialer) subDialerForAddr(raddr ma.Multiaddr) transport.Dialer {
+	if sds := d.subDialersForAddr(raddr); len(sds) > 0 {
+		return sds[0]
+	}
+	return nil
+}
+
+// subDialersForAddr returns the sub-dialers that can dial raddr, see
+// CanDialer, by preference, or else the fallback dialer, if it can.
+func (d *Dialer) subDialersForAddr(raddr ma.Multiaddr) []transport.Dialer {
+	d.dialersMu.RLock()
+	var sds []transport.Dialer
 	for _, pd := range d.Dialers {
-		if pd.Matches(raddr) {
-			return pd
+		if canDial(pd, raddr) {
+			sds = append(sds, pd)
 		}
 	}
+	d.dialersMu.RUnlock()
+	sort.SliceStable(sds, func(i, j int) bool {
+		return preferenceOf(sds[i]) > preferenceOf(sds[j])
+	})
 
-	if d.fallback.Matches(raddr) {
-		return d.fallback
+	if len(sds) == 0 && d.fallback.Matches(raddr) {
+		sds = append(sds, d.fallback)
 	}
+	return sds
+}
 
-	return nil
+// rawConnDial dials the underlying net.Conn + manet.Conns. timeout is the
+// timeout of the call, if any, which overrides the ones of the
+// sub-dialers.
+func (d *Dialer) rawConnDial(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, timeout time.Duration) (transport.Conn, error) {
+	if _, _, _, ok := splitDNSAddr(raddr); !ok {
+		return d.rawConnDialAddr(ctx, raddr, remote, timeout)
+	}
+
+	addrs, err := d.resolve(ctx, raddr)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", raddr, err)
+	}
+	for _, a := range addrs {
+		if d.Filters != nil && d.Filters.AddrBlocked(a) {
+			log.Event(ctx, "connDialFiltered", lgbl.Dial("conn", d.LocalPeer, remote, nil, a))
+			err = &Error{Kind: ErrAddrFiltered, Err: fmt.Errorf("refusing to dial %s (%s)", a, raddr)}
+			continue
+		}
+		if selfErr := d.checkSelfAddr(a); selfErr != nil {
+			err = selfErr
+			continue
+		}
+		var c transport.Conn
+		c, err = d.rawConnDialAddr(ctx, a, remote, timeout)
+		if err == nil || ctx.Err() != nil {
+			return c, err
+		}
+		d.logger().Debugf("dial to %s at %s (%s) failed: %s", remote, a, raddr, err)
+	}
+	return nil, err
 }
 
-// rawConnDial dials the underlying net.Conn + manet.Conns
-func (d *Dialer) rawConnDial(ctx context.Context, raddr ma.Multiaddr, remote peer.ID) (transport.Conn, error) {
+func (d *Dialer) rawConnDialAddr(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, timeout time.Duration) (transport.Conn, error) {
 	if strings.HasPrefix(raddr.String(), "/ip4/0.0.0.0") {
 		log.Event(ctx, "connDialZeroAddr", lgbl.Dial("conn", d.LocalPeer, remote, nil, raddr))
 		return nil, fmt.Errorf("Attempted to connect to zero address: %s", raddr)
 	}
 
-	sd := d.subDialerForAddr(raddr)
-	if sd == nil {
-		return nil, fmt.Errorf("no dialer for %s", raddr)
+	sds := d.subDialersForAddr(raddr)
+	if len(sds) == 0 {
+		return nil, &Error{Kind: ErrNoTransportForAddr, Err: fmt.Errorf("no dialer for %s", raddr)}
 	}
 
-	return sd.DialContext(ctx, raddr)
+	var errs []error
+	for _, sd := range sds {
+		sctx, cancel := ctx, context.CancelFunc(func() {})
+		if t := subDialerTimeout(sd); t != 0 && timeout == 0 {
+			sctx, cancel = withTimeout(ctx, t)
+		}
+		var c transport.Conn
+		err := d.DialThrottle.do(sctx, func() error {
+			var err error
+			c, err = sd.DialContext(sctx, raddr)
+			return err
+		})
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			return c, err
+		}
+		d.logger().Debugf("dial to %s at %s through %T failed: %s", remote, raddr, unwrapSubDialer(sd), err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, &TransportDialError{Addr: raddr, Errs: errs}
 }
 
 func pickLocalAddr(laddrs []ma.Multiaddr, raddr ma.Multiaddr) (laddr ma.Multiaddr) {
@@ -3,6 +3,7 @@ package conn
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/libp2p/go-libp2p-conn/pnettest"
 	ic "github.com/libp2p/go-libp2p-crypto"
 	iconn "github.com/libp2p/go-libp2p-interface-conn"
 	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
@@ -277,7 +279,7 @@ func testDialerCloseEarly(t *testing.T, secure bool) {
 		defer close(results)
 		c, err := l1.Accept()
 		if err != nil {
-			if strings.Contains(err.Error(), "closed") {
+			if errors.Is(err, ErrListenerClosed) {
 				results <- nil
 				return
 			}
@@ -665,43 +667,6 @@ func TestForcePNet(t *testing.T) {
 	}
 }
 
-type fakeProtector struct {
-	used bool
-}
-
-func (f *fakeProtector) Fingerprint() []byte {
-	return make([]byte, 32)
-}
-
-func (f *fakeProtector) Protect(c transport.Conn) (transport.Conn, error) {
-	f.used = true
-	return &rot13Crypt{c}, nil
-}
-
-type rot13Crypt struct {
-	transport.Conn
-}
-
-func (r *rot13Crypt) Read(b []byte) (int, error) {
-	n, err := r.Conn.Read(b)
-	if err != nil {
-		return n, err
-	}
-
-	for i, _ := range b {
-		b[i] = byte((uint8(b[i]) - 13) & 0xff)
-	}
-	return n, err
-}
-
-func (r *rot13Crypt) Write(b []byte) (int, error) {
-	rotated := make([]byte, len(b))
-	for i, _ := range b {
-		rotated[i] = byte((uint8(b[i]) + 13) & 0xff)
-	}
-	return r.Conn.Write(rotated)
-}
-
 func TestPNetIsUsed(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -709,7 +674,7 @@ func TestPNetIsUsed(t *testing.T) {
 	p1 := tu.RandPeerNetParamsOrFatal(t)
 	p2 := tu.RandPeerNetParamsOrFatal(t)
 
-	p1Protec := &fakeProtector{}
+	p1Protec := &pnettest.ShiftProtector{Shift: 13}
 
 	list, err := tcpt.NewTCPTransport().Listen(p1.Addr)
 	if err != nil {
@@ -723,7 +688,7 @@ func TestPNetIsUsed(t *testing.T) {
 	p1.Addr = l1.Multiaddr() // Addr has been determined by kernel.
 
 	d2 := NewDialer(p2.ID, p2.PrivKey, nil)
-	d2.Protector = &fakeProtector{}
+	d2.Protector = &pnettest.ShiftProtector{Shift: 13}
 
 	d2.AddDialer(dialer(t, p2.Addr))
 	_, err = d2.Dial(ctx, p1.Addr, p1.ID)
@@ -736,11 +701,11 @@ func TestPNetIsUsed(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !p1Protec.used {
+	if p1Protec.Used() == 0 {
 		t.Error("Listener did not use protector for the connection")
 	}
 
-	if !d2.Protector.(*fakeProtector).used {
+	if d2.Protector.(*pnettest.ShiftProtector).Used() == 0 {
 		t.Error("Dialer did not use protector for the connection")
 	}
 }
@@ -778,4 +743,183 @@ func TestWrongPeer(t *testing.T) {
 		c.Close()
 		t.Fatal("expected error when dialing peer")
 	}
+	if !errors.Is(err, ErrPeerIDMismatch) {
+		t.Fatal("expected a peer id mismatch, got: ", err)
+	}
+}
+
+// connDialer is a transport.Dialer handing out an already open conn.
+type connDialer struct {
+	conn transport.Conn
+}
+
+func (d connDialer) Dial(raddr ma.Multiaddr) (transport.Conn, error) {
+	return d.conn, nil
+}
+
+func (d connDialer) DialContext(ctx context.Context, raddr ma.Multiaddr) (transport.Conn, error) {
+	return d.conn, nil
+}
+
+func (d connDialer) Matches(ma.Multiaddr) bool {
+	return true
+}
+
+func TestDialSimOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	// a simultaneous open yields a single conn on which both ends dialed.
+	list, err := tcpt.NewTCPTransport().Listen(p1.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+	tptd, err := tcpt.NewTCPTransport().Dialer(p2.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end2, err := tptd.DialContext(ctx, list.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	end1, err := list.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d1 := NewDialer(p1.ID, p1.PrivKey, nil)
+	d1.AddDialer(connDialer{end1})
+	d2 := NewDialer(p2.ID, p2.PrivKey, nil)
+	d2.AddDialer(connDialer{end2})
+
+	var c2 iconn.Conn
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		c2, err = d2.DialSimOpen(ctx, end2.RemoteMultiaddr(), p1.ID)
+		done <- err
+	}()
+	c1, err := d1.DialSimOpen(ctx, end1.RemoteMultiaddr(), p2.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	testOneSendRecv(t, c1, c2)
+	testOneSendRecv(t, c2, c1)
+
+	if _, err := d1.DialSimOpen(ctx, end1.RemoteMultiaddr(), ""); err == nil {
+		t.Fatal("simultaneous open with an unknown peer should fail")
+	}
+}
+
+// BenchmarkDialSecure measures a full secure dial, and reports the
+// goroutines each idle conn keeps on both ends.
+func BenchmarkDialSecure(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1, err := tu.RandPeerNetParams()
+	if err != nil {
+		b.Fatal(err)
+	}
+	p2, err := tu.RandPeerNetParams()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l1.Close()
+	accepted := make(chan iconn.Conn, 16)
+	go func() {
+		for {
+			c, err := l1.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c.(iconn.Conn)
+		}
+	}()
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		c.Close()
+		(<-accepted).Close()
+	}
+	b.StopTimer()
+
+	const idle = 16
+	before := runtime.NumGoroutine()
+	var conns []iconn.Conn
+	for i := 0; i < idle; i++ {
+		c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns = append(conns, c, <-accepted)
+	}
+	b.ReportMetric(float64(runtime.NumGoroutine()-before)/idle, "goroutines/conn")
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func TestDialUnknownPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+
+	c, err := d.Dial(ctx, l1.Multiaddr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.RemotePeer() != p1.ID {
+		t.Fatalf("expected the listener's identity %s, got %s", p1.ID, c.RemotePeer())
+	}
+	it := d.RecentEvents(1)
+	if !it.Next() || it.Event().Remote != p1.ID {
+		t.Fatal("the dial event should name the proven peer")
+	}
+
+	// a listener with our own identity is ourselves.
+	p3 := tu.RandPeerNetParamsOrFatal(t)
+	l2, err := Listen(ctx, p3.Addr, p2.ID, p2.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go echoListen(ctx, l2)
+	if _, err := d.Dial(ctx, l2.Multiaddr(), ""); !errors.Is(err, ErrDialToSelf) {
+		t.Fatal("expected ErrDialToSelf, got ", err)
+	}
 }
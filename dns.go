@@ -0,0 +1,190 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Resolver looks up DNS records for Dialers. *net.Resolver is one.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// defaultResolverCacheTTL is how long lookups are reused when
+// Dialer.ResolverCacheTTL is zero.
+const defaultResolverCacheTTL = time.Minute
+
+// maxDNSAddrDepth bounds the chains of /dnsaddr records followed.
+const maxDNSAddrDepth = 4
+
+// splitDNSAddr splits a multiaddr starting with a DNS component into
+// the protocol name, the domain and the rest of the address.
+func splitDNSAddr(raddr ma.Multiaddr) (proto, host, rest string, ok bool) {
+	parts := strings.SplitN(raddr.String(), "/", 4)
+	if len(parts) < 3 || parts[0] != "" {
+		return "", "", "", false
+	}
+	switch parts[1] {
+	case "dns", "dns4", "dns6", "dnsaddr":
+	default:
+		return "", "", "", false
+	}
+	if len(parts) == 4 {
+		rest = "/" + parts[3]
+	}
+	return parts[1], parts[2], rest, true
+}
+
+// resolve returns the addresses raddr stands for. Addresses that don't
+// start with /dns, /dns4, /dns6 or /dnsaddr stand for themselves.
+func (d *Dialer) resolve(ctx context.Context, raddr ma.Multiaddr) ([]ma.Multiaddr, error) {
+	return d.resolveDepth(ctx, raddr, 0)
+}
+
+func (d *Dialer) resolveDepth(ctx context.Context, raddr ma.Multiaddr, depth int) ([]ma.Multiaddr, error) {
+	proto, host, rest, ok := splitDNSAddr(raddr)
+	if !ok {
+		return []ma.Multiaddr{raddr}, nil
+	}
+
+	var addrs []string
+	if proto == "dnsaddr" {
+		if depth >= maxDNSAddrDepth {
+			return nil, fmt.Errorf("too many nested dnsaddr records resolving %s", raddr)
+		}
+		txts, err := d.lookup(ctx, "txt", "_dnsaddr."+host)
+		if err != nil {
+			return nil, err
+		}
+		for _, txt := range txts {
+			if !strings.HasPrefix(txt, "dnsaddr=") {
+				continue
+			}
+			// with a peer id, /dnsaddr/host/p2p/Qm... only stands for
+			// that peer's addresses.
+			a := strings.TrimPrefix(txt, "dnsaddr=")
+			if strings.HasSuffix(a, rest) {
+				addrs = append(addrs, a)
+			}
+		}
+	} else {
+		ips, err := d.lookup(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			v4 := net.ParseIP(ip).To4() != nil
+			switch {
+			case v4 && proto != "dns6":
+				addrs = append(addrs, "/ip4/"+ip+rest)
+			case !v4 && proto != "dns4":
+				addrs = append(addrs, "/ip6/"+ip+rest)
+			}
+		}
+	}
+
+	var resolved []ma.Multiaddr
+	for _, s := range addrs {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			d.logger().Debugf("ignoring invalid address %q resolving %s: %s", s, raddr, err)
+			continue
+		}
+		more, err := d.resolveDepth(ctx, a, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, more...)
+	}
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("%s resolved to no addresses", raddr)
+	}
+	return resolved, nil
+}
+
+// lookup looks up the IP addresses ("ip") or TXT records ("txt") of
+// name, through the cache.
+func (d *Dialer) lookup(ctx context.Context, kind, name string) ([]string, error) {
+	ttl := d.ResolverCacheTTL
+	if ttl == 0 {
+		ttl = defaultResolverCacheTTL
+	}
+	key := kind + " " + name
+	if ttl > 0 {
+		if recs, ok := d.dnsCache.get(key); ok {
+			return recs, nil
+		}
+	}
+
+	var r Resolver = net.DefaultResolver
+	if d.Resolver != nil {
+		r = d.Resolver
+	}
+
+	var recs []string
+	if kind == "txt" {
+		txts, err := r.LookupTXT(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		recs = txts
+	} else {
+		ips, err := r.LookupIPAddr(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			recs = append(recs, ip.IP.String())
+		}
+	}
+
+	if ttl > 0 {
+		d.dnsCache.set(key, recs, ttl)
+	}
+	return recs, nil
+}
+
+// dnsCache remembers lookups for a while. The zero value is ready to use.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	recs    []string
+	expires time.Time
+}
+
+func (c *dnsCache) get(key string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.recs, true
+}
+
+func (c *dnsCache) set(key string, recs []string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]dnsEntry)
+	}
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = dnsEntry{recs: recs, expires: now.Add(ttl)}
+}
@@ -0,0 +1,88 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type fakeResolver struct {
+	ips     map[string][]net.IPAddr
+	txts    map[string][]string
+	lookups int
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups++
+	ips, ok := r.ips[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.lookups++
+	txts, ok := r.txts[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return txts, nil
+}
+
+func TestResolve(t *testing.T) {
+	if _, err := ma.NewMultiaddr("/dns4/example.com/tcp/1"); err != nil {
+		// they are registered by go-multiaddr-dns.
+		t.Skip("dns protocols not registered: ", err)
+	}
+
+	r := &fakeResolver{
+		ips: map[string][]net.IPAddr{
+			"example.com": {{IP: net.ParseIP("1.2.3.4")}, {IP: net.ParseIP("::1")}},
+		},
+		txts: map[string][]string{
+			"_dnsaddr.bootstrap.example.com": {
+				"dnsaddr=/dns4/example.com/tcp/4001/ipfs/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+				"dnsaddr=/ip4/5.6.7.8/tcp/4001/ipfs/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+				"v=spf1 -all",
+			},
+		},
+	}
+	d := &Dialer{Resolver: r}
+
+	cases := map[string][]string{
+		"/ip4/1.2.3.4/tcp/4001":          {"/ip4/1.2.3.4/tcp/4001"},
+		"/dns4/example.com/tcp/4001":     {"/ip4/1.2.3.4/tcp/4001"},
+		"/dns6/example.com/tcp/4001":     {"/ip6/::1/tcp/4001"},
+		"/dnsaddr/bootstrap.example.com": {"/ip4/1.2.3.4/tcp/4001/ipfs/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN", "/ip4/5.6.7.8/tcp/4001/ipfs/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt"},
+		"/dnsaddr/bootstrap.example.com/ipfs/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt": {"/ip4/5.6.7.8/tcp/4001/ipfs/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt"},
+	}
+	for in, want := range cases {
+		got, err := d.resolve(context.Background(), ma.StringCast(in))
+		if err != nil {
+			t.Fatalf("resolving %s: %s", in, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s resolved to %v, want %v", in, got, want)
+		}
+		for i := range got {
+			if got[i].String() != want[i] {
+				t.Fatalf("%s resolved to %v, want %v", in, got, want)
+			}
+		}
+	}
+
+	// the lookups above were cached.
+	n := r.lookups
+	d.resolve(context.Background(), ma.StringCast("/dns4/example.com/tcp/1"))
+	if r.lookups != n {
+		t.Fatal("lookup not cached")
+	}
+
+	if _, err := d.resolve(context.Background(), ma.StringCast("/dns4/unknown.example.com/tcp/1")); err == nil {
+		t.Fatal("expected resolving an unknown host to fail")
+	}
+}
@@ -0,0 +1,53 @@
+package conn
+
+import (
+	"context"
+	"sync"
+
+	lgbl "github.com/libp2p/go-libp2p-loggables"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// downgrades counts, per peer, the dials that settled for a security
+// protocol other than the preferred one. The zero value is ready to use.
+type downgrades struct {
+	mu     sync.Mutex
+	counts map[peer.ID]int
+}
+
+func (dg *downgrades) add(p peer.ID) {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	if dg.counts == nil {
+		dg.counts = make(map[peer.ID]int)
+	}
+	dg.counts[p]++
+}
+
+// noteSecurity records a downgrade when proto isn't the first of protos.
+func (d *Dialer) noteSecurity(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, protos []string, proto string) {
+	if proto == protos[0] {
+		return
+	}
+	d.downgrades.add(remote)
+
+	ld := lgbl.Dial("conn", d.LocalPeer, remote, nil, raddr)
+	ld["preferred"] = protos[0]
+	ld["protocol"] = proto
+	log.Event(ctx, "connSecurityDowngrade", ld)
+}
+
+// Downgrades returns, for each peer, how many dials to it used a security
+// protocol other than the first of SecurityProtocols, because the peer
+// doesn't support it. Peers never downgraded are left out.
+func (d *Dialer) Downgrades() map[peer.ID]int {
+	d.downgrades.mu.Lock()
+	defer d.downgrades.mu.Unlock()
+
+	counts := make(map[peer.ID]int, len(d.downgrades.counts))
+	for p, n := range d.downgrades.counts {
+		counts[p] = n
+	}
+	return counts
+}
@@ -0,0 +1,45 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DialWithEarlyData is like Dial, but data is sent on the new conn as
+// soon as it can be, before the conn is returned, for request/response
+// protocols that would otherwise write right away.
+//
+// Secio carries no application data in its handshake, so on secure
+// conns data goes out in the first frames after it. On insecure dials
+// with Optimistic, and NoEncryptionTag as the only security protocol,
+// data goes out with the protocol selection itself, without waiting for
+// the listener to agree, like secio handshakes do with Optimistic.
+//
+// The Dialer's Pool is bypassed, and so is coalescing: data is for a
+// conn of its own.
+func (d *Dialer) DialWithEarlyData(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, data []byte) (iconn.Conn, error) {
+	return d.dial(ctx, raddr, remote, dialOpts{protecs: d.protectorsFor(remote), early: data})
+}
+
+// writeEarly writes the early data of a dial to c, and flushes it out of
+// any write batch, within the deadline of ctx.
+func writeEarly(ctx context.Context, c iconn.Conn, data []byte) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetWriteDeadline(deadline)
+		defer c.SetWriteDeadline(time.Time{})
+	}
+	if _, err := c.Write(data); err != nil {
+		return fmt.Errorf("writing early data: %s", err)
+	}
+	if f, ok := c.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("writing early data: %s", err)
+		}
+	}
+	return nil
+}
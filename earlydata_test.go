@@ -0,0 +1,58 @@
+package conn
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestDialWithEarlyData(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		protos []string
+	}{
+		{"secio", nil},
+		{"plaintext", []string{NoEncryptionTag}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			p1 := tu.RandPeerNetParamsOrFatal(t)
+			p2 := tu.RandPeerNetParamsOrFatal(t)
+
+			l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l1.Close()
+			if tc.protos != nil {
+				if err := l1.(ListenerSecurityProtocols).SetSecurityProtocols(tc.protos); err != nil {
+					t.Fatal(err)
+				}
+			}
+			go echoListen(ctx, l1)
+
+			d := NewDialer(p2.ID, p2.PrivKey, nil)
+			d.AddDialer(dialer(t, p2.Addr))
+			d.SecurityProtocols = tc.protos
+			d.Optimistic = true
+			c, err := d.DialWithEarlyData(ctx, l1.Multiaddr(), p1.ID, []byte("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			// the listener echoes the early data without a write of ours.
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "hello" {
+				t.Fatal("bad echo: ", string(buf))
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package conn
+
+import (
+	"context"
+	"errors"
+
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+)
+
+var (
+	// ErrPeerIDMismatch is matched by errors from Dial when the remote
+	// proved another identity than the one dialed. See MisdialError.
+	ErrPeerIDMismatch = errors.New("peer id mismatch")
+
+	// ErrHandshakeTimeout is matched by errors from connections that
+	// didn't complete protocol selection or the handshake in time.
+	ErrHandshakeTimeout = errors.New("handshake timed out")
+
+	// ErrProtocolNegotiationFailed is matched by errors from connections
+	// on which no security protocol could be agreed on.
+	ErrProtocolNegotiationFailed = errors.New("protocol negotiation failed")
+
+	// ErrProtectorRequired is returned when dialing or listening without
+	// a Protector while private networks are forced by the environment.
+	ErrProtectorRequired = ipnet.ErrNotInPrivateNetwork
+
+	// ErrDialBackoff is matched by errors from dials refused locally
+	// because their destination is backing off.
+	ErrDialBackoff = errors.New("dial backoff")
+
+	// ErrListenerClosed is returned by Accept once the listener is closed.
+	ErrListenerClosed = errors.New("listener is closed")
+
+	// ErrForeignConn is matched by errors from UpgradeInbound for conns
+	// that aren't libp2p, handed to the foreign handler instead. See
+	// ListenerPortSharing.
+	ErrForeignConn = errors.New("not a libp2p conn")
+)
+
+// Error is a failure of kind Kind, one of the errors above, caused by Err.
+// Both can be matched with errors.Is and errors.As.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// handshakeErr wraps err as a handshake timeout if ctx expired, and it
+// isn't one already.
+func handshakeErr(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded && !errors.Is(err, ErrHandshakeTimeout) {
+		return &Error{Kind: ErrHandshakeTimeout, Err: err}
+	}
+	return err
+}
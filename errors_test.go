@@ -0,0 +1,81 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func TestErrorMatching(t *testing.T) {
+	err := &Error{Kind: ErrProtocolNegotiationFailed, Err: io.EOF}
+	if !errors.Is(err, ErrProtocolNegotiationFailed) {
+		t.Error("error should match its kind")
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Error("error should match its cause")
+	}
+	if errors.Is(err, ErrHandshakeTimeout) {
+		t.Error("error should not match other kinds")
+	}
+
+	var e *Error
+	if !errors.As(err, &e) || e.Err != io.EOF {
+		t.Error("errors.As should expose the cause")
+	}
+}
+
+func TestHandshakeErr(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	if err := handshakeErr(ctx, io.EOF); !errors.Is(err, ErrHandshakeTimeout) || !errors.Is(err, io.EOF) {
+		t.Error("expected a handshake timeout caused by EOF, got: ", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := handshakeErr(ctx, io.EOF); err != io.EOF {
+		t.Error("canceled handshakes are not timeouts, got: ", err)
+	}
+}
+
+func TestDialNegotiationFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a server speaking garbage instead of multistream.
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nl.Close()
+	go func() {
+		c, err := nl.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		c.Close()
+	}()
+
+	raddr, err := manet.FromNetAddr(nl.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+
+	if _, err := d.Dial(ctx, raddr, p1.ID); !errors.Is(err, ErrProtocolNegotiationFailed) {
+		t.Fatal("expected a negotiation failure, got: ", err)
+	}
+}
@@ -0,0 +1,135 @@
+package conn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNoKeyingMaterial is returned by ExportKeyingMaterial on conns that
+// didn't agree on a secret, see Dialer.ChannelBinding.
+var ErrNoKeyingMaterial = errors.New("no keying material")
+
+// maxKeyingMaterial is the most ExportKeyingMaterial derives for a label.
+const maxKeyingMaterial = 255 * sha256.Size
+
+// KeyingMaterialExporter is implemented by the conns returned by Dial and
+// Accept.
+type KeyingMaterialExporter interface {
+	// ExportKeyingMaterial derives length bytes for label from the
+	// secret of the conn. Both ends get the same bytes, which no other
+	// conn shares, so that higher level tokens can be bound to the conn. It
+	// fails with ErrNoKeyingMaterial on conns that have no secret.
+	ExportKeyingMaterial(label string, length int) ([]byte, error)
+}
+
+func (c *singleConn) ExportKeyingMaterial(label string, length int) ([]byte, error) {
+	return nil, ErrNoKeyingMaterial
+}
+
+func (c *secureConn) ExportKeyingMaterial(label string, length int) ([]byte, error) {
+	if c.exporter == nil {
+		return nil, ErrNoKeyingMaterial
+	}
+	if length < 0 || length > maxKeyingMaterial {
+		return nil, fmt.Errorf("invalid keying material length %d", length)
+	}
+
+	// HKDF-Expand, with the label and length as info.
+	info := make([]byte, len(label)+4)
+	copy(info, label)
+	binary.BigEndian.PutUint32(info[len(label):], uint32(length))
+	out := make([]byte, 0, length+sha256.Size)
+	var t []byte
+	for i := byte(1); len(out) < length; i++ {
+		t = hmacSum(c.exporter, t, info, []byte{i})
+		out = append(out, t...)
+	}
+	return out[:length], nil
+}
+
+// ListenerChannelBinding is implemented by listeners that can agree on a
+// secret for ExportKeyingMaterial with the conns they accept.
+type ListenerChannelBinding interface {
+	// SetChannelBinding turns the agreement on a secret, as with
+	// Dialer.ChannelBinding, on or off. It must be called before any
+	// call to Accept.
+	SetChannelBinding(on bool)
+}
+
+func (l *listener) SetChannelBinding(on bool) {
+	l.channelBinding = on
+}
+
+// exchangeBinding agrees on the exporter secret of c with its remote.
+// Secio doesn't export its own secrets, so both sides send a random half
+// over the secure channel, and the secret covers the public keys of both
+// ends too: a proxy relaying the halves between two conns of its own
+// doesn't give them the same secret, as it can't prove the keys of the
+// ends. The secret is thus not derived from the secrets of the secio
+// handshake: it is only as private as the channel it was sent over. The
+// exchange is a round trip on c, and its bytes are counted in the Stat
+// of c like any others.
+func exchangeBinding(ctx context.Context, c *secureConn, initiator bool) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+
+	local, err := randomBytes(32)
+	if err != nil {
+		return fmt.Errorf("exchanging channel binding: %s", err)
+	}
+	werr := make(chan error, 1)
+	go func() {
+		_, err := c.Write(local)
+		werr <- err
+	}()
+	remote := make([]byte, len(local))
+	_, err = io.ReadFull(c, remote)
+	if err == nil {
+		err = <-werr
+	}
+	if err != nil {
+		return fmt.Errorf("exchanging channel binding: %s", err)
+	}
+
+	localKey, err := c.LocalPrivateKey().GetPublic().Bytes()
+	if err != nil {
+		return err
+	}
+	remoteKey, err := c.RemotePublicKey().Bytes()
+	if err != nil {
+		return err
+	}
+	dialer, listener := local, remote
+	dialerKey, listenerKey := localKey, remoteKey
+	if !initiator {
+		dialer, listener = remote, local
+		dialerKey, listenerKey = remoteKey, localKey
+	}
+	c.exporter = hmacSum([]byte("libp2p-conn exporter"), dialer, listener, dialerKey, listenerKey)
+	return nil
+}
+
+func hmacSum(key []byte, parts ...[]byte) []byte {
+	m := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		m.Write(p)
+	}
+	return m.Sum(nil)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,84 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestExportKeyingMaterial(t *testing.T) {
+	c := &secureConn{}
+	if _, err := c.ExportKeyingMaterial("label", 32); err != ErrNoKeyingMaterial {
+		t.Fatal("expected no keying material, got ", err)
+	}
+
+	c.exporter = []byte("secret")
+	a, err := c.ExportKeyingMaterial("label", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 100 {
+		t.Fatalf("expected 100 bytes, got %d", len(a))
+	}
+	b, _ := c.ExportKeyingMaterial("label", 100)
+	if !bytes.Equal(a, b) {
+		t.Fatal("exports should be deterministic")
+	}
+	if b, _ := c.ExportKeyingMaterial("other", 100); bytes.Equal(a, b) {
+		t.Fatal("labels should derive different bytes")
+	}
+	if b, _ := c.ExportKeyingMaterial("label", 32); bytes.Equal(a[:32], b) {
+		t.Fatal("lengths should derive different bytes")
+	}
+	if _, err := c.ExportKeyingMaterial("label", maxKeyingMaterial+1); err == nil {
+		t.Fatal("expected an error exporting too much")
+	}
+}
+
+func TestDialChannelBinding(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	l1.(ListenerChannelBinding).SetChannelBinding(true)
+
+	accepted := make(chan []byte, 1)
+	go func() {
+		c, err := l1.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		km, err := c.(KeyingMaterialExporter).ExportKeyingMaterial("token", 32)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- km
+	}()
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.ChannelBinding = true
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	km, err := c.(KeyingMaterialExporter).ExportKeyingMaterial("token", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(km, <-accepted) {
+		t.Fatal("both ends should export the same bytes")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package conn
+
+import "syscall"
+
+// fdLimit returns the soft limit on the file descriptors of the process.
+func fdLimit() (int, bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false
+	}
+	if rl.Cur > 1<<20 {
+		// unlimited, or as good as.
+		return 1 << 20, true
+	}
+	return int(rl.Cur), true
+}
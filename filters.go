@@ -0,0 +1,72 @@
+package conn
+
+import (
+	"errors"
+	"net"
+
+	filter "github.com/libp2p/go-maddr-filter"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrAddrFiltered is matched by errors from dials to addresses blocked by
+// the Dialer's Filters, and from UpgradeInbound for conns from addresses
+// the listener doesn't take.
+var ErrAddrFiltered = errors.New("address blocked by filters")
+
+// privateRanges are the non publicly routable networks, see
+// NewPrivateRangeFilters.
+var privateRanges = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// NewPrivateRangeFilters returns filters blocking loopback, link-local
+// and private (RFC 1918, RFC 4193, carrier-grade NAT...) networks. Set as
+// Dialer.Filters, they keep addresses learned from untrusted sources from
+// making a node dial into its own internal network.
+func NewPrivateRangeFilters() *filter.Filters {
+	fs := filter.NewFilters()
+	for _, r := range privateRanges {
+		_, ipnet, err := net.ParseCIDR(r)
+		if err != nil {
+			panic(err)
+		}
+		fs.AddDialFilter(ipnet)
+	}
+	return fs
+}
+
+// ListenerAddrAllowlist is implemented by listeners that can accept
+// conns from some networks only.
+type ListenerAddrAllowlist interface {
+	// SetAddrAllowlist restricts the listener to conns from the networks
+	// of fs, the ones it would block as filters. Conns from anywhere else,
+	// or from addresses with no IP, like unix sockets, are closed as soon
+	// as the transport accepts them, as are the ones the filters of
+	// SetAddrFilters block, before any protocol selection or handshake.
+	// A nil fs lifts the restriction. It must be called before any call to
+	// Accept.
+	SetAddrAllowlist(fs *filter.Filters)
+}
+
+func (l *listener) SetAddrAllowlist(fs *filter.Filters) {
+	l.allowed = fs
+}
+
+// addrAllowed tells whether the listener takes conns from a.
+func (l *listener) addrAllowed(a ma.Multiaddr) bool {
+	if l.filters != nil && l.filters.AddrBlocked(a) {
+		return false
+	}
+	return l.allowed == nil || l.allowed.AddrBlocked(a)
+}
@@ -0,0 +1,107 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	filter "github.com/libp2p/go-maddr-filter"
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestPrivateRangeFilters(t *testing.T) {
+	fs := NewPrivateRangeFilters()
+
+	blocked := []string{
+		"/ip4/10.0.0.1/tcp/4001",
+		"/ip4/192.168.1.1/tcp/4001",
+		"/ip4/172.20.0.1/tcp/4001",
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip6/fd00::1/tcp/4001",
+	}
+	for _, a := range blocked {
+		if !fs.AddrBlocked(ma.StringCast(a)) {
+			t.Errorf("%s should be blocked", a)
+		}
+	}
+
+	allowed := []string{
+		"/ip4/1.2.3.4/tcp/4001",
+		"/ip6/2001:db8::1/tcp/4001",
+	}
+	for _, a := range allowed {
+		if fs.AddrBlocked(ma.StringCast(a)) {
+			t.Errorf("%s should not be blocked", a)
+		}
+	}
+}
+
+func TestDialFiltered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.Filters = NewPrivateRangeFilters()
+
+	if _, err := d.Dial(ctx, l1.Multiaddr(), p1.ID); !errors.Is(err, ErrAddrFiltered) {
+		t.Fatal("dial to loopback should have been filtered, got: ", err)
+	}
+}
+
+func TestListenerAddrAllowlist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	p3 := tu.RandPeerNetParamsOrFatal(t)
+
+	d := NewDialer(p3.ID, p3.PrivKey, nil)
+	d.AddDialer(dialer(t, p3.Addr))
+
+	for _, tc := range []struct {
+		p       tu.PeerNetParams
+		allowed string
+		ok      bool
+	}{
+		{p1, "10.0.0.0/8", false},
+		{p2, "127.0.0.0/8", true},
+	} {
+		_, ipnet, err := net.ParseCIDR(tc.allowed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs := filter.NewFilters()
+		fs.AddDialFilter(ipnet)
+
+		l, err := Listen(ctx, tc.p.Addr, tc.p.ID, tc.p.PrivKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		l.(ListenerAddrAllowlist).SetAddrAllowlist(fs)
+		go echoListen(ctx, l)
+
+		c, err := d.DialWithTimeout(ctx, l.Multiaddr(), tc.p.ID, time.Second)
+		if tc.ok != (err == nil) {
+			t.Fatalf("allowing %s: unexpected dial error %v", tc.allowed, err)
+		}
+		if c != nil {
+			c.Close()
+		}
+	}
+}
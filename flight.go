@@ -0,0 +1,51 @@
+package conn
+
+import (
+	"context"
+	"sync"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+)
+
+// dialFlights coalesces concurrent dials to the same destination. The
+// zero value is ready to use.
+type dialFlights struct {
+	mu      sync.Mutex
+	flights map[string]*dialFlight
+}
+
+type dialFlight struct {
+	done chan struct{}
+	conn iconn.Conn
+	err  error
+}
+
+// do runs dial, unless a dial with the same key is in flight, in which
+// case it waits for that one's result instead. The first caller's dial
+// is the one made: when its ctx is canceled, all callers get the error.
+func (f *dialFlights) do(ctx context.Context, key string, dial func() (iconn.Conn, error)) (c iconn.Conn, shared bool, err error) {
+	f.mu.Lock()
+	if fl, ok := f.flights[key]; ok {
+		f.mu.Unlock()
+		select {
+		case <-fl.done:
+			return fl.conn, true, fl.err
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+	if f.flights == nil {
+		f.flights = make(map[string]*dialFlight)
+	}
+	fl := &dialFlight{done: make(chan struct{})}
+	f.flights[key] = fl
+	f.mu.Unlock()
+
+	fl.conn, fl.err = dial()
+
+	f.mu.Lock()
+	delete(f.flights, key)
+	f.mu.Unlock()
+	close(fl.done)
+	return fl.conn, false, fl.err
+}
@@ -0,0 +1,74 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+)
+
+func TestDialFlights(t *testing.T) {
+	var f dialFlights
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	dials := 0
+	dial := func() (iconn.Conn, error) {
+		dials++
+		<-release
+		return nil, errors.New("dial result")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := f.do(ctx, "k", dial)
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	if dials != 1 {
+		t.Fatalf("%d dials made", dials)
+	}
+	for err := range errs {
+		if err == nil || err.Error() != "dial result" {
+			t.Fatalf("unexpected result %v", err)
+		}
+	}
+
+	// the flight is over: the next call dials again.
+	release = make(chan struct{})
+	close(release)
+	f.do(ctx, "k", dial)
+	if dials != 2 {
+		t.Fatal("finished flight reused")
+	}
+}
+
+func TestDialFlightsCanceled(t *testing.T) {
+	var f dialFlights
+
+	release := make(chan struct{})
+	defer close(release)
+	go f.do(context.Background(), "k", func() (iconn.Conn, error) {
+		<-release
+		return nil, nil
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, shared, err := f.do(ctx, "k", nil); !shared || err != context.DeadlineExceeded {
+		t.Fatalf("expected the waiter to time out, got %t, %v", shared, err)
+	}
+}
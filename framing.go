@@ -0,0 +1,134 @@
+package conn
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxMessageSize is the largest message ReadMsg accepts, matching msgio,
+// unless a Dialer or listener sets its own: see Dialer.MaxMessageSize.
+var MaxMessageSize = 8 * 1024 * 1024
+
+// msgPool backs the buffers returned by ReadMsg and used by WriteMsg.
+var msgPool bufferPool
+
+// bufferPool keeps byte slices in power of two size classes.
+type bufferPool struct {
+	pools [32]sync.Pool
+}
+
+// Get returns a slice of length n, from the pool if possible.
+func (p *bufferPool) Get(n int) []byte {
+	if n == 0 {
+		return nil
+	}
+	i := bits.Len(uint(n - 1))
+	if i >= len(p.pools) {
+		return make([]byte, n)
+	}
+	if b, ok := p.pools[i].Get().(*[]byte); ok {
+		return (*b)[:n]
+	}
+	return make([]byte, n, 1<<uint(i))
+}
+
+// Put returns b to the pool. Slices that didn't come from Get are dropped.
+func (p *bufferPool) Put(b []byte) {
+	c := cap(b)
+	if c == 0 || c&(c-1) != 0 {
+		return
+	}
+	i := bits.Len(uint(c - 1))
+	if i >= len(p.pools) {
+		return
+	}
+	b = b[:0]
+	p.pools[i].Put(&b)
+}
+
+// msgFramer provides msgio compatible messages (a 4 byte big endian length
+// followed by the payload) on top of a conn's byte stream, without
+// allocating a fresh buffer per message like msgio.NewReadWriter does.
+// Messages returned by ReadMsg should be given back with ReleaseMsg.
+type msgFramer struct {
+	rw  io.ReadWriter
+	max int // MaxMessageSize if zero
+
+	rlock   sync.Mutex
+	lbuf    [4]byte
+	nextLen int
+	haveLen bool
+
+	wlock sync.Mutex
+
+	msgsSent uint64 // accessed atomically, see Stat
+	msgsRecv uint64
+}
+
+// WriteMsg writes msg as a single length-prefixed message.
+func (f *msgFramer) WriteMsg(msg []byte) error {
+	buf := msgPool.Get(4 + len(msg))
+	defer msgPool.Put(buf)
+
+	binary.BigEndian.PutUint32(buf, uint32(len(msg)))
+	copy(buf[4:], msg)
+
+	f.wlock.Lock()
+	defer f.wlock.Unlock()
+	if _, err := f.rw.Write(buf); err != nil {
+		return err
+	}
+	atomic.AddUint64(&f.msgsSent, 1)
+	return nil
+}
+
+// ReadMsg reads the next message.
+func (f *msgFramer) ReadMsg() ([]byte, error) {
+	f.rlock.Lock()
+	defer f.rlock.Unlock()
+
+	n, err := f.nextMsgLen()
+	if err != nil {
+		return nil, err
+	}
+	f.haveLen = false
+
+	msg := msgPool.Get(n)
+	if _, err := io.ReadFull(f.rw, msg); err != nil {
+		msgPool.Put(msg)
+		return nil, err
+	}
+	atomic.AddUint64(&f.msgsRecv, 1)
+	return msg, nil
+}
+
+// NextMsgLen returns the length of the next message, reading its
+// length prefix if needed.
+func (f *msgFramer) NextMsgLen() (int, error) {
+	f.rlock.Lock()
+	defer f.rlock.Unlock()
+	return f.nextMsgLen()
+}
+
+func (f *msgFramer) nextMsgLen() (int, error) {
+	if !f.haveLen {
+		if _, err := io.ReadFull(f.rw, f.lbuf[:]); err != nil {
+			return 0, err
+		}
+		n := binary.BigEndian.Uint32(f.lbuf[:])
+		if max := resolveMaxMessageSize(f.max); uint64(n) > uint64(max) {
+			return 0, &MessageTooLargeError{Size: uint64(n), Max: max}
+		}
+		f.nextLen = int(n)
+		f.haveLen = true
+	}
+	return f.nextLen, nil
+}
+
+// ReleaseMsg hands a message returned by ReadMsg back to the buffer pool.
+func (f *msgFramer) ReleaseMsg(msg []byte) {
+	msgPool.Put(msg)
+}
@@ -0,0 +1,88 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	msgio "github.com/libp2p/go-msgio"
+)
+
+var _ msgio.ReadWriter = (*singleConn)(nil)
+var _ msgio.ReadWriter = (*secureConn)(nil)
+
+func TestBufferPool(t *testing.T) {
+	var p bufferPool
+
+	for _, n := range []int{1, 3, 4, 1000, 1024, 1025} {
+		b := p.Get(n)
+		if len(b) != n {
+			t.Fatalf("expected len %d, got %d", n, len(b))
+		}
+		if c := cap(b); c < n || c&(c-1) != 0 {
+			t.Fatalf("expected a power of two capacity >= %d, got %d", n, c)
+		}
+		p.Put(b)
+	}
+
+	// foreign slices are not pooled.
+	p.Put(make([]byte, 1000))
+	if b := p.Get(999); cap(b) != 1024 {
+		t.Fatal("pool handed out a slice it did not allocate: ", cap(b))
+	}
+}
+
+func testMsgInterop(t *testing.T, c1, c2 iconn.Conn) {
+	mc1 := c1.(msgio.ReadWriter)
+	mc2 := msgioWrap(c2)
+
+	msgs := [][]byte{[]byte("beep"), {}, bytes.Repeat([]byte("boop"), 1000)}
+	for _, m := range msgs {
+		if err := mc1.WriteMsg(m); err != nil {
+			t.Fatal(err)
+		}
+		out, err := mc2.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(m, out) {
+			t.Fatal("message mangled on the way out")
+		}
+
+		if err := mc2.WriteMsg(m); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := mc1.NextMsgLen(); err != nil || n != len(m) {
+			t.Fatalf("expected next message of %d bytes, got %d (%v)", len(m), n, err)
+		}
+		out, err = mc1.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(m, out) {
+			t.Fatal("message mangled on the way in")
+		}
+		mc1.ReleaseMsg(out)
+	}
+}
+
+func TestMsgInterop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c1, c2, _, _ := setupSingleConn(t, ctx)
+	defer c1.Close()
+	defer c2.Close()
+
+	testMsgInterop(t, c1, c2)
+}
+
+func TestSecureMsgInterop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c1, c2, _, _ := setupSecureConn(t, ctx)
+	defer c1.Close()
+	defer c2.Close()
+
+	testMsgInterop(t, c1, c2)
+}
@@ -0,0 +1,60 @@
+package conn
+
+import (
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// HandshakeResult is what a conn established with the remote before the
+// first application byte. Identify-like protocols can use it instead of
+// exchanging the same information again.
+//
+// secio doesn't report the cipher suites it agreed on, and exchanges no
+// metadata besides the keys, so neither is part of it.
+type HandshakeResult struct {
+	// Protocol is the security protocol selected with multistream,
+	// SecioTag, NoEncryptionTag or PlaintextIdentityTag, or
+	// TrustedTransportTag over a trusted transport, which selects none.
+	Protocol string
+
+	LocalPeer  peer.ID
+	RemotePeer peer.ID
+
+	// RemotePublicKey is nil on NoEncryptionTag conns, and on trusted
+	// ones when the transport doesn't know it.
+	RemotePublicKey ic.PubKey
+
+	// Completed is when the handshake finished.
+	Completed time.Time
+}
+
+// HandshakeInfo is implemented by the conns returned by Dial and Accept.
+// The result is available, and stable, as soon as they are returned.
+type HandshakeInfo interface {
+	HandshakeResult() HandshakeResult
+}
+
+// HandshakeResult returns the outcome of protocol selection, plaintext,
+// or what the trusted transport vouched for.
+func (c *singleConn) HandshakeResult() HandshakeResult {
+	return HandshakeResult{
+		Protocol:        c.Security(),
+		LocalPeer:       c.local,
+		RemotePeer:      c.remote,
+		RemotePublicKey: c.remoteKey,
+		Completed:       c.established,
+	}
+}
+
+// HandshakeResult returns the outcome of the secio handshake.
+func (c *secureConn) HandshakeResult() HandshakeResult {
+	return HandshakeResult{
+		Protocol:        SecioTag,
+		LocalPeer:       c.LocalPeer(),
+		RemotePeer:      c.RemotePeer(),
+		RemotePublicKey: c.RemotePublicKey(),
+		Completed:       c.established,
+	}
+}
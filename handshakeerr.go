@@ -0,0 +1,61 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// HandshakeError describes an inbound conn that failed to upgrade.
+type HandshakeError struct {
+	// Addr is the remote address of the conn.
+	Addr ma.Multiaddr
+	// Stage is how far the upgrade got.
+	Stage Stage
+	Err   error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("inbound conn from %s failed at %s: %s", e.Addr, e.Stage, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// ListenerHandshakeErrors is implemented by listeners that can report the
+// inbound conns that failed to upgrade, which otherwise only show in debug
+// logs.
+type ListenerHandshakeErrors interface {
+	// SetHandshakeErrorHandler makes the listener call h for every
+	// inbound conn that fails to upgrade, including the ones that run out
+	// of AcceptTimeout. h is called from the handshake goroutines, maybe
+	// concurrently, and must not block. It must be called before any call
+	// to Accept.
+	SetHandshakeErrorHandler(h func(*HandshakeError))
+}
+
+func (l *listener) SetHandshakeErrorHandler(h func(*HandshakeError)) {
+	l.handshakeErrs = h
+}
+
+// handshakeFailed reports an inbound conn from raddr that failed at stage,
+// to the handler and the audit sink, unless the listener is closing.
+func (l *listener) handshakeFailed(ctx context.Context, raddr ma.Multiaddr, stage Stage, err error) {
+	if l.handshakeErrs == nil && l.audit == nil {
+		return
+	}
+	select {
+	case <-l.proc.Closing():
+		return
+	default:
+	}
+	err = handshakeErr(ctx, err)
+	if l.handshakeErrs != nil {
+		l.handshakeErrs(&HandshakeError{Addr: raddr, Stage: stage, Err: err})
+	}
+	if l.audit != nil {
+		l.audit.Audit(auditRecord(true, raddr, "", stage, err))
+	}
+}
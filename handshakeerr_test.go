@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestHandshakeErrorHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	failures := make(chan *HandshakeError, 1)
+	l1.(ListenerHandshakeErrors).SetHandshakeErrorHandler(func(e *HandshakeError) {
+		failures <- e
+	})
+	go echoListen(ctx, l1)
+
+	raw, err := net.Dial("tcp", l1.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Write([]byte("SSH-2.0-OpenSSH_7.4\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-failures:
+		if e.Stage != StagePreamble {
+			t.Fatalf("expected a failure at the preamble, got %s", e.Stage)
+		}
+		if !errors.Is(e, ErrProtocolNegotiationFailed) {
+			t.Fatalf("unexpected error %v", e)
+		}
+		if e.Addr == nil {
+			t.Fatal("expected the remote address")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake failure not reported")
+	}
+}
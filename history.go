@@ -0,0 +1,147 @@
+package conn
+
+import (
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// EventHistory is how many lifecycle events listeners and dialers keep
+// for RecentEvents. Listeners pick up its value when they are created,
+// dialers on their first event. Zero keeps no history.
+var EventHistory = 256
+
+// ConnEvent is a connection lifecycle event, as kept for RecentEvents.
+type ConnEvent struct {
+	Time time.Time
+	// Type is one of "dial", "dialPooled", "dialCoalesced", "accept",
+	// "acceptFiltered", "acceptRefused", "acceptTimeout",
+	// "acceptDropped", "standby", "promoted", "paused", "resumed" and
+	// "identityChanged".
+	Type   string
+	Remote peer.ID      // if known
+	Addr   ma.Multiaddr // remote address, if any
+	Err    error        // why the dial or accept failed, if it did
+
+	// ConnID is the ConnID of the conn, or of the one being upgraded, if
+	// any.
+	ConnID uint64
+
+	// Purpose is the label of dials, see WithPurpose.
+	Purpose string
+}
+
+// EventIterator iterates over a snapshot of events, oldest first:
+//
+//	it := d.RecentEvents(10)
+//	for it.Next() {
+//		ev := it.Event()
+//		...
+//	}
+type EventIterator struct {
+	events []ConnEvent
+	cur    int
+}
+
+// Next advances to the next event, and reports whether there is one.
+func (it *EventIterator) Next() bool {
+	if it.cur >= len(it.events) {
+		return false
+	}
+	it.cur++
+	return true
+}
+
+// Event returns the current event. It is only valid after Next returned
+// true.
+func (it *EventIterator) Event() ConnEvent {
+	return it.events[it.cur-1]
+}
+
+// Len returns how many events the iterator covers in total.
+func (it *EventIterator) Len() int {
+	return len(it.events)
+}
+
+// ListenerRecentEvents is implemented by listeners that can report their
+// recent lifecycle events, without having subscribed beforehand.
+type ListenerRecentEvents interface {
+	// RecentEvents iterates over the last n events at most, or all of
+	// them if n is negative.
+	RecentEvents(n int) *EventIterator
+}
+
+// eventRing is a bounded buffer of the most recent events. The zero value
+// is ready to use, and sized by EventHistory on its first event.
+type eventRing struct {
+	mu     sync.Mutex
+	size   int
+	sized  bool
+	events []ConnEvent
+	next   int // where the next event goes, once events is full
+}
+
+func newEventRing(size int) *eventRing {
+	return &eventRing{size: size, sized: true}
+}
+
+func (r *eventRing) add(typ string, remote peer.ID, addr ma.Multiaddr, err error) {
+	r.addEvent(ConnEvent{Type: typ, Remote: remote, Addr: addr, Err: err})
+}
+
+func (r *eventRing) addConn(typ string, id uint64, remote peer.ID, addr ma.Multiaddr, err error) {
+	r.addEvent(ConnEvent{Type: typ, Remote: remote, Addr: addr, Err: err, ConnID: id})
+}
+
+func (r *eventRing) addEvent(ev ConnEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.sized {
+		r.size, r.sized = EventHistory, true
+	}
+	if r.size <= 0 {
+		return
+	}
+
+	ev.Time = time.Now()
+	if len(r.events) < r.size {
+		r.events = append(r.events, ev)
+		return
+	}
+	r.events[r.next] = ev
+	r.next = (r.next + 1) % r.size
+}
+
+// recent returns an iterator over the last n events, all if n < 0.
+func (r *eventRing) recent(n int) *EventIterator {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make([]ConnEvent, 0, len(r.events))
+	all = append(all, r.events[r.next:]...)
+	all = append(all, r.events[:r.next]...)
+	if n >= 0 && n < len(all) {
+		all = all[len(all)-n:]
+	}
+	return &EventIterator{events: all}
+}
+
+// RecentEvents iterates over the last n lifecycle events of the Dialer at
+// most, or all of them if n is negative. See EventHistory.
+func (d *Dialer) RecentEvents(n int) *EventIterator {
+	return d.history.recent(n)
+}
+
+func (l *listener) RecentEvents(n int) *EventIterator {
+	return l.history.recent(n)
+}
+
+// remotePeer returns the remote peer of c, if it knows it.
+func remotePeer(c interface{}) peer.ID {
+	if pc, ok := c.(interface{ RemotePeer() peer.ID }); ok {
+		return pc.RemotePeer()
+	}
+	return ""
+}
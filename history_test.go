@@ -0,0 +1,51 @@
+package conn
+
+import (
+	"fmt"
+	"testing"
+)
+
+func eventTypes(it *EventIterator) []string {
+	var types []string
+	for it.Next() {
+		types = append(types, it.Event().Type)
+	}
+	return types
+}
+
+func TestEventRing(t *testing.T) {
+	r := newEventRing(3)
+	for i := 0; i < 5; i++ {
+		r.add(fmt.Sprint(i), "", nil, nil)
+	}
+
+	if got := fmt.Sprint(eventTypes(r.recent(-1))); got != "[2 3 4]" {
+		t.Fatalf("expected the last three events, oldest first, got %s", got)
+	}
+	if got := fmt.Sprint(eventTypes(r.recent(2))); got != "[3 4]" {
+		t.Fatalf("expected the last two events, got %s", got)
+	}
+	if n := r.recent(10).Len(); n != 3 {
+		t.Fatalf("expected 3 events, got %d", n)
+	}
+
+	none := newEventRing(0)
+	none.add("dial", "", nil, nil)
+	if none.recent(-1).Next() {
+		t.Fatal("expected no history")
+	}
+}
+
+func TestDialerRecentEventsZeroValue(t *testing.T) {
+	old := EventHistory
+	EventHistory = 2
+	defer func() { EventHistory = old }()
+
+	var d Dialer
+	d.history.add("dial", "", nil, nil)
+	d.history.add("dialPooled", "", nil, nil)
+	d.history.add("dialCoalesced", "", nil, nil)
+	if got := fmt.Sprint(eventTypes(d.RecentEvents(-1))); got != "[dialPooled dialCoalesced]" {
+		t.Fatalf("unexpected events %s", got)
+	}
+}
@@ -0,0 +1,124 @@
+package conn
+
+import (
+	"context"
+	"runtime"
+	"time"
+)
+
+// HandshakePool bounds how many secio handshakes run at once, so that a
+// burst of inbound conns doesn't have hundreds of goroutines doing key
+// exchanges and signatures at the same time, starving the rest of the
+// process. Handshakes beyond Workers wait their turn, first come, first
+// served, within their own timeouts.
+//
+// A handshake keeps its worker for at most Hold, network round trips
+// included, then goes on without it, so that peers stalling mid-handshake
+// can't lock the pool up. Set it on a Dialer and on listeners with
+// ListenerHandshakePool. The same pool may be shared by any number of
+// them.
+type HandshakePool struct {
+	// Workers is how many handshakes may run at once. Zero means
+	// DefaultHandshakeWorkers.
+	Workers int
+
+	// Hold is how long a handshake may keep its worker. Zero means
+	// DefaultHandshakeHold, and NoTimeout keeps it for the whole
+	// handshake.
+	Hold time.Duration
+
+	q slotQueue
+}
+
+// DefaultHandshakeWorkers returns the default size of a HandshakePool:
+// four handshakes per GOMAXPROCS.
+func DefaultHandshakeWorkers() int {
+	return 4 * runtime.GOMAXPROCS(0)
+}
+
+// DefaultHandshakeHold is the default HandshakePool.Hold. It is well
+// above the time a secio handshake takes on a healthy link.
+const DefaultHandshakeHold = 2 * time.Second
+
+// NewHandshakePool returns a pool running at most workers handshakes at
+// once.
+func NewHandshakePool(workers int) *HandshakePool {
+	return &HandshakePool{Workers: workers}
+}
+
+// HandshakePoolStats are the queueing metrics of a HandshakePool.
+type HandshakePoolStats struct {
+	Workers int
+	// Running and Queued are the handshakes currently running, and
+	// waiting for a worker.
+	Running int
+	Queued  int
+
+	// Completed is the number of handshakes run, successful or not, and
+	// Abandoned the number of those whose context was done, or whose
+	// listener was closed, before they got a worker. Overran is the
+	// number of handshakes that gave up their worker after Hold.
+	Completed uint64
+	Abandoned uint64
+	Overran   uint64
+
+	// TotalWait is the time spent waiting for a worker, over all the
+	// handshakes that got one, and MaxWait the longest single wait.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// Stats returns the current metrics of the pool.
+func (p *HandshakePool) Stats() HandshakePoolStats {
+	s := p.q.snapshot(p.workers)
+	return HandshakePoolStats{
+		Workers:   s.size,
+		Running:   s.running,
+		Queued:    s.queued,
+		Completed: s.completed,
+		Abandoned: s.abandoned,
+		Overran:   s.overran,
+		TotalWait: s.totalWait,
+		MaxWait:   s.maxWait,
+	}
+}
+
+func (p *HandshakePool) workers() int {
+	if p.Workers > 0 {
+		return p.Workers
+	}
+	return DefaultHandshakeWorkers()
+}
+
+func (p *HandshakePool) hold() time.Duration {
+	switch {
+	case p.Hold == NoTimeout:
+		return 0
+	case p.Hold > 0:
+		return p.Hold
+	}
+	return DefaultHandshakeHold
+}
+
+// do runs f once a worker is free, or returns ctx.Err() if ctx is done
+// first, or ErrListenerClosed if closing is. A nil pool runs f right
+// away.
+func (p *HandshakePool) do(ctx context.Context, closing <-chan struct{}, f func() error) error {
+	if p == nil {
+		return f()
+	}
+	return p.q.do(ctx, closing, p.workers, p.hold(), f)
+}
+
+// ListenerHandshakePool is implemented by listeners that can schedule
+// their handshakes on a HandshakePool.
+type ListenerHandshakePool interface {
+	// SetHandshakePool makes the secio handshakes of inbound conns wait
+	// for a worker of p, like Dialer.HandshakePool. It must be called
+	// before any call to Accept.
+	SetHandshakePool(p *HandshakePool)
+}
+
+func (l *listener) SetHandshakePool(p *HandshakePool) {
+	l.hsPool = p
+}
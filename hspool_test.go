@@ -0,0 +1,151 @@
+package conn
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandshakePoolBounds(t *testing.T) {
+	p := NewHandshakePool(2)
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.do(context.Background(), nil, func() error {
+				mu.Lock()
+				running++
+				if running > peak {
+					peak = running
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak != 2 {
+		t.Fatal("expected at most two handshakes at once, got ", peak)
+	}
+	st := p.Stats()
+	if st.Workers != 2 || st.Running != 0 || st.Queued != 0 || st.Completed != 10 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if st.MaxWait <= 0 || st.TotalWait < st.MaxWait {
+		t.Fatalf("unexpected wait times %+v", st)
+	}
+}
+
+func TestHandshakePoolAbandon(t *testing.T) {
+	p := NewHandshakePool(1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.do(context.Background(), nil, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ran := false
+	if err := p.do(ctx, nil, func() error { ran = true; return nil }); err != context.DeadlineExceeded {
+		t.Fatal("expected the wait to time out, got ", err)
+	}
+	if ran {
+		t.Fatal("abandoned handshake shouldn't run")
+	}
+	close(release)
+
+	if st := p.Stats(); st.Abandoned != 1 || st.Queued != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestHandshakePoolNil(t *testing.T) {
+	var p *HandshakePool
+	ran := false
+	if err := p.do(context.Background(), nil, func() error { ran = true; return nil }); err != nil || !ran {
+		t.Fatal("a nil pool should run handshakes right away: ", err)
+	}
+}
+
+func TestHandshakePoolDefaultWorkers(t *testing.T) {
+	var p HandshakePool
+	if n := p.Stats().Workers; n != DefaultHandshakeWorkers() {
+		t.Fatal("unexpected default workers: ", n)
+	}
+}
+
+func TestHandshakePoolHold(t *testing.T) {
+	p := NewHandshakePool(1)
+	p.Hold = 10 * time.Millisecond
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.do(context.Background(), nil, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	defer close(release)
+
+	// the stalled handshake gives its worker up after Hold.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.do(ctx, nil, func() error { return nil }); err != nil {
+		t.Fatal("expected the stalled handshake to give its worker up, got ", err)
+	}
+	if st := p.Stats(); st.Overran != 1 || st.Running != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestHandshakePoolClosing(t *testing.T) {
+	p := NewHandshakePool(1)
+	p.Hold = NoTimeout
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.do(context.Background(), nil, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	defer close(release)
+
+	closing := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- p.do(context.Background(), closing, func() error { return nil })
+	}()
+	close(closing)
+	select {
+	case err := <-errs:
+		if err != ErrListenerClosed {
+			t.Fatal("expected ErrListenerClosed, got ", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued handshake didn't return on close")
+	}
+	if st := p.Stats(); st.Abandoned != 1 || st.Queued != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
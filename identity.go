@@ -0,0 +1,51 @@
+package conn
+
+import (
+	"errors"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ListenerIdentity is implemented by listeners whose identity can be
+// rotated while they run.
+type ListenerIdentity interface {
+	// SetIdentity makes the listener secure new conns with sk, as the
+	// peer ID of its public key, which LocalPeer returns from then on.
+	// Conns already accepted, or in their handshake, keep the identity
+	// they started with. It fails on listeners created without a private
+	// key. It may be called at any time.
+	SetIdentity(sk ic.PrivKey) error
+}
+
+func (l *listener) SetIdentity(sk ic.PrivKey) error {
+	if sk == nil {
+		return errors.New("private key is nil")
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return err
+	}
+
+	l.idMu.Lock()
+	if l.privk == nil {
+		l.idMu.Unlock()
+		return errors.New("listener is insecure")
+	}
+	old := l.local
+	l.local, l.privk = id, sk
+	l.idMu.Unlock()
+
+	log.Event(l.ctx, "listenerIdentityChanged", l)
+	l.history.add("identityChanged", "", nil, nil)
+	l.logger.Debugf("listener identity changed: %s to %s", old, id)
+	return nil
+}
+
+// identity returns the peer ID and private key to secure a new conn
+// with.
+func (l *listener) identity() (peer.ID, ic.PrivKey) {
+	l.idMu.RLock()
+	defer l.idMu.RUnlock()
+	return l.local, l.privk
+}
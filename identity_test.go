@@ -0,0 +1,68 @@
+package conn
+
+import (
+	"context"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestListenerSetIdentity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	p3 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p3.ID, p3.PrivKey, nil)
+	d.AddDialer(dialer(t, p3.Addr))
+
+	c1, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+
+	if err := l1.(ListenerIdentity).SetIdentity(p2.PrivKey); err != nil {
+		t.Fatal(err)
+	}
+	if l1.LocalPeer() != p2.ID {
+		t.Fatal("listener should have the new identity, got ", l1.LocalPeer())
+	}
+
+	c2, err := d.Dial(ctx, l1.Multiaddr(), p2.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if c2.RemotePeer() != p2.ID || !c2.RemotePublicKey().Equals(p2.PubKey) {
+		t.Fatal("new conns should get the new identity, got ", c2.RemotePeer())
+	}
+	if c1.RemotePeer() != p1.ID {
+		t.Fatal("old conns should keep the old identity, got ", c1.RemotePeer())
+	}
+	if _, err := c1.Write([]byte("hello")); err != nil {
+		t.Fatal("old conns should still work: ", err)
+	}
+
+	if _, err := d.Dial(ctx, l1.Multiaddr(), p1.ID); err == nil {
+		t.Fatal("dialing the old identity should fail")
+	}
+
+	l2, err := Listen(ctx, p3.Addr, p3.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	if err := l2.(ListenerIdentity).SetIdentity(p2.PrivKey); err == nil {
+		t.Fatal("insecure listeners shouldn't take an identity")
+	}
+}
@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// ErrIdleTimeout is matched by the errors of reads and writes on conns
+// closed for being idle, see Dialer.IdleTimeout.
+var ErrIdleTimeout = errors.New("conn idle for too long")
+
+// ListenerIdleTimeout is implemented by listeners that can close the
+// conns they accept once idle.
+type ListenerIdleTimeout interface {
+	// SetIdleTimeout makes accepted conns close once they have read and
+	// written nothing for d, like Dialer.IdleTimeout. Zero disables it.
+	// It must be called before any call to Accept.
+	SetIdleTimeout(d time.Duration)
+}
+
+func (l *listener) SetIdleTimeout(d time.Duration) {
+	l.idleTimeout = d
+}
+
+// watchIdle closes c once it has been idle for timeout, if positive.
+// Activity is what Stat reports.
+func watchIdle(c transport.Conn, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	var single *singleConn
+	var stat func() Stat
+	switch c := c.(type) {
+	case *secureConn:
+		single, _ = c.insecure.(*singleConn)
+		stat = c.Stat
+	case *singleConn:
+		single = c
+		stat = c.Stat
+	}
+	if single == nil {
+		return
+	}
+
+	go func() {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-single.ctx.Done():
+				return
+			}
+			if idle := time.Since(lastActive(stat())); idle < timeout {
+				t.Reset(timeout - idle)
+				continue
+			}
+			log.Debugf("closing idle conn %s", c)
+			atomic.StoreInt32(&single.idleClosed, 1)
+			c.Close()
+			return
+		}
+	}()
+}
+
+func lastActive(st Stat) time.Time {
+	last := st.Opened
+	if st.LastSent.After(last) {
+		last = st.LastSent
+	}
+	if st.LastRecv.After(last) {
+		last = st.LastRecv
+	}
+	return last
+}
+
+// idleErr wraps err as ErrIdleTimeout if c was closed for being idle.
+func (c *singleConn) idleErr(err error) error {
+	if err != nil && atomic.LoadInt32(&c.idleClosed) == 1 {
+		return &Error{Kind: ErrIdleTimeout, Err: err}
+	}
+	return err
+}
+
+func (c *secureConn) idleErr(err error) error {
+	if single, ok := c.insecure.(*singleConn); ok {
+		return single.idleErr(err)
+	}
+	return err
+}
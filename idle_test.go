@@ -0,0 +1,44 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	a, b := net.Pipe()
+	c1 := newSingleConn(ctx, "a", "b", pipeConn{a})
+	c2 := newSingleConn(ctx, "b", "a", pipeConn{b})
+	defer c2.Close()
+
+	watchIdle(c1, 100*time.Millisecond)
+
+	// activity keeps the conn open.
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := c2.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := c1.Write([]byte("x")); err != nil {
+			t.Fatal("active conns shouldn't time out: ", err)
+		}
+	}
+
+	select {
+	case <-c1.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("idle conn wasn't closed")
+	}
+	if _, err := c1.Read(make([]byte, 1)); !errors.Is(err, ErrIdleTimeout) {
+		t.Fatal("expected an idle timeout, got ", err)
+	}
+}
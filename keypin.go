@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ci "github.com/libp2p/go-libp2p-crypto"
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrPublicKeyMismatch is matched by errors from DialWithExpectedKey when
+// the remote didn't prove the expected public key.
+var ErrPublicKeyMismatch = errors.New("public key mismatch")
+
+// DialWithExpectedKey is like Dial, but the remote must prove it holds
+// the private half of key, which is compared in full rather than through
+// its hash in remote. This takes secio, or a trusted transport that
+// knows the key: other security protocols prove no key, and fail the
+// dial. remote may be empty, in which case it is derived from key.
+//
+// The Dialer's Pool is bypassed, and so is coalescing, as the conns they
+// share weren't checked against key.
+func (d *Dialer) DialWithExpectedKey(ctx context.Context, raddr ma.Multiaddr, remote peer.ID, key ci.PubKey) (iconn.Conn, error) {
+	if key == nil {
+		return nil, errors.New("no public key to expect")
+	}
+	id, err := peer.IDFromPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if remote == "" {
+		remote = id
+	} else if remote != id {
+		return nil, &Error{Kind: ErrPublicKeyMismatch, Err: fmt.Errorf("expected key is not the key of %s", remote)}
+	}
+	return d.dial(ctx, raddr, remote, dialOpts{protecs: d.protectorsFor(remote), key: key})
+}
+
+// checkExpectedKey checks the key a conn over proto proved against
+// expected, if set. actual is nil when the conn proved no key.
+func checkExpectedKey(expected, actual ci.PubKey, proto string) error {
+	switch {
+	case expected == nil:
+		return nil
+	case actual == nil:
+		return &Error{Kind: ErrPublicKeyMismatch, Err: fmt.Errorf("%s proves no public key", proto)}
+	case !expected.Equals(actual):
+		return &Error{Kind: ErrPublicKeyMismatch, Err: fmt.Errorf("remote proved another public key over %s", proto)}
+	}
+	return nil
+}
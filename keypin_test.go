@@ -0,0 +1,53 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestDialWithExpectedKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	p3 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	if err := l1.(ListenerSecurityProtocols).SetSecurityProtocols([]string{SecioTag, PlaintextIdentityTag}); err != nil {
+		t.Fatal(err)
+	}
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	c, err := d.DialWithExpectedKey(ctx, l1.Multiaddr(), "", p1.PubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemotePeer() != p1.ID {
+		t.Fatal("dialed the wrong peer: ", c.RemotePeer())
+	}
+	c.Close()
+
+	// the key must be the one of the peer dialed.
+	if _, err := d.DialWithExpectedKey(ctx, l1.Multiaddr(), p1.ID, p3.PubKey); !errors.Is(err, ErrPublicKeyMismatch) {
+		t.Fatal("expected a key mismatch, got: ", err)
+	}
+
+	// and proven.
+	d.SecurityProtocols = []string{PlaintextIdentityTag}
+	_, err = d.DialWithExpectedKey(ctx, l1.Multiaddr(), p1.ID, p1.PubKey)
+	if !errors.Is(err, ErrPublicKeyMismatch) {
+		t.Fatal("expected a key mismatch over plaintext, got: ", err)
+	}
+	if class := ErrorClass(err); class != "key-mismatch" {
+		t.Fatal("unexpected error class: ", class)
+	}
+}
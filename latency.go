@@ -0,0 +1,175 @@
+package conn
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+var errLatencyClosed = errors.New("write on closed conn")
+
+// latencyQueue is how many writes a LatencyConn holds before Write blocks.
+const latencyQueue = 1024
+
+// Latency emulates the propagation delay of a WAN link on the conns it
+// wraps, e.g. in staging clusters. It can be adjusted at any time, and
+// applies to the writes made from then on. Use it as a ConnWrapper, with
+// Dialer.Wrapper and ListenerConnWrapper, so that the connections are
+// secured as usual on top of it:
+//
+//	lat := NewLatency(40*time.Millisecond, 5*time.Millisecond)
+//	d.Wrapper = lat.Wrap
+//
+// In builds with the conndebug tag, the debug console's latency command
+// shows and adjusts it too.
+type Latency struct {
+	mu     sync.Mutex
+	delay  time.Duration
+	jitter time.Duration
+	rand   *rand.Rand
+}
+
+// NewLatency returns a Latency delaying writes by delay, give or take up
+// to jitter.
+func NewLatency(delay, jitter time.Duration) *Latency {
+	return &Latency{
+		delay:  delay,
+		jitter: jitter,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Set changes the delay and jitter.
+func (l *Latency) Set(delay, jitter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.delay, l.jitter = delay, jitter
+}
+
+// Get returns the delay and jitter.
+func (l *Latency) Get() (delay, jitter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.delay, l.jitter
+}
+
+func (l *Latency) next() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d := l.delay
+	if l.jitter > 0 {
+		d += time.Duration(l.rand.Int63n(int64(2*l.jitter))) - l.jitter
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// Wrap wraps c, which then suffers the latency. It is a ConnWrapper.
+func (l *Latency) Wrap(c transport.Conn) transport.Conn {
+	lc := &LatencyConn{
+		Conn:    c,
+		latency: l,
+		queue:   make(chan delayedWrite, latencyQueue),
+		done:    make(chan struct{}),
+	}
+	go lc.deliver()
+	return lc
+}
+
+// WrapLatency wraps c with a Latency of its own. See Latency.
+func WrapLatency(c transport.Conn, delay, jitter time.Duration) *LatencyConn {
+	return NewLatency(delay, jitter).Wrap(c).(*LatencyConn)
+}
+
+// LatencyConn is a conn wrapped by a Latency. Writes return right away,
+// and their data is sent once delayed, in order: the link is slower, not
+// narrower. Wrapping one end only delays one direction. A failed delayed
+// write fails the next Write. Close sends what is pending first.
+type LatencyConn struct {
+	transport.Conn
+
+	latency *Latency
+
+	mu      sync.RWMutex
+	closed  bool
+	lastDue time.Time
+	queue   chan delayedWrite
+	done    chan struct{}
+
+	errMu sync.Mutex
+	err   error
+}
+
+type delayedWrite struct {
+	data []byte
+	due  time.Time
+}
+
+// Latency returns the Latency of c, to adjust it.
+func (c *LatencyConn) Latency() *Latency {
+	return c.latency
+}
+
+func (c *LatencyConn) Write(b []byte) (int, error) {
+	c.errMu.Lock()
+	err := c.err
+	c.errMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return 0, errLatencyClosed
+	}
+
+	due := time.Now().Add(c.latency.next())
+	data := make([]byte, len(b))
+	copy(data, b)
+	c.queue <- delayedWrite{data: data, due: due}
+	return len(b), nil
+}
+
+func (c *LatencyConn) deliver() {
+	defer close(c.done)
+
+	// writes are sent in order, so jitter can't make one overtake another.
+	var last time.Time
+	for w := range c.queue {
+		if w.due.Before(last) {
+			w.due = last
+		}
+		last = w.due
+		time.Sleep(time.Until(w.due))
+
+		if _, err := c.Conn.Write(w.data); err != nil {
+			c.errMu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.errMu.Unlock()
+		}
+	}
+}
+
+// Close sends the pending writes, and closes the wrapped conn.
+func (c *LatencyConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return c.Conn.Close()
+	}
+	c.closed = true
+	close(c.queue)
+	c.mu.Unlock()
+
+	<-c.done
+	return c.Conn.Close()
+}
@@ -0,0 +1,86 @@
+package conn
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// pipeConn is a net.Pipe end posing as a transport conn.
+type pipeConn struct {
+	net.Conn
+}
+
+func (pipeConn) LocalMultiaddr() ma.Multiaddr   { return nil }
+func (pipeConn) RemoteMultiaddr() ma.Multiaddr  { return nil }
+func (pipeConn) Transport() transport.Transport { return nil }
+
+func TestLatency(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	lc := WrapLatency(pipeConn{a}, 50*time.Millisecond, 0)
+
+	start := time.Now()
+	if _, err := lc.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 20*time.Millisecond {
+		t.Fatal("write blocked on the delay")
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("data arrived after %s", d)
+	}
+
+	// adjusting applies to the next writes.
+	lc.Latency().Set(0, 0)
+	start = time.Now()
+	lc.Write([]byte("again"))
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 40*time.Millisecond {
+		t.Fatalf("data arrived after %s", d)
+	}
+	lc.Close()
+}
+
+func TestLatencyJitterOrder(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	lc := WrapLatency(pipeConn{a}, 10*time.Millisecond, 10*time.Millisecond)
+
+	var want []byte
+	for i := 0; i < 50; i++ {
+		lc.Write([]byte{byte(i)})
+		want = append(want, byte(i))
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- lc.Close() }()
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(b, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("writes reordered: %v", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc.Write([]byte("x")); err == nil {
+		t.Fatal("expected write after close to fail")
+	}
+}
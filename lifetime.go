@@ -0,0 +1,70 @@
+package conn
+
+import (
+	"context"
+	"time"
+)
+
+type lifetimeKey struct{}
+
+// WithConnLifetime ties the conns dialed with ctx, or accepted by the
+// listeners created with it, to ctx: they are closed once it is done.
+//
+// By default, the context passed to Dial or to a listener only governs
+// the setup of conns. Once returned, they live until closed, whatever
+// becomes of it, and ConnContext tells when that happens.
+func WithConnLifetime(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lifetimeKey{}, ctx)
+}
+
+// lifetimeFrom returns the context the conns set up with ctx are tied
+// to, if any.
+func lifetimeFrom(ctx context.Context) context.Context {
+	bound, _ := ctx.Value(lifetimeKey{}).(context.Context)
+	return bound
+}
+
+// ConnContext is implemented by the conns returned by Dial and Accept.
+type ConnContext interface {
+	// Context returns a context canceled once the conn is closed. It
+	// carries the values of the context the conn was set up with, like
+	// its WithAffinity key, but not its deadline nor its cancelation.
+	Context() context.Context
+}
+
+func (c *singleConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *secureConn) Context() context.Context {
+	if cc, ok := c.insecure.(ConnContext); ok {
+		return cc.Context()
+	}
+	return context.Background()
+}
+
+// valuesOnly has the values of the wrapped context, but neither its
+// deadline nor its cancelation.
+type valuesOnly struct {
+	context.Context
+}
+
+func (valuesOnly) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesOnly) Done() <-chan struct{}       { return nil }
+func (valuesOnly) Err() error                  { return nil }
+
+// bindLifetime closes c once the context it was tied to with
+// WithConnLifetime is done, if any.
+func (c *singleConn) bindLifetime(ctx context.Context) {
+	bound := lifetimeFrom(ctx)
+	if bound == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-bound.Done():
+			c.Close()
+		case <-c.ctx.Done():
+		}
+	}()
+}
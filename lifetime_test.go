@@ -0,0 +1,64 @@
+package conn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestConnLifetime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+
+	// by default, the context only covers the dial.
+	dctx, dcancel := context.WithCancel(ctx)
+	c, err := d.Dial(WithAffinity(dctx, "shard-1"), l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dcancel()
+	testOneSendRecv(t, c, c)
+	cctx := c.(ConnContext).Context()
+	if affinityFrom(cctx) != "shard-1" {
+		t.Fatal("conn context lost the dial context's values")
+	}
+	if cctx.Err() != nil {
+		t.Fatal("conn context canceled with the dial context")
+	}
+	c.Close()
+	select {
+	case <-cctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("conn context not canceled on Close")
+	}
+
+	// tied to the context, the conn closes with it.
+	dctx, dcancel = context.WithCancel(ctx)
+	c, err = d.Dial(WithConnLifetime(dctx), l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testOneSendRecv(t, c, c)
+	dcancel()
+	select {
+	case <-c.(ConnContext).Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("conn not closed with its context")
+	}
+}
@@ -0,0 +1,264 @@
+package conn
+
+import (
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// DefaultWriteQuantum is the number of bytes each peer may write per round
+// of a WriteLimiter, unless configured otherwise.
+const DefaultWriteQuantum = 16 * 1024
+
+// WriteLimiter caps the rate at which the conns sharing it write, in
+// bytes per second. When it is saturated, pending writes are scheduled
+// with deficit round-robin across peers instead of first come, first
+// served: each round, every peer with data to send may write up to
+// Quantum bytes. A bulk transfer then can't starve the small messages and
+// keepalives other peers are waiting on.
+//
+// Set it on a Dialer and on listeners with ListenerWriteLimiter. The same
+// limiter may be shared by any number of them.
+type WriteLimiter struct {
+	// Rate is the maximum number of bytes written per second. If it
+	// isn't positive, writes are still scheduled fairly, but not paced.
+	Rate int
+
+	// Quantum is how much each peer may write per round. Writes are
+	// split in chunks of at most that size. Zero means
+	// DefaultWriteQuantum.
+	Quantum int
+
+	mu      sync.Mutex
+	flows   map[string]*writeFlow
+	active  []*writeFlow // flows with pending writes, in round order
+	running bool
+	next    time.Time // when the link is free again
+}
+
+// NewWriteLimiter returns a limiter writing at most rate bytes per second.
+func NewWriteLimiter(rate int) *WriteLimiter {
+	return &WriteLimiter{Rate: rate}
+}
+
+// writeFlow holds the pending writes of one peer.
+type writeFlow struct {
+	key     string
+	deficit int
+	queue   []writeGrant
+}
+
+type writeGrant struct {
+	n       int
+	granted chan struct{}
+}
+
+func (l *WriteLimiter) quantum() int {
+	if l.Quantum > 0 {
+		return l.Quantum
+	}
+	return DefaultWriteQuantum
+}
+
+// write writes buf with w on behalf of key, in quantum sized chunks, each
+// once the scheduler grants it. Waiting for grants stops at the deadline
+// dl, if not nil, and when closed is.
+func (l *WriteLimiter) write(key string, w func([]byte) (int, error), buf []byte, dl *writeDeadline, closed <-chan struct{}) (int, error) {
+	var written int
+	for len(buf) > 0 {
+		chunk := buf
+		if q := l.quantum(); len(chunk) > q {
+			chunk = chunk[:q]
+		}
+
+		if err := l.wait(key, len(chunk), dl, closed); err != nil {
+			return written, err
+		}
+		n, err := w(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		buf = buf[n:]
+	}
+	return written, nil
+}
+
+// wait blocks until key may write n bytes. It fails with errDeadline once
+// the deadline dl passes, and errConnClosed when closed is closed.
+func (l *WriteLimiter) wait(key string, n int, dl *writeDeadline, closed <-chan struct{}) error {
+	g := writeGrant{n: n, granted: make(chan struct{})}
+
+	l.mu.Lock()
+	if l.flows == nil {
+		l.flows = make(map[string]*writeFlow)
+	}
+	f, ok := l.flows[key]
+	if !ok {
+		f = &writeFlow{key: key}
+		l.flows[key] = f
+	}
+	f.queue = append(f.queue, g)
+	if len(f.queue) == 1 {
+		l.active = append(l.active, f)
+	}
+	if !l.running {
+		l.running = true
+		go l.schedule()
+	}
+	l.mu.Unlock()
+
+	for {
+		var timer *time.Timer
+		var expired <-chan time.Time
+		var changed chan struct{}
+		if dl != nil {
+			var t time.Time
+			t, changed = dl.load()
+			if !t.IsZero() {
+				d := time.Until(t)
+				if d <= 0 {
+					return l.cancel(f, g, errDeadline)
+				}
+				timer = time.NewTimer(d)
+				expired = timer.C
+			}
+		}
+
+		var err error
+		done := true
+		select {
+		case <-g.granted:
+		case <-expired:
+			err = l.cancel(f, g, errDeadline)
+		case <-closed:
+			err = l.cancel(f, g, errConnClosed)
+		case <-changed:
+			done = false
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if done {
+			return err
+		}
+	}
+}
+
+// cancel withdraws g from the queue of f, and returns err. If g was
+// granted meanwhile, its turn is lost.
+func (l *WriteLimiter) cancel(f *writeFlow, g writeGrant, err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, q := range f.queue {
+		if q.granted != g.granted {
+			continue
+		}
+		f.queue = append(f.queue[:i], f.queue[i+1:]...)
+		if len(f.queue) == 0 {
+			for j, a := range l.active {
+				if a == f {
+					l.active = append(l.active[:j], l.active[j+1:]...)
+					break
+				}
+			}
+			f.deficit = 0
+			delete(l.flows, f.key)
+		}
+		break
+	}
+	return err
+}
+
+// schedule grants pending writes, round-robin, paced at Rate. It runs
+// while there are any.
+func (l *WriteLimiter) schedule() {
+	for {
+		l.mu.Lock()
+		if len(l.active) == 0 {
+			l.running = false
+			l.mu.Unlock()
+			return
+		}
+
+		f := l.active[0]
+		l.active = l.active[1:]
+		f.deficit += l.quantum()
+
+		var grants []writeGrant
+		for len(f.queue) > 0 && f.queue[0].n <= f.deficit {
+			f.deficit -= f.queue[0].n
+			grants = append(grants, f.queue[0])
+			f.queue = f.queue[1:]
+		}
+		if len(f.queue) > 0 {
+			l.active = append(l.active, f)
+		} else {
+			f.deficit = 0
+			delete(l.flows, f.key)
+		}
+		l.mu.Unlock()
+
+		for _, g := range grants {
+			l.pace(g.n)
+			close(g.granted)
+		}
+	}
+}
+
+// pace sleeps until the link has room for n more bytes.
+func (l *WriteLimiter) pace(n int) {
+	if l.Rate <= 0 {
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	time.Sleep(l.next.Sub(now))
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.Rate))
+}
+
+// writeDeadline is the write deadline of a conn, for the writes waiting
+// on its WriteLimiter.
+type writeDeadline struct {
+	mu  sync.Mutex
+	t   time.Time
+	set chan struct{} // closed when t changes
+}
+
+func (d *writeDeadline) store(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.t = t
+	if d.set != nil {
+		close(d.set)
+	}
+	d.set = make(chan struct{})
+}
+
+// load returns the deadline, and a channel closed when it changes, nil if
+// it was never set.
+func (d *writeDeadline) load() (time.Time, chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.t, d.set
+}
+
+// setWriteLimiter makes c write through l, with the writes of the other
+// conns to remote. See setLimitKey.
+func (c *singleConn) setWriteLimiter(l *WriteLimiter, remote peer.ID) {
+	c.limiter = l
+	c.setLimitKey(remote)
+}
+
+// setLimitKey sets who c's writes are accounted to. Until the remote peer
+// is known, which takes a handshake on inbound conns, it is its address.
+func (c *singleConn) setLimitKey(remote peer.ID) {
+	key := string(remote)
+	if key == "" {
+		key = c.maconn.RemoteMultiaddr().String()
+	}
+	c.limitKey.Store(key)
+}
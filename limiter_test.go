@@ -0,0 +1,81 @@
+package conn
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteLimiterFairness(t *testing.T) {
+	l := &WriteLimiter{Rate: 1 << 20, Quantum: 1024}
+
+	var mu sync.Mutex
+	var order []string
+	record := func(key string) func([]byte) (int, error) {
+		return func(b []byte) (int, error) {
+			mu.Lock()
+			order = append(order, key)
+			mu.Unlock()
+			return len(b), nil
+		}
+	}
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.write("bulk", record("bulk"), make([]byte, 64*1024), nil, nil)
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := l.write("small", record("small"), make([]byte, 100), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("64KiB at 1MiB/s took only %s", elapsed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, key := range order {
+		if key == "small" {
+			if i > len(order)/2 {
+				t.Fatalf("small write was scheduled %dth out of %d", i, len(order))
+			}
+			return
+		}
+	}
+	t.Fatal("small write never happened")
+}
+
+func TestWriteLimiterDeadline(t *testing.T) {
+	l := &WriteLimiter{Rate: 1024, Quantum: 1024}
+	w := func(b []byte) (int, error) { return len(b), nil }
+
+	// the first KiB takes a second to clear the link.
+	if _, err := l.write("a", w, make([]byte, 1024), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var dl writeDeadline
+	dl.store(time.Now().Add(50 * time.Millisecond))
+	_, err := l.write("b", w, make([]byte, 2048), &dl, nil)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	closed := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(closed) })
+	if _, err := l.write("c", w, make([]byte, 2048), nil, closed); err != errConnClosed {
+		t.Fatalf("expected the write to stop on close, got %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.flows) != 0 {
+		t.Fatalf("canceled writes left %d flows queued", len(l.flows))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	tec "github.com/jbenet/go-temp-err-catcher"
@@ -26,12 +27,12 @@ const (
 	NoEncryptionTag = "/plaintext/1.0.0"
 )
 
-var connAcceptBuffer = 32
-
 // AcceptTimeout is the maximum duration an Accept is allowed to take.
 // This includes the time between accepting the raw network connection,
 // protocol selection as well as the handshake, if applicable.
-var AcceptTimeout = 60 * time.Second
+// Zero means the default of 60 seconds, and NoTimeout disables it.
+// Listeners pick up its value when they are created.
+var AcceptTimeout = defaultTimeout
 
 // ConnWrapper is any function that wraps a raw multiaddr connection.
 type ConnWrapper func(transport.Conn) transport.Conn
@@ -40,14 +41,55 @@ type ConnWrapper func(transport.Conn) transport.Conn
 type listener struct {
 	transport.Listener
 
-	local  peer.ID    // LocalPeer is the identity of the local Peer
-	privk  ic.PrivKey // private key to use to initialize secure conns
-	protec ipnet.Protector
+	idMu    sync.RWMutex      // guards local and privk, see SetIdentity
+	local   peer.ID           // LocalPeer is the identity of the local Peer
+	privk   ic.PrivKey        // private key to use to initialize secure conns
+	protecs []ipnet.Protector // private network keys, current first
 
 	filters *filter.Filters
-
-	wrapper ConnWrapper
-	catcher tec.TempErrCatcher
+	allowed *filter.Filters // if set, the only networks to accept from
+
+	acceptTimeout   time.Duration
+	preambleTimeout time.Duration
+	stages          stageTimeouts
+	history         *eventRing
+	garbage         garbageCounts
+	replays         *replayCache
+	overflow        OverflowPolicy
+
+	wrapper     ConnWrapper
+	messageMode bool
+	maxMsg      int
+	limiter     *WriteLimiter
+	reporter    BandwidthReporter
+	msgLimiter  *MessageLimiter
+	foreign     func(net.Conn)
+	standby     standby
+	paused      standby // the same gate, in front of the transport listener
+	catcher     tec.TempErrCatcher
+
+	handshakeErrs func(*HandshakeError)
+	logger        Logger
+	audit         AuditSink
+	resources     ResourceManager
+	trusted       bool
+
+	exchangeObserved bool
+	channelBinding   bool
+	fingerprint      bool
+
+	batching *WriteBatching
+	buffers  *BufferSizes
+	tcpOpts  *TCPOptions
+	hsPool   *HandshakePool
+
+	compression []Compression
+
+	allowlist     *PeerAllowlist
+	peersRejected uint64 // accessed atomically
+
+	idleTimeout time.Duration
+	upgrader    *Upgrader
 
 	proc goprocess.Process
 
@@ -55,21 +97,100 @@ type listener struct {
 
 	incoming chan connErr
 
-	ctx context.Context
+	// handshakesDone is closed once every in-flight handshake has
+	// finished, right before incoming is closed.
+	handshakesDone chan struct{}
+	handshakes     int64 // in flight, accessed atomically
+
+	// taken is closed, and replaced, each time Accept takes a conn off
+	// incoming, for waitDrained.
+	takenMu sync.Mutex
+	taken   chan struct{}
+
+	draining  chan struct{}
+	drainOnce sync.Once
+	closeOnce sync.Once
+
+	// ctx is canceled once the listener is closed, cutting the
+	// handshakes in flight short.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (l *listener) teardown() error {
-	defer log.Debugf("listener closed: %s %s", l.local, l.Multiaddr())
-	return l.Listener.Close()
+	defer l.logger.Debugf("listener closed: %s %s", l.LocalPeer(), l.Multiaddr())
+	l.cancel()
+	return l.closeTransport()
+}
+
+// closeTransport closes the underlying transport listener, at most once.
+func (l *listener) closeTransport() error {
+	var err error
+	l.closeOnce.Do(func() {
+		err = l.Listener.Close()
+	})
+	return err
 }
 
 func (l *listener) Close() error {
-	log.Debugf("listener closing: %s %s", l.local, l.Multiaddr())
+	l.logger.Debugf("listener closing: %s %s", l.LocalPeer(), l.Multiaddr())
 	return l.proc.Close()
 }
 
+// Drain stops accepting new connections, waits for in-flight handshakes
+// to complete and for the resulting conns to be picked up by Accept, and
+// then closes the listener. If ctx is done first, the listener is closed
+// right away and conns that didn't make it to Accept are dropped.
+func (l *listener) Drain(ctx context.Context) error {
+	_, err := l.Shutdown(ctx)
+	return err
+}
+
+func (l *listener) waitDrained(ctx context.Context) error {
+	select {
+	case <-l.handshakesDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for {
+		taken := l.takenSignal()
+		if len(l.incoming) == 0 {
+			return nil
+		}
+		select {
+		case <-taken:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// takenSignal returns a channel closed once Accept next takes a conn.
+func (l *listener) takenSignal() <-chan struct{} {
+	l.takenMu.Lock()
+	defer l.takenMu.Unlock()
+	return l.taken
+}
+
+func (l *listener) tookConn() {
+	l.takenMu.Lock()
+	close(l.taken)
+	l.taken = make(chan struct{})
+	l.takenMu.Unlock()
+}
+
+func (l *listener) isDraining() bool {
+	select {
+	case <-l.draining:
+		return true
+	default:
+		return false
+	}
+}
+
 func (l *listener) String() string {
-	return fmt.Sprintf("<Listener %s %s>", l.local, l.Multiaddr())
+	return fmt.Sprintf("<Listener %s %s>", l.LocalPeer(), l.Multiaddr())
 }
 
 func (l *listener) SetAddrFilters(fs *filter.Filters) {
@@ -83,10 +204,38 @@ type connErr struct {
 
 // Accept waits for and returns the next connection to the listener.
 func (l *listener) Accept() (transport.Conn, error) {
-	if c, ok := <-l.incoming; ok {
-		return c.conn, c.err
+	return l.AcceptContext(context.Background())
+}
+
+// ListenerAcceptContext is implemented by listeners whose accepts can be
+// bounded or canceled.
+type ListenerAcceptContext interface {
+	// AcceptContext is like Accept, but gives up with ctx.Err() once ctx
+	// is done. The listener stays open: a conn that arrives later is
+	// returned by the next accept.
+	AcceptContext(ctx context.Context) (transport.Conn, error)
+}
+
+func (l *listener) AcceptContext(ctx context.Context) (transport.Conn, error) {
+	if gate := l.standby.wait(); gate != nil {
+		select {
+		case <-gate:
+		case <-l.proc.Closing():
+			return nil, ErrListenerClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
-	return nil, fmt.Errorf("listener is closed")
+	select {
+	case c, ok := <-l.incoming:
+		if ok {
+			l.tookConn()
+			return c.conn, c.err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return nil, ErrListenerClosed
 }
 
 func (l *listener) Addr() net.Addr {
@@ -102,16 +251,18 @@ func (l *listener) Multiaddr() ma.Multiaddr {
 
 // LocalPeer is the identity of the local Peer.
 func (l *listener) LocalPeer() peer.ID {
-	return l.local
+	local, _ := l.identity()
+	return local
 }
 
 func (l *listener) Loggable() map[string]interface{} {
+	local, sk := l.identity()
 	return map[string]interface{}{
 		"listener": map[string]interface{}{
-			"peer":      l.LocalPeer(),
+			"peer":      local,
 			"address":   l.Multiaddr(),
-			"secure":    (l.privk != nil),
-			"inPrivNet": (l.protec != nil),
+			"secure":    (sk != nil),
+			"inPrivNet": (len(l.protecs) > 0),
 		},
 	}
 }
@@ -120,6 +271,7 @@ func (l *listener) handleIncoming() {
 	var wg sync.WaitGroup
 	defer func() {
 		wg.Wait()
+		close(l.handshakesDone)
 		close(l.incoming)
 	}()
 
@@ -127,12 +279,27 @@ func (l *listener) handleIncoming() {
 	defer wg.Done()
 
 	for {
+		if !l.waitResumed() {
+			return
+		}
 		maconn, err := l.Listener.Accept()
+		accepted := time.Now()
 		if err != nil {
 			if l.catcher.IsTemporary(err) {
 				continue
 			}
 
+			if l.isDraining() {
+				return
+			}
+
+			// the transport listener is only closed once we are closing.
+			select {
+			case <-l.proc.Closing():
+				return
+			default:
+			}
+
 			select {
 			case <-l.proc.Closing():
 			case l.incoming <- connErr{err: err}:
@@ -140,18 +307,30 @@ func (l *listener) handleIncoming() {
 			return
 		}
 
-		log.Debugf("listener %s got connection: %s <---> %s", l, maconn.LocalMultiaddr(), maconn.RemoteMultiaddr())
+		id := nextConnID()
+		lg := withConnID(l.logger, id)
+		lg.Debugf("listener %s got connection: %s <---> %s", l, maconn.LocalMultiaddr(), maconn.RemoteMultiaddr())
 
-		if l.filters != nil && l.filters.AddrBlocked(maconn.RemoteMultiaddr()) {
-			log.Debugf("blocked connection from %s", maconn.RemoteMultiaddr())
+		if !l.addrAllowed(maconn.RemoteMultiaddr()) {
+			lg.Debugf("blocked connection from %s", maconn.RemoteMultiaddr())
+			l.history.addConn("acceptFiltered", id, "", maconn.RemoteMultiaddr(), nil)
+			maconn.Close()
+			continue
+		}
+		scope, err := reserveConn(l.resources, true, maconn.RemoteMultiaddr())
+		if err != nil {
+			lg.Debugf("refused connection from %s: %s", maconn.RemoteMultiaddr(), err)
+			l.history.addConn("acceptRefused", id, "", maconn.RemoteMultiaddr(), err)
 			maconn.Close()
 			continue
 		}
 		wg.Add(1)
+		atomic.AddInt64(&l.handshakes, 1)
 		go func() {
 			defer wg.Done()
+			defer atomic.AddInt64(&l.handshakes, -1)
 
-			ctx, cancel := context.WithTimeout(l.ctx, AcceptTimeout)
+			ctx, cancel := withTimeout(l.ctx, l.acceptTimeout)
 			defer cancel()
 
 			result := make(chan transport.Conn, 1)
@@ -161,80 +340,147 @@ func (l *listener) handleIncoming() {
 				defer wg.Done()
 				defer close(result)
 
-				if l.protec != nil {
-					pc, err := l.protec.Protect(conn)
-					if err != nil {
-						conn.Close()
-						log.Warning("protector failed: ", err)
-						return
-					}
-					conn = pc
+				c, err := l.handshake(ctx, conn, id, scope, accepted)
+				if err == nil && c != nil {
+					watchIdle(c, l.idleTimeout)
+					l.history.addConn("accept", id, remotePeer(c), conn.RemoteMultiaddr(), nil)
+					result <- c
+				} else if err != nil && ctx.Err() == nil {
+					l.history.addConn("accept", id, "", conn.RemoteMultiaddr(), err)
 				}
-
-				// If we have a wrapper func, wrap this conn
-				if l.wrapper != nil {
-					conn = l.wrapper(conn)
-				}
-
-				// Negotiate secio (or no secio).
-				_, _, err = l.mux.Negotiate(conn)
-				if err != nil {
-					conn.Close()
-					log.Warning("incoming conn: negotiation of crypto protocol failed: ", err)
-					return
-				}
-
-				insecureConn := newSingleConn(ctx, l.local, "", conn)
-
-				if l.privk != nil && iconn.EncryptConnections {
-					secureConn, err := newSecureConn(ctx, l.privk, insecureConn)
-					if err != nil {
-						conn.Close()
-						log.Infof("ignoring conn we failed to secure: %s %s", err, insecureConn)
-						return
-					}
-					conn = secureConn
-				} else {
-					log.Warning("listener %s listening INSECURELY!", l)
-					conn = insecureConn
-				}
-
-				result <- conn
 			}(maconn)
 
 			select {
 			case <-ctx.Done():
-				log.Warning("incoming conn: conn not established in time:",
-					ctx.Err().Error())
+				lg.Warningf("incoming conn: conn not established in time: %s", ctx.Err())
+				l.history.addConn("acceptTimeout", id, "", maconn.RemoteMultiaddr(), ctx.Err())
 				// Will cause the other go routine to bail.
 				maconn.Close()
+				// unless it just succeeded.
+				if c, ok := <-result; ok {
+					c.Close()
+				}
+			case <-l.proc.Closing():
+				maconn.Close()
+				if c, ok := <-result; ok {
+					c.Close()
+				}
 			case c, ok := <-result: // connection completed (or errored)
 				if ok {
-					select {
-					case <-l.proc.Closing():
-						maconn.Close()
-					case l.incoming <- connErr{conn: c}:
-					}
+					l.enqueue(c)
 				}
 			}
 		}()
 	}
 }
 
+// handshake sets up an inbound conn with the Upgrader: protection,
+// protocol negotiation and the secio handshake, as configured. It closes
+// conn when it fails. It returns a nil conn without error when conn is
+// handed to the foreign handler.
+func (l *listener) handshake(ctx context.Context, conn transport.Conn, id uint64, scope ResourceScope, accepted time.Time) (c transport.Conn, err error) {
+	ctx = withConnIDValue(ctx, id)
+	lg := withConnID(l.logger, id)
+	raddr := conn.RemoteMultiaddr()
+	ctx, endSpan := startSpan(ctx, "conn.accept", map[string]interface{}{
+		"address": raddr.String(),
+	})
+	at := StagePreamble
+	defer func() {
+		endSpan(err)
+		if err != nil {
+			l.handshakeFailed(ctx, raddr, at, err)
+		}
+		if c == nil {
+			// failed, or handed to the foreign handler.
+			scope.Done()
+		}
+	}()
+
+	tuneSocket(conn, l.buffers, l.tcpOpts, lg)
+
+	if l.foreign != nil && len(l.protecs) == 0 && !securedByTransport(conn, l.trusted) {
+		sniffed, isLibp2p, err := sniff(conn)
+		if err != nil {
+			conn.Close()
+			lg.Debugf("incoming conn: failed to read first byte: %s", err)
+			return nil, err
+		}
+		if !isLibp2p {
+			go l.foreign(sniffed)
+			return nil, nil
+		}
+		conn = sniffed
+	}
+
+	local, sk := l.identity()
+	p := &upgradeParams{
+		inbound:          true,
+		local:            local,
+		sk:               sk,
+		raddr:            raddr,
+		protecs:          l.protecs,
+		protect:          l.protect,
+		fingerprint:      l.fingerprint,
+		wrapper:          l.wrapper,
+		trusted:          l.trusted,
+		mux:              l.mux,
+		stages:           l.stages,
+		preambleTimeout:  l.preambleTimeout,
+		replays:          l.replays,
+		hsPool:           l.hsPool,
+		closing:          l.proc.Closing(),
+		scope:            scope,
+		accepted:         accepted,
+		maxMsg:           l.maxMsg,
+		buffers:          l.buffers,
+		limiter:          l.limiter,
+		msgLimiter:       l.msgLimiter,
+		reporter:         l.reporter,
+		messageMode:      l.messageMode,
+		batching:         l.batching,
+		compression:      l.compression,
+		exchangeObserved: l.exchangeObserved,
+		channelBinding:   l.channelBinding,
+		logger:           lg,
+		span:             "conn.accept",
+		vet: func(remote peer.ID, _ ic.PubKey, _ string) error {
+			return l.checkPeerAllowed(remote)
+		},
+		garbage: l.rejectGarbage,
+	}
+	return l.upgrader.upgrade(ctx, conn, p, &at)
+}
+
 // WrapTransportListener wraps a raw transport.Listener in an iconn.Listener.
 // If sk is not provided, transport encryption is disabled.
 //
 // The Listener will accept connections in the background and attempt to
 // negotiate the protocol before making the wrapped connection available to Accept.
 // If the negotiation and handshake take more than AcceptTimeout, the connection
-// is dropped. However, note that once a connection handshake succeeds, it will
-// wait indefinitely for an Accept call to service it (possibly consuming a goroutine).
+// is dropped. Once a connection handshake succeeds, it waits in a backlog of
+// AcceptBacklog conns for an Accept call to service it. When the backlog is
+// full, AcceptOverflow decides whether the handshake goroutine waits
+// indefinitely for room, or a conn is dropped. Conns that don't open with
+// multistream are closed early, see PreambleTimeout. Conns from
+// SecureCapable transports skip protocol selection and the handshake.
 //
 // The context covers the listener and its background activities, but not the
-// connections once returned from Accept. Calling Close and canceling the
-// context are equivalent.
+// connections once returned from Accept, unless it comes from
+// WithConnLifetime. Calling Close and canceling the context are equivalent.
 //
-// The returned Listener implements ListenerConnWrapper.
+// The returned Listener implements ListenerConnWrapper, ListenerDrainer,
+// ListenerAcceptContext, ListenerAcceptInfo, ListenerMessageMode,
+// ListenerPortSharing, ListenerWriteLimiter, ListenerBandwidthReporter,
+// ListenerGarbageStats, ListenerSecurityProtocols, ListenerStandby,
+// ListenerMessageLimiter, ListenerRecentEvents, ListenerHandshakeErrors,
+// ListenerTrustedTransport, ListenerObservedAddrs, ListenerShutdown,
+// ListenerAudit, ListenerLogger, ListenerMaxMessageSize,
+// ListenerResourceManager, ListenerPNetFingerprint, ListenerWriteBatching,
+// ListenerBufferSizes, ListenerTCPOptions, ListenerHandshakePool,
+// ListenerCompression, ListenerChannelBinding, ListenerPause,
+// ListenerIdentity, ListenerAddrAllowlist, ListenerPeerAllowlist,
+// ListenerIdleTimeout, ListenerUpgrade and ListenerUpgrader.
 func WrapTransportListener(ctx context.Context, ml transport.Listener, local peer.ID,
 	sk ic.PrivKey) (iconn.Listener, error) {
 	return WrapTransportListenerWithProtector(ctx, ml, local, sk, nil)
@@ -242,47 +488,121 @@ func WrapTransportListener(ctx context.Context, ml transport.Listener, local pee
 
 func WrapTransportListenerWithProtector(ctx context.Context, ml transport.Listener, local peer.ID,
 	sk ic.PrivKey, protec ipnet.Protector) (iconn.Listener, error) {
+	var protecs []ipnet.Protector
+	if protec != nil {
+		protecs = append(protecs, protec)
+	}
+	return WrapTransportListenerWithProtectors(ctx, ml, local, sk, protecs)
+}
+
+// WrapTransportListenerWithProtectors is like WrapTransportListener, for a
+// listener in a private network accepting connections protected with any
+// of the given protectors. This allows rotating the network's key without
+// partitioning it: listen with both the new and the old key, and dial
+// with the new one as Dialer.Protector, and the old one in
+// Dialer.RotatedProtectors. With several protectors, the one of each conn
+// is found by its fingerprint, or by its multistream header, so dialers
+// must set Dialer.PNetFingerprint or Dialer.Optimistic, whichever key
+// they have.
+func WrapTransportListenerWithProtectors(ctx context.Context, ml transport.Listener, local peer.ID,
+	sk ic.PrivKey, protecs []ipnet.Protector) (iconn.Listener, error) {
+	l, err := wrapTransportListener(ctx, ml, local, sk, protecs, defaultListenerParams())
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// listenerParams are the settings listeners otherwise pick up from
+// package variables when they are created.
+type listenerParams struct {
+	acceptTimeout   time.Duration
+	preambleTimeout time.Duration
+	stages          stageTimeouts
+	history         int
+	backlog         int
+	overflow        OverflowPolicy
+	replayWindow    time.Duration
+}
+
+func defaultListenerParams() listenerParams {
+	return listenerParams{
+		acceptTimeout:   AcceptTimeout,
+		preambleTimeout: PreambleTimeout,
+		stages:          currentStageTimeouts(),
+		history:         EventHistory,
+		backlog:         AcceptBacklog,
+		overflow:        AcceptOverflow,
+		replayWindow:    ReplayWindow,
+	}
+}
 
-	if protec == nil && ipnet.ForcePrivateNetwork {
+func wrapTransportListener(ctx context.Context, ml transport.Listener, local peer.ID,
+	sk ic.PrivKey, protecs []ipnet.Protector, params listenerParams) (*listener, error) {
+
+	if len(protecs) == 0 && ipnet.ForcePrivateNetwork {
 		log.Error("tried to listen with no Private Network Protector but usage" +
 			" of Private Networks is forced by the enviroment")
-		return nil, ipnet.ErrNotInPrivateNetwork
+		return nil, ErrProtectorRequired
+	}
+
+	timeout, err := resolveTimeout(params.acceptTimeout)
+	if err != nil {
+		return nil, err
+	}
+	preamble, err := resolveTimeout(params.preambleTimeout, defaultPreambleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if params.backlog < 0 {
+		return nil, fmt.Errorf("invalid accept backlog %d", params.backlog)
 	}
 
 	l := &listener{
 		Listener: ml,
 		local:    local,
 		privk:    sk,
-		protec:   protec,
-		mux:      msmux.NewMultistreamMuxer(),
-		incoming: make(chan connErr, connAcceptBuffer),
-		ctx:      ctx,
+		protecs:  protecs,
+
+		acceptTimeout:   timeout,
+		preambleTimeout: preamble,
+		stages:          params.stages,
+		history:         newEventRing(params.history),
+		overflow:        params.overflow,
+
+		incoming: make(chan connErr, params.backlog),
+		taken:    make(chan struct{}),
+
+		handshakesDone: make(chan struct{}),
+		draining:       make(chan struct{}),
+
+		logger: log,
 	}
+	if params.replayWindow > 0 {
+		l.replays = newReplayCache(params.replayWindow)
+	}
+	l.ctx, l.cancel = context.WithCancel(ctx)
 	l.proc = goprocessctx.WithContextAndTeardown(ctx, l.teardown)
 	l.catcher.IsTemp = func(e error) bool {
 		// ignore connection breakages up to this point. but log them
 		if e == io.EOF {
-			log.Debugf("listener ignoring conn with EOF: %s", e)
+			l.logger.Debugf("listener ignoring conn with EOF: %s", e)
 			return true
 		}
 
 		te, ok := e.(tec.Temporary)
 		if ok {
-			log.Debugf("listener ignoring conn with temporary err: %s", e)
+			l.logger.Debugf("listener ignoring conn with temporary err: %s", e)
 			return te.Temporary()
 		}
 		return false
 	}
 
-	if iconn.EncryptConnections && sk != nil {
-		l.mux.AddHandler(SecioTag, nil)
-	} else {
-		l.mux.AddHandler(NoEncryptionTag, nil)
-	}
+	l.mux = newSecurityMuxer(defaultSecurityProtocols(sk))
 
 	go l.handleIncoming()
 
-	log.Debugf("Conn Listener on %s", l.Multiaddr())
+	l.logger.Debugf("Conn Listener on %s", l.Multiaddr())
 	log.Event(ctx, "swarmListen", l)
 	return l, nil
 }
@@ -296,3 +616,91 @@ type ListenerConnWrapper interface {
 func (l *listener) SetConnWrapper(cw ConnWrapper) {
 	l.wrapper = cw
 }
+
+type ListenerDrainer interface {
+	// Drain gracefully shuts the listener down: no new connections are
+	// accepted, but handshakes in progress may finish and their conns be
+	// returned by Accept until ctx is done.
+	Drain(ctx context.Context) error
+}
+
+type ListenerMessageMode interface {
+	// SetMessageMode makes accepted secure conns preserve message
+	// boundaries, like Dialer.MessageMode. It must be called before any
+	// call to Accept.
+	SetMessageMode(bool)
+}
+
+func (l *listener) SetMessageMode(enabled bool) {
+	l.messageMode = enabled
+}
+
+type ListenerPortSharing interface {
+	// SetForeignHandler makes the listener share its port with other
+	// protocols, like TLS or HTTP: connections that don't open with
+	// multistream are handed to h, in a goroutine of their own, instead
+	// of being dropped. h owns the conn, and must close it.
+	//
+	// Telling protocols apart relies on foreign clients speaking first:
+	// conns that send nothing for a short while are taken for libp2p
+	// dialers waiting for the listener's multistream header, which
+	// delays those dials by as much. It is not possible in a private
+	// network, where everything is encrypted; h is then never called.
+	// It must be called before any call to Accept.
+	SetForeignHandler(h func(net.Conn))
+}
+
+func (l *listener) SetForeignHandler(h func(net.Conn)) {
+	if len(l.protecs) > 0 {
+		l.logger.Warningf("listener %s is in a private network, it can't share its port", l)
+	}
+	l.foreign = h
+}
+
+// sniffWindow is how long a listener sharing its port waits for an
+// inbound conn to speak first. TLS and HTTP clients do right away, while
+// msmux dialers wait for the listener's header.
+var sniffWindow = 250 * time.Millisecond
+
+// sniff tells whether conn is a libp2p one: it stays silent for
+// sniffWindow, or opens with multistream. The returned conn reads the
+// byte sniffed again, if any.
+func sniff(conn transport.Conn) (transport.Conn, bool, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(sniffWindow)); err != nil {
+		return nil, false, err
+	}
+	first := make([]byte, 1)
+	_, err := io.ReadFull(conn, first)
+	if derr := conn.SetReadDeadline(time.Time{}); derr != nil {
+		return nil, false, derr
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return conn, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &prefixConn{Conn: conn, prefix: first}, first[0] == mssHeader[0], nil
+}
+
+type ListenerWriteLimiter interface {
+	// SetWriteLimiter rate limits and schedules the writes of accepted
+	// conns with l, like Dialer.Limiter. It must be called before any
+	// call to Accept.
+	SetWriteLimiter(l *WriteLimiter)
+}
+
+func (l *listener) SetWriteLimiter(wl *WriteLimiter) {
+	l.limiter = wl
+}
+
+type ListenerBandwidthReporter interface {
+	// SetBandwidthReporter makes accepted conns report their traffic to
+	// r, like Dialer.Reporter. It must be called before any call to
+	// Accept.
+	SetBandwidthReporter(r BandwidthReporter)
+}
+
+func (l *listener) SetBandwidthReporter(r BandwidthReporter) {
+	l.reporter = r
+}
@@ -2,10 +2,14 @@ package conn
 
 import (
 	"context"
+	"io"
+	"net"
 	"testing"
 	"time"
 
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
 	tu "github.com/libp2p/go-testutil"
+	msmux "github.com/multiformats/go-multistream"
 	grc "github.com/whyrusleeping/gorocheck"
 )
 
@@ -21,7 +25,7 @@ func TestAcceptLeak(t *testing.T) {
 	}
 	p1.Addr = l1.Multiaddr() // Addr has been determined by kernel.
 
-	for i := 0; i < connAcceptBuffer+1; i++ {
+	for i := 0; i < AcceptBacklog+1; i++ {
 		// Dial a full valid connection, but never Accept it, and cancel instead
 		p := tu.RandPeerNetParamsOrFatal(t)
 		d := NewDialer(p.ID, p.PrivKey, nil)
@@ -38,3 +42,278 @@ func TestAcceptLeak(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 5
+	for i := 0; i < n; i++ {
+		p := tu.RandPeerNetParamsOrFatal(t)
+		d := NewDialer(p.ID, p.PrivKey, nil)
+		c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+		if err != nil {
+			t.Fatal("dial failed: ", err)
+		}
+		defer c.Close()
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- l1.(ListenerDrainer).Drain(ctx)
+	}()
+
+	for i := 0; i < n; i++ {
+		c, err := l1.Accept()
+		if err != nil {
+			t.Fatal("conns handshaken before draining should be accepted: ", err)
+		}
+		c.Close()
+	}
+
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l1.Accept(); err == nil {
+		t.Fatal("accept should fail once drained")
+	}
+
+	p := tu.RandPeerNetParamsOrFatal(t)
+	d := NewDialer(p.ID, p.PrivKey, nil)
+	if c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID); err == nil {
+		c.Close()
+		t.Fatal("dial should fail once drained")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := tu.RandPeerNetParamsOrFatal(t)
+	d := NewDialer(p.ID, p.PrivKey, nil)
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal("dial failed: ", err)
+	}
+	defer c.Close()
+
+	// never Accept, so the drain can't complete.
+	dctx, dcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer dcancel()
+	if err := l1.(ListenerDrainer).Drain(dctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected drain to time out, got: %v", err)
+	}
+}
+
+func TestShutdownStalledHandshake(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a peer that never says a word.
+	c, err := net.Dial("tcp", l1.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	dctx, dcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer dcancel()
+	report, err := l1.(ListenerShutdown).Shutdown(dctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected shutdown to time out, got: %v", err)
+	}
+	if report.HandshakesAborted != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Duration > time.Second {
+		t.Fatalf("shutdown took %s past its context", report.Duration)
+	}
+}
+
+func TestForeignHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	opened := make(chan string, 1)
+	l1.(ListenerPortSharing).SetForeignHandler(func(c net.Conn) {
+		defer c.Close()
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Error(err)
+		}
+		opened <- string(buf)
+	})
+	go echoListen(ctx, l1)
+
+	for _, hello := range []string{"GET / HTTP/1.0\r\n\r\n", "\x16\x03\x01\x02\x00\x01\x00"} {
+		raw, err := net.Dial("tcp", l1.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer raw.Close()
+		if _, err := raw.Write([]byte(hello)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-opened:
+			if got != hello[:3] {
+				t.Fatalf("foreign handler got %q", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("foreign handler not called for %q", hello)
+		}
+	}
+
+	// libp2p conns still go through, msmux clients waiting for the
+	// listener's header included.
+	raw, err := net.Dial("tcp", l1.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := msmux.SelectProtoOrFail(SecioTag, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOneSendRecv(t, c, c)
+	c.Close()
+
+	select {
+	case got := <-opened:
+		t.Fatalf("libp2p conn handed to the foreign handler, opening with %q", got)
+	default:
+	}
+}
+
+func TestAcceptOverflow(t *testing.T) {
+	defer func(n int, p OverflowPolicy) {
+		AcceptBacklog, AcceptOverflow = n, p
+	}(AcceptBacklog, AcceptOverflow)
+	AcceptBacklog = 1
+
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest} {
+		t.Run(policy.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			AcceptOverflow = policy
+			p1 := tu.RandPeerNetParamsOrFatal(t)
+			l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l1.Close()
+
+			var dialed []tu.PeerNetParams
+			for i := 0; i < 2; i++ {
+				p := tu.RandPeerNetParamsOrFatal(t)
+				d := NewDialer(p.ID, p.PrivKey, nil)
+				d.AddDialer(dialer(t, p.Addr))
+				c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close()
+				dialed = append(dialed, p)
+				time.Sleep(100 * time.Millisecond) // let the listener queue it
+			}
+
+			c, err := l1.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			kept := dialed[0].ID
+			if policy == OverflowDropOldest {
+				kept = dialed[1].ID
+			}
+			if remote := c.(iconn.Conn).RemotePeer(); remote != kept {
+				t.Fatalf("expected to accept %s, got %s", kept, remote)
+			}
+			if n := len(l1.(*listener).incoming); n != 0 {
+				t.Fatalf("expected an empty backlog, got %d conns", n)
+			}
+		})
+	}
+}
+
+func TestAcceptContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	la := l1.(ListenerAcceptContext)
+
+	actx, acancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer acancel()
+	if _, err := la.AcceptContext(actx); err != context.DeadlineExceeded {
+		t.Fatal("expected the accept to time out, got: ", err)
+	}
+
+	// the listener is still usable.
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ac, err := la.AcceptContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac.Close()
+
+	l1.Close()
+	if _, err := la.AcceptContext(ctx); err != ErrListenerClosed {
+		t.Fatal("expected the listener to be closed, got: ", err)
+	}
+}
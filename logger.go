@@ -0,0 +1,61 @@
+package conn
+
+import (
+	"fmt"
+)
+
+// Logger is what dialers and listeners log with, instead of the go-log
+// logger "conn", for embedders with logging stacks of their own. Lines
+// about a conn start with its ID, as "conn 42: ", so they can be told
+// apart. Events still go to go-log.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// ListenerLogger is implemented by listeners that can log with a Logger
+// other than the package one.
+type ListenerLogger interface {
+	// SetLogger makes the listener log with lg. It must be called before
+	// any call to Accept.
+	SetLogger(lg Logger)
+}
+
+func (l *listener) SetLogger(lg Logger) {
+	l.logger = lg
+}
+
+func (d *Dialer) logger() Logger {
+	if d.Logger != nil {
+		return d.Logger
+	}
+	return log
+}
+
+// connLogger prefixes the lines it logs with the ID of a conn.
+type connLogger struct {
+	Logger
+	prefix string
+}
+
+func withConnID(lg Logger, id uint64) Logger {
+	return connLogger{Logger: lg, prefix: fmt.Sprintf("conn %d: ", id)}
+}
+
+func (l connLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debugf(l.prefix+format, args...)
+}
+
+func (l connLogger) Infof(format string, args ...interface{}) {
+	l.Logger.Infof(l.prefix+format, args...)
+}
+
+func (l connLogger) Warningf(format string, args ...interface{}) {
+	l.Logger.Warningf(l.prefix+format, args...)
+}
+
+func (l connLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Errorf(l.prefix+format, args...)
+}
@@ -0,0 +1,63 @@
+package conn
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// recordLogger keeps the lines logged with it.
+type recordLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordLogger) logf(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Debugf(format string, args ...interface{}) {
+	l.logf("debug", format, args...)
+}
+
+func (l *recordLogger) Infof(format string, args ...interface{}) {
+	l.logf("info", format, args...)
+}
+
+func (l *recordLogger) Warningf(format string, args ...interface{}) {
+	l.logf("warning", format, args...)
+}
+
+func (l *recordLogger) Errorf(format string, args ...interface{}) {
+	l.logf("error", format, args...)
+}
+
+func TestConnLogger(t *testing.T) {
+	rec := &recordLogger{}
+	lg := withConnID(rec, 42)
+	lg.Debugf("dialed %s", "QmPeer")
+	lg.Warningf("100%% %s", "insecure")
+
+	expected := []string{"debug conn 42: dialed QmPeer", "warning conn 42: 100% insecure"}
+	if fmt.Sprint(rec.lines) != fmt.Sprint(expected) {
+		t.Fatalf("expected %q, got %q", expected, rec.lines)
+	}
+}
+
+func TestDialerLogger(t *testing.T) {
+	var d Dialer
+	if d.logger() != Logger(log) {
+		t.Fatal("expected the package logger by default")
+	}
+	rec := &recordLogger{}
+	d.Logger = rec
+	if d.logger() != Logger(rec) {
+		t.Fatal("expected the Dialer's logger")
+	}
+
+	if a, b := nextConnID(), nextConnID(); b <= a {
+		t.Fatalf("conn IDs %d, %d not increasing", a, b)
+	}
+}
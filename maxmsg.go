@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	msgio "github.com/libp2p/go-msgio"
+)
+
+// MessageTooLargeError is returned when reading a message, or a secio
+// frame, longer than allowed, before allocating anything for it, and when
+// writing a message longer than allowed. It matches msgio.ErrMsgTooLarge.
+type MessageTooLargeError struct {
+	Size uint64
+	Max  int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds the maximum of %d", e.Size, e.Max)
+}
+
+func (e *MessageTooLargeError) Is(target error) bool {
+	return target == msgio.ErrMsgTooLarge
+}
+
+// ListenerMaxMessageSize is implemented by listeners that can cap the
+// size of the messages their conns accept.
+type ListenerMaxMessageSize interface {
+	// SetMaxMessageSize caps the messages and secio frames accepted
+	// conns read, from the handshake on, at max bytes, like
+	// Dialer.MaxMessageSize. It must be called before any call to Accept.
+	SetMaxMessageSize(max int)
+}
+
+func (l *listener) SetMaxMessageSize(max int) {
+	l.maxMsg = max
+}
+
+// resolveMaxMessageSize returns max, or MaxMessageSize if max isn't set.
+func resolveMaxMessageSize(max int) int {
+	if max > 0 {
+		return max
+	}
+	return MaxMessageSize
+}
+
+// maxFrameOverhead is the most a secio frame adds to the data it carries:
+// the MAC, of up to SHA-512.
+const maxFrameOverhead = 64
+
+// frameGuard checks the length prefixes of the secio frames read through
+// it, and fails on frames longer than max before secio allocates for
+// them. It needs secio to read a frame's length on its own, which it
+// does.
+type frameGuard struct {
+	io.ReadWriteCloser
+	max int
+
+	hdr  [4]byte
+	nhdr int    // bytes of hdr read so far
+	left uint32 // bytes of the current frame left
+	err  error
+}
+
+func (g *frameGuard) Read(b []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	if g.left == 0 {
+		if len(b) > len(g.hdr)-g.nhdr {
+			b = b[:len(g.hdr)-g.nhdr]
+		}
+		n, err := g.ReadWriteCloser.Read(b)
+		g.nhdr += copy(g.hdr[g.nhdr:], b[:n])
+		if g.nhdr == len(g.hdr) {
+			g.nhdr = 0
+			g.left = binary.BigEndian.Uint32(g.hdr[:])
+			if uint64(g.left) > uint64(g.max) {
+				g.err = &MessageTooLargeError{Size: uint64(g.left), Max: g.max}
+				return n, g.err
+			}
+		}
+		return n, err
+	}
+
+	if uint64(len(b)) > uint64(g.left) {
+		b = b[:g.left]
+	}
+	n, err := g.ReadWriteCloser.Read(b)
+	g.left -= uint32(n)
+	return n, err
+}
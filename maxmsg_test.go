@@ -0,0 +1,67 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	msgio "github.com/libp2p/go-msgio"
+)
+
+func lengthPrefix(size int) []byte {
+	var lbuf [4]byte
+	binary.BigEndian.PutUint32(lbuf[:], uint32(size))
+	return lbuf[:]
+}
+
+func TestFrameGuard(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(lengthPrefix(100))
+	stream.Write(make([]byte, 100))
+	stream.Write(lengthPrefix(164))
+	stream.Write(make([]byte, 164))
+	stream.Write(lengthPrefix(1 << 30))
+	stream.Write(make([]byte, 64))
+
+	g := &frameGuard{ReadWriteCloser: nopCloser{&stream}, max: 164}
+	for _, size := range []int{100, 164} {
+		var lbuf [4]byte
+		if _, err := io.ReadFull(g, lbuf[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(g, make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := ioutil.ReadAll(g)
+	var tooLarge *MessageTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Size != 1<<30 || tooLarge.Max != 164 {
+		t.Fatalf("expected the huge frame refused, got %v", err)
+	}
+	if !errors.Is(err, msgio.ErrMsgTooLarge) {
+		t.Fatal("MessageTooLargeError should match msgio.ErrMsgTooLarge")
+	}
+	if stream.Len() != 64 {
+		t.Fatal("read past the refused length prefix")
+	}
+}
+
+func TestFramerMaxMessageSize(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(lengthPrefix(200))
+
+	f := &msgFramer{rw: &stream, max: 100}
+	if _, err := f.ReadMsg(); !errors.Is(err, msgio.ErrMsgTooLarge) {
+		t.Fatalf("expected the message refused, got %v", err)
+	}
+}
+
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,129 @@
+package conn
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	ci "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// MisdialCacheTTL is how long a Dialer remembers which peer actually
+// answered at an address, after dialing it expecting somebody else.
+var MisdialCacheTTL = 5 * time.Minute
+
+// misdialCacheSize bounds the number of addresses a Dialer remembers
+// misdials at.
+const misdialCacheSize = 1024
+
+// MisdialError is returned by Dial when the remote proves a different
+// identity than the one dialed. It is also returned, wrapping the actual
+// failure as Err, when dialing an address that recently turned out to
+// belong to another peer.
+type MisdialError struct {
+	Addr     ma.Multiaddr
+	Expected peer.ID
+
+	// Actual is the peer which proved its identity at Addr, and ActualKey
+	// its public key.
+	Actual    peer.ID
+	ActualKey ci.PubKey
+
+	// Seen is when Actual was found at Addr.
+	Seen time.Time
+
+	Err error
+}
+
+func (e *MisdialError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("dial to %s through %s failed: %s (%s answered there %s ago)",
+			e.Expected, e.Addr, e.Err, e.Actual, time.Since(e.Seen))
+	}
+	return fmt.Sprintf("misdial to %s through %s (got %s)", e.Expected, e.Addr, e.Actual)
+}
+
+func (e *MisdialError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrPeerIDMismatch, for actual misdials.
+func (e *MisdialError) Is(target error) bool {
+	return e.Err == nil && target == ErrPeerIDMismatch
+}
+
+// misdialCache maps addresses to the last misdial seen there.
+// The zero value is ready to use.
+type misdialCache struct {
+	mu      sync.Mutex
+	entries map[string]*MisdialError
+	order   []misdialEntry // oldest first
+}
+
+type misdialEntry struct {
+	key string
+	e   *MisdialError
+}
+
+func (mc *misdialCache) add(e *MisdialError) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.entries == nil {
+		mc.entries = make(map[string]*MisdialError)
+	}
+	// drop the expired entries, and the oldest ones past the size.
+	for len(mc.order) > 0 && (len(mc.order) >= misdialCacheSize || time.Since(mc.order[0].e.Seen) > MisdialCacheTTL) {
+		old := mc.order[0]
+		if mc.entries[old.key] == old.e {
+			delete(mc.entries, old.key)
+		}
+		mc.order = mc.order[1:]
+	}
+
+	key := e.Addr.String()
+	mc.entries[key] = e
+	mc.order = append(mc.order, misdialEntry{key: key, e: e})
+}
+
+func (mc *misdialCache) remove(addr ma.Multiaddr) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	delete(mc.entries, addr.String())
+}
+
+// annotate returns err enriched with what was last seen at addr, if
+// somebody else than remote recently answered there.
+func (mc *misdialCache) annotate(addr ma.Multiaddr, remote peer.ID, err error) error {
+	if _, ok := err.(*MisdialError); ok {
+		return err
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	key := addr.String()
+	e, ok := mc.entries[key]
+	if !ok {
+		return err
+	}
+	if time.Since(e.Seen) > MisdialCacheTTL {
+		delete(mc.entries, key)
+		return err
+	}
+	if e.Actual == remote {
+		return err
+	}
+
+	return &MisdialError{
+		Addr:      addr,
+		Expected:  remote,
+		Actual:    e.Actual,
+		ActualKey: e.ActualKey,
+		Seen:      e.Seen,
+		Err:       err,
+	}
+}
@@ -0,0 +1,133 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestMisdialCache(t *testing.T) {
+	var mc misdialCache
+
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	expected := tu.RandPeerIDFatal(t)
+	actual := tu.RandPeerIDFatal(t)
+	dialErr := errors.New("dial failed")
+
+	if err := mc.annotate(addr, expected, dialErr); err != dialErr {
+		t.Fatal("nothing cached yet, error should be untouched")
+	}
+
+	mc.add(&MisdialError{Addr: addr, Expected: expected, Actual: actual, Seen: time.Now()})
+
+	err := mc.annotate(addr, expected, dialErr)
+	merr, ok := err.(*MisdialError)
+	if !ok {
+		t.Fatalf("expected a MisdialError, got %s", err)
+	}
+	if merr.Actual != actual || merr.Err != dialErr {
+		t.Fatal("MisdialError doesn't report the cached peer: ", merr)
+	}
+
+	// dialing the peer which actually lives there is not a misdial.
+	if err := mc.annotate(addr, actual, dialErr); err != dialErr {
+		t.Fatal("error should be untouched when dialing the cached peer")
+	}
+
+	mc.remove(addr)
+	if err := mc.annotate(addr, expected, dialErr); err != dialErr {
+		t.Fatal("error should be untouched once the entry is removed")
+	}
+}
+
+func TestMisdialCacheExpires(t *testing.T) {
+	defer func(old time.Duration) {
+		MisdialCacheTTL = old
+	}(MisdialCacheTTL)
+	MisdialCacheTTL = time.Millisecond
+
+	var mc misdialCache
+
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	dialErr := errors.New("dial failed")
+	mc.add(&MisdialError{Addr: addr, Actual: tu.RandPeerIDFatal(t), Seen: time.Now()})
+
+	time.Sleep(5 * time.Millisecond)
+	if err := mc.annotate(addr, tu.RandPeerIDFatal(t), dialErr); err != dialErr {
+		t.Fatal("expired entries should not be reported")
+	}
+}
+
+func TestMisdialCacheBounded(t *testing.T) {
+	var mc misdialCache
+
+	first := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	mc.add(&MisdialError{Addr: first, Actual: tu.RandPeerIDFatal(t), Seen: time.Now()})
+	for i := 2; i <= misdialCacheSize+10; i++ {
+		addr := ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", i))
+		mc.add(&MisdialError{Addr: addr, Seen: time.Now()})
+	}
+
+	if n := len(mc.entries); n > misdialCacheSize {
+		t.Fatalf("cache grew to %d entries, past its size of %d", n, misdialCacheSize)
+	}
+	if len(mc.order) > misdialCacheSize {
+		t.Fatalf("cache order grew to %d entries", len(mc.order))
+	}
+	dialErr := errors.New("dial failed")
+	if err := mc.annotate(first, tu.RandPeerIDFatal(t), dialErr); err != dialErr {
+		t.Fatal("the oldest entry should have been evicted")
+	}
+}
+
+func TestMisdialCacheSweeps(t *testing.T) {
+	defer func(old time.Duration) {
+		MisdialCacheTTL = old
+	}(MisdialCacheTTL)
+	MisdialCacheTTL = time.Millisecond
+
+	var mc misdialCache
+	mc.add(&MisdialError{Addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), Seen: time.Now()})
+	time.Sleep(5 * time.Millisecond)
+	mc.add(&MisdialError{Addr: ma.StringCast("/ip4/1.2.3.4/tcp/2"), Seen: time.Now()})
+
+	if n := len(mc.entries); n != 1 {
+		t.Fatalf("expected the expired entry to be swept, got %d entries", n)
+	}
+}
+
+func TestMisdialReportsActualPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	p3 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d2 := NewDialer(p2.ID, p2.PrivKey, nil)
+	d2.AddDialer(dialer(t, p2.Addr))
+
+	_, err = d2.Dial(ctx, l1.Multiaddr(), p3.ID)
+	merr, ok := err.(*MisdialError)
+	if !ok {
+		t.Fatalf("expected a MisdialError, got %v", err)
+	}
+	if merr.Actual != p1.ID || merr.Expected != p3.ID {
+		t.Fatal("MisdialError reports the wrong peers: ", merr)
+	}
+	if merr.ActualKey == nil || !merr.ActualKey.Equals(p1.PubKey) {
+		t.Fatal("MisdialError should carry the key presented by the remote")
+	}
+}
@@ -0,0 +1,195 @@
+package conn
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// MessageLimiter caps the rate at which secure conns receive messages,
+// that is secio frames, regardless of their size: floods of tiny frames
+// are cheap in bytes, but each costs a MAC check, a decryption and
+// syscalls. Frames over the rate are not read until allowed, which
+// pushes back on the sender through the transport's flow control.
+// Insecure conns have no frames, and aren't limited.
+//
+// Set it on a Dialer and on listeners with ListenerMessageLimiter. The
+// same limiter may be shared by any number of them, the per peer limit
+// then applying to the conns to a peer across all of them.
+type MessageLimiter struct {
+	// PerConn is the maximum number of frames per second read from each
+	// conn. Zero means no limit.
+	PerConn float64
+
+	// PerPeer is the maximum number of frames per second read from all
+	// the conns to a peer together. Zero means no limit.
+	PerPeer float64
+
+	// Burst is how many frames may be read at once above the rates.
+	// Zero means one second worth of frames.
+	Burst int
+
+	mu    sync.Mutex
+	peers map[peer.ID]*peerBucket
+}
+
+type peerBucket struct {
+	tokenBucket
+	conns int
+}
+
+// tokenBucket is a token bucket letting tokens be taken in advance.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token out of a bucket filling at rate per second, up to
+// burst, and returns how long to wait until it is actually there.
+func (b *tokenBucket) take(now time.Time, rate float64, burst float64) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+rate*now.Sub(b.last).Seconds())
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func (l *MessageLimiter) burst(rate float64) float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// msgLimit applies a MessageLimiter to a conn.
+type msgLimit struct {
+	limiter *MessageLimiter
+	remote  peer.ID
+	conn    tokenBucket
+	peer    *peerBucket
+	ready   time.Time // see next, guarded by the conn's frameMu
+
+	closeOnce sync.Once
+}
+
+func (l *MessageLimiter) attach(remote peer.ID) *msgLimit {
+	ml := &msgLimit{limiter: l, remote: remote}
+	if l.PerPeer <= 0 {
+		return ml
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.peers == nil {
+		l.peers = make(map[peer.ID]*peerBucket)
+	}
+	pb := l.peers[remote]
+	if pb == nil {
+		pb = new(peerBucket)
+		l.peers[remote] = pb
+	}
+	pb.conns++
+	ml.peer = pb
+	return ml
+}
+
+// detach forgets the conn. A peer's bucket goes when its last conn does.
+func (ml *msgLimit) detach() {
+	ml.closeOnce.Do(func() {
+		if ml.peer == nil {
+			return
+		}
+		l := ml.limiter
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		ml.peer.conns--
+		if ml.peer.conns == 0 {
+			delete(l.peers, ml.remote)
+		}
+	})
+}
+
+// next returns when the next frame may be read. The token taken for it
+// is kept for the calls after until done is called, so that waits cut
+// short by the read deadline don't take several.
+func (ml *msgLimit) next() time.Time {
+	if !ml.ready.IsZero() {
+		return ml.ready
+	}
+	l := ml.limiter
+	now := time.Now()
+
+	var d time.Duration
+	l.mu.Lock()
+	if l.PerConn > 0 {
+		d = ml.conn.take(now, l.PerConn, l.burst(l.PerConn))
+	}
+	if ml.peer != nil {
+		if pd := ml.peer.take(now, l.PerPeer, l.burst(l.PerPeer)); pd > d {
+			d = pd
+		}
+	}
+	l.mu.Unlock()
+
+	ml.ready = now.Add(d)
+	return ml.ready
+}
+
+// done marks the token taken by next as used.
+func (ml *msgLimit) done() {
+	ml.ready = time.Time{}
+}
+
+// waitMsgLimit blocks until the message limiter lets the next frame be
+// read, the read deadline passes, or c is closed. frameMu must be held.
+func (c *secureConn) waitMsgLimit() error {
+	ready := c.msgLimit.next()
+	for {
+		now := time.Now()
+		if !now.Before(ready) {
+			c.msgLimit.done()
+			return nil
+		}
+		dl, changed := c.deadline()
+		if !dl.IsZero() && !now.Before(dl) {
+			return errDeadline
+		}
+		wake := ready
+		if !dl.IsZero() && dl.Before(wake) {
+			wake = dl
+		}
+
+		select {
+		case <-c.armReadTimer(wake.Sub(now)):
+			continue
+		case <-changed:
+		case <-c.done:
+			c.stopReadTimer()
+			return errConnClosed
+		}
+		c.stopReadTimer()
+	}
+}
+
+func (c *secureConn) setMessageLimiter(l *MessageLimiter) {
+	c.msgLimit = l.attach(c.RemotePeer())
+}
+
+type ListenerMessageLimiter interface {
+	// SetMessageLimiter caps the rate at which accepted secure conns
+	// receive frames with l, like Dialer.MessageLimiter. It must be
+	// called before any call to Accept.
+	SetMessageLimiter(l *MessageLimiter)
+}
+
+func (l *listener) SetMessageLimiter(ml *MessageLimiter) {
+	l.msgLimiter = ml
+}
@@ -0,0 +1,114 @@
+package conn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if d := b.take(now, 10, 2); d != 0 {
+			t.Fatalf("token %d of the burst delayed by %s", i, d)
+		}
+	}
+	if d := b.take(now, 10, 2); d != 100*time.Millisecond {
+		t.Fatalf("token past the burst delayed by %s", d)
+	}
+	// refills at the rate, taking the advance into account.
+	if d := b.take(now.Add(300*time.Millisecond), 10, 2); d != 0 {
+		t.Fatalf("refilled token delayed by %s", d)
+	}
+}
+
+func TestMessageLimiter(t *testing.T) {
+	l := &MessageLimiter{PerPeer: 20, Burst: 1}
+	a := l.attach("peer")
+	b := l.attach("peer")
+
+	wait := func(ml *msgLimit) {
+		time.Sleep(time.Until(ml.next()))
+		ml.done()
+	}
+
+	// both conns draw from the peer's bucket.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		wait(a)
+		wait(b)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("6 frames at 20/s read in %s", d)
+	}
+
+	a.detach()
+	a.detach()
+	if l.peers["peer"].conns != 1 {
+		t.Fatal("detach not idempotent")
+	}
+	b.detach()
+	if len(l.peers) != 0 {
+		t.Fatal("bucket kept after the last conn")
+	}
+}
+
+// Reads held back by the limiter still honor the read deadline, and
+// Close.
+func TestMessageLimiterDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	d2 := NewDialer(p2.ID, p2.PrivKey, nil)
+	d2.MessageLimiter = &MessageLimiter{PerConn: 0.1, Burst: 1}
+	d2.AddDialer(dialer(t, p2.Addr))
+	c2, err := d2.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c1, err := l1.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+
+	for _, m := range []string{"hello", "world"} {
+		if _, err := c1.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 5)
+	if _, err := c2.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second frame is 10s away.
+	start := time.Now()
+	c2.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = c2.Read(buf)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	c2.SetReadDeadline(time.Time{})
+	time.AfterFunc(50*time.Millisecond, func() { c2.Close() })
+	if _, err := c2.Read(buf); err == nil {
+		t.Fatal("read on a closed conn should fail")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("limited reads took %s to give up", d)
+	}
+}
@@ -0,0 +1,127 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	tec "github.com/jbenet/go-temp-err-catcher"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	peer "github.com/libp2p/go-libp2p-peer"
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// MultiListener is a listener accepting from several transport listeners
+// at once, e.g. TCP on IPv4 and IPv6, with one accept loop, backlog and
+// configuration. It implements the same interfaces as the listeners of
+// WrapTransportListener. Multiaddr returns the address of the first
+// transport listener; Multiaddrs returns them all.
+type MultiListener struct {
+	*listener
+	mls *multiTransportListener
+}
+
+// WrapTransportListeners is like WrapTransportListenerWithProtectors, for
+// several transport listeners. Closing the MultiListener closes them
+// all. A permanent accept error from any of them closes the
+// MultiListener, as it would a single listener.
+func WrapTransportListeners(ctx context.Context, mls []transport.Listener, local peer.ID,
+	sk ic.PrivKey, protecs []ipnet.Protector) (*MultiListener, error) {
+	if len(mls) == 0 {
+		return nil, errors.New("no transport listeners to wrap")
+	}
+
+	ml := newMultiTransportListener(mls)
+	l, err := wrapTransportListener(ctx, ml, local, sk, protecs, defaultListenerParams())
+	if err != nil {
+		return nil, err
+	}
+	return &MultiListener{listener: l, mls: ml}, nil
+}
+
+// Multiaddrs returns the addresses of all the transport listeners, in
+// order.
+func (l *MultiListener) Multiaddrs() []ma.Multiaddr {
+	addrs := make([]ma.Multiaddr, len(l.mls.listeners))
+	for i, ml := range l.mls.listeners {
+		addrs[i] = ml.Multiaddr()
+	}
+	return addrs
+}
+
+// multiTransportListener merges the accepts of several transport
+// listeners.
+type multiTransportListener struct {
+	listeners []transport.Listener
+
+	accepts   chan connErr
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiTransportListener(mls []transport.Listener) *multiTransportListener {
+	l := &multiTransportListener{
+		listeners: mls,
+		accepts:   make(chan connErr),
+		closed:    make(chan struct{}),
+	}
+	for _, ml := range mls {
+		go l.acceptFrom(ml)
+	}
+	return l
+}
+
+func (l *multiTransportListener) acceptFrom(ml transport.Listener) {
+	for {
+		c, err := ml.Accept()
+		select {
+		case l.accepts <- connErr{conn: c, err: err}:
+		case <-l.closed:
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+		if err != nil && !isTemporary(err) {
+			return
+		}
+	}
+}
+
+func isTemporary(err error) bool {
+	te, ok := err.(tec.Temporary)
+	return ok && te.Temporary()
+}
+
+func (l *multiTransportListener) Accept() (transport.Conn, error) {
+	select {
+	case ce := <-l.accepts:
+		return ce.conn, ce.err
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *multiTransportListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		for _, ml := range l.listeners {
+			if cerr := ml.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+func (l *multiTransportListener) Addr() net.Addr {
+	return l.listeners[0].Addr()
+}
+
+func (l *multiTransportListener) Multiaddr() ma.Multiaddr {
+	return l.listeners[0].Multiaddr()
+}
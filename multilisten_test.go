@@ -0,0 +1,56 @@
+package conn
+
+import (
+	"context"
+	"testing"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	tcpt "github.com/libp2p/go-tcp-transport"
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestMultiListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	var mls []transport.Listener
+	for i := 0; i < 2; i++ {
+		ml, err := tcpt.NewTCPTransport().Listen(p1.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mls = append(mls, ml)
+	}
+	l, err := WrapTransportListeners(ctx, mls, p1.ID, p1.PrivKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoListen(ctx, l)
+
+	addrs := l.Multiaddrs()
+	if len(addrs) != 2 || addrs[0].Equal(addrs[1]) {
+		t.Fatalf("unexpected listen addresses %v", addrs)
+	}
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	for _, a := range addrs {
+		c, err := d.Dial(ctx, a, p1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testOneSendRecv(t, c, c)
+		c.Close()
+	}
+
+	l.Close()
+	for _, ml := range mls {
+		if _, err := ml.Accept(); err == nil {
+			t.Fatal("transport listener still open")
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package conn
+
+import (
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// Preambles of conns, as reported by NegotiatedPreamble.
+const (
+	// PreambleMultistream is plain multistream.
+	PreambleMultistream = msmux.ProtocolID
+	// PreamblePrivateNetwork is multistream within a private network.
+	PreamblePrivateNetwork = "/pnet/1.0.0"
+	// PreamblePNetFingerprint is a private network fingerprint check,
+	// see Dialer.PNetFingerprint, followed by multistream.
+	PreamblePNetFingerprint = "/libp2p/pnet-fingerprint/1.0.0"
+)
+
+// Negotiated is implemented by the conns returned by Dial and Accept. It
+// tells what was spoken to set them up, for diagnostics and
+// compatibility shims.
+type Negotiated interface {
+	// NegotiatedSecurity is the security protocol selected with
+	// multistream, or "" over a trusted transport, which selects none.
+	NegotiatedSecurity() string
+	// NegotiatedPreamble is how the conn opened, before security
+	// selection: PreambleMultistream, PreamblePrivateNetwork,
+	// PreamblePNetFingerprint or, over a trusted transport outside of
+	// any private network, "".
+	NegotiatedPreamble() string
+}
+
+// negotiated holds what a conn negotiated.
+type negotiated struct {
+	security string
+	preamble string
+}
+
+func (n *negotiated) NegotiatedSecurity() string {
+	return n.security
+}
+
+func (n *negotiated) NegotiatedPreamble() string {
+	return n.preamble
+}
+
+func (c *secureConn) NegotiatedSecurity() string {
+	if n, ok := c.insecure.(Negotiated); ok {
+		return n.NegotiatedSecurity()
+	}
+	return SecioTag
+}
+
+func (c *secureConn) NegotiatedPreamble() string {
+	if n, ok := c.insecure.(Negotiated); ok {
+		return n.NegotiatedPreamble()
+	}
+	return ""
+}
+
+// preambleOf returns the preamble of conns opening with multistream, if
+// so, with or without protection and fingerprint checks.
+func preambleOf(multistream, protected, fingerprint bool) string {
+	switch {
+	case protected && fingerprint:
+		return PreamblePNetFingerprint
+	case protected:
+		return PreamblePrivateNetwork
+	case multistream:
+		return PreambleMultistream
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package conn
+
+import (
+	"context"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestPreambleOf(t *testing.T) {
+	cases := []struct {
+		multistream, protected, fingerprint bool
+		preamble                            string
+	}{
+		{true, false, false, PreambleMultistream},
+		{true, false, true, PreambleMultistream},
+		{true, true, false, PreamblePrivateNetwork},
+		{true, true, true, PreamblePNetFingerprint},
+		{false, false, false, ""},
+		{false, true, false, PreamblePrivateNetwork},
+	}
+	for _, c := range cases {
+		if p := preambleOf(c.multistream, c.protected, c.fingerprint); p != c.preamble {
+			t.Errorf("preambleOf(%t, %t, %t) = %q, expected %q", c.multistream, c.protected, c.fingerprint, p, c.preamble)
+		}
+	}
+}
+
+func TestNegotiated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	accepted := make(chan Negotiated, 1)
+	go func() {
+		c, err := l1.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		accepted <- c.(Negotiated)
+	}()
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, n := range []Negotiated{c.(Negotiated), <-accepted} {
+		if n.NegotiatedSecurity() != SecioTag || n.NegotiatedPreamble() != PreambleMultistream {
+			t.Fatalf("negotiated %q over %q", n.NegotiatedSecurity(), n.NegotiatedPreamble())
+		}
+	}
+}
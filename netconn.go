@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"net"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// NetListener returns l as a net.Listener, to serve the conns it accepts
+// with http.Serve and the like.
+//
+// The conns returned by Dial and Accept are net.Conns already: they can
+// be handed as they are to the standard library, or to stream
+// multiplexers. Their deadlines work like the ones of net.Conn, and
+// LocalAddr and RemoteAddr are never nil. Only in message mode do reads
+// differ, failing with io.ErrShortBuffer rather than returning part of a
+// frame.
+func NetListener(l iconn.Listener) net.Listener {
+	return netListener{l}
+}
+
+type netListener struct {
+	iconn.Listener
+}
+
+func (l netListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// netAddr returns a, or, for transports with no net.Addr, the one of m.
+func netAddr(a net.Addr, m ma.Multiaddr) net.Addr {
+	if a != nil || m == nil {
+		return a
+	}
+	if na, err := manet.ToNetAddr(m); err == nil {
+		return na
+	}
+	return multiaddrAddr{m}
+}
+
+// multiaddrAddr is the net.Addr of multiaddrs with no net equivalent.
+type multiaddrAddr struct {
+	ma.Multiaddr
+}
+
+func (multiaddrAddr) Network() string {
+	return "multiaddr"
+}
@@ -0,0 +1,57 @@
+package conn
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+var _ net.Conn = (*singleConn)(nil)
+var _ net.Conn = (*secureConn)(nil)
+
+func TestNetListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go http.Serve(NetListener(l1), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + r.RemoteAddr))
+	}))
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.LocalAddr() == nil || c.RemoteAddr() == nil {
+		t.Fatal("conns should have addresses")
+	}
+
+	req, _ := http.NewRequest("GET", "http://"+p1.ID.Pretty()+"/", nil)
+	if err := req.Write(c); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello "+c.LocalAddr().String() {
+		t.Fatalf("unexpected response %q", body)
+	}
+}
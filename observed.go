@@ -0,0 +1,92 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ObservedAddrs is implemented by the conns returned by Dial and Accept.
+// Once both sides exchanged the addresses they see, see
+// Dialer.ExchangeObservedAddrs, NAT'd nodes learn their external address
+// without waiting for identify.
+type ObservedAddrs interface {
+	// ObservedLocalAddr is our address as the remote sees it, or nil if
+	// it didn't tell.
+	ObservedLocalAddr() ma.Multiaddr
+	// ObservedRemoteAddr is the address of the remote as we see it, and
+	// told it, or nil if there was no exchange.
+	ObservedRemoteAddr() ma.Multiaddr
+}
+
+// observed are the addresses exchanged on a conn.
+type observed struct {
+	local, remote ma.Multiaddr
+}
+
+func (o *observed) ObservedLocalAddr() ma.Multiaddr  { return o.local }
+func (o *observed) ObservedRemoteAddr() ma.Multiaddr { return o.remote }
+
+func (c *secureConn) ObservedLocalAddr() ma.Multiaddr {
+	return c.insecure.(ObservedAddrs).ObservedLocalAddr()
+}
+
+func (c *secureConn) ObservedRemoteAddr() ma.Multiaddr {
+	return c.insecure.(ObservedAddrs).ObservedRemoteAddr()
+}
+
+// ListenerObservedAddrs is implemented by listeners that can exchange
+// observed addresses with the conns they accept.
+type ListenerObservedAddrs interface {
+	// SetObservedAddrExchange turns the exchange of observed addresses,
+	// as with Dialer.ExchangeObservedAddrs, on or off. It must be called
+	// before any call to Accept.
+	SetObservedAddrExchange(on bool)
+}
+
+func (l *listener) SetObservedAddrExchange(on bool) {
+	l.exchangeObserved = on
+}
+
+// exchangeObserved tells the remote of c the address we see it at, and
+// records into o the one it sees us at. Both sides write before reading,
+// so it takes a single round trip. It gives up with ctx.
+func exchangeObserved(ctx context.Context, c iconn.Conn, o *observed) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+
+	remote := c.RemoteMultiaddr()
+	var sent []byte
+	if remote != nil {
+		sent = remote.Bytes()
+	}
+	werr := make(chan error, 1)
+	go func() {
+		_, err := c.Write(delimited(string(sent)))
+		werr <- err
+	}()
+
+	msg, err := readDelimited(c)
+	if err == nil {
+		err = <-werr
+	}
+	if err != nil {
+		return fmt.Errorf("exchanging observed addresses: %s", err)
+	}
+
+	o.remote = remote
+	if msg == "" {
+		return nil
+	}
+	local, err := ma.NewMultiaddrBytes([]byte(msg))
+	if err != nil {
+		return fmt.Errorf("invalid observed address from %s: %s", remote, err)
+	}
+	o.local = local
+	return nil
+}
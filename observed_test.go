@@ -0,0 +1,55 @@
+package conn
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// addrConn is a pipeConn with addresses.
+type addrConn struct {
+	pipeConn
+	laddr, raddr ma.Multiaddr
+}
+
+func (c addrConn) LocalMultiaddr() ma.Multiaddr  { return c.laddr }
+func (c addrConn) RemoteMultiaddr() ma.Multiaddr { return c.raddr }
+
+func TestExchangeObserved(t *testing.T) {
+	ctx := context.Background()
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	// behind a NAT, 1 sees 2 at its address, 2 sees 1 at the NAT's.
+	priv := ma.StringCast("/ip4/192.168.1.2/tcp/4001")
+	nat := ma.StringCast("/ip4/1.2.3.4/tcp/50000")
+	addr2 := ma.StringCast("/ip4/5.6.7.8/tcp/4001")
+
+	a, b := net.Pipe()
+	c1 := newSingleConn(ctx, p1.ID, p2.ID, addrConn{pipeConn{a}, priv, addr2})
+	c2 := newSingleConn(ctx, p2.ID, p1.ID, addrConn{pipeConn{b}, addr2, nat})
+	defer c1.Close()
+	defer c2.Close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- exchangeObserved(ctx, c2, &c2.observed) }()
+	if err := exchangeObserved(ctx, c1, &c1.observed); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if !c1.ObservedLocalAddr().Equal(nat) {
+		t.Fatalf("expected to be seen at %s, got %s", nat, c1.ObservedLocalAddr())
+	}
+	if !c1.ObservedRemoteAddr().Equal(addr2) {
+		t.Fatalf("expected to see %s, got %s", addr2, c1.ObservedRemoteAddr())
+	}
+	if !c2.ObservedLocalAddr().Equal(addr2) {
+		t.Fatalf("expected to be seen at %s, got %s", addr2, c2.ObservedLocalAddr())
+	}
+}
@@ -0,0 +1,139 @@
+package conn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// optimisticConn selects proto with multistream without waiting for the
+// listener: the multistream header and the selection go out with the
+// first write, and their echoes are checked before the first read. It
+// saves a round trip on dials, the listener answering the selection and
+// the first handshake message at once.
+type optimisticConn struct {
+	transport.Conn
+	proto string
+
+	wmu      sync.Mutex
+	selected bool
+
+	rmu     sync.Mutex
+	checked bool
+	err     error
+}
+
+func newOptimisticConn(c transport.Conn, proto string) *optimisticConn {
+	return &optimisticConn{Conn: c, proto: proto}
+}
+
+// delimited is msg as multistream sends it: length prefixed and newline
+// terminated.
+func delimited(msg string) []byte {
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(msg)+1)
+	n := binary.PutUvarint(buf, uint64(len(msg)+1))
+	return append(append(buf[:n], msg...), '\n')
+}
+
+func (c *optimisticConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.selected {
+		return c.Conn.Write(b)
+	}
+	c.selected = true
+
+	sel := append(append([]byte{}, mssHeader...), delimited(c.proto)...)
+	n, err := c.Conn.Write(append(sel, b...))
+	n -= len(sel)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+func (c *optimisticConn) Read(b []byte) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// check reads the listener's answer to the selection, once; reading
+// before writing sends the selection on its own.
+func (c *optimisticConn) check() error {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if c.checked {
+		return c.err
+	}
+	c.checked = true
+
+	c.wmu.Lock()
+	selected := c.selected
+	c.wmu.Unlock()
+	if !selected {
+		if _, err := c.Write(nil); err != nil {
+			c.err = err
+			return err
+		}
+	}
+
+	for _, want := range []string{msmux.ProtocolID, c.proto} {
+		got, err := readDelimited(c.Conn)
+		if err != nil {
+			c.err = &Error{Kind: ErrProtocolNegotiationFailed, Err: err}
+			return c.err
+		}
+		if got != want {
+			c.err = &Error{
+				Kind: ErrProtocolNegotiationFailed,
+				Err:  fmt.Errorf("selected %s, got %q", want, got),
+			}
+			return c.err
+		}
+	}
+	return nil
+}
+
+// negotiationErr returns the error of a failed selection, if any.
+func (c *optimisticConn) negotiationErr() error {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	return c.err
+}
+
+// readDelimited reads a multistream message from r, not reading past it.
+func readDelimited(r io.Reader) (string, error) {
+	n, err := binary.ReadUvarint(&byteReader{r: r})
+	if err != nil {
+		return "", err
+	}
+	if n == 0 || n > 1024 {
+		return "", fmt.Errorf("invalid multistream message length %d", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return "", err
+	}
+	if msg[n-1] != '\n' {
+		return "", fmt.Errorf("multistream message %q not newline terminated", msg)
+	}
+	return string(msg[:n-1]), nil
+}
+
+type byteReader struct {
+	r io.Reader
+	b [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(r.r, r.b[:])
+	return r.b[0], err
+}
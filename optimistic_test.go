@@ -0,0 +1,97 @@
+package conn
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+// answerSelection plays a multistream listener supporting proto, on c.
+func answerSelection(t *testing.T, c net.Conn, proto string) {
+	if _, err := c.Write(append(delimited("/multistream/1.0.0"), delimited(proto)...)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOptimisticConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	oc := newOptimisticConn(pipeConn{a}, SecioTag)
+	go func() {
+		hdr := make([]byte, len(mssHeader)+len(delimited(SecioTag))+5)
+		if _, err := io.ReadFull(b, hdr); err != nil {
+			t.Error(err)
+			return
+		}
+		if string(hdr[len(hdr)-5:]) != "hello" {
+			t.Errorf("selection and data not sent together: %q", hdr)
+		}
+		answerSelection(t, b, SecioTag)
+		b.Write([]byte("world"))
+	}()
+
+	if n, err := oc.Write([]byte("hello")); n != 5 || err != nil {
+		t.Fatal(n, err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(oc, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "world" {
+		t.Fatalf("read %q", buf)
+	}
+}
+
+func TestOptimisticConnRefused(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	oc := newOptimisticConn(pipeConn{a}, SecioTag)
+	go func() {
+		hdr := make([]byte, len(mssHeader)+len(delimited(SecioTag)))
+		io.ReadFull(b, hdr)
+		answerSelection(t, b, "na")
+	}()
+
+	// reading first sends the selection on its own.
+	if _, err := oc.Read(make([]byte, 1)); err == nil || !isNegotiationErr(err) {
+		t.Fatalf("expected a negotiation error, got %v", err)
+	}
+	if oc.negotiationErr() == nil {
+		t.Fatal("negotiation error not kept")
+	}
+}
+
+func isNegotiationErr(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Kind == ErrProtocolNegotiationFailed
+}
+
+func TestDialOptimistic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.Optimistic = true
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOneSendRecv(t, c, c)
+	c.Close()
+}
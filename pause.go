@@ -0,0 +1,53 @@
+package conn
+
+// ListenerPause is implemented by listeners that can stop taking new
+// conns for a while, to shed load.
+type ListenerPause interface {
+	// Pause stops taking new conns off the transport listener, leaving
+	// them to the kernel's backlog, until Resume. Conns already taken
+	// are still secured and handed to Accept, and a transport Accept in
+	// progress may still bring in one more. It may be called at any time.
+	Pause()
+
+	// Resume takes new conns again. It does nothing on a listener that
+	// isn't paused.
+	Resume()
+
+	// Paused tells whether the listener is paused.
+	Paused() bool
+}
+
+func (l *listener) Pause() {
+	l.paused.set()
+	log.Event(l.ctx, "listenerPaused", l)
+	l.history.add("paused", "", nil, nil)
+}
+
+func (l *listener) Resume() {
+	if !l.Paused() {
+		return
+	}
+	l.paused.promote()
+	log.Event(l.ctx, "listenerResumed", l)
+	l.history.add("resumed", "", nil, nil)
+}
+
+func (l *listener) Paused() bool {
+	return l.paused.wait() != nil
+}
+
+// waitResumed waits for the listener to be resumed, and tells whether it
+// was, rather than closed or drained first.
+func (l *listener) waitResumed() bool {
+	gate := l.paused.wait()
+	if gate == nil {
+		return true
+	}
+	select {
+	case <-gate:
+		return true
+	case <-l.proc.Closing():
+	case <-l.draining:
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package conn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestListenerPause(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+
+	pl := l1.(ListenerPause)
+	pl.Pause()
+	if !pl.Paused() {
+		t.Fatal("listener should be paused")
+	}
+
+	// the transport Accept already running takes one more conn.
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := d.DialWithTimeout(ctx, l1.Multiaddr(), p1.ID, 200*time.Millisecond); err == nil {
+		t.Fatal("a paused listener shouldn't take new conns")
+	}
+
+	pl.Resume()
+	if pl.Paused() {
+		t.Fatal("listener should be resumed")
+	}
+	c2, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+
+	// Close isn't held up by a pause.
+	pl.Pause()
+	done := make(chan struct{})
+	go func() {
+		l1.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("paused listener didn't close")
+	}
+}
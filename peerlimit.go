@@ -0,0 +1,83 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// dialSlots bounds the dials in flight per key, for
+// Dialer.MaxDialsPerPeer and MaxDialsPerAddr.
+type dialSlots struct {
+	mu    sync.Mutex
+	slots map[string]*dialSlot
+}
+
+type dialSlot struct {
+	sem   chan struct{}
+	users int // holding or waiting for sem
+}
+
+// acquire waits for one of the max slots of key, and returns the func
+// giving it back, or ctx's error.
+func (s *dialSlots) acquire(ctx context.Context, key string, max int) (func(), error) {
+	s.mu.Lock()
+	if s.slots == nil {
+		s.slots = make(map[string]*dialSlot)
+	}
+	slot := s.slots[key]
+	if slot == nil {
+		slot = &dialSlot{sem: make(chan struct{}, max)}
+		s.slots[key] = slot
+	}
+	slot.users++
+	s.mu.Unlock()
+
+	done := func() {
+		s.mu.Lock()
+		if slot.users--; slot.users == 0 {
+			delete(s.slots, key)
+		}
+		s.mu.Unlock()
+	}
+	select {
+	case slot.sem <- struct{}{}:
+		return func() {
+			<-slot.sem
+			done()
+		}, nil
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+}
+
+// acquireDialSlots waits for the per peer and per address dial slots of a
+// dial to remote at raddr, as configured, and returns the func giving
+// them back.
+func (d *Dialer) acquireDialSlots(ctx context.Context, raddr ma.Multiaddr, remote peer.ID) (func(), error) {
+	release := func() {}
+	if d.MaxDialsPerAddr > 0 {
+		r, err := d.addrDials.acquire(ctx, string(remote)+" "+raddr.String(), d.MaxDialsPerAddr)
+		if err != nil {
+			return nil, fmt.Errorf("waiting to dial %s at %s: %w", remote, raddr, err)
+		}
+		release = r
+	}
+	if d.MaxDialsPerPeer > 0 && remote != "" {
+		r, err := d.peerDials.acquire(ctx, string(remote), d.MaxDialsPerPeer)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("waiting to dial %s: %w", remote, err)
+		}
+		addrRelease := release
+		release = func() {
+			r()
+			addrRelease()
+		}
+	}
+	return release, nil
+}
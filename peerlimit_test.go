@@ -0,0 +1,57 @@
+package conn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDialSlots(t *testing.T) {
+	var s dialSlots
+	ctx := context.Background()
+
+	r1, err := s.acquire(ctx, "a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := s.acquire(ctx, "a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other keys have slots of their own.
+	rb, err := s.acquire(ctx, "b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb()
+
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(tctx, "a", 2); err != context.DeadlineExceeded {
+		t.Fatal("expected the third dial to time out waiting, got ", err)
+	}
+
+	got := make(chan func(), 1)
+	go func() {
+		r, err := s.acquire(ctx, "a", 2)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- r
+	}()
+	select {
+	case <-got:
+		t.Fatal("the dial should wait for a slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	r1()
+	r3 := <-got
+	r2()
+	r3()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.slots) != 0 {
+		t.Fatalf("expected no slots left, got %d", len(s.slots))
+	}
+}
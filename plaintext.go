@@ -0,0 +1,68 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// PlaintextIdentityTag is a plaintext security protocol in which both
+// sides send their public key once selected, so that conns know, and
+// dials check, the remote peer. Nothing is encrypted, nor is the key
+// proven, so it is only meant for tests and trusted networks. It needs a
+// private key, and is never used unless listed in SecurityProtocols.
+const PlaintextIdentityTag = "/plaintext-id/1.0.0"
+
+// exchangeIdentity sends our public key over c, and makes the remote of c
+// the peer of the key it sends. If c has a remote peer already, the key
+// must be its. Both sides write before reading, so it takes a single
+// round trip. It gives up with ctx.
+func exchangeIdentity(ctx context.Context, c *singleConn, sk ic.PrivKey) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+
+	ours, err := ic.MarshalPublicKey(sk.GetPublic())
+	if err != nil {
+		return err
+	}
+	werr := make(chan error, 1)
+	go func() {
+		_, err := c.Write(delimited(string(ours)))
+		werr <- err
+	}()
+
+	msg, err := readDelimited(c)
+	if err == nil {
+		err = <-werr
+	}
+	if err != nil {
+		return handshakeErr(ctx, fmt.Errorf("exchanging identities: %s", err))
+	}
+
+	key, err := ic.UnmarshalPublicKey([]byte(msg))
+	if err != nil {
+		return fmt.Errorf("invalid public key from %s: %s", c.RemoteMultiaddr(), err)
+	}
+	actual, err := peer.IDFromPublicKey(key)
+	if err != nil {
+		return err
+	}
+	if c.remote != "" && actual != c.remote {
+		return &MisdialError{
+			Addr:      c.RemoteMultiaddr(),
+			Expected:  c.remote,
+			Actual:    actual,
+			ActualKey: key,
+			Seen:      time.Now(),
+		}
+	}
+	c.remote = actual
+	c.remoteKey = key
+	c.identified = true
+	return nil
+}
@@ -0,0 +1,71 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestPlaintextIdentity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	p3 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	if err := l1.(ListenerSecurityProtocols).SetSecurityProtocols([]string{PlaintextIdentityTag}); err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan HandshakeResult, 1)
+	go func() {
+		c, err := l1.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		accepted <- c.(HandshakeInfo).HandshakeResult()
+	}()
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.SecurityProtocols = []string{PlaintextIdentityTag}
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	hr := c.(HandshakeInfo).HandshakeResult()
+	if hr.Protocol != PlaintextIdentityTag || hr.RemotePeer != p1.ID || hr.RemotePublicKey == nil {
+		t.Fatalf("unexpected dialed handshake %+v", hr)
+	}
+	if hr := <-accepted; hr.Protocol != PlaintextIdentityTag || hr.RemotePeer != p2.ID || hr.RemotePublicKey == nil {
+		t.Fatalf("unexpected accepted handshake %+v", hr)
+	}
+
+	// the remote's key must match the peer dialed.
+	go func() {
+		if c, err := l1.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	if _, err := d.Dial(ctx, l1.Multiaddr(), p3.ID); !errors.Is(err, ErrPeerIDMismatch) {
+		t.Fatalf("expected a peer id mismatch, got %v", err)
+	}
+}
+
+func TestPlaintextIdentityNeedsKey(t *testing.T) {
+	if err := checkSecurityProtocols([]string{PlaintextIdentityTag}, nil); !errors.Is(err, ErrUnsupportedSecurity) {
+		t.Fatalf("expected unsupported protocol, got %v", err)
+	}
+}
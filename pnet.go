@@ -0,0 +1,234 @@
+package conn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	peer "github.com/libp2p/go-libp2p-peer"
+	transport "github.com/libp2p/go-libp2p-transport"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// ErrNoMatchingProtector is matched by errors for inbound connections not
+// protected with any of the listener's private network keys.
+var ErrNoMatchingProtector = errors.New("no private network key matches the remote's")
+
+// ErrPNetFingerprintMismatch is matched by errors from dials and inbound
+// conns whose remote is in another private network, when fingerprints
+// are checked. See Dialer.PNetFingerprint.
+var ErrPNetFingerprintMismatch = errors.New("private network fingerprint mismatch")
+
+// pnetMagic is what both ends of a protected conn send first when they
+// check fingerprints. The remote only decrypts it right if it has the
+// same key.
+var pnetMagic = []byte(PreamblePNetFingerprint + "\n")
+
+// ListenerPNetFingerprint is implemented by listeners that can check the
+// private network fingerprint of the conns they accept.
+type ListenerPNetFingerprint interface {
+	// SetPNetFingerprint turns fingerprint checks, as with
+	// Dialer.PNetFingerprint, on or off. Listeners with several
+	// protectors use the fingerprints of the conns that send one
+	// regardless, to find their protector, and only accept conns without
+	// one while checks are off. It must be called before any call to
+	// Accept.
+	SetPNetFingerprint(on bool)
+}
+
+func (l *listener) SetPNetFingerprint(on bool) {
+	l.fingerprint = on
+}
+
+// checkFingerprint sends pnetMagic over the protected conn, and checks
+// that the remote sent it too. Both sides write before reading, so that
+// both notice a mismatch.
+func checkFingerprint(conn io.ReadWriter) error {
+	werr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(pnetMagic)
+		werr <- err
+	}()
+
+	got := make([]byte, len(pnetMagic))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, pnetMagic) {
+		return &Error{Kind: ErrPNetFingerprintMismatch, Err: fmt.Errorf("remote sent %q", got)}
+	}
+	return <-werr
+}
+
+// mssHeader is the multistream header: the protocol id, length prefixed
+// and newline terminated. Listeners send it first, and dialers answer
+// with it once they have read it.
+var mssHeader = append([]byte{byte(len(msmux.ProtocolID) + 1)}, msmux.ProtocolID+"\n"...)
+
+// protectorsFor returns the protectors to try when dialing remote, in
+// order. It always returns at least one, which may be nil.
+func (d *Dialer) protectorsFor(remote peer.ID) []ipnet.Protector {
+	protecs := append([]ipnet.Protector{d.Protector}, d.RotatedProtectors...)
+	if hint := d.protectorHints.get(remote); hint != nil {
+		for i, p := range protecs {
+			if p == hint {
+				protecs[0], protecs[i] = protecs[i], protecs[0]
+				break
+			}
+		}
+	}
+	return protecs
+}
+
+// protectorHints remembers which protector last worked for each peer.
+// The zero value is ready to use.
+type protectorHints struct {
+	mu    sync.Mutex
+	hints map[peer.ID]ipnet.Protector
+}
+
+func (h *protectorHints) get(p peer.ID) ipnet.Protector {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.hints[p]
+}
+
+func (h *protectorHints) set(p peer.ID, protec ipnet.Protector) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if protec == nil {
+		delete(h.hints, p)
+		return
+	}
+	if h.hints == nil {
+		h.hints = make(map[peer.ID]ipnet.Protector)
+	}
+	h.hints[p] = protec
+}
+
+// protect protects an inbound conn with the listener's protectors, and
+// returns the one used, and whether fingerprints were exchanged. With
+// several of them, the remote's opening bytes are decrypted with each in
+// turn until one yields either pnetMagic or, if the listener doesn't
+// insist on fingerprints, the multistream header; that one is then used
+// on the whole conn, opening bytes included, and no matching protector
+// is a fingerprint mismatch. Dialers without fingerprints are thus only
+// told apart if they speak first, as optimistic ones do: the others wait
+// for a header the listener can't send without knowing the key. This
+// relies on Protect not doing any I/O itself, as is the case for
+// go-libp2p-pnet.
+func (l *listener) protect(conn transport.Conn) (transport.Conn, ipnet.Protector, bool, error) {
+	if len(l.protecs) == 1 {
+		pc, err := l.protecs[0].Protect(conn)
+		if err == nil && l.fingerprint {
+			err = checkFingerprint(pc)
+		}
+		return pc, l.protecs[0], l.fingerprint, err
+	}
+
+	// the opening is read in two steps, so as not to wait for more than
+	// the shorter of pnetMagic and mssHeader when it's the other.
+	short := len(pnetMagic)
+	if len(mssHeader) < short {
+		short = len(mssHeader)
+	}
+	peek := &peekConn{Conn: conn}
+	for _, protec := range l.protecs {
+		peek.rewind()
+		pc, err := protec.Protect(peek)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		hdr := make([]byte, short)
+		if _, err := io.ReadFull(pc, hdr); err != nil {
+			return nil, nil, false, err
+		}
+		fingerprinted := bytes.HasPrefix(pnetMagic, hdr)
+		want := pnetMagic
+		switch {
+		case fingerprinted:
+		case !l.fingerprint && bytes.HasPrefix(mssHeader, hdr):
+			want = mssHeader
+		default:
+			continue
+		}
+		hdr = append(hdr, make([]byte, len(want)-short)...)
+		if _, err := io.ReadFull(pc, hdr[short:]); err != nil {
+			return nil, nil, false, err
+		}
+		if !bytes.Equal(hdr, want) {
+			continue
+		}
+
+		pc, err = protec.Protect(&prefixConn{Conn: conn, prefix: peek.buf})
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if !fingerprinted {
+			// the header is left for protocol selection.
+			return pc, protec, false, nil
+		}
+		// skip the remote's magic, already checked, and send ours.
+		if _, err := io.ReadFull(pc, hdr); err != nil {
+			return nil, nil, false, err
+		}
+		if _, err := pc.Write(pnetMagic); err != nil {
+			return nil, nil, false, err
+		}
+		return pc, protec, true, nil
+	}
+
+	// let the remote know too, with the current key.
+	if pc, err := l.protecs[0].Protect(conn); err == nil {
+		pc.Write(pnetMagic)
+	}
+	return nil, nil, false, &Error{Kind: ErrPNetFingerprintMismatch, Err: ErrNoMatchingProtector}
+}
+
+// peekConn records what is read from the wrapped conn, so that it can be
+// read again after a rewind. It can't be written to.
+type peekConn struct {
+	transport.Conn
+	buf []byte
+	off int
+}
+
+func (c *peekConn) rewind() {
+	c.off = 0
+}
+
+func (c *peekConn) Read(b []byte) (int, error) {
+	if c.off < len(c.buf) {
+		n := copy(b, c.buf[c.off:])
+		c.off += n
+		return n, nil
+	}
+
+	n, err := c.Conn.Read(b)
+	c.buf = append(c.buf, b[:n]...)
+	c.off += n
+	return n, err
+}
+
+func (c *peekConn) Write(b []byte) (int, error) {
+	return 0, errors.New("can't write to a conn while peeking at it")
+}
+
+// prefixConn returns prefix before reading from the wrapped conn.
+type prefixConn struct {
+	transport.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
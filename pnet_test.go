@@ -0,0 +1,229 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-conn/pnettest"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	tcpt "github.com/libp2p/go-tcp-transport"
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestPNetRotation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	newKey := &pnettest.ShiftProtector{Shift: 7}
+	oldKey := &pnettest.ShiftProtector{Shift: 13}
+
+	list, err := tcpt.NewTCPTransport().Listen(p1.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1, err := WrapTransportListenerWithProtectors(ctx, list, p1.ID, p1.PrivKey, []ipnet.Protector{newKey, oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	// peers which haven't rotated yet can still connect, with
+	// fingerprints or speaking first.
+	for _, key := range []*pnettest.ShiftProtector{oldKey, newKey} {
+		for _, fingerprint := range []bool{true, false} {
+			d := NewDialer(p2.ID, p2.PrivKey, nil)
+			d.Protector = &pnettest.ShiftProtector{Shift: key.Shift}
+			d.PNetFingerprint = fingerprint
+			d.Optimistic = !fingerprint
+			d.AddDialer(dialer(t, p2.Addr))
+
+			c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+			if err != nil {
+				t.Fatalf("dial with key %d, fingerprint %t failed: %s", key.Shift, fingerprint, err)
+			}
+			testOneSendRecv(t, c, c)
+			c.Close()
+		}
+	}
+
+	// rotated dialers fall back on the old key for peers that didn't rotate.
+	list3, err := tcpt.NewTCPTransport().Listen(p1.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l3, err := WrapTransportListenerWithProtector(ctx, list3, p1.ID, p1.PrivKey, &pnettest.ShiftProtector{Shift: 13})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l3.Close()
+	go echoListen(ctx, l3)
+
+	rotated := &pnettest.ShiftProtector{Shift: 7}
+	previous := &pnettest.ShiftProtector{Shift: 13}
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.Protector = rotated
+	d.RotatedProtectors = []ipnet.Protector{previous}
+	d.AddDialer(dialer(t, p2.Addr))
+
+	c, err := d.Dial(ctx, l3.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal("dial should fall back on the previous key: ", err)
+	}
+	c.Close()
+	if rotated.Used() != 1 || previous.Used() != 1 {
+		t.Fatalf("expected both keys to be tried once, got %d and %d", rotated.Used(), previous.Used())
+	}
+
+	if protecs := d.protectorsFor(p1.ID); protecs[0] != previous {
+		t.Fatal("the key that worked should be tried first next time")
+	}
+}
+
+func TestDialWithProtector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	list, err := tcpt.NewTCPTransport().Listen(p1.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1, err := WrapTransportListenerWithProtector(ctx, list, p1.ID, p1.PrivKey, &pnettest.ShiftProtector{Shift: 13})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	home := &pnettest.ShiftProtector{Shift: 7}
+	other := &pnettest.ShiftProtector{Shift: 13}
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.Protector = home
+	d.AddDialer(dialer(t, p2.Addr))
+
+	c, err := d.DialWithProtector(ctx, l1.Multiaddr(), p1.ID, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOneSendRecv(t, c, c)
+	c.Close()
+
+	if home.Used() != 0 || other.Used() != 1 {
+		t.Fatalf("expected only the given protector to be used, got %d and %d", home.Used(), other.Used())
+	}
+	if d.protectorHints.get(p1.ID) != nil {
+		t.Fatal("per-dial protectors should not be remembered")
+	}
+}
+
+// fingerprintDial checks the fingerprint of a conn protected with protec,
+// against a listener with protecs that checks fingerprints.
+func fingerprintDial(protec ipnet.Protector, protecs ...ipnet.Protector) (dialErr, listenErr error) {
+	return fingerprintDialListener(protec, &listener{protecs: protecs, fingerprint: true})
+}
+
+func fingerprintDialListener(protec ipnet.Protector, l *listener) (dialErr, listenErr error) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	listened := make(chan error, 1)
+	go func() {
+		pc, _, _, err := l.protect(pipeConn{b})
+		if err == nil {
+			// the dialer goes on with protocol selection.
+			_, err = pc.Read(make([]byte, len(mssHeader)))
+		}
+		listened <- err
+	}()
+
+	pc, _ := protec.Protect(pipeConn{a})
+	dialErr = checkFingerprint(pc)
+	if dialErr == nil {
+		pc.Write(mssHeader)
+	} else {
+		a.Close()
+	}
+	return dialErr, <-listened
+}
+
+// headerDialListener sends the multistream header first, without a
+// fingerprint, over a conn protected with protec, and returns the error
+// of the listener finding its protector.
+func headerDialListener(protec ipnet.Protector, l *listener) error {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	listened := make(chan error, 1)
+	go func() {
+		pc, _, fingerprinted, err := l.protect(pipeConn{b})
+		if err == nil && fingerprinted {
+			err = errors.New("no fingerprint was sent")
+		}
+		if err == nil {
+			// the header is left for protocol selection.
+			hdr := make([]byte, len(mssHeader))
+			if _, err = io.ReadFull(pc, hdr); err == nil && !bytes.Equal(hdr, mssHeader) {
+				err = fmt.Errorf("read %q instead of the header", hdr)
+			}
+		}
+		if err != nil {
+			b.Close()
+		}
+		listened <- err
+	}()
+
+	pc, _ := protec.Protect(pipeConn{a})
+	pc.Write(mssHeader)
+	// the mismatch answer of the listener, if any.
+	go io.Copy(ioutil.Discard, a)
+	return <-listened
+}
+
+func TestPNetFingerprint(t *testing.T) {
+	key := &pnettest.ShiftProtector{Shift: 7}
+	other := &pnettest.ShiftProtector{Shift: 13}
+
+	if derr, lerr := fingerprintDial(key, key); derr != nil || lerr != nil {
+		t.Fatalf("same key: %v, %v", derr, lerr)
+	}
+	if derr, lerr := fingerprintDial(key, other, key); derr != nil || lerr != nil {
+		t.Fatalf("rotated key: %v, %v", derr, lerr)
+	}
+	// listeners with several keys find the one of each conn by its
+	// fingerprint, whether they were told to check them or not.
+	l := &listener{protecs: []ipnet.Protector{other, key}}
+	if derr, lerr := fingerprintDialListener(key, l); derr != nil || lerr != nil {
+		t.Fatalf("rotated key, without fingerprint checks: %v, %v", derr, lerr)
+	}
+	// or by its multistream header, unless they were told to check them.
+	if err := headerDialListener(key, l); err != nil {
+		t.Fatal("rotated key, without fingerprint: ", err)
+	}
+	l = &listener{protecs: []ipnet.Protector{other, key}, fingerprint: true}
+	if err := headerDialListener(key, l); !errors.Is(err, ErrPNetFingerprintMismatch) {
+		t.Fatal("expected a mismatch without fingerprint, got ", err)
+	}
+	for _, protecs := range [][]ipnet.Protector{{other}, {other, &pnettest.ShiftProtector{Shift: 21}}} {
+		derr, lerr := fingerprintDial(key, protecs...)
+		if !errors.Is(derr, ErrPNetFingerprintMismatch) {
+			t.Fatalf("dial with %d listener keys: %v", len(protecs), derr)
+		}
+		if !errors.Is(lerr, ErrPNetFingerprintMismatch) {
+			t.Fatalf("listener with %d keys: %v", len(protecs), lerr)
+		}
+	}
+}
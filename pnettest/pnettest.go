@@ -0,0 +1,91 @@
+// Package pnettest provides fake private network protectors, to exercise
+// the private network code paths of go-libp2p-conn, and of the packages
+// built on it, without real keys.
+package pnettest
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync/atomic"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// ShiftProtector is a deterministic fake private network key: the conns
+// it protects shift every byte by Shift, so peers protecting their conns
+// with the same Shift understand each other, and no one else does. A
+// Shift of 13 is rot13.
+type ShiftProtector struct {
+	Shift byte
+
+	used int64 // accessed atomically
+}
+
+// Protect protects c with the key.
+func (p *ShiftProtector) Protect(c transport.Conn) (transport.Conn, error) {
+	atomic.AddInt64(&p.used, 1)
+	return &shiftConn{Conn: c, shift: p.Shift}, nil
+}
+
+// Fingerprint is a hash of Shift.
+func (p *ShiftProtector) Fingerprint() []byte {
+	sum := sha256.Sum256([]byte{'s', 'h', 'i', 'f', 't', p.Shift})
+	return sum[:]
+}
+
+// Used returns how many conns Protect protected.
+func (p *ShiftProtector) Used() int {
+	return int(atomic.LoadInt64(&p.used))
+}
+
+type shiftConn struct {
+	transport.Conn
+	shift byte
+}
+
+func (c *shiftConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for i := 0; i < n; i++ {
+		b[i] -= c.shift
+	}
+	return n, err
+}
+
+func (c *shiftConn) Write(b []byte) (int, error) {
+	shifted := make([]byte, len(b))
+	for i := range b {
+		shifted[i] = b[i] + c.shift
+	}
+	return c.Conn.Write(shifted)
+}
+
+// ErrProtectFailed is the default error of FailingProtector.
+var ErrProtectFailed = errors.New("pnettest: protect failed")
+
+// FailingProtector is a protector that fails to protect any conn, like
+// one whose key can't be loaded.
+type FailingProtector struct {
+	// Err is returned by Protect. Nil means ErrProtectFailed.
+	Err error
+
+	used int64 // accessed atomically
+}
+
+// Protect fails with Err. c is left open.
+func (p *FailingProtector) Protect(c transport.Conn) (transport.Conn, error) {
+	atomic.AddInt64(&p.used, 1)
+	if p.Err != nil {
+		return nil, p.Err
+	}
+	return nil, ErrProtectFailed
+}
+
+// Fingerprint is all zeros.
+func (p *FailingProtector) Fingerprint() []byte {
+	return make([]byte, sha256.Size)
+}
+
+// Used returns how many conns Protect was called with.
+func (p *FailingProtector) Used() int {
+	return int(atomic.LoadInt64(&p.used))
+}
@@ -0,0 +1,90 @@
+package pnettest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var _ ipnet.Protector = (*ShiftProtector)(nil)
+var _ ipnet.Protector = (*FailingProtector)(nil)
+
+// pipeConn is a net.Pipe end posing as a transport conn.
+type pipeConn struct {
+	net.Conn
+}
+
+func (pipeConn) LocalMultiaddr() ma.Multiaddr   { return nil }
+func (pipeConn) RemoteMultiaddr() ma.Multiaddr  { return nil }
+func (pipeConn) Transport() transport.Transport { return nil }
+
+func protectedPipe(t *testing.T, pa, pb ipnet.Protector) (transport.Conn, transport.Conn) {
+	a, b := net.Pipe()
+	ca, err := pa.Protect(pipeConn{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := pb.Protect(pipeConn{b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, cb
+}
+
+func exchange(t *testing.T, a, b transport.Conn, msg []byte) []byte {
+	go a.Write(msg)
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestShiftProtector(t *testing.T) {
+	msg := []byte("hello")
+	key := &ShiftProtector{Shift: 13}
+	a, b := protectedPipe(t, key, &ShiftProtector{Shift: 13})
+	defer a.Close()
+	defer b.Close()
+	if got := exchange(t, a, b, msg); !bytes.Equal(got, msg) {
+		t.Fatalf("peers with the same key should understand each other, got %q", got)
+	}
+	if key.Used() != 1 {
+		t.Fatalf("expected 1 protected conn, got %d", key.Used())
+	}
+
+	c, d := protectedPipe(t, key, &ShiftProtector{Shift: 7})
+	defer c.Close()
+	defer d.Close()
+	if got := exchange(t, c, d, msg); bytes.Equal(got, msg) {
+		t.Fatal("peers with other keys shouldn't understand each other")
+	}
+	if bytes.Equal(key.Fingerprint(), (&ShiftProtector{Shift: 7}).Fingerprint()) {
+		t.Fatal("other keys should have other fingerprints")
+	}
+}
+
+func TestFailingProtector(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	p := &FailingProtector{}
+	if _, err := p.Protect(pipeConn{a}); err != ErrProtectFailed {
+		t.Fatal("expected ErrProtectFailed, got ", err)
+	}
+	failed := errors.New("no key")
+	p.Err = failed
+	if _, err := p.Protect(pipeConn{a}); err != failed {
+		t.Fatal("expected Err, got ", err)
+	}
+	if p.Used() != 2 {
+		t.Fatalf("expected 2 calls, got %d", p.Used())
+	}
+}
@@ -0,0 +1,170 @@
+package conn
+
+import (
+	"sync"
+	"time"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// defaultPoolIdleTimeout and defaultPoolMaxIdle apply when the fields of
+// ConnPool are zero.
+const (
+	defaultPoolIdleTimeout = time.Minute
+	defaultPoolMaxIdle     = 4
+)
+
+// ConnPool keeps released conns open, for Dialers to reuse them instead
+// of dialing the same peers again. Set it as Dialer.Pool; it may be
+// shared by several Dialers of the same identity.
+//
+// A conn returned by Dial belongs to the caller, who either closes it, or
+// hands it back with Release once done with it, so that a later Dial to
+// the same peer returns it. A conn that failed must be closed rather
+// than released: a pool only notices conns closed on this side.
+type ConnPool struct {
+	// IdleTimeout is how long a released conn stays in the pool before
+	// it is closed. Zero means a minute.
+	IdleTimeout time.Duration
+
+	// MaxIdle is how many released conns are kept per peer; more are
+	// closed. Zero means 4.
+	MaxIdle int
+
+	mu   sync.Mutex
+	idle map[peer.ID][]*pooledConn
+}
+
+type pooledConn struct {
+	conn  iconn.Conn
+	timer *time.Timer
+}
+
+// Release hands c back to the pool. It must not be used afterwards.
+func (p *ConnPool) Release(c iconn.Conn) {
+	remote := c.RemotePeer()
+	if remote == "" || !healthy(c) {
+		c.Close()
+		return
+	}
+
+	timeout := p.IdleTimeout
+	if timeout <= 0 {
+		timeout = defaultPoolIdleTimeout
+	}
+	max := p.MaxIdle
+	if max <= 0 {
+		max = defaultPoolMaxIdle
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[remote]) >= max {
+		c.Close()
+		return
+	}
+	if p.idle == nil {
+		p.idle = make(map[peer.ID][]*pooledConn)
+	}
+	pc := &pooledConn{conn: c}
+	pc.timer = time.AfterFunc(timeout, func() { p.expire(remote, pc) })
+	p.idle[remote] = append(p.idle[remote], pc)
+}
+
+// get takes a healthy conn to remote out of the pool, if there is one.
+// The most recently released conns are reused first.
+func (p *ConnPool) get(remote peer.ID) iconn.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[remote]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if !pc.timer.Stop() {
+			// expiring right now.
+			continue
+		}
+		if !healthy(pc.conn) {
+			pc.conn.Close()
+			continue
+		}
+		p.setIdle(remote, conns)
+		return pc.conn
+	}
+	p.setIdle(remote, nil)
+	return nil
+}
+
+func (p *ConnPool) expire(remote peer.ID, pc *pooledConn) {
+	p.mu.Lock()
+	conns := p.idle[remote]
+	for i, c := range conns {
+		if c == pc {
+			p.setIdle(remote, append(conns[:i:i], conns[i+1:]...))
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	pc.conn.Close()
+}
+
+func (p *ConnPool) setIdle(remote peer.ID, conns []*pooledConn) {
+	if len(conns) == 0 {
+		delete(p.idle, remote)
+		return
+	}
+	p.idle[remote] = conns
+}
+
+// Idle returns how many conns to remote are in the pool.
+func (p *ConnPool) Idle(remote peer.ID) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle[remote])
+}
+
+// Close closes all the conns in the pool. The pool can still be used.
+func (p *ConnPool) Close() error {
+	p.closeIdle()
+	return nil
+}
+
+// closeIdle closes all the conns in the pool, and returns them.
+func (p *ConnPool) closeIdle() []iconn.Conn {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	var closed []iconn.Conn
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.timer.Stop()
+			pc.conn.Close()
+			closed = append(closed, pc.conn)
+		}
+	}
+	return closed
+}
+
+// healthy tells whether c is still open, as far as this side knows.
+func healthy(c iconn.Conn) bool {
+	if cc, ok := c.(interface{ closed() bool }); ok {
+		return !cc.closed()
+	}
+	return true
+}
+
+func (c *singleConn) closed() bool {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+	return c.event == nil
+}
+
+func (c *secureConn) closed() bool {
+	return !healthy(c.insecure)
+}
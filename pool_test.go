@@ -0,0 +1,119 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	peer "github.com/libp2p/go-libp2p-peer"
+	filter "github.com/libp2p/go-maddr-filter"
+	tu "github.com/libp2p/go-testutil"
+)
+
+type fakePoolConn struct {
+	iconn.Conn
+	remote peer.ID
+	closed int32
+}
+
+func (c *fakePoolConn) RemotePeer() peer.ID { return c.remote }
+
+func (c *fakePoolConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *fakePoolConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+func TestConnPool(t *testing.T) {
+	p := &ConnPool{MaxIdle: 1, IdleTimeout: 50 * time.Millisecond}
+
+	a := &fakePoolConn{remote: "a"}
+	b := &fakePoolConn{remote: "a"}
+	p.Release(a)
+	p.Release(b)
+	if !b.isClosed() || p.Idle("a") != 1 {
+		t.Fatal("conn over MaxIdle kept")
+	}
+
+	if c := p.get("a"); c != a {
+		t.Fatalf("got %v from the pool", c)
+	}
+	if c := p.get("a"); c != nil {
+		t.Fatal("conn handed out twice")
+	}
+
+	p.Release(a)
+	time.Sleep(100 * time.Millisecond)
+	if !a.isClosed() || p.Idle("a") != 0 {
+		t.Fatal("idle conn not evicted")
+	}
+
+	// conns of unknown peers are never pooled.
+	u := &fakePoolConn{}
+	p.Release(u)
+	if !u.isClosed() {
+		t.Fatal("conn to unknown peer pooled")
+	}
+}
+
+func TestDialPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.Pool = new(ConnPool)
+	defer d.Pool.Close()
+
+	c1, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOneSendRecv(t, c1, c1)
+	d.Pool.Release(c1)
+
+	c2, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2 != c1 {
+		t.Fatal("released conn not reused")
+	}
+
+	// pooled conns are subject to the Dialer's filters.
+	d.Pool.Release(c2)
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	d.Filters = filter.NewFilters()
+	d.Filters.AddDialFilter(loopback)
+	if _, err := d.Dial(ctx, l1.Multiaddr(), p1.ID); !errors.Is(err, ErrAddrFiltered) {
+		t.Fatal("expected the dial to be filtered, got ", err)
+	}
+	if d.Pool.get(p1.ID) != c2 {
+		t.Fatal("the pooled conn should stay pooled")
+	}
+	d.Filters = nil
+
+	// closed conns aren't.
+	c2.Close()
+	d.Pool.Release(c2)
+	if d.Pool.Idle(p1.ID) != 0 {
+		t.Fatal("closed conn pooled")
+	}
+}
@@ -0,0 +1,196 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// PreambleTimeout is the maximum duration an inbound conn may take to
+// send the multistream header back, once the listener sent its own,
+// within AcceptTimeout. Conns sending
+// anything else are closed as soon as it shows, and silent ones once
+// PreambleTimeout is over. Zero means the default of 10 seconds, and
+// NoTimeout leaves it to AcceptTimeout. Listeners pick up its value when
+// they are created.
+//
+// Inside a private network, the opening bytes are checked once decrypted,
+// and classified by what they were before. Those that the protector can't
+// make sense of count as garbage too.
+var PreambleTimeout time.Duration
+
+const defaultPreambleTimeout = 10 * time.Second
+
+// GarbageClass is the kind of traffic an inbound conn was rejected for,
+// before any handshake.
+type GarbageClass int
+
+const (
+	// GarbageOther is anything unrecognized.
+	GarbageOther GarbageClass = iota
+	// GarbageHTTP is an HTTP request, e.g. from a crawler.
+	GarbageHTTP
+	// GarbageTLS is a TLS ClientHello.
+	GarbageTLS
+	// GarbageSSH is an SSH client banner.
+	GarbageSSH
+	// GarbageSilent is a conn that closed or timed out before sending
+	// the whole header, e.g. a port scanner.
+	GarbageSilent
+
+	numGarbageClasses
+)
+
+func (g GarbageClass) String() string {
+	switch g {
+	case GarbageHTTP:
+		return "http"
+	case GarbageTLS:
+		return "tls"
+	case GarbageSSH:
+		return "ssh"
+	case GarbageSilent:
+		return "silent"
+	default:
+		return "other"
+	}
+}
+
+var httpMethods = []string{
+	"GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "OPTIONS ",
+	"CONNECT ", "PATCH ", "TRACE ", "PRI ",
+}
+
+// classifyGarbage tells what the opening bytes b of a conn look like.
+func classifyGarbage(b []byte) GarbageClass {
+	switch {
+	case len(b) == 0:
+		return GarbageSilent
+	case b[0] == 0x16 && (len(b) < 2 || b[1] == 0x03):
+		// a handshake record, of any TLS or SSL 3 version.
+		return GarbageTLS
+	case bytes.HasPrefix(b, []byte("SSH-")):
+		return GarbageSSH
+	}
+	for _, m := range httpMethods {
+		if strings.HasPrefix(string(b), m) {
+			return GarbageHTTP
+		}
+	}
+	return GarbageOther
+}
+
+// garbageError is returned for inbound conns that didn't open with the
+// multistream header.
+type garbageError struct {
+	class GarbageClass
+	err   error
+}
+
+func (e *garbageError) Error() string {
+	if e.err != nil {
+		return "no multistream header (" + e.class.String() + "): " + e.err.Error()
+	}
+	return "no multistream header (" + e.class.String() + ")"
+}
+
+func (e *garbageError) Unwrap() error {
+	return e.err
+}
+
+// sendHeader writes the multistream header to conn, for dialers that
+// wait for it before sending theirs, as msmux clients do. The returned
+// conn drops the header once, when protocol negotiation writes it again.
+func sendHeader(conn transport.Conn) (transport.Conn, error) {
+	if _, err := conn.Write(mssHeader); err != nil {
+		return nil, err
+	}
+	return &headerSentConn{Conn: conn, skip: mssHeader}, nil
+}
+
+// headerSentConn is a conn the multistream header was written to already.
+type headerSentConn struct {
+	transport.Conn
+	skip []byte // what is left to drop of the header
+}
+
+func (c *headerSentConn) Write(b []byte) (int, error) {
+	n := 0
+	for len(c.skip) > 0 && n < len(b) && b[n] == c.skip[0] {
+		c.skip = c.skip[1:]
+		n++
+	}
+	if n == len(b) {
+		return n, nil
+	}
+	c.skip = nil
+	m, err := c.Conn.Write(b[n:])
+	return n + m, err
+}
+
+// readPreamble reads the multistream header from conn, giving up as soon
+// as what is read doesn't match it, or after timeout. The returned conn
+// reads the header again.
+func readPreamble(conn transport.Conn, timeout time.Duration) (transport.Conn, error) {
+	if timeout != NoTimeout {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	hdr := make([]byte, len(mssHeader))
+	n := 0
+	for n < len(hdr) {
+		m, err := conn.Read(hdr[n:])
+		n += m
+		if !bytes.HasPrefix(mssHeader, hdr[:n]) {
+			return nil, &garbageError{class: classifyGarbage(hdr[:n])}
+		}
+		if err != nil {
+			return nil, &garbageError{class: GarbageSilent, err: err}
+		}
+	}
+	return &prefixConn{Conn: conn, prefix: hdr}, nil
+}
+
+// garbageCounts counts the conns rejected by a listener, by class.
+type garbageCounts [numGarbageClasses]uint64
+
+func (l *listener) rejectGarbage(ctx context.Context, conn transport.Conn, err *garbageError) {
+	atomic.AddUint64(&l.garbage[err.class], 1)
+	log.Event(ctx, "connGarbage", l, garbageLoggable{conn, err.class})
+}
+
+type garbageLoggable struct {
+	conn  transport.Conn
+	class GarbageClass
+}
+
+func (g garbageLoggable) Loggable() map[string]interface{} {
+	return map[string]interface{}{
+		"remoteAddr": g.conn.RemoteMultiaddr(),
+		"class":      g.class.String(),
+	}
+}
+
+type ListenerGarbageStats interface {
+	// GarbageStats returns how many inbound conns were closed for not
+	// opening with multistream, by class, since the listener was
+	// created. Classes without any are left out.
+	GarbageStats() map[GarbageClass]uint64
+}
+
+func (l *listener) GarbageStats() map[GarbageClass]uint64 {
+	stats := make(map[GarbageClass]uint64)
+	for class := range l.garbage {
+		if n := atomic.LoadUint64(&l.garbage[class]); n > 0 {
+			stats[GarbageClass(class)] = n
+		}
+	}
+	return stats
+}
@@ -0,0 +1,172 @@
+package conn
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-conn/pnettest"
+	tcpt "github.com/libp2p/go-tcp-transport"
+	tu "github.com/libp2p/go-testutil"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+func TestClassifyGarbage(t *testing.T) {
+	cases := map[string]GarbageClass{
+		"":                     GarbageSilent,
+		"GET / HTTP/1.1\r\n":   GarbageHTTP,
+		"PRI * HTTP/2.0\r\n":   GarbageHTTP,
+		"\x16\x03\x01\x02\x00": GarbageTLS,
+		"SSH-2.0-OpenSSH_7.4":  GarbageSSH,
+		"\xff\xff\xff":         GarbageOther,
+	}
+	for in, want := range cases {
+		if got := classifyGarbage([]byte(in)); got != want {
+			t.Errorf("%q classified as %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestReadPreamble(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	go b.Write(append(mssHeader, "rest"...))
+
+	c, err := readPreamble(pipeConn{a}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(mssHeader)+4)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(mssHeader)+"rest" {
+		t.Fatalf("read %q again", buf)
+	}
+
+	a, b = net.Pipe()
+	defer b.Close()
+	go b.Write([]byte("\x16\x03\x01"))
+	_, err = readPreamble(pipeConn{a}, time.Second)
+	if ge, ok := err.(*garbageError); !ok || ge.class != GarbageTLS {
+		t.Fatalf("expected tls garbage, got %v", err)
+	}
+
+	a, b = net.Pipe()
+	defer b.Close()
+	start := time.Now()
+	_, err = readPreamble(pipeConn{a}, 50*time.Millisecond)
+	if ge, ok := err.(*garbageError); !ok || ge.class != GarbageSilent {
+		t.Fatalf("expected silent garbage, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("preamble timeout not applied")
+	}
+}
+
+func TestPreambleReadFirstDialer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+
+	list, err := tcpt.NewTCPTransport().Listen(p1.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := defaultListenerParams()
+	params.preambleTimeout = 200 * time.Millisecond
+	l1, err := wrapTransportListener(ctx, list, p1.ID, p1.PrivKey, nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	// msmux clients wait for the listener's header before sending theirs.
+	con, err := net.Dial("tcp", l1.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	if err := msmux.SelectProtoOrFail(SecioTag, con); err != nil {
+		t.Fatal(err)
+	}
+	if stats := l1.GarbageStats(); len(stats) != 0 {
+		t.Fatalf("unexpected garbage stats %v", stats)
+	}
+}
+
+func TestGarbageClosedEarly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	raw, err := net.Dial("tcp", l1.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// closed long before AcceptTimeout.
+	raw.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(raw); err != nil {
+		t.Fatal("conn not closed: ", err)
+	}
+
+	stats := l1.(ListenerGarbageStats).GarbageStats()
+	if stats[GarbageHTTP] != 1 || len(stats) != 1 {
+		t.Fatalf("unexpected garbage stats %v", stats)
+	}
+}
+
+func TestGarbageClosedEarlyPrivate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+
+	list, err := tcpt.NewTCPTransport().Listen(p1.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1, err := WrapTransportListenerWithProtector(ctx, list, p1.ID, p1.PrivKey, &pnettest.ShiftProtector{Shift: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	raw, err := net.Dial("tcp", l1.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	hello := append([]byte("\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03"), make([]byte, 32)...)
+	if _, err := raw.Write(hello); err != nil {
+		t.Fatal(err)
+	}
+
+	raw.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(raw); err != nil {
+		t.Fatal("conn not closed: ", err)
+	}
+
+	stats := l1.(ListenerGarbageStats).GarbageStats()
+	if stats[GarbageTLS] != 1 || len(stats) != 1 {
+		t.Fatalf("unexpected garbage stats %v", stats)
+	}
+}
@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ProbeResult is the outcome of a successful Dialer.Probe.
+type ProbeResult struct {
+	// Connect is how long the transport took to connect.
+	Connect time.Duration
+
+	// Multistream is how long the remote took to answer with the
+	// multistream header, once connected. It is zero unless requested.
+	Multistream time.Duration
+}
+
+// Probe checks that raddr is reachable, without a handshake: it only
+// connects with the transport and, if multistream is true, waits for the
+// remote to speak multistream, then hangs up. Protector and Wrapper are
+// used for the multistream check, as they would for a dial. The remote
+// sees an aborted protocol negotiation.
+//
+// Probes obey the Dialer's timeout and Filters, but not its Breaker.
+func (d *Dialer) Probe(ctx context.Context, raddr ma.Multiaddr, multistream bool) (*ProbeResult, error) {
+	timeout, err := resolveTimeout(d.Timeout, DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	if d.Filters != nil && d.Filters.AddrBlocked(raddr) {
+		return nil, &Error{Kind: ErrAddrFiltered, Err: fmt.Errorf("refusing to probe %s", raddr)}
+	}
+
+	start := time.Now()
+	maconn, err := d.rawConnDial(ctx, raddr, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer maconn.Close()
+
+	res := &ProbeResult{Connect: time.Since(start)}
+	if !multistream {
+		return res, nil
+	}
+
+	if d.Protector != nil {
+		maconn, err = d.Protector.Protect(maconn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.Wrapper != nil {
+		maconn = d.Wrapper(maconn)
+	}
+
+	start = time.Now()
+	result := make(chan error, 1)
+	go func() {
+		hdr := make([]byte, len(mssHeader))
+		if _, err := maconn.Write(mssHeader); err != nil {
+			result <- err
+			return
+		}
+		if _, err := io.ReadFull(maconn, hdr); err != nil {
+			result <- err
+			return
+		}
+		if !bytes.Equal(hdr, mssHeader) {
+			result <- &Error{Kind: ErrProtocolNegotiationFailed, Err: fmt.Errorf("%s doesn't speak multistream", raddr)}
+			return
+		}
+		result <- nil
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, handshakeErr(ctx, ctx.Err())
+	case err := <-result:
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.Multistream = time.Since(start)
+	return res, nil
+}
@@ -0,0 +1,64 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func TestProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+
+	res, err := d.Probe(ctx, l1.Multiaddr(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Connect <= 0 || res.Multistream <= 0 {
+		t.Fatalf("unexpected probe result %+v", res)
+	}
+
+	// something else listening is reachable, but doesn't speak multistream.
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nl.Close()
+	go func() {
+		for {
+			c, err := nl.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("SSH-2.0-OpenSSH_7.4\r\n"))
+			c.Close()
+		}
+	}()
+	addr, err := manet.FromNetAddr(nl.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Probe(ctx, addr, false); err != nil {
+		t.Fatal("connect-only probe should succeed: ", err)
+	}
+	if _, err := d.Probe(ctx, addr, true); !errors.Is(err, ErrProtocolNegotiationFailed) {
+		t.Fatalf("expected a failed negotiation, got %v", err)
+	}
+}
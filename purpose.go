@@ -0,0 +1,47 @@
+package conn
+
+import (
+	"context"
+)
+
+// Common dial purposes. Any other label can be used.
+const (
+	PurposeDHT   = "dht"
+	PurposeRelay = "relay"
+	PurposeUser  = "user"
+)
+
+type purposeKey struct{}
+
+// WithPurpose labels the dials made with ctx with why they are made, such
+// as PurposeDHT, so that connection managers can tell which conns they
+// can trim. The label shows in the connDial and connLifetime events, in
+// the dial events of RecentEvents and AuditRecords, and on the conns: see
+// PurposeInfo.
+func WithPurpose(ctx context.Context, purpose string) context.Context {
+	return context.WithValue(ctx, purposeKey{}, purpose)
+}
+
+func purposeFrom(ctx context.Context) string {
+	purpose, _ := ctx.Value(purposeKey{}).(string)
+	return purpose
+}
+
+// PurposeInfo is implemented by the conns returned by Dial and Accept.
+// Accepted conns have no purpose.
+type PurposeInfo interface {
+	// Purpose returns the label the conn was dialed with, if any. See
+	// WithPurpose.
+	Purpose() string
+}
+
+func (c *singleConn) Purpose() string {
+	return c.purpose
+}
+
+func (c *secureConn) Purpose() string {
+	if pi, ok := c.insecure.(PurposeInfo); ok {
+		return pi.Purpose()
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package conn
+
+import (
+	"context"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestDialPurpose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	accepted := make(chan string, 1)
+	go func() {
+		c, err := l1.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		accepted <- c.(PurposeInfo).Purpose()
+	}()
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	c, err := d.Dial(WithPurpose(ctx, PurposeDHT), l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if purpose := c.(PurposeInfo).Purpose(); purpose != PurposeDHT {
+		t.Fatalf("dialed conn has purpose %q", purpose)
+	}
+	if purpose := <-accepted; purpose != "" {
+		t.Fatalf("accepted conn has purpose %q", purpose)
+	}
+
+	it := d.RecentEvents(1)
+	if !it.Next() {
+		t.Fatal("no dial event")
+	}
+	if ev := it.Event(); ev.Type != "dial" || ev.Purpose != PurposeDHT {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
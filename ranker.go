@@ -0,0 +1,174 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrDelay is an address for DialAddrs to dial, Delay after it started.
+type AddrDelay struct {
+	Addr  ma.Multiaddr
+	Delay time.Duration
+}
+
+// AddressRanker decides in which order, and how staggered, DialAddrs
+// dials the addresses of a peer. Rankers can prefer private, low latency
+// or previously successful addresses, leave some out, and so on.
+type AddressRanker interface {
+	// Rank returns the addresses of remote to dial, out of addrs.
+	Rank(remote peer.ID, addrs []ma.Multiaddr) []AddrDelay
+}
+
+// DelayRanker dials direct addresses before relayed ones, and IPv6 ones
+// before IPv4 ones, as with Happy Eyeballs (RFC 8305). Within each group,
+// the order of the addresses is kept, and all are dialed at once.
+type DelayRanker struct {
+	// IPv4Delay is how long after IPv6 addresses IPv4 ones are dialed,
+	// if there are IPv6 ones.
+	IPv4Delay time.Duration
+
+	// RelayDelay is how long after direct addresses relayed ones are
+	// dialed, if there are direct ones.
+	RelayDelay time.Duration
+}
+
+// DefaultRanker is the AddressRanker of Dialers without one.
+var DefaultRanker AddressRanker = DelayRanker{
+	IPv4Delay:  300 * time.Millisecond,
+	RelayDelay: 500 * time.Millisecond,
+}
+
+func (r DelayRanker) Rank(remote peer.ID, addrs []ma.Multiaddr) []AddrDelay {
+	var ip6, ip4, other, relayed []ma.Multiaddr
+	for _, a := range addrs {
+		switch {
+		case isRelayAddr(a):
+			relayed = append(relayed, a)
+		case hasProtocol(a, ma.P_IP6) || hasProtocol(a, ma.P_DNS6):
+			ip6 = append(ip6, a)
+		case hasProtocol(a, ma.P_IP4) || hasProtocol(a, ma.P_DNS4):
+			ip4 = append(ip4, a)
+		default:
+			other = append(other, a)
+		}
+	}
+
+	ranked := make([]AddrDelay, 0, len(addrs))
+	add := func(as []ma.Multiaddr, delay time.Duration) {
+		for _, a := range as {
+			ranked = append(ranked, AddrDelay{Addr: a, Delay: delay})
+		}
+	}
+	var delay time.Duration
+	add(ip6, 0)
+	add(other, 0)
+	if len(ip6) > 0 && len(ip4) > 0 {
+		delay = r.IPv4Delay
+	}
+	add(ip4, delay)
+	if len(ranked) > 0 && len(relayed) > 0 {
+		delay += r.RelayDelay
+	}
+	add(relayed, delay)
+	return ranked
+}
+
+func hasProtocol(a ma.Multiaddr, code int) bool {
+	_, err := a.ValueForProtocol(code)
+	return err == nil
+}
+
+// isRelayAddr reports whether a goes through a circuit relay.
+func isRelayAddr(a ma.Multiaddr) bool {
+	for _, p := range a.Protocols() {
+		if p.Name == "p2p-circuit" {
+			return true
+		}
+	}
+	return false
+}
+
+// DialAddrs connects to remote over the first of raddrs that works, as
+// ranked by the Dialer's Ranker. Each address is dialed like with Dial,
+// once its delay is over, or as soon as all the dials started before
+// failed. The first conn established is returned, and the other dials
+// canceled.
+func (d *Dialer) DialAddrs(ctx context.Context, raddrs []ma.Multiaddr, remote peer.ID) (iconn.Conn, error) {
+	if len(raddrs) == 0 {
+		return nil, errors.New("no addresses to dial")
+	}
+	ranker := d.Ranker
+	if ranker == nil {
+		ranker = DefaultRanker
+	}
+	ranked := ranker.Rank(remote, raddrs)
+	if len(ranked) == 0 {
+		return nil, fmt.Errorf("none of the %d addresses of %s ranked", len(raddrs), remote)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Delay < ranked[j].Delay })
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(ranked))
+	start := time.Now()
+	next, pending := 0, 0
+	for {
+		for next < len(ranked) && (pending == 0 || time.Since(start) >= ranked[next].Delay) {
+			raddr := ranked[next].Addr
+			go func() {
+				c, err := d.Dial(ctx, raddr, remote)
+				results <- dialResult{c, err}
+			}()
+			next++
+			pending++
+		}
+
+		var due <-chan time.Time
+		var timer *time.Timer
+		if next < len(ranked) {
+			timer = time.NewTimer(ranked[next].Delay - time.Since(start))
+			due = timer.C
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go closeLosers(results, pending, r.c)
+				return r.c, nil
+			}
+			if pending == 0 && next == len(ranked) {
+				return nil, fmt.Errorf("all %d addresses failed, the last with: %w", len(ranked), r.err)
+			}
+		case <-due:
+		case <-ctx.Done():
+			go closeLosers(results, pending, nil)
+			return nil, ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+type dialResult struct {
+	c   iconn.Conn
+	err error
+}
+
+// closeLosers closes the conns of the n dials still running, other than
+// winner, as they complete.
+func closeLosers(results <-chan dialResult, n int, winner iconn.Conn) {
+	for ; n > 0; n-- {
+		if r := <-results; r.c != nil && r.c != winner {
+			r.c.Close()
+		}
+	}
+}
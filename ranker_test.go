@@ -0,0 +1,86 @@
+package conn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestDelayRanker(t *testing.T) {
+	v4 := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	v6 := ma.StringCast("/ip6/::1/tcp/4001")
+	dns := ma.StringCast("/dns4/example.com/tcp/4001")
+
+	r := DelayRanker{IPv4Delay: time.Second}
+	ranked := r.Rank("", []ma.Multiaddr{v4, dns, v6})
+	expected := []AddrDelay{{v6, 0}, {v4, time.Second}, {dns, time.Second}}
+	if len(ranked) != len(expected) {
+		t.Fatalf("ranked %v", ranked)
+	}
+	for i, ad := range ranked {
+		if !ad.Addr.Equal(expected[i].Addr) || ad.Delay != expected[i].Delay {
+			t.Fatalf("ranked %v, expected %v", ranked, expected)
+		}
+	}
+
+	// without IPv6, IPv4 goes first.
+	if ranked := r.Rank("", []ma.Multiaddr{v4}); ranked[0].Delay != 0 {
+		t.Fatalf("ranked %v", ranked)
+	}
+}
+
+type reverseRanker struct{}
+
+func (reverseRanker) Rank(remote peer.ID, addrs []ma.Multiaddr) []AddrDelay {
+	ranked := make([]AddrDelay, len(addrs))
+	for i, a := range addrs {
+		ranked[len(addrs)-1-i] = AddrDelay{Addr: a, Delay: time.Duration(len(addrs)-1-i) * time.Hour}
+	}
+	return ranked
+}
+
+func TestDialAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	// nothing listens there.
+	dead := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.Ranker = reverseRanker{}
+
+	// the dead address goes first, and the next one doesn't wait for its
+	// hour long delay once it failed.
+	start := time.Now()
+	c, err := d.DialAddrs(ctx, []ma.Multiaddr{l1.Multiaddr(), dead}, p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("the next address waited for its delay")
+	}
+	if !c.RemoteMultiaddr().Equal(l1.Multiaddr()) {
+		t.Fatalf("dialed %s", c.RemoteMultiaddr())
+	}
+	testOneSendRecv(t, c, c)
+
+	if _, err := d.DialAddrs(ctx, []ma.Multiaddr{dead}, p1.ID); err == nil {
+		t.Fatal("dial to a dead address succeeded")
+	}
+}
@@ -0,0 +1,108 @@
+package conn
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// ErrHandshakeReplayed is returned when an inbound handshake opens with a
+// message the listener already received recently, byte for byte.
+var ErrHandshakeReplayed = errors.New("replayed handshake")
+
+// ReplayWindow is how long listeners remember the opening handshake
+// messages they received, to reject exact replays. Zero disables replay
+// detection. Listeners pick up its value when they are created.
+var ReplayWindow = 10 * time.Minute
+
+// replayCacheSize bounds the number of remembered handshakes.
+const replayCacheSize = 8192
+
+// maxGuardedFrame is the largest opening frame inspected. secio proposals
+// are a couple hundred bytes; anything bigger is left to secio to reject.
+const maxGuardedFrame = 64 * 1024
+
+type replayEntry struct {
+	sum  [sha256.Size]byte
+	seen time.Time
+}
+
+// replayCache remembers handshake digests for a window of time.
+type replayCache struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	seen  map[[sha256.Size]byte]time.Time
+	order []replayEntry // oldest first
+}
+
+func newReplayCache(window time.Duration) *replayCache {
+	return &replayCache{
+		window: window,
+		seen:   make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// add records sum, and reports whether it had been seen within the window.
+func (rc *replayCache) add(sum [sha256.Size]byte) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	now := time.Now()
+	for len(rc.order) > 0 && (len(rc.order) >= replayCacheSize || now.Sub(rc.order[0].seen) > rc.window) {
+		e := rc.order[0]
+		if rc.seen[e.sum] == e.seen {
+			delete(rc.seen, e.sum)
+		}
+		rc.order = rc.order[1:]
+	}
+
+	if t, ok := rc.seen[sum]; ok && now.Sub(t) <= rc.window {
+		return true
+	}
+	rc.seen[sum] = now
+	rc.order = append(rc.order, replayEntry{sum: sum, seen: now})
+	return false
+}
+
+// replayGuard watches the first length-prefixed frame read from a conn,
+// the remote's handshake proposal, and fails the read if it is a replay.
+type replayGuard struct {
+	transport.Conn
+	cache *replayCache
+
+	frame []byte
+	done  bool
+}
+
+func (g *replayGuard) Read(b []byte) (int, error) {
+	n, err := g.Conn.Read(b)
+	if g.done || n == 0 {
+		return n, err
+	}
+
+	g.frame = append(g.frame, b[:n]...)
+	if len(g.frame) < 4 {
+		return n, err
+	}
+	size := int(binary.BigEndian.Uint32(g.frame))
+	if size > maxGuardedFrame {
+		g.done, g.frame = true, nil
+		return n, err
+	}
+	if len(g.frame) < 4+size {
+		return n, err
+	}
+
+	sum := sha256.Sum256(g.frame[:4+size])
+	g.done, g.frame = true, nil
+	if g.cache.add(sum) {
+		log.Warningf("rejecting replayed handshake from %s", g.RemoteMultiaddr())
+		return 0, ErrHandshakeReplayed
+	}
+	return n, err
+}
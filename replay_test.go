@@ -0,0 +1,81 @@
+package conn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+type readerConn struct {
+	transport.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func frame(payload string) []byte {
+	b := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	copy(b[4:], payload)
+	return b
+}
+
+// readAllTiny reads the guarded conn a few bytes at a time, like a
+// handshake reading a length prefix and then the message would.
+func readAllTiny(g *replayGuard) error {
+	buf := make([]byte, 3)
+	for {
+		_, err := g.Read(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	cache := newReplayCache(time.Minute)
+	propose := append(frame("propose-with-nonce"), frame("exchange")...)
+
+	first := &replayGuard{Conn: &readerConn{r: bytes.NewReader(propose)}, cache: cache}
+	if err := readAllTiny(first); err != nil {
+		t.Fatal("first handshake should go through, got: ", err)
+	}
+
+	replay := &replayGuard{Conn: &readerConn{r: bytes.NewReader(propose)}, cache: cache}
+	if err := readAllTiny(replay); err != ErrHandshakeReplayed {
+		t.Fatal("expected the replay to be rejected, got: ", err)
+	}
+
+	other := append(frame("propose-with-other-nonce"), frame("exchange")...)
+	fresh := &replayGuard{Conn: &readerConn{r: bytes.NewReader(other)}, cache: cache}
+	if err := readAllTiny(fresh); err != nil {
+		t.Fatal("fresh handshake should go through, got: ", err)
+	}
+}
+
+func TestReplayCacheWindow(t *testing.T) {
+	cache := newReplayCache(10 * time.Millisecond)
+	sum := sha256.Sum256([]byte("propose"))
+
+	if cache.add(sum) {
+		t.Fatal("first sighting can't be a replay")
+	}
+	if !cache.add(sum) {
+		t.Fatal("second sighting should be a replay")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if cache.add(sum) {
+		t.Fatal("sightings outside the window are not replays")
+	}
+}
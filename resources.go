@@ -0,0 +1,72 @@
+package conn
+
+import (
+	"errors"
+	"sync"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// HandshakeMemory is how many bytes of memory are reserved with a
+// ResourceManager for the buffers of a conn's setup.
+var HandshakeMemory = 64 * 1024
+
+// ErrResourceLimit is matched by errors from dials, and by the inbound
+// conns dropped, because a ResourceManager refused them.
+var ErrResourceLimit = errors.New("resource limit exceeded")
+
+// ResourceManager is consulted for every conn dialed or accepted, before
+// anything else, so that this layer doesn't exhaust a node's file
+// descriptors.
+type ResourceManager interface {
+	// ReserveConn reserves a file descriptor, and memory bytes for the
+	// setup, of a conn to or from raddr. The conn is refused when it
+	// fails.
+	ReserveConn(inbound bool, raddr ma.Multiaddr, memory int) (ResourceScope, error)
+}
+
+// ResourceScope holds the resources of a conn.
+type ResourceScope interface {
+	// Done releases them. It is called once, when the conn is closed or
+	// its setup failed.
+	Done()
+}
+
+// ListenerResourceManager is implemented by listeners that can consult a
+// ResourceManager for the conns they accept.
+type ListenerResourceManager interface {
+	// SetResourceManager makes the listener reserve resources with rm
+	// for every conn, like Dialer.ResourceManager. Refused conns are
+	// closed right away. It must be called before any call to Accept.
+	SetResourceManager(rm ResourceManager)
+}
+
+func (l *listener) SetResourceManager(rm ResourceManager) {
+	l.resources = rm
+}
+
+// reserveConn reserves resources for a conn with rm, if not nil. The
+// returned scope can be released any number of times.
+func reserveConn(rm ResourceManager, inbound bool, raddr ma.Multiaddr) (ResourceScope, error) {
+	if rm == nil {
+		return noScope{}, nil
+	}
+	scope, err := rm.ReserveConn(inbound, raddr, HandshakeMemory)
+	if err != nil {
+		return nil, &Error{Kind: ErrResourceLimit, Err: err}
+	}
+	return &onceScope{scope: scope}, nil
+}
+
+type noScope struct{}
+
+func (noScope) Done() {}
+
+type onceScope struct {
+	once  sync.Once
+	scope ResourceScope
+}
+
+func (s *onceScope) Done() {
+	s.once.Do(s.scope.Done)
+}
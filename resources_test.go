@@ -0,0 +1,57 @@
+package conn
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// fdLimiter is a ResourceManager with a fixed number of fds.
+type fdLimiter struct {
+	mu   sync.Mutex
+	free int
+}
+
+func (l *fdLimiter) ReserveConn(inbound bool, raddr ma.Multiaddr, memory int) (ResourceScope, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.free == 0 {
+		return nil, errors.New("out of fds")
+	}
+	l.free--
+	return fdScope{l}, nil
+}
+
+type fdScope struct {
+	l *fdLimiter
+}
+
+func (s fdScope) Done() {
+	s.l.mu.Lock()
+	s.l.free++
+	s.l.mu.Unlock()
+}
+
+func TestReserveConn(t *testing.T) {
+	if _, err := reserveConn(nil, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := &fdLimiter{free: 1}
+	scope, err := reserveConn(rm, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reserveConn(rm, true, nil); !errors.Is(err, ErrResourceLimit) {
+		t.Fatalf("expected ErrResourceLimit, got %v", err)
+	}
+
+	// released once, however often the conn gives it back.
+	scope.Done()
+	scope.Done()
+	if rm.free != 1 {
+		t.Fatalf("expected 1 free fd, got %d", rm.free)
+	}
+}
@@ -0,0 +1,207 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ReusePortDialer returns a dialer for t which binds outbound sockets to
+// laddr, the address we listen on, with SO_REUSEPORT. The remote then sees
+// us connecting from the address it can dial back, which address
+// observation and NAT traversal rely on. When the bind fails, for instance
+// because the port is taken for that destination already, the dial is
+// retried from an ephemeral port, with a connReusePortConflict event. Such
+// conflicts, typically a previous conn to the same destination lingering
+// in TIME_WAIT, last a while: further dials to that destination go
+// straight to an ephemeral port for ReusePortConflictBackoff.
+//
+// Add it to a Dialer with AddDialer. It implements ReusePortDiagnostics.
+func ReusePortDialer(t transport.Transport, laddr ma.Multiaddr) (transport.Dialer, error) {
+	reuse, err := t.Dialer(laddr, transport.ReusePorts)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := t.Dialer(ephemeralAddr(laddr))
+	if err != nil {
+		return nil, err
+	}
+	return &reusePortDialer{laddr: laddr, reuse: reuse, ephemeral: ephemeral}, nil
+}
+
+// ephemeralAddr is the local address to dial from ephemeral ports instead
+// of laddr: laddr with the unspecified address of its family, and port
+// zero, over the same transport protocols. It can't be laddr, as
+// transports like go-tcp-transport cache their dialers by local address,
+// and would return the one reusing the port again.
+func ephemeralAddr(laddr ma.Multiaddr) ma.Multiaddr {
+	parts := ma.Split(laddr)
+	for i, part := range parts {
+		var unspecified string
+		switch part.Protocols()[0].Code {
+		case ma.P_IP4:
+			unspecified = "/ip4/0.0.0.0"
+		case ma.P_IP6:
+			unspecified = "/ip6/::"
+		case ma.P_TCP:
+			unspecified = "/tcp/0"
+		case ma.P_UDP:
+			unspecified = "/udp/0"
+		default:
+			continue
+		}
+		parts[i] = ma.StringCast(unspecified)
+	}
+	return ma.Join(parts...)
+}
+
+// ReusePortConflictBackoff is how long a destination is dialed from
+// ephemeral ports after a conflict on the listen port. It is a little
+// over the two minutes conns commonly spend in TIME_WAIT.
+var ReusePortConflictBackoff = 150 * time.Second
+
+// ReusePortStats counts the dials of a ReusePortDialer.
+type ReusePortStats struct {
+	// Reused is the number of dials made from the listen port.
+	Reused uint64
+	// Conflicts is the number of dials from the listen port that failed
+	// to bind, by reason, e.g. "address in use".
+	Conflicts map[string]uint64
+	// Avoided is the number of dials made from an ephemeral port right
+	// away, because of an earlier conflict with the destination.
+	Avoided uint64
+}
+
+// ReusePortDiagnostics is implemented by the dialers of ReusePortDialer.
+type ReusePortDiagnostics interface {
+	ReusePortStats() ReusePortStats
+}
+
+type reusePortDialer struct {
+	laddr     ma.Multiaddr
+	reuse     transport.Dialer
+	ephemeral transport.Dialer
+
+	mu        sync.Mutex
+	stats     ReusePortStats
+	conflicts map[string]time.Time // destinations to avoid, until when
+}
+
+func (d *reusePortDialer) Dial(raddr ma.Multiaddr) (transport.Conn, error) {
+	return d.DialContext(context.Background(), raddr)
+}
+
+func (d *reusePortDialer) DialContext(ctx context.Context, raddr ma.Multiaddr) (transport.Conn, error) {
+	if d.avoid(raddr) {
+		return d.ephemeral.DialContext(ctx, raddr)
+	}
+
+	c, err := d.reuse.DialContext(ctx, raddr)
+	if err == nil || ctx.Err() != nil {
+		if err == nil {
+			d.mu.Lock()
+			d.stats.Reused++
+			d.mu.Unlock()
+		}
+		return c, err
+	}
+	reason := bindErrorReason(err)
+	if reason == "" {
+		return c, err
+	}
+	d.conflict(raddr, reason)
+	log.Event(ctx, "connReusePortConflict", reusePortLoggable{d.laddr, raddr, reason})
+	log.Debugf("dial to %s from the listen port failed, using an ephemeral port: %s", raddr, err)
+	return d.ephemeral.DialContext(ctx, raddr)
+}
+
+// avoid tells whether raddr conflicted recently, counting an avoided dial
+// if so.
+func (d *reusePortDialer) avoid(raddr ma.Multiaddr) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	until, ok := d.conflicts[raddr.String()]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(d.conflicts, raddr.String())
+		return false
+	}
+	d.stats.Avoided++
+	return true
+}
+
+func (d *reusePortDialer) conflict(raddr ma.Multiaddr, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if d.conflicts == nil {
+		d.conflicts = make(map[string]time.Time)
+		d.stats.Conflicts = make(map[string]uint64)
+	}
+	for a, until := range d.conflicts {
+		if now.After(until) {
+			delete(d.conflicts, a)
+		}
+	}
+	d.conflicts[raddr.String()] = now.Add(ReusePortConflictBackoff)
+	d.stats.Conflicts[reason]++
+}
+
+func (d *reusePortDialer) ReusePortStats() ReusePortStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	stats.Conflicts = make(map[string]uint64, len(d.stats.Conflicts))
+	for r, n := range d.stats.Conflicts {
+		stats.Conflicts[r] = n
+	}
+	return stats
+}
+
+type reusePortLoggable struct {
+	laddr, raddr ma.Multiaddr
+	reason       string
+}
+
+func (r reusePortLoggable) Loggable() map[string]interface{} {
+	return map[string]interface{}{
+		"localAddr":  r.laddr,
+		"remoteAddr": r.raddr,
+		"reason":     r.reason,
+	}
+}
+
+func (d *reusePortDialer) Matches(a ma.Multiaddr) bool {
+	return d.reuse.Matches(a)
+}
+
+// bindErrorReason tells why err is about the local address being
+// unusable, or returns "" if it isn't.
+func bindErrorReason(err error) string {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return ""
+	}
+	switch errno {
+	case syscall.EADDRINUSE:
+		// the same 5-tuple exists already, maybe in TIME_WAIT.
+		return "address in use"
+	case syscall.EADDRNOTAVAIL:
+		return "address not available"
+	case syscall.EACCES:
+		return "permission denied"
+	case syscall.EINVAL:
+		return "invalid bind"
+	}
+	return ""
+}
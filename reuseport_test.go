@@ -0,0 +1,165 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	tcpt "github.com/libp2p/go-tcp-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// failingTransport hands out dialers failing with reuseErr when reusing
+// ports, and with errEphemeral otherwise.
+type failingTransport struct {
+	reuseErr error
+}
+
+var errEphemeral = errors.New("ephemeral dial")
+
+func (t *failingTransport) Dialer(laddr ma.Multiaddr, opts ...transport.DialOpt) (transport.Dialer, error) {
+	err := errEphemeral
+	for _, o := range opts {
+		if o == transport.ReusePorts {
+			err = t.reuseErr
+		}
+	}
+	return &failingDialer{err: err}, nil
+}
+
+func (t *failingTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (t *failingTransport) Matches(ma.Multiaddr) bool {
+	return true
+}
+
+type failingDialer struct {
+	err error
+}
+
+func (d *failingDialer) Dial(raddr ma.Multiaddr) (transport.Conn, error) {
+	return nil, d.err
+}
+
+func (d *failingDialer) DialContext(ctx context.Context, raddr ma.Multiaddr) (transport.Conn, error) {
+	return nil, d.err
+}
+
+func (d *failingDialer) Matches(ma.Multiaddr) bool {
+	return true
+}
+
+func TestReusePortFallback(t *testing.T) {
+	laddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		reuseErr error
+		fallback bool
+	}{
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}, true},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, false},
+	} {
+		d, err := ReusePortDialer(&failingTransport{reuseErr: tc.reuseErr}, laddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.DialContext(context.Background(), raddr)
+		if fellBack := err == errEphemeral; fellBack != tc.fallback {
+			t.Fatalf("%s: expected fallback %t, got error %v", tc.reuseErr, tc.fallback, err)
+		}
+	}
+}
+
+func TestReusePortConflictAvoidance(t *testing.T) {
+	laddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inUse := &net.OpError{Op: "dial", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}
+	d, err := ReusePortDialer(&failingTransport{reuseErr: inUse}, laddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := d.DialContext(context.Background(), raddr); err != errEphemeral {
+			t.Fatalf("expected fallback, got %v", err)
+		}
+	}
+
+	// the first dial conflicted; the others went straight to an
+	// ephemeral port.
+	stats := d.(ReusePortDiagnostics).ReusePortStats()
+	if stats.Conflicts["address in use"] != 1 || stats.Avoided != 2 || stats.Reused != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestReusePortEphemeral(t *testing.T) {
+	laddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tcpt.NewTCPTransport()
+	l, err := tr.Listen(laddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	remote, err := tcpt.NewTCPTransport().Listen(laddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	go func() {
+		for {
+			c, err := remote.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	d, err := ReusePortDialer(tr, l.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.(*reusePortDialer).ephemeral.DialContext(context.Background(), remote.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.LocalMultiaddr().Equal(l.Multiaddr()) {
+		t.Fatal("the fallback dialed from the listen port")
+	}
+}
+
+func TestEphemeralAddr(t *testing.T) {
+	for laddr, want := range map[string]string{
+		"/ip4/1.2.3.4/tcp/4001": "/ip4/0.0.0.0/tcp/0",
+		"/ip6/::1/tcp/4001":     "/ip6/::/tcp/0",
+		"/ip6/::1/udp/4001":     "/ip6/::/udp/0",
+	} {
+		if got := ephemeralAddr(ma.StringCast(laddr)); got.String() != want {
+			t.Errorf("ephemeral address for %s: got %s, want %s", laddr, got, want)
+		}
+	}
+}
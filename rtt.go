@@ -0,0 +1,58 @@
+package conn
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// secioRoundTrips is how many round trips the secio handshake takes:
+// proposals, key exchanges, then nonces, each side sending before it
+// waits for the other.
+const secioRoundTrips = 3
+
+// RTTInfo is implemented by the conns returned by Dial and Accept, which
+// estimate the round trip time to the remote from the round trips of
+// their setup, so higher layers needn't measure it again.
+type RTTInfo interface {
+	// Latency is the smoothed round trip time, or zero without any
+	// sample yet, as on insecure accepted conns.
+	Latency() time.Duration
+
+	// AddRTTSample folds a round trip measured otherwise, like with
+	// keepalive pings, into the estimate.
+	AddRTTSample(rtt time.Duration)
+}
+
+// rttEstimator smooths round trip samples like TCP does, with a gain of
+// 1/8. The zero value is ready to use.
+type rttEstimator struct {
+	srtt int64 // time.Duration, accessed atomically
+}
+
+func (e *rttEstimator) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&e.srtt))
+}
+
+func (e *rttEstimator) AddRTTSample(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+	for {
+		old := atomic.LoadInt64(&e.srtt)
+		srtt := int64(rtt)
+		if old != 0 {
+			srtt = old + (int64(rtt)-old)/8
+		}
+		if atomic.CompareAndSwapInt64(&e.srtt, old, srtt) {
+			return
+		}
+	}
+}
+
+func (c *secureConn) Latency() time.Duration {
+	return c.insecure.(RTTInfo).Latency()
+}
+
+func (c *secureConn) AddRTTSample(rtt time.Duration) {
+	c.insecure.(RTTInfo).AddRTTSample(rtt)
+}
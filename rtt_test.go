@@ -0,0 +1,29 @@
+package conn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	var e rttEstimator
+	if e.Latency() != 0 {
+		t.Fatal("expected no estimate without samples")
+	}
+
+	e.AddRTTSample(80 * time.Millisecond)
+	if e.Latency() != 80*time.Millisecond {
+		t.Fatalf("expected the first sample as is, got %s", e.Latency())
+	}
+
+	e.AddRTTSample(160 * time.Millisecond)
+	if e.Latency() != 90*time.Millisecond {
+		t.Fatalf("expected a smoothed 90ms, got %s", e.Latency())
+	}
+
+	e.AddRTTSample(0)
+	e.AddRTTSample(-time.Second)
+	if e.Latency() != 90*time.Millisecond {
+		t.Fatalf("invalid samples changed the estimate to %s", e.Latency())
+	}
+}
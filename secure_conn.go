@@ -3,7 +3,10 @@ package conn
 import (
 	"context"
 	"errors"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ic "github.com/libp2p/go-libp2p-crypto"
@@ -18,10 +21,58 @@ import (
 type secureConn struct {
 	insecure iconn.Conn    // the wrapped conn
 	secure   secio.Session // secure Session
+
+	established time.Time
+
+	writeTimeout int64 // time.Duration, accessed atomically
+	writeChunk   int   // MaxWriteChunk if zero
+
+	msgFramer
+
+	// messageMode makes every Write a single secio frame, and every Read
+	// return a single frame. See Dialer.MessageMode.
+	messageMode bool
+
+	// frameMu serializes reads, and guards the fields below it.
+	frameMu   sync.Mutex
+	frame     []byte // frame read but not returned yet
+	haveFrame bool
+	unread    []byte          // read past the deadline but not returned yet
+	pending   chan secureRead // read still running past its deadline
+	results   chan secureRead // reused as pending
+	readBuf   []byte          // reused by reads, see readBuffer
+	readTimer *time.Timer     // reused by readWithDeadline
+
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+	deadlineSet  chan struct{} // closed when readDeadline changes
+
+	reporter BandwidthReporter
+	stats    connStats
+	msgLimit *msgLimit
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+
+	writeErrMu sync.Mutex
+	writeErr   error // a write that timed out, possibly mid-frame
+
+	batch *batchWriter // see WriteBatching
+
+	exporter []byte // see ExportKeyingMaterial
+
+	comp         Compression // negotiated, see Dialer.Compression
+	decompressed []byte      // reused by decompress
 }
 
 // newConn constructs a new connection
-func newSecureConn(ctx context.Context, sk ic.PrivKey, insecure iconn.Conn) (iconn.Conn, error) {
+func newSecureConn(ctx context.Context, sk ic.PrivKey, insecure iconn.Conn) (*secureConn, error) {
+	return newSecureConnLimited(ctx, sk, insecure, MaxMessageSize)
+}
+
+// newSecureConnLimited is newSecureConn, failing on frames longer than
+// maxMsg from the handshake on.
+func newSecureConnLimited(ctx context.Context, sk ic.PrivKey, insecure iconn.Conn, maxMsg int) (*secureConn, error) {
 
 	if insecure == nil {
 		return nil, errors.New("insecure is nil")
@@ -35,7 +86,8 @@ func newSecureConn(ctx context.Context, sk ic.PrivKey, insecure iconn.Conn) (ico
 
 	// NewSession performs the secure handshake, which takes multiple RTT
 	sessgen := secio.SessionGenerator{LocalID: insecure.LocalPeer(), PrivateKey: sk}
-	secure, err := sessgen.NewSession(ctx, insecure)
+	guard := &frameGuard{ReadWriteCloser: insecure, max: maxMsg + maxFrameOverhead}
+	secure, err := sessgen.NewSession(ctx, guard)
 	if err != nil {
 		return nil, err
 	}
@@ -43,12 +95,30 @@ func newSecureConn(ctx context.Context, sk ic.PrivKey, insecure iconn.Conn) (ico
 	conn := &secureConn{
 		insecure: insecure,
 		secure:   secure,
+
+		established: time.Now(),
+		done:        make(chan struct{}),
 	}
+	conn.msgFramer.rw = conn
+	conn.msgFramer.max = maxMsg
 	return conn, nil
 }
 
 func (c *secureConn) Close() error {
-	return c.secure.Close()
+	c.closeOnce.Do(func() {
+		if c.done != nil {
+			close(c.done)
+		}
+	})
+	if c.batch != nil {
+		c.batch.Flush()
+	}
+	if c.msgLimit != nil {
+		c.msgLimit.detach()
+	}
+	err := c.secure.Close()
+	c.endBackgroundRead()
+	return err
 }
 
 // ID is an identifier unique to this connection.
@@ -57,7 +127,7 @@ func (c *secureConn) ID() string {
 }
 
 func (c *secureConn) String() string {
-	return iconn.String(c, "secureConn")
+	return describeConn("secureConn", c)
 }
 
 func (c *secureConn) LocalAddr() net.Addr {
@@ -68,15 +138,26 @@ func (c *secureConn) RemoteAddr() net.Addr {
 	return c.insecure.RemoteAddr()
 }
 
+// SetDeadline sets the read and write deadlines. See SetReadDeadline and
+// SetWriteDeadline.
 func (c *secureConn) SetDeadline(t time.Time) error {
-	return c.insecure.SetDeadline(t)
+	c.setReadDeadline(t)
+	return c.SetWriteDeadline(t)
 }
 
+// SetReadDeadline sets the read deadline. A read that misses it fails
+// with a timeout, but the frame it was reading is not lost: it is
+// returned by the next read.
 func (c *secureConn) SetReadDeadline(t time.Time) error {
-	return c.insecure.SetReadDeadline(t)
+	c.setReadDeadline(t)
+	return nil
 }
 
+// SetWriteDeadline sets the deadline of the underlying conn for writes.
+// A write that misses it may have sent part of a frame, so the conn
+// can't be written to anymore: later writes fail with its error.
 func (c *secureConn) SetWriteDeadline(t time.Time) error {
+	setWriteTimeout(&c.writeTimeout, t)
 	return c.insecure.SetWriteDeadline(t)
 }
 
@@ -110,19 +191,229 @@ func (c *secureConn) RemotePublicKey() ic.PubKey {
 	return c.secure.RemotePublicKey()
 }
 
-// Read reads data, net.Conn style
+// Read reads data, net.Conn style. In message mode, it reads exactly one
+// frame, and fails with io.ErrShortBuffer if buf can't hold it; the frame
+// is then kept for the next call.
 func (c *secureConn) Read(buf []byte) (int, error) {
-	return c.secure.ReadWriter().Read(buf)
+	c.frameMu.Lock()
+	defer c.frameMu.Unlock()
+
+	n, err := c.read(buf)
+	c.reportRecv(n)
+	return n, c.idleErr(err)
 }
 
-// Write writes data, net.Conn style
+// read is Read, with frameMu held.
+func (c *secureConn) read(buf []byte) (int, error) {
+	if !c.messageMode {
+		if len(c.unread) > 0 {
+			n := copy(buf, c.unread)
+			c.unread = c.unread[n:]
+			return n, nil
+		}
+
+		if c.msgLimit != nil || c.comp != nil {
+			// frame by frame, to count or decompress them.
+			if err := c.fillFrame(); err != nil {
+				return 0, err
+			}
+			data, err := c.decompress(c.frame)
+			if err != nil {
+				c.releaseFrame()
+				return 0, err
+			}
+			n := copy(buf, data)
+			c.unread = append(c.readBuf[:0], data[n:]...)
+			if cap(c.unread) <= maxReadBuffer {
+				c.readBuf = c.unread[:0]
+			}
+			c.releaseFrame()
+			return n, nil
+		}
+
+		rw := c.secure.ReadWriter()
+		if c.pending == nil && !c.usesDeadlines() {
+			return rw.Read(buf)
+		}
+		data, err := c.readWithDeadline(func() ([]byte, error) {
+			b := c.readBuffer(len(buf))
+			n, err := rw.Read(b)
+			return b[:n], err
+		})
+		n := copy(buf, data)
+		c.unread = data[n:]
+		return n, err
+	}
+
+	if err := c.fillFrame(); err != nil {
+		return 0, err
+	}
+	if len(c.frame) > len(buf) {
+		return 0, io.ErrShortBuffer
+	}
+	n := copy(buf, c.frame)
+	c.releaseFrame()
+	return n, nil
+}
+
+// maxReadBuffer caps the buffer kept by secureConns for reads.
+const maxReadBuffer = 32 * 1024
+
+// readBuffer returns a buffer of at most n bytes, and maxReadBuffer, to
+// read into. It is the same buffer every time: reads are serialized, and
+// a read only starts once what the previous one left in unread is
+// consumed.
+func (c *secureConn) readBuffer(n int) []byte {
+	if n > maxReadBuffer {
+		n = maxReadBuffer
+	}
+	if cap(c.readBuf) < n {
+		c.readBuf = make([]byte, n)
+	}
+	return c.readBuf[:n]
+}
+
+// Write writes data, net.Conn style. Large writes are split into
+// several secio frames, except in message mode.
 func (c *secureConn) Write(buf []byte) (int, error) {
-	return c.secure.ReadWriter().Write(buf)
+	c.writeErrMu.Lock()
+	err := c.writeErr
+	c.writeErrMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.write(buf)
+	c.reportSent(n)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		c.writeErrMu.Lock()
+		c.writeErr = err
+		c.writeErrMu.Unlock()
+	}
+	return n, c.idleErr(err)
+}
+
+func (c *secureConn) write(buf []byte) (int, error) {
+	if c.messageMode {
+		if max := resolveMaxMessageSize(c.msgFramer.max); len(buf) > max {
+			return 0, &MessageTooLargeError{Size: uint64(len(buf)), Max: max}
+		}
+		return c.secure.ReadWriter().Write(buf)
+	}
+	if c.batch != nil {
+		return c.batch.Write(buf)
+	}
+	return c.writeFrames(buf)
+}
+
+// writeFrames writes buf in as few secio frames as the write chunk size
+// allows, compressed if so negotiated.
+func (c *secureConn) writeFrames(buf []byte) (int, error) {
+	chunk := resolveWriteChunk(c.writeChunk)
+	var w io.Writer = c.secure.ReadWriter()
+	if c.comp != nil {
+		w = compressedFrames{c}
+	}
+	if len(buf) <= chunk {
+		return w.Write(buf)
+	}
+	return writeChunked(w, c.insecure.SetWriteDeadline, &c.writeTimeout, chunk, buf)
+}
+
+// ReadMsg reads the next message. In message mode, messages are frames.
+func (c *secureConn) ReadMsg() ([]byte, error) {
+	if !c.messageMode {
+		return c.msgFramer.ReadMsg()
+	}
+
+	c.frameMu.Lock()
+	defer c.frameMu.Unlock()
+
+	if err := c.fillFrame(); err != nil {
+		return nil, c.idleErr(err)
+	}
+	msg := msgPool.Get(len(c.frame))
+	copy(msg, c.frame)
+	c.releaseFrame()
+	c.reportRecv(len(msg))
+	atomic.AddUint64(&c.msgFramer.msgsRecv, 1)
+	return msg, nil
+}
+
+// WriteMsg writes a message. In message mode, messages are frames.
+func (c *secureConn) WriteMsg(msg []byte) error {
+	if !c.messageMode {
+		return c.msgFramer.WriteMsg(msg)
+	}
+	if _, err := c.Write(msg); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.msgFramer.msgsSent, 1)
+	return nil
+}
+
+// NextMsgLen returns the length of the next message.
+func (c *secureConn) NextMsgLen() (int, error) {
+	if !c.messageMode {
+		return c.msgFramer.NextMsgLen()
+	}
+
+	c.frameMu.Lock()
+	defer c.frameMu.Unlock()
+
+	if err := c.fillFrame(); err != nil {
+		return 0, err
+	}
+	return len(c.frame), nil
+}
+
+// fillFrame reads the next frame, unless one is already pending.
+// frameMu must be held.
+func (c *secureConn) fillFrame() error {
+	if c.haveFrame {
+		return nil
+	}
+	if c.msgLimit != nil {
+		if err := c.waitMsgLimit(); err != nil {
+			return err
+		}
+	}
+	frame, err := c.readWithDeadline(c.secure.ReadWriter().ReadMsg)
+	if err != nil {
+		return err
+	}
+	c.frame = frame
+	c.haveFrame = true
+	return nil
 }
 
-// ReleaseMsg releases a buffer
-func (c *secureConn) ReleaseMsg(m []byte) {
-	c.secure.ReadWriter().ReleaseMsg(m)
+// releaseFrame drops the pending frame. frameMu must be held.
+func (c *secureConn) releaseFrame() {
+	c.secure.ReadWriter().ReleaseMsg(c.frame)
+	c.frame = nil
+	c.haveFrame = false
+}
+
+// CloseWrite shuts down the writing side of the connection, once the
+// writes still batched are sent, see WriteBatching.
+func (c *secureConn) CloseWrite() error {
+	hc, ok := c.insecure.(HalfCloser)
+	if !ok {
+		return ErrHalfCloseUnsupported
+	}
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	return hc.CloseWrite()
+}
+
+// CloseRead shuts down the reading side of the connection.
+func (c *secureConn) CloseRead() error {
+	hc, ok := c.insecure.(HalfCloser)
+	if !ok {
+		return ErrHalfCloseUnsupported
+	}
+	return hc.CloseRead()
 }
 
 func (c *secureConn) Transport() tpt.Transport {
@@ -3,6 +3,9 @@ package conn
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
+	"net"
 	"runtime"
 	"sync"
 	"testing"
@@ -10,6 +13,7 @@ import (
 
 	ic "github.com/libp2p/go-libp2p-crypto"
 	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	tu "github.com/libp2p/go-testutil"
 	travis "github.com/libp2p/go-testutil/ci/travis"
 )
 
@@ -210,3 +214,261 @@ func TestSecureCloseLeak(t *testing.T) {
 		t.Fatal("leaking goroutines:", ngr)
 	}
 }
+
+func TestSecureHalfClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c1, c2, _, _ := setupSecureConn(t, ctx)
+	defer c1.Close()
+	defer c2.Close()
+
+	testHalfCloseEcho(t, c1, c2)
+}
+
+func TestSecureMessageMode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	l1.(ListenerMessageMode).SetMessageMode(true)
+
+	d2 := NewDialer(p2.ID, p2.PrivKey, nil)
+	d2.MessageMode = true
+	d2.AddDialer(dialer(t, p2.Addr))
+
+	c2, err := d2.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	c1, err := l1.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+
+	for _, m := range []string{"hello", "world!"} {
+		if _, err := c2.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	short := make([]byte, 2)
+	if _, err := c1.Read(short); err != io.ErrShortBuffer {
+		t.Fatal("expected a short buffer error, got: ", err)
+	}
+
+	buf := make([]byte, 100)
+	for _, m := range []string{"hello", "world!"} {
+		n, err := c1.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != m {
+			t.Fatalf("expected exactly one frame %q, got %q", m, buf[:n])
+		}
+	}
+}
+
+func TestHandshakeResult(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c1, c2, p1, p2 := setupSecureConn(t, ctx)
+	defer c1.Close()
+	defer c2.Close()
+
+	hs := c1.(HandshakeInfo).HandshakeResult()
+	if hs.Protocol != SecioTag || hs.LocalPeer != p1.ID || hs.RemotePeer != p2.ID {
+		t.Fatalf("unexpected handshake result %+v", hs)
+	}
+	if !hs.RemotePublicKey.Equals(p2.PubKey) {
+		t.Fatal("handshake result has the wrong remote key")
+	}
+	if hs.Completed.IsZero() {
+		t.Fatal("handshake result has no completion time")
+	}
+
+	s1, s2, _, _ := setupSingleConn(t, ctx)
+	defer s1.Close()
+	defer s2.Close()
+	if hs := s1.(HandshakeInfo).HandshakeResult(); hs.Protocol != NoEncryptionTag || hs.RemotePublicKey != nil {
+		t.Fatalf("unexpected plaintext handshake result %+v", hs)
+	}
+}
+
+func TestSecureReadDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c1, c2, _, _ := setupSecureConn(t, ctx)
+	defer c1.Close()
+	defer c2.Close()
+
+	buf := make([]byte, 16)
+	c1.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := c1.Read(buf)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// the read that timed out must not break the stream.
+	if _, err := c2.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c1.SetReadDeadline(time.Time{})
+	n, err := c1.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("read %q after a timeout", buf[:n])
+	}
+	testOneSendRecv(t, c1, c2)
+	testOneSendRecv(t, c2, c1)
+}
+
+func TestSecureReadDeadlineClose(t *testing.T) {
+	c := &secureConn{done: make(chan struct{})}
+	c.setReadDeadline(time.Time{})
+
+	block := make(chan struct{})
+	defer close(block)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(c.done)
+	}()
+	_, err := c.readWithDeadline(func() ([]byte, error) {
+		<-block
+		return nil, io.EOF
+	})
+	if err != errConnClosed {
+		t.Fatal("expected a read waiting in the background to end on close, got ", err)
+	}
+}
+
+func TestSecurityProtocols(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	// a legacy listener that only speaks plaintext.
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	if err := l1.(ListenerSecurityProtocols).SetSecurityProtocols([]string{NoEncryptionTag}); err != nil {
+		t.Fatal(err)
+	}
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.SecurityProtocols = []string{SecioTag, NoEncryptionTag}
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proto := c.(HandshakeInfo).HandshakeResult().Protocol; proto != NoEncryptionTag {
+		t.Fatalf("negotiated %s", proto)
+	}
+	testOneSendRecv(t, c, c)
+	c.Close()
+	if dg := d.Downgrades(); dg[p1.ID] != 1 || len(dg) != 1 {
+		t.Fatalf("unexpected downgrades %v", dg)
+	}
+
+	d.SecurityProtocols = []string{SecioTag}
+	if _, err := d.Dial(ctx, l1.Multiaddr(), p1.ID); !errors.Is(err, ErrProtocolNegotiationFailed) {
+		t.Fatalf("expected negotiation to fail, got %v", err)
+	}
+
+	d.SecurityProtocols = []string{"/tls/1.0.0"}
+	if _, err := d.Dial(ctx, l1.Multiaddr(), p1.ID); !errors.Is(err, ErrUnsupportedSecurity) {
+		t.Fatalf("expected unsupported protocol, got %v", err)
+	}
+}
+
+// BenchmarkThroughput measures bulk transfers over a secure conn.
+func BenchmarkThroughput(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1, err := tu.RandPeerNetParams()
+	if err != nil {
+		b.Fatal(err)
+	}
+	p2, err := tu.RandPeerNetParams()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l1.Close()
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	c1, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c1.Close()
+	a, err := l1.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	c2 := a.(iconn.Conn)
+	defer c2.Close()
+
+	const chunk = 32 * 1024
+	errs := make(chan error, 1)
+	go func() {
+		buf := make([]byte, chunk)
+		for i := 0; i < b.N; i++ {
+			if _, err := c1.Write(buf); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+
+	buf := make([]byte, chunk)
+	b.SetBytes(chunk)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.ReadFull(c2, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if err := <-errs; err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestSecureReadBufferReused(t *testing.T) {
+	var c secureConn
+	b1 := c.readBuffer(1 << 20)
+	if len(b1) != maxReadBuffer {
+		t.Fatal("read buffer should be capped, got ", len(b1))
+	}
+	b2 := c.readBuffer(100)
+	if len(b2) != 100 || &b1[0] != &b2[0] {
+		t.Fatal("read buffer should be reused")
+	}
+}
@@ -0,0 +1,84 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// ErrUnsupportedSecurity is matched by errors from configuring security
+// protocols this package can't speak, or can't speak without a key.
+var ErrUnsupportedSecurity = errors.New("unsupported security protocol")
+
+// defaultSecurityProtocols are the security protocols used when none are
+// configured: secio when there is a key and encryption is enabled,
+// plaintext otherwise.
+func defaultSecurityProtocols(sk ic.PrivKey) []string {
+	if sk == nil || !iconn.EncryptConnections {
+		return []string{NoEncryptionTag}
+	}
+	return []string{SecioTag}
+}
+
+// checkSecurityProtocols checks that protos can be spoken with sk.
+func checkSecurityProtocols(protos []string, sk ic.PrivKey) error {
+	if len(protos) == 0 {
+		return &Error{Kind: ErrUnsupportedSecurity, Err: errors.New("no security protocols")}
+	}
+	for _, p := range protos {
+		switch p {
+		case SecioTag:
+			if sk == nil || !iconn.EncryptConnections {
+				return &Error{Kind: ErrUnsupportedSecurity, Err: fmt.Errorf("%s needs a private key", p)}
+			}
+		case PlaintextIdentityTag:
+			if sk == nil {
+				return &Error{Kind: ErrUnsupportedSecurity, Err: fmt.Errorf("%s needs a private key", p)}
+			}
+		case NoEncryptionTag:
+		default:
+			return &Error{Kind: ErrUnsupportedSecurity, Err: fmt.Errorf("%q", p)}
+		}
+	}
+	return nil
+}
+
+// securityProtocols returns the security protocols to propose, in order.
+func (d *Dialer) securityProtocols() ([]string, error) {
+	if d.SecurityProtocols == nil {
+		return defaultSecurityProtocols(d.PrivateKey), nil
+	}
+	if err := checkSecurityProtocols(d.SecurityProtocols, d.PrivateKey); err != nil {
+		return nil, err
+	}
+	return d.SecurityProtocols, nil
+}
+
+type ListenerSecurityProtocols interface {
+	// SetSecurityProtocols sets the security protocols accepted conns
+	// may select, like Dialer.SecurityProtocols. With multistream, the
+	// dialer picks the first of its own protocols that the listener
+	// supports, so the order only matters for the dialing side. It must
+	// be called before any call to Accept.
+	SetSecurityProtocols(protos []string) error
+}
+
+func (l *listener) SetSecurityProtocols(protos []string) error {
+	_, sk := l.identity()
+	if err := checkSecurityProtocols(protos, sk); err != nil {
+		return err
+	}
+	l.mux = newSecurityMuxer(protos)
+	return nil
+}
+
+func newSecurityMuxer(protos []string) *msmux.MultistreamMuxer {
+	mux := msmux.NewMultistreamMuxer()
+	for _, p := range protos {
+		mux.AddHandler(p, nil)
+	}
+	return mux
+}
@@ -0,0 +1,32 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrDialToSelf is matched by errors from dials to the Dialer's own
+// LocalPeer, or to one of its ListenAddrs. They fail before any socket is
+// opened, rather than after a handshake with ourselves.
+var ErrDialToSelf = errors.New("dial to self attempted")
+
+// checkSelfPeer fails with ErrDialToSelf if remote is the local peer.
+func (d *Dialer) checkSelfPeer(remote peer.ID) error {
+	if remote != "" && remote == d.LocalPeer {
+		return &Error{Kind: ErrDialToSelf, Err: fmt.Errorf("%s is the local peer", remote)}
+	}
+	return nil
+}
+
+// checkSelfAddr fails with ErrDialToSelf if raddr is one of ListenAddrs.
+func (d *Dialer) checkSelfAddr(raddr ma.Multiaddr) error {
+	for _, a := range d.ListenAddrs {
+		if a.Equal(raddr) {
+			return &Error{Kind: ErrDialToSelf, Err: fmt.Errorf("%s is a local listen address", raddr)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestDialToSelf(t *testing.T) {
+	ctx := context.Background()
+	p := tu.RandPeerNetParamsOrFatal(t)
+	raddr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	// with no sub-dialer, any dial that gets to opening a socket fails
+	// otherwise.
+	d := NewDialer(p.ID, p.PrivKey, nil)
+	if _, err := d.Dial(ctx, raddr, p.ID); !errors.Is(err, ErrDialToSelf) {
+		t.Fatal("expected ErrDialToSelf, got ", err)
+	}
+
+	d.ListenAddrs = []ma.Multiaddr{raddr}
+	if _, err := d.Dial(ctx, raddr, ""); !errors.Is(err, ErrDialToSelf) {
+		t.Fatal("expected ErrDialToSelf, got ", err)
+	}
+	if _, err := d.Dial(ctx, ma.StringCast("/ip4/1.2.3.4/tcp/4002"), ""); errors.Is(err, ErrDialToSelf) {
+		t.Fatal("other addresses aren't ours")
+	}
+
+	a, b := net.Pipe()
+	defer b.Close()
+	if _, err := d.UpgradeOutbound(ctx, pipeConn{a}, p.ID); !errors.Is(err, ErrDialToSelf) {
+		t.Fatal("expected ErrDialToSelf, got ", err)
+	}
+}
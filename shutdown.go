@@ -0,0 +1,141 @@
+package conn
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownReport tells what a listener or dialer gave up when shut down,
+// for orchestration logs, and tests asserting a clean teardown.
+type ShutdownReport struct {
+	// ConnsClosed counts the established conns closed before anyone used
+	// them: the ones waiting for Accept, or idle in the Dialer's Pool.
+	ConnsClosed int
+	// HandshakesAborted counts the inbound handshakes, or dials, cut
+	// short.
+	HandshakesAborted int
+	// BytesDropped counts the bytes received, and buffered in the closed
+	// conns, that were never read.
+	BytesDropped int64
+	// Duration is how long the shutdown took.
+	Duration time.Duration
+}
+
+// ListenerShutdown is implemented by listeners that can report what they
+// gave up when shut down.
+type ListenerShutdown interface {
+	// Shutdown is Drain, reporting what didn't make it to Accept before
+	// ctx was done.
+	Shutdown(ctx context.Context) (ShutdownReport, error)
+}
+
+func (l *listener) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	start := time.Now()
+	l.logger.Debugf("listener draining: %s %s", l.LocalPeer(), l.Multiaddr())
+	l.drainOnce.Do(func() {
+		close(l.draining)
+	})
+	l.closeTransport()
+
+	err := l.waitDrained(ctx)
+	report := ShutdownReport{HandshakesAborted: int(atomic.LoadInt64(&l.handshakes))}
+	if cerr := l.proc.Close(); err == nil {
+		err = cerr
+	}
+
+	// close whatever was left behind for Accept.
+	for c := range l.incoming {
+		if c.conn != nil {
+			report.ConnsClosed++
+			report.BytesDropped += int64(bufferedBytes(c.conn))
+			c.conn.Close()
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, err
+}
+
+// CancelAll cancels the dials in progress, and closes the idle conns of
+// the Pool, if any. It returns once the canceled dials did. The Dialer
+// can still be used.
+func (d *Dialer) CancelAll() ShutdownReport {
+	start := time.Now()
+	report := ShutdownReport{HandshakesAborted: d.dials.cancelAll()}
+	if d.Pool != nil {
+		for _, c := range d.Pool.closeIdle() {
+			report.ConnsClosed++
+			report.BytesDropped += int64(bufferedBytes(c))
+		}
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+// bufferedBytes returns how many bytes c read off the network, and holds
+// for its reader.
+func bufferedBytes(c interface{}) int {
+	switch c := c.(type) {
+	case *secureConn:
+		return len(c.frame) + len(c.unread) + bufferedBytes(c.insecure)
+	case *singleConn:
+		return bufferedBytes(c.maconn)
+	case *prefixConn:
+		return len(c.prefix) + bufferedBytes(c.Conn)
+	}
+	return 0
+}
+
+// dialRegistry keeps the cancel functions of the dials in progress. The
+// zero value is ready to use.
+type dialRegistry struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	next    uint64
+	cancels map[uint64]context.CancelFunc
+}
+
+// add registers the dial that cancel cancels, and returns the function
+// to call once it returned.
+func (r *dialRegistry) add(cancel context.CancelFunc) (done func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancels == nil {
+		r.cancels = make(map[uint64]context.CancelFunc)
+	}
+	id := r.next
+	r.next++
+	r.cancels[id] = cancel
+	return func() {
+		r.mu.Lock()
+		delete(r.cancels, id)
+		if r.cond != nil {
+			r.cond.Broadcast()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// cancelAll cancels the registered dials, waits for them to return, and
+// says how many there were.
+func (r *dialRegistry) cancelAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cond == nil {
+		r.cond = sync.NewCond(&r.mu)
+	}
+
+	canceled := make([]uint64, 0, len(r.cancels))
+	for id, cancel := range r.cancels {
+		cancel()
+		canceled = append(canceled, id)
+	}
+	for _, id := range canceled {
+		for r.cancels[id] != nil {
+			r.cond.Wait()
+		}
+	}
+	return len(canceled)
+}
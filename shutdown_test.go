@@ -0,0 +1,57 @@
+package conn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCancelAll(t *testing.T) {
+	var d Dialer
+
+	returned := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		defer close(returned)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := d.dials.add(cancel)
+		close(started)
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		done()
+	}()
+	<-started
+
+	report := d.CancelAll()
+	select {
+	case <-returned:
+	default:
+		t.Fatal("CancelAll returned before the dial did")
+	}
+	if report.HandshakesAborted != 1 || report.ConnsClosed != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Duration < 20*time.Millisecond {
+		t.Fatalf("duration %s too short", report.Duration)
+	}
+
+	if report := d.CancelAll(); report.HandshakesAborted != 0 {
+		t.Fatalf("expected nothing left to cancel, got %+v", report)
+	}
+}
+
+func TestBufferedBytes(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	c := &prefixConn{Conn: pipeConn{a}, prefix: []byte("/multistream")}
+	if n := bufferedBytes(c); n != 12 {
+		t.Fatalf("expected 12 buffered bytes, got %d", n)
+	}
+	if n := bufferedBytes(pipeConn{a}); n != 0 {
+		t.Fatalf("expected no buffered bytes, got %d", n)
+	}
+}
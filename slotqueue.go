@@ -0,0 +1,112 @@
+package conn
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// slotQueue runs functions at most size at once, the others waiting their
+// turn, first come, first served, and keeps queueing metrics. It is the
+// scheduling shared by HandshakePool and DialThrottle.
+type slotQueue struct {
+	once  sync.Once
+	slots chan struct{}
+
+	mu    sync.Mutex
+	stats slotStats
+}
+
+type slotStats struct {
+	size    int
+	running int
+	queued  int
+
+	completed uint64
+	abandoned uint64
+	overran   uint64
+
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+// init sizes q, on first use, with size, which resolves the configured
+// size and its default.
+func (q *slotQueue) init(size func() int) {
+	q.once.Do(func() {
+		n := size()
+		q.slots = make(chan struct{}, n)
+		q.stats.size = n
+	})
+}
+
+func (q *slotQueue) snapshot(size func() int) slotStats {
+	q.init(size)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
+
+// do runs f once a slot is free, or returns ctx.Err() if ctx is done
+// first, or ErrListenerClosed if closing is closed first. With a
+// positive hold, f gives its slot to the next in line after hold, done
+// or not.
+func (q *slotQueue) do(ctx context.Context, closing <-chan struct{}, size func() int, hold time.Duration, f func() error) error {
+	q.init(size)
+
+	start := time.Now()
+	q.mu.Lock()
+	q.stats.queued++
+	q.mu.Unlock()
+
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		q.abandon()
+		return ctx.Err()
+	case <-closing:
+		q.abandon()
+		return ErrListenerClosed
+	}
+
+	wait := time.Since(start)
+	q.mu.Lock()
+	q.stats.queued--
+	q.stats.running++
+	q.stats.totalWait += wait
+	if wait > q.stats.maxWait {
+		q.stats.maxWait = wait
+	}
+	q.mu.Unlock()
+
+	var once sync.Once
+	release := func(overran bool) {
+		once.Do(func() {
+			<-q.slots
+			q.mu.Lock()
+			q.stats.running--
+			if overran {
+				q.stats.overran++
+			}
+			q.mu.Unlock()
+		})
+	}
+	if hold > 0 {
+		t := time.AfterFunc(hold, func() { release(true) })
+		defer t.Stop()
+	}
+	defer func() {
+		release(false)
+		q.mu.Lock()
+		q.stats.completed++
+		q.mu.Unlock()
+	}()
+	return f()
+}
+
+func (q *slotQueue) abandon() {
+	q.mu.Lock()
+	q.stats.queued--
+	q.stats.abandoned++
+	q.mu.Unlock()
+}
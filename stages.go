@@ -0,0 +1,107 @@
+package conn
+
+import (
+	"fmt"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// Stage is a stage of connection setup.
+type Stage string
+
+const (
+	// StagePreamble is the exchange of the multistream header on inbound
+	// conns, right before security protocol selection.
+	StagePreamble Stage = "preamble"
+	// StageProtect is private network protection.
+	StageProtect Stage = "protect"
+	// StageSelect is security protocol selection with multistream.
+	StageSelect Stage = "select"
+	// StageSecure is the security handshake.
+	StageSecure Stage = "secure"
+)
+
+// ProtectTimeout, SelectTimeout and SecureTimeout bound the stages of
+// connection setup, on dials as well as accepts, within DialTimeout and
+// AcceptTimeout: a stalled peer then shows as such, rather than as a slow
+// handshake. A stage over its budget fails with a StageTimeoutError.
+// Zero leaves the stage to the overall timeout. Dials pick up their
+// values when they start, and listeners when they are created.
+var (
+	ProtectTimeout time.Duration
+	SelectTimeout  time.Duration
+	SecureTimeout  time.Duration
+)
+
+// StageTimeoutError is returned when a stage of connection setup takes
+// longer than its budget. It matches ErrHandshakeTimeout.
+type StageTimeoutError struct {
+	Stage   Stage
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *StageTimeoutError) Error() string {
+	return fmt.Sprintf("%s stage timed out after %s: %s", e.Stage, e.Timeout, e.Err)
+}
+
+func (e *StageTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StageTimeoutError) Is(target error) bool {
+	return target == ErrHandshakeTimeout
+}
+
+// stageTimeouts are the budgets of the stages, zero when unbounded.
+type stageTimeouts struct {
+	protect, sel, secure time.Duration
+}
+
+func currentStageTimeouts() stageTimeouts {
+	return stageTimeouts{protect: ProtectTimeout, sel: SelectTimeout, secure: SecureTimeout}
+}
+
+func (st stageTimeouts) of(s Stage) time.Duration {
+	switch s {
+	case StageProtect:
+		return st.protect
+	case StageSelect:
+		return st.sel
+	default:
+		return st.secure
+	}
+}
+
+// stageTimer enforces the budget of a stage with a deadline on the conn.
+type stageTimer struct {
+	stage    Stage
+	conn     transport.Conn
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// startStage starts stage s on conn, setting a deadline on it if s has a
+// budget.
+func (st stageTimeouts) start(s Stage, conn transport.Conn) *stageTimer {
+	t := &stageTimer{stage: s, conn: conn, timeout: st.of(s)}
+	if t.timeout > 0 {
+		t.deadline = time.Now().Add(t.timeout)
+		conn.SetDeadline(t.deadline)
+	}
+	return t
+}
+
+// end ends the stage, clearing the deadline, and turns err into a
+// StageTimeoutError if the stage ran out of time.
+func (t *stageTimer) end(err error) error {
+	if t.deadline.IsZero() {
+		return err
+	}
+	t.conn.SetDeadline(time.Time{})
+	if err != nil && !time.Now().Before(t.deadline) {
+		return &StageTimeoutError{Stage: t.stage, Timeout: t.timeout, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package conn
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStageTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := pipeConn{a}
+
+	stages := stageTimeouts{sel: 20 * time.Millisecond}
+	stage := stages.start(StageSelect, c)
+	_, err := c.Read(make([]byte, 1))
+	err = stage.end(err)
+
+	var ste *StageTimeoutError
+	if !errors.As(err, &ste) || ste.Stage != StageSelect {
+		t.Fatalf("expected a select stage timeout, got %v", err)
+	}
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatal("stage timeouts should match ErrHandshakeTimeout")
+	}
+
+	// the deadline is gone once the stage ends.
+	go b.Write([]byte{1})
+	if _, err := c.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// stages without a budget leave errors alone.
+	failed := errors.New("failed")
+	stage = stages.start(StageSecure, c)
+	if err := stage.end(failed); err != failed {
+		t.Fatalf("expected the error unchanged, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package conn
+
+import (
+	"sync"
+)
+
+type ListenerStandby interface {
+	// Standby puts the listener in standby, as the passive side of an
+	// active-passive pair: it keeps accepting and securing conns, but
+	// Accept holds them back until Promote is called. Held conns wait in
+	// the backlog, subject to AcceptOverflow. It must be called before
+	// any call to Accept.
+	Standby()
+
+	// Promote makes a standby listener active, releasing the conns it
+	// holds to Accept. It does nothing on an active listener.
+	Promote()
+}
+
+// standby holds Accept back while its gate is set. The zero value is
+// active.
+type standby struct {
+	mu   sync.Mutex
+	gate chan struct{} // closed on promotion
+}
+
+func (s *standby) set() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gate == nil {
+		s.gate = make(chan struct{})
+	}
+}
+
+func (s *standby) promote() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gate != nil {
+		close(s.gate)
+		s.gate = nil
+	}
+}
+
+// wait returns a channel closed once the listener is active, or nil if
+// it already is.
+func (s *standby) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gate
+}
+
+func (l *listener) Standby() {
+	l.standby.set()
+	log.Event(l.ctx, "listenerStandby", l)
+	l.history.add("standby", "", nil, nil)
+}
+
+func (l *listener) Promote() {
+	l.standby.promote()
+	log.Event(l.ctx, "listenerPromoted", l)
+	l.history.add("promoted", "", nil, nil)
+}
@@ -0,0 +1,58 @@
+package conn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestListenerStandby(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	l1.(ListenerStandby).Standby()
+
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := l1.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+
+	// the handshake completes, but the conn is held back.
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case <-accepted:
+		t.Fatal("standby listener accepted a conn")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	l1.(ListenerStandby).Promote()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("held conn not released on promotion")
+	}
+}
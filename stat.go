@@ -0,0 +1,91 @@
+package conn
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stat is a snapshot of the state and traffic of a conn.
+type Stat struct {
+	// Inbound is set on accepted conns, unset on dialed ones.
+	Inbound bool
+	// Opened is when the conn was established, handshake included.
+	Opened time.Time
+
+	// BytesSent and BytesRecv count the bytes written to and read from
+	// the conn, with the same accounting as BandwidthReporter.
+	BytesSent uint64
+	BytesRecv uint64
+	// MsgsSent and MsgsRecv count the messages of WriteMsg and ReadMsg.
+	MsgsSent uint64
+	MsgsRecv uint64
+
+	// LastSent and LastRecv are when bytes were last written and read,
+	// zero if none ever were.
+	LastSent time.Time
+	LastRecv time.Time
+}
+
+// StatInfo is implemented by the conns returned by Dial and Accept.
+type StatInfo interface {
+	// Stat returns a snapshot of the conn. It may be called at any time,
+	// concurrently with reads and writes.
+	Stat() Stat
+}
+
+func (c *singleConn) Stat() Stat {
+	st := c.stats.snapshot(&c.msgFramer)
+	st.Inbound = !c.accepted.IsZero()
+	st.Opened = c.established
+	return st
+}
+
+func (c *secureConn) Stat() Stat {
+	st := c.stats.snapshot(&c.msgFramer)
+	if single, ok := c.insecure.(*singleConn); ok {
+		st.Inbound = !single.accepted.IsZero()
+	}
+	st.Opened = c.established
+	return st
+}
+
+// connStats counts the traffic of a conn, on its read and write paths.
+// Its fields are accessed atomically.
+type connStats struct {
+	bytesSent uint64
+	bytesRecv uint64
+	lastSent  int64 // UnixNano
+	lastRecv  int64
+}
+
+func (s *connStats) sent(n int) {
+	if n > 0 {
+		atomic.AddUint64(&s.bytesSent, uint64(n))
+		atomic.StoreInt64(&s.lastSent, time.Now().UnixNano())
+	}
+}
+
+func (s *connStats) recv(n int) {
+	if n > 0 {
+		atomic.AddUint64(&s.bytesRecv, uint64(n))
+		atomic.StoreInt64(&s.lastRecv, time.Now().UnixNano())
+	}
+}
+
+func (s *connStats) snapshot(f *msgFramer) Stat {
+	return Stat{
+		BytesSent: atomic.LoadUint64(&s.bytesSent),
+		BytesRecv: atomic.LoadUint64(&s.bytesRecv),
+		MsgsSent:  atomic.LoadUint64(&f.msgsSent),
+		MsgsRecv:  atomic.LoadUint64(&f.msgsRecv),
+		LastSent:  unixNano(atomic.LoadInt64(&s.lastSent)),
+		LastRecv:  unixNano(atomic.LoadInt64(&s.lastRecv)),
+	}
+}
+
+func unixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
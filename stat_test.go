@@ -0,0 +1,56 @@
+package conn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnStat(t *testing.T) {
+	ctx := context.Background()
+	a, b := net.Pipe()
+	c1 := newSingleConn(ctx, "a", "b", pipeConn{a})
+	c2 := newSingleConn(ctx, "b", "a", pipeConn{b})
+	c2.accepted = time.Now()
+	defer c1.Close()
+	defer c2.Close()
+
+	if st := c1.Stat(); st.Inbound || !st.LastSent.IsZero() || !st.LastRecv.IsZero() {
+		t.Fatalf("unexpected stat of a new conn: %+v", st)
+	}
+	if !c2.Stat().Inbound {
+		t.Fatal("accepted conns should be inbound")
+	}
+
+	before := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c1.WriteMsg([]byte("hello"))
+		c1.Write([]byte("world"))
+	}()
+	msg, err := c2.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.ReleaseMsg(msg)
+	if _, err := c2.Read(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	sent, recv := c1.Stat(), c2.Stat()
+	if sent.BytesSent != 4+5+5 || sent.MsgsSent != 1 || sent.BytesRecv != 0 {
+		t.Fatalf("unexpected stat of the writing end: %+v", sent)
+	}
+	if recv.BytesRecv != 4+5+5 || recv.MsgsRecv != 1 || recv.BytesSent != 0 {
+		t.Fatalf("unexpected stat of the reading end: %+v", recv)
+	}
+	if sent.LastSent.Before(before) || recv.LastRecv.Before(before) || !recv.LastSent.IsZero() {
+		t.Fatal("unexpected activity times")
+	}
+	if sent.Opened != c1.established {
+		t.Fatal("unexpected open time ", sent.Opened)
+	}
+}
@@ -0,0 +1,150 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrNoTransportForAddr is matched by errors from dials to addresses that
+// none of the Dialer's sub-dialers can dial.
+var ErrNoTransportForAddr = errors.New("no transport for address")
+
+// CanDialer is implemented by sub-dialers that can tell whether they dial
+// an address more precisely than their Matches does. Dialers ask CanDial
+// instead of Matches then, so that, where a TCP transport matches any
+// address over TCP, WebSocket addresses still go to the WebSocket one.
+type CanDialer interface {
+	CanDial(raddr ma.Multiaddr) bool
+}
+
+// canDial reports whether pd, a sub-dialer, can dial raddr.
+func canDial(pd transport.Dialer, raddr ma.Multiaddr) bool {
+	if cd, ok := unwrapSubDialer(pd).(CanDialer); ok {
+		return cd.CanDial(raddr)
+	}
+	return pd.Matches(raddr)
+}
+
+// SubDialerOption configures a sub-dialer added with AddDialer.
+type SubDialerOption func(*subDialer)
+
+// WithDialTimeout makes dials through the sub-dialer time out after
+// timeout, instead of the Dialer's Timeout, as transports connect at
+// very different paces: a relay may need a minute where direct TCP takes
+// seconds. It covers the whole dial, handshake included, like Timeout.
+// When dials fall back from a sub-dialer to the next, each gets its own
+// timeout, the dial as a whole taking up to their sum. DialWithTimeout
+// still overrides it. NoTimeout disables it.
+func WithDialTimeout(timeout time.Duration) SubDialerOption {
+	return func(sd *subDialer) {
+		sd.timeout = timeout
+	}
+}
+
+// WithPreference makes dials try the sub-dialer before the sub-dialers of
+// lower preference that can dial the same address, and after the ones of
+// higher preference. Sub-dialers have a preference of zero by default.
+func WithPreference(preference int) SubDialerOption {
+	return func(sd *subDialer) {
+		sd.preference = preference
+	}
+}
+
+// subDialer is a sub-dialer added with options, as it appears in
+// Dialer.Dialers.
+type subDialer struct {
+	transport.Dialer
+	timeout    time.Duration
+	preference int
+}
+
+// preferenceOf returns the preference of pd, a sub-dialer.
+func preferenceOf(pd transport.Dialer) int {
+	if sd, ok := pd.(*subDialer); ok {
+		return sd.preference
+	}
+	return 0
+}
+
+// TransportDialError is the error of dials that several sub-dialers could
+// make, when they all failed. It matches whatever one of Errs matches.
+type TransportDialError struct {
+	Addr ma.Multiaddr
+	Errs []error // of each sub-dialer, in the order they were tried
+}
+
+func (e *TransportDialError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("all %d dialers for %s failed: %s", len(e.Errs), e.Addr, strings.Join(msgs, "; "))
+}
+
+func (e *TransportDialError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func newSubDialer(pd transport.Dialer, opts []SubDialerOption) transport.Dialer {
+	if len(opts) == 0 {
+		return pd
+	}
+	sd := &subDialer{Dialer: pd}
+	for _, opt := range opts {
+		opt(sd)
+	}
+	return sd
+}
+
+// unwrapSubDialer returns the sub-dialer given to AddDialer for pd, as
+// it appears in Dialer.Dialers.
+func unwrapSubDialer(pd transport.Dialer) transport.Dialer {
+	if sd, ok := pd.(*subDialer); ok {
+		return sd.Dialer
+	}
+	return pd
+}
+
+// subDialersTimeout returns the timeout of dials to raddr: the sum of
+// the timeouts of the sub-dialers tried in turn, with dflt for those
+// without one, or NoTimeout if any has none. It is zero if none has a
+// timeout of its own, the Dialer's then covering the dial.
+func (d *Dialer) subDialersTimeout(raddr ma.Multiaddr, dflt time.Duration) time.Duration {
+	var own bool
+	var sum time.Duration
+	for _, pd := range d.subDialersForAddr(raddr) {
+		t := dflt
+		if sd, ok := pd.(*subDialer); ok && sd.timeout != 0 {
+			t = sd.timeout
+			own = true
+		}
+		if t == NoTimeout {
+			sum = NoTimeout
+		} else if sum != NoTimeout {
+			sum += t
+		}
+	}
+	if !own {
+		return 0
+	}
+	return sum
+}
+
+// subDialerTimeout returns the timeout of pd, a sub-dialer, or zero if
+// it has none.
+func subDialerTimeout(pd transport.Dialer) time.Duration {
+	if sd, ok := pd.(*subDialer); ok {
+		return sd.timeout
+	}
+	return 0
+}
@@ -0,0 +1,175 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestWithDialTimeout(t *testing.T) {
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	bd := blockingDialer{started: make(chan struct{}, 1)}
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.Timeout = time.Minute
+	d.AddDialer(bd, WithDialTimeout(20*time.Millisecond))
+	if d.subDialersTimeout(p1.Addr, time.Minute) != 20*time.Millisecond {
+		t.Fatal("the sub-dialer's timeout should apply")
+	}
+
+	start := time.Now()
+	_, err := d.Dial(context.Background(), p1.Addr, p1.ID)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the dial to time out, got ", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("the dial took the Dialer's timeout")
+	}
+
+	// DialWithTimeout still overrides it.
+	start = time.Now()
+	_, err = d.DialWithTimeout(context.Background(), p1.Addr, p1.ID, 50*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the dial to time out, got ", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("the dial didn't take the timeout of the call")
+	}
+}
+
+// Sub-dialers tried in turn each get their own timeout.
+func TestWithDialTimeoutFallback(t *testing.T) {
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.Timeout = time.Minute
+	fast := blockingDialer{started: make(chan struct{}, 1)}
+	slow := blockingDialer{started: make(chan struct{}, 1)}
+	d.AddDialer(fast, WithDialTimeout(20*time.Millisecond), WithPreference(1))
+	d.AddDialer(slow, WithDialTimeout(100*time.Millisecond))
+	if timeout := d.subDialersTimeout(p1.Addr, d.Timeout); timeout != 120*time.Millisecond {
+		t.Fatal("the dial should take the timeouts of both sub-dialers, got ", timeout)
+	}
+
+	start := time.Now()
+	_, err := d.Dial(context.Background(), p1.Addr, p1.ID)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the dial to time out, got ", err)
+	}
+	select {
+	case <-slow.started:
+	default:
+		t.Fatal("the dial didn't fall back to the second sub-dialer")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatal("the second sub-dialer didn't get its own timeout, the dial took ", elapsed)
+	}
+}
+
+func TestRemoveDialer(t *testing.T) {
+	d := NewDialer("a", nil, nil)
+	pd1 := &failingDialer{err: errors.New("1")}
+	pd2 := &failingDialer{err: errors.New("2")}
+	d.AddDialer(pd1, WithDialTimeout(time.Second))
+	d.AddDialer(pd2)
+
+	if pds := d.ListDialers(); len(pds) != 2 || pds[0] != pd1 || pds[1] != pd2 {
+		t.Fatalf("expected the dialers as added, got %v", pds)
+	}
+	if !d.RemoveDialer(pd1) || d.RemoveDialer(pd1) {
+		t.Fatal("the dialer should be removed once")
+	}
+	if d.subDialerForAddr(nil) != pd2 {
+		t.Fatal("the remaining dialer should be selected")
+	}
+
+	// dials and changes can go at the same time.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			pd := &failingDialer{}
+			d.AddDialer(pd)
+			d.RemoveDialer(pd)
+		}()
+		go func() {
+			defer wg.Done()
+			d.subDialerForAddr(nil)
+			d.ListDialers()
+		}()
+	}
+	wg.Wait()
+	if pds := d.ListDialers(); len(pds) != 1 || pds[0] != pd2 {
+		t.Fatalf("expected only the second dialer, got %v", pds)
+	}
+}
+
+// pickyDialer matches everything, but can only dial what can says.
+type pickyDialer struct {
+	failingDialer
+	can bool
+}
+
+func (d *pickyDialer) CanDial(ma.Multiaddr) bool {
+	return d.can
+}
+
+func TestCanDialer(t *testing.T) {
+	d := NewDialer("a", nil, nil)
+	loose := &pickyDialer{}
+	exact := &pickyDialer{can: true}
+	d.AddDialer(loose, WithDialTimeout(time.Second))
+	d.AddDialer(exact)
+	if d.subDialerForAddr(nil) != exact {
+		t.Fatal("CanDial should take precedence over Matches")
+	}
+
+	d.RemoveDialer(exact)
+	_, err := d.Dial(context.Background(), ma.StringCast("/ip4/1.2.3.4/tcp/4001/ws"), "b")
+	if !errors.Is(err, ErrNoTransportForAddr) {
+		t.Fatal("expected ErrNoTransportForAddr, got ", err)
+	}
+}
+
+func TestSubDialerFallback(t *testing.T) {
+	ctx := context.Background()
+	raddr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	errLow, errHigh := errors.New("low"), errors.New("high")
+
+	d := NewDialer("a", nil, nil)
+	low := &failingDialer{err: errLow}
+	high := &failingDialer{err: errHigh}
+	d.AddDialer(low)
+	d.AddDialer(high, WithPreference(1))
+	if sds := d.subDialersForAddr(raddr); len(sds) != 2 || unwrapSubDialer(sds[0]) != high {
+		t.Fatal("the preferred dialer should be tried first")
+	}
+
+	_, err := d.rawConnDialAddr(ctx, raddr, "b", 0)
+	var terr *TransportDialError
+	if !errors.As(err, &terr) || len(terr.Errs) != 2 || terr.Errs[0] != errHigh || terr.Errs[1] != errLow {
+		t.Fatal("expected the errors of both dialers, by preference, got ", err)
+	}
+	if !errors.Is(err, errLow) {
+		t.Fatal("the error should match the ones of the dialers")
+	}
+
+	// the first dialer to connect wins.
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	d.AddDialer(connDialer{pipeConn{a}})
+	c, err := d.rawConnDialAddr(ctx, raddr, "b", 0)
+	if err != nil || c == nil {
+		t.Fatal("expected the last dialer to connect, got ", err)
+	}
+}
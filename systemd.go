@@ -0,0 +1,122 @@
+package conn
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// sdListenFdsStart is the first file descriptor passed by systemd.
+const sdListenFdsStart = 3
+
+// SystemdListeners returns the listening sockets passed to this process
+// by systemd socket activation (see sd_listen_fds(3)), as transport
+// listeners ready to be given to WrapTransportListener. Accepted conns
+// report t as their Transport. It returns no listeners if the process
+// was not socket activated.
+//
+// The LISTEN_* environment variables are cleared, so that child
+// processes don't try to use the sockets too.
+func SystemdListeners(t transport.Transport) ([]transport.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	var names []string
+	if fdnames := os.Getenv("LISTEN_FDNAMES"); fdnames != "" {
+		names = strings.Split(fdnames, ":")
+	}
+	return listenersFromFds(sdListenFdsStart, n, names, t)
+}
+
+// listenersFromFds builds transport listeners out of n consecutive
+// listening sockets, starting at file descriptor start.
+func listenersFromFds(start, n int, names []string, t transport.Transport) ([]transport.Listener, error) {
+	var ls []transport.Listener
+	for i := 0; i < n; i++ {
+		fd := start + i
+		name := fmt.Sprintf("LISTEN_FD_%d", fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		l, err := listenerFromFd(uintptr(fd), name, t)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, fmt.Errorf("inherited socket %s (fd %d): %s", name, fd, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+func listenerFromFd(fd uintptr, name string, t transport.Transport) (transport.Listener, error) {
+	f := os.NewFile(fd, name)
+	defer f.Close()
+
+	nl, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := nl.(*net.UnixListener); ok {
+		addr := unixAddr(nl.Addr(), nil)
+		if addr == nil {
+			nl.Close()
+			return nil, fmt.Errorf("unnamed unix socket")
+		}
+		log.Debugf("inherited listener %s on %s", name, addr)
+		return &unixListener{Listener: nl, addr: addr, transport: t}, nil
+	}
+
+	// derives the multiaddr from the bound address.
+	ml, err := manet.WrapNetListener(nl)
+	if err != nil {
+		nl.Close()
+		return nil, err
+	}
+
+	log.Debugf("inherited listener %s on %s", name, ml.Multiaddr())
+	return &fdListener{Listener: ml, transport: t}, nil
+}
+
+// fdListener is a transport.Listener over an inherited socket.
+type fdListener struct {
+	manet.Listener
+	transport transport.Transport
+}
+
+func (l *fdListener) Accept() (transport.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &fdConn{Conn: c, transport: l.transport}, nil
+}
+
+type fdConn struct {
+	manet.Conn
+	transport transport.Transport
+}
+
+func (c *fdConn) Transport() transport.Transport {
+	return c.transport
+}
@@ -0,0 +1,82 @@
+package conn
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	tcpt "github.com/libp2p/go-tcp-transport"
+	tu "github.com/libp2p/go-testutil"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func TestSystemdListenersNotActivated(t *testing.T) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+
+	ls, err := SystemdListeners(tcpt.NewTCPTransport())
+	if err != nil || len(ls) != 0 {
+		t.Fatal("expected no listeners for another process, got: ", ls, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Fatal("LISTEN_* should be cleared from the environment")
+	}
+}
+
+func TestListenersFromFds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := nl.(*net.TCPListener).File()
+	nl.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// listenersFromFds takes ownership of the descriptor.
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpt := tcpt.NewTCPTransport()
+	ls, err := listenersFromFds(fd, 1, []string{"libp2p"}, tpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 {
+		t.Fatal("expected one listener, got: ", len(ls))
+	}
+
+	expected, err := manet.FromNetAddr(nl.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ls[0].Multiaddr().Equal(expected) {
+		t.Fatalf("expected multiaddr %s, got %s", expected, ls[0].Multiaddr())
+	}
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	l1, err := WrapTransportListener(ctx, ls[0], p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
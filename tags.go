@@ -0,0 +1,60 @@
+package conn
+
+import (
+	"sync"
+)
+
+// Tagger is implemented by the conns returned by Dial and Accept, for the
+// layers above to attach their own data to conns, such as scores or where
+// they came from, without keeping a map of conns on the side.
+type Tagger interface {
+	// SetTag sets the value of the tag key, or removes it if value is
+	// nil.
+	SetTag(key string, value interface{})
+
+	// GetTag returns the value of the tag key, if set.
+	GetTag(key string) (interface{}, bool)
+}
+
+// tags are the tags of a conn. The zero value is ready to use.
+type tags struct {
+	mu sync.Mutex
+	m  map[string]interface{}
+}
+
+func (t *tags) SetTag(key string, value interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if value == nil {
+		delete(t.m, key)
+		return
+	}
+	if t.m == nil {
+		t.m = make(map[string]interface{})
+	}
+	t.m[key] = value
+}
+
+func (t *tags) GetTag(key string) (interface{}, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.m[key]
+	return v, ok
+}
+
+// SetTag sets the tag on the wrapped conn: a secure conn and the conn it
+// wraps share their tags.
+func (c *secureConn) SetTag(key string, value interface{}) {
+	if t, ok := c.insecure.(Tagger); ok {
+		t.SetTag(key, value)
+	}
+}
+
+func (c *secureConn) GetTag(key string) (interface{}, bool) {
+	if t, ok := c.insecure.(Tagger); ok {
+		return t.GetTag(key)
+	}
+	return nil, false
+}
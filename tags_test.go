@@ -0,0 +1,39 @@
+package conn
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	var tg tags
+	if _, ok := tg.GetTag("score"); ok {
+		t.Fatal("unset tag found")
+	}
+	tg.SetTag("score", 10)
+	if v, ok := tg.GetTag("score"); !ok || v != 10 {
+		t.Fatalf("got %v, %t", v, ok)
+	}
+	tg.SetTag("score", nil)
+	if _, ok := tg.GetTag("score"); ok {
+		t.Fatal("removed tag found")
+	}
+}
+
+func TestConnTags(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, secure := range []bool{false, true} {
+		a, b, _, _ := setupConn(t, ctx, secure)
+		a.(Tagger).SetTag("relay", "QmRelay")
+		if v, ok := a.(Tagger).GetTag("relay"); !ok || v != "QmRelay" {
+			t.Fatalf("secure=%t: got %v, %t", secure, v, ok)
+		}
+		if _, ok := b.(Tagger).GetTag("relay"); ok {
+			t.Fatalf("secure=%t: tag set on the other end", secure)
+		}
+		a.Close()
+		b.Close()
+	}
+}
@@ -0,0 +1,115 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// TCPOptions are socket options for TCP conns. They only apply to
+// transports whose conns give access to their socket: *net.TCPConn or
+// the like, or a conn with a NetConn method returning one. Zero fields
+// keep the transport's defaults.
+type TCPOptions struct {
+	// NoDelay, if set, turns Nagle's algorithm off when true, on when
+	// false. Go turns it off by default.
+	NoDelay *bool
+
+	// KeepAlive, if positive, turns keepalives on, probing the remote
+	// after that long idle. Negative turns them off.
+	KeepAlive time.Duration
+
+	// Linger, if set, is as with net.TCPConn.SetLinger: how many seconds
+	// Close keeps sending unsent data for, the OS default if negative.
+	// Zero discards it, resetting the conn.
+	Linger *int
+
+	// UserTimeout, if set, is how long sent data may go unacknowledged
+	// before the kernel gives up on the conn, TCP_USER_TIMEOUT. It is
+	// only supported on Linux.
+	UserTimeout time.Duration
+}
+
+// errUserTimeoutUnsupported is returned when setting TCP_USER_TIMEOUT on
+// platforms without it.
+var errUserTimeoutUnsupported = errors.New("TCP user timeouts are not supported on this platform")
+
+type tcpSocket interface {
+	SetNoDelay(noDelay bool) error
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+	SetLinger(sec int) error
+	SyscallConn() (syscall.RawConn, error)
+}
+
+// setTCPOptions sets the options o on the TCP socket of c, if c gives
+// access to one. It reports whether it did.
+func setTCPOptions(c net.Conn, o *TCPOptions) (bool, error) {
+	if o == nil {
+		return false, nil
+	}
+	s, ok := findSocket(c, func(c net.Conn) bool {
+		_, ok := c.(tcpSocket)
+		return ok
+	}).(tcpSocket)
+	if !ok {
+		return false, nil
+	}
+
+	if o.NoDelay != nil {
+		if err := s.SetNoDelay(*o.NoDelay); err != nil {
+			return false, fmt.Errorf("setting TCP_NODELAY: %s", err)
+		}
+	}
+	if o.KeepAlive != 0 {
+		if err := s.SetKeepAlive(o.KeepAlive > 0); err != nil {
+			return false, fmt.Errorf("setting SO_KEEPALIVE: %s", err)
+		}
+		if o.KeepAlive > 0 {
+			if err := s.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+				return false, fmt.Errorf("setting the keepalive period: %s", err)
+			}
+		}
+	}
+	if o.Linger != nil {
+		if err := s.SetLinger(*o.Linger); err != nil {
+			return false, fmt.Errorf("setting SO_LINGER: %s", err)
+		}
+	}
+	if o.UserTimeout > 0 {
+		rc, err := s.SyscallConn()
+		if err != nil {
+			return false, err
+		}
+		if err := setUserTimeout(rc, o.UserTimeout); err != nil {
+			return false, fmt.Errorf("setting TCP_USER_TIMEOUT: %s", err)
+		}
+	}
+	return true, nil
+}
+
+// tuneSocket applies the buffer sizes b and TCP options o, either of
+// which may be nil, to the socket of c. Failures only get logged: the
+// conn works without.
+func tuneSocket(c net.Conn, b *BufferSizes, o *TCPOptions, lg Logger) {
+	if _, err := setSocketBuffers(c, b); err != nil {
+		lg.Debugf("setting socket buffers: %s", err)
+	}
+	if _, err := setTCPOptions(c, o); err != nil {
+		lg.Debugf("setting TCP options: %s", err)
+	}
+}
+
+// ListenerTCPOptions is implemented by listeners that can set TCP
+// options on the conns they accept.
+type ListenerTCPOptions interface {
+	// SetTCPOptions sets the socket options of accepted conns, like
+	// Dialer.TCPOptions. It must be called before any call to Accept.
+	SetTCPOptions(o *TCPOptions)
+}
+
+func (l *listener) SetTCPOptions(o *TCPOptions) {
+	l.tcpOpts = o
+}
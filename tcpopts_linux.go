@@ -0,0 +1,20 @@
+package conn
+
+import (
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT, which syscall lacks.
+const tcpUserTimeout = 0x12
+
+func setUserTimeout(rc syscall.RawConn, d time.Duration) error {
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, int(d/time.Millisecond))
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
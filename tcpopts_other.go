@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package conn
+
+import (
+	"syscall"
+	"time"
+)
+
+func setUserTimeout(rc syscall.RawConn, d time.Duration) error {
+	return errUserTimeoutUnsupported
+}
@@ -0,0 +1,44 @@
+package conn
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSetTCPOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	noDelay, linger := false, 5
+	o := &TCPOptions{NoDelay: &noDelay, KeepAlive: time.Minute, Linger: &linger}
+	if runtime.GOOS == "linux" {
+		o.UserTimeout = 30 * time.Second
+	}
+	for _, nc := range []net.Conn{c, hiddenConn{c}} {
+		if set, err := setTCPOptions(nc, o); !set || err != nil {
+			t.Fatalf("%T: options not set: %v", nc, err)
+		}
+	}
+
+	a, p := net.Pipe()
+	defer a.Close()
+	defer p.Close()
+	if set, err := setTCPOptions(a, o); set || err != nil {
+		t.Fatalf("pipe: set %t, %v", set, err)
+	}
+}
@@ -0,0 +1,86 @@
+// Package conntesting provides in-memory transports and connections, and
+// leak checks, for testing code built on go-libp2p-conn without binding
+// real ports. Import it under a name that doesn't shadow the standard
+// testing package:
+//
+//	import conntesting "github.com/libp2p/go-libp2p-conn/testing"
+package conntesting
+
+import (
+	"context"
+	"testing"
+
+	conn "github.com/libp2p/go-libp2p-conn"
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	tu "github.com/libp2p/go-testutil"
+)
+
+// ConnPair returns both ends of a connection between two new random
+// peers, set up over a Transport by a conn.Dialer and a conn listener,
+// like a real one would be. a is the dialer's end, and b the listener's.
+// If secure is true, the connection is secured with secio.
+func ConnPair(ctx context.Context, secure bool) (a, b iconn.Conn, err error) {
+	p1, err := tu.RandPeerNetParams()
+	if err != nil {
+		return nil, nil, err
+	}
+	p2, err := tu.RandPeerNetParams()
+	if err != nil {
+		return nil, nil, err
+	}
+	key1, key2 := p1.PrivKey, p2.PrivKey
+	if !secure {
+		key1, key2 = nil, nil
+	}
+
+	tpt := NewTransport()
+	tl, err := tpt.Listen(p2.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := conn.WrapTransportListener(ctx, tl, p2.ID, key2)
+	if err != nil {
+		tl.Close()
+		return nil, nil, err
+	}
+	defer l.Close()
+
+	td, err := tpt.Dialer(p1.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := conn.NewDialer(p1.ID, key1, nil)
+	d.AddDialer(td)
+
+	dialed := make(chan error, 1)
+	go func() {
+		var err error
+		a, err = d.Dial(ctx, p2.Addr, p2.ID)
+		dialed <- err
+	}()
+
+	accepted, err := l.Accept()
+	if err != nil {
+		<-dialed
+		if a != nil {
+			a.Close()
+		}
+		return nil, nil, err
+	}
+	b = accepted.(iconn.Conn)
+	if err := <-dialed; err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// ConnPairOrFatal is ConnPair, failing t on error.
+func ConnPairOrFatal(t testing.TB, ctx context.Context, secure bool) (a, b iconn.Conn) {
+	t.Helper()
+	a, b, err := ConnPair(ctx, secure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
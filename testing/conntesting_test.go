@@ -0,0 +1,60 @@
+package conntesting
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe(tu.RandLocalTCPAddress(), tu.RandLocalTCPAddress(), nil)
+	defer a.Close()
+	defer b.Close()
+
+	// writes don't wait for reads.
+	if _, err := a.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	a.(interface{ CloseWrite() error }).CloseWrite()
+	msg, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, []byte("hello")) {
+		t.Fatalf("read %q", msg)
+	}
+
+	a.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := a.Read(make([]byte, 1)); err == nil || !err.(net.Error).Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestConnPair(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, secure := range []bool{false, true} {
+		a, b := ConnPairOrFatal(t, ctx, secure)
+
+		go a.Write([]byte("hello"))
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(b, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "hello" {
+			t.Fatalf("read %q", buf)
+		}
+		if secure && b.RemotePeer() != a.LocalPeer() {
+			t.Fatal("listener side doesn't know the dialer")
+		}
+		a.Close()
+		b.Close()
+	}
+}
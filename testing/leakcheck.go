@@ -0,0 +1,86 @@
+package conntesting
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	conn "github.com/libp2p/go-libp2p-conn"
+)
+
+// LeakCheckTimeout is how long the function returned by AssertNoConnLeaks
+// waits for connections and goroutines to wind down before failing.
+var LeakCheckTimeout = 2 * time.Second
+
+// pkgPrefix matches stack frames belonging to the conn package, whatever
+// path it was imported under (gx rewrites it).
+var pkgPrefix = reflect.TypeOf(conn.Dialer{}).PkgPath() + "."
+
+// defaultLeakAllowances are goroutines that are expected to outlive a test.
+var defaultLeakAllowances = []string{"go-log."}
+
+// AssertNoConnLeaks snapshots the connections and goroutines owned by the
+// conn package, and returns a function that fails t if more of them are
+// alive once the test body is done. Goroutines whose stack contains any
+// of the allowed substrings are not counted. It is meant to be deferred:
+//
+//	defer conntesting.AssertNoConnLeaks(t)()
+func AssertNoConnLeaks(t testing.TB, allowed ...string) func() {
+	allowed = append(append([]string{}, defaultLeakAllowances...), allowed...)
+	conns := conn.OpenConns()
+	goros := len(ownedGoroutines(allowed))
+
+	return func() {
+		t.Helper()
+
+		deadline := time.Now().Add(LeakCheckTimeout)
+		for {
+			leakedConns := conn.OpenConns() - conns
+			stacks := ownedGoroutines(allowed)
+			leakedGoros := len(stacks) - goros
+			if leakedConns <= 0 && leakedGoros <= 0 {
+				return
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("leaked %d connections and %d goroutines:\n\n%s",
+					leakedConns, leakedGoros, strings.Join(stacks, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// ownedGoroutines returns the stacks of all goroutines running code from
+// the conn package, except test goroutines and the allowed ones.
+func ownedGoroutines(allowed []string) []string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	var owned []string
+next:
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		stack := string(g)
+		if !strings.Contains(stack, pkgPrefix) || strings.Contains(stack, "testing.tRunner") {
+			continue
+		}
+		for _, a := range allowed {
+			if strings.Contains(stack, a) {
+				continue next
+			}
+		}
+		owned = append(owned, stack)
+	}
+	return owned
+}
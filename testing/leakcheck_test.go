@@ -0,0 +1,59 @@
+package conntesting
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fatalRecorder struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fatalRecorder) Helper() {}
+
+func (f *fatalRecorder) Fatalf(format string, args ...interface{}) {
+	f.failed = true
+}
+
+func TestAssertNoConnLeaks(t *testing.T) {
+	check := AssertNoConnLeaks(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c1, c2 := ConnPairOrFatal(t, ctx, true)
+	if _, err := c1.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Read(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	c1.Close()
+	c2.Close()
+	cancel()
+
+	check()
+}
+
+func TestAssertNoConnLeaksDetectsLeak(t *testing.T) {
+	defer func(old time.Duration) {
+		LeakCheckTimeout = old
+	}(LeakCheckTimeout)
+	LeakCheckTimeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rec := &fatalRecorder{TB: t}
+	check := AssertNoConnLeaks(rec)
+
+	c1, c2 := ConnPairOrFatal(t, ctx, false)
+	check()
+	if !rec.failed {
+		t.Error("expected open connections to be reported as leaks")
+	}
+
+	c1.Close()
+	c2.Close()
+}
@@ -0,0 +1,221 @@
+package conntesting
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrClosed is returned when using a closed pipe conn or listener.
+var ErrClosed = errors.New("pipe closed")
+
+// errTimeout is returned when a deadline passes.
+var errTimeout net.Error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Pipe returns the two ends of an in-memory, buffered, full duplex
+// connection between laddr and raddr. Unlike net.Pipe, writes don't wait
+// for the other end to read, just like with a socket. Both ends support
+// deadlines and half-closing, and report tpt as their transport.
+func Pipe(laddr, raddr ma.Multiaddr, tpt transport.Transport) (transport.Conn, transport.Conn) {
+	ab, ba := newPipeBuffer(), newPipeBuffer()
+	a := &pipeConn{in: ba, out: ab, laddr: laddr, raddr: raddr, tpt: tpt}
+	b := &pipeConn{in: ab, out: ba, laddr: raddr, raddr: laddr, tpt: tpt}
+	return a, b
+}
+
+// pipeBuffer is one direction of a pipe.
+type pipeBuffer struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	eof     bool          // the writer is done
+	closed  bool          // the reader is done
+	changed chan struct{} // closed and replaced on every change
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{changed: make(chan struct{})}
+}
+
+// notify wakes up readers. mu must be held.
+func (p *pipeBuffer) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *pipeBuffer) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.eof {
+		return 0, ErrClosed
+	}
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	p.buf.Write(b)
+	p.notify()
+	return len(b), nil
+}
+
+func (p *pipeBuffer) read(b []byte, deadline func() time.Time) (int, error) {
+	for {
+		p.mu.Lock()
+		switch {
+		case p.closed:
+			p.mu.Unlock()
+			return 0, ErrClosed
+		case p.buf.Len() > 0:
+			n, _ := p.buf.Read(b)
+			p.mu.Unlock()
+			return n, nil
+		case p.eof:
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		if dl := deadline(); !dl.IsZero() {
+			d := time.Until(dl)
+			if d <= 0 {
+				return 0, errTimeout
+			}
+			timer := time.NewTimer(d)
+			select {
+			case <-changed:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+		<-changed
+	}
+}
+
+// closeWrite makes the reader see EOF once it read everything.
+func (p *pipeBuffer) closeWrite() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.eof {
+		p.eof = true
+		p.notify()
+	}
+}
+
+// closeRead fails reads and writes from now on.
+func (p *pipeBuffer) closeRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.buf.Reset()
+		p.notify()
+	}
+}
+
+// kick wakes up readers, so that they notice a new deadline.
+func (p *pipeBuffer) kick() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.notify()
+}
+
+type pipeConn struct {
+	in, out     *pipeBuffer
+	laddr       ma.Multiaddr
+	raddr       ma.Multiaddr
+	tpt         transport.Transport
+	closeOnce   sync.Once
+	deadlineMu  sync.Mutex
+	readDL      time.Time
+	writeDL     time.Time
+	writeClosed bool
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	return c.in.read(b, func() time.Time {
+		c.deadlineMu.Lock()
+		defer c.deadlineMu.Unlock()
+		return c.readDL
+	})
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	c.deadlineMu.Lock()
+	dl := c.writeDL
+	c.deadlineMu.Unlock()
+	if !dl.IsZero() && !time.Now().Before(dl) {
+		return 0, errTimeout
+	}
+	return c.out.write(b)
+}
+
+// Close closes both directions: the other end reads EOF, and can't
+// write anymore.
+func (c *pipeConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.out.closeWrite()
+		c.in.closeRead()
+	})
+	return nil
+}
+
+// CloseWrite makes the other end read EOF.
+func (c *pipeConn) CloseWrite() error {
+	c.out.closeWrite()
+	return nil
+}
+
+// CloseRead makes the other end's writes fail.
+func (c *pipeConn) CloseRead() error {
+	c.in.closeRead()
+	return nil
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDL = t
+	c.deadlineMu.Unlock()
+	c.in.kick()
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.writeDL = t
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr           { return pipeAddr{c.laddr} }
+func (c *pipeConn) RemoteAddr() net.Addr          { return pipeAddr{c.raddr} }
+func (c *pipeConn) LocalMultiaddr() ma.Multiaddr  { return c.laddr }
+func (c *pipeConn) RemoteMultiaddr() ma.Multiaddr { return c.raddr }
+func (c *pipeConn) Transport() transport.Transport {
+	return c.tpt
+}
+
+// pipeAddr is the net.Addr of a pipe end.
+type pipeAddr struct {
+	ma.Multiaddr
+}
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return a.Multiaddr.String() }
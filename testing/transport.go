@@ -0,0 +1,124 @@
+package conntesting
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Transport is an in-memory transport.Transport: its dialers connect to
+// its listeners with pipes, whatever the addresses, so tests don't bind
+// real ports. Listening on an address already listened on fails.
+type Transport struct {
+	mu        sync.Mutex
+	listeners map[string]*listener
+}
+
+// NewTransport returns a new, empty in-memory transport.
+func NewTransport() *Transport {
+	return &Transport{listeners: make(map[string]*listener)}
+}
+
+// Dialer returns a dialer connecting from laddr. Options are ignored.
+func (t *Transport) Dialer(laddr ma.Multiaddr, opts ...transport.DialOpt) (transport.Dialer, error) {
+	return &dialer{t: t, laddr: laddr}, nil
+}
+
+// Listen listens on laddr.
+func (t *Transport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := laddr.String()
+	if _, ok := t.listeners[key]; ok {
+		return nil, fmt.Errorf("already listening on %s", laddr)
+	}
+	l := &listener{
+		t:        t,
+		addr:     laddr,
+		incoming: make(chan transport.Conn),
+		closed:   make(chan struct{}),
+	}
+	t.listeners[key] = l
+	return l, nil
+}
+
+// Matches returns true: any address can be listened on and dialed.
+func (t *Transport) Matches(ma.Multiaddr) bool {
+	return true
+}
+
+func (t *Transport) listener(a ma.Multiaddr) *listener {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.listeners[a.String()]
+}
+
+type dialer struct {
+	t     *Transport
+	laddr ma.Multiaddr
+}
+
+func (d *dialer) Dial(raddr ma.Multiaddr) (transport.Conn, error) {
+	return d.DialContext(context.Background(), raddr)
+}
+
+func (d *dialer) DialContext(ctx context.Context, raddr ma.Multiaddr) (transport.Conn, error) {
+	l := d.t.listener(raddr)
+	if l == nil {
+		return nil, fmt.Errorf("connection refused: nothing listens on %s", raddr)
+	}
+
+	local, remote := Pipe(d.laddr, raddr, d.t)
+	select {
+	case l.incoming <- remote:
+		return local, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("connection refused: nothing listens on %s", raddr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (d *dialer) Matches(ma.Multiaddr) bool {
+	return true
+}
+
+type listener struct {
+	t         *Transport
+	addr      ma.Multiaddr
+	incoming  chan transport.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *listener) Accept() (transport.Conn, error) {
+	select {
+	case c := <-l.incoming:
+		return c, nil
+	case <-l.closed:
+		return nil, ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.t.mu.Lock()
+		delete(l.t.listeners, l.addr.String())
+		l.t.mu.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return pipeAddr{l.addr}
+}
+
+func (l *listener) Multiaddr() ma.Multiaddr {
+	return l.addr
+}
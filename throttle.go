@@ -0,0 +1,90 @@
+package conn
+
+import (
+	"context"
+	"time"
+)
+
+// DialThrottle bounds how many transport dials are outstanding at once,
+// so that a burst of dials doesn't run the process out of file
+// descriptors, failing everything with EMFILE. Dials beyond Limit wait
+// their turn, first come, first served, within their own timeouts. A
+// dial holds its slot until the transport conn is established, or
+// fails; secure handshakes don't count.
+//
+// Set it as Dialer.DialThrottle. Sharing one throttle among all the
+// Dialers of the process bounds them together.
+type DialThrottle struct {
+	// Limit is how many dials may be outstanding at once. Zero means
+	// DefaultDialThrottleLimit.
+	Limit int
+
+	q slotQueue
+}
+
+// NewDialThrottle returns a throttle allowing limit outstanding dials.
+func NewDialThrottle(limit int) *DialThrottle {
+	return &DialThrottle{Limit: limit}
+}
+
+// DefaultDialThrottleLimit returns the default Limit of a DialThrottle: a
+// quarter of the file descriptors the process may open, leaving the rest
+// to established conns, listeners and files, or 160 where the limit is
+// unknown.
+func DefaultDialThrottleLimit() int {
+	n, ok := fdLimit()
+	if !ok || n/4 < 1 {
+		return 160
+	}
+	return n / 4
+}
+
+// DialThrottleStats are the queueing metrics of a DialThrottle.
+type DialThrottleStats struct {
+	Limit int
+	// Outstanding and Queued are the dials currently running, and
+	// waiting for a slot.
+	Outstanding int
+	Queued      int
+
+	// Completed is the number of dials run, successful or not, and
+	// Abandoned the number of those whose context was done before they
+	// got a slot.
+	Completed uint64
+	Abandoned uint64
+
+	// TotalWait is the time spent waiting for a slot, over all the dials
+	// that got one, and MaxWait the longest single wait.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// Stats returns the current metrics of the throttle.
+func (t *DialThrottle) Stats() DialThrottleStats {
+	s := t.q.snapshot(t.limit)
+	return DialThrottleStats{
+		Limit:       s.size,
+		Outstanding: s.running,
+		Queued:      s.queued,
+		Completed:   s.completed,
+		Abandoned:   s.abandoned,
+		TotalWait:   s.totalWait,
+		MaxWait:     s.maxWait,
+	}
+}
+
+func (t *DialThrottle) limit() int {
+	if t.Limit > 0 {
+		return t.Limit
+	}
+	return DefaultDialThrottleLimit()
+}
+
+// do runs f once a slot is free, or returns ctx.Err() if ctx is done
+// first. A nil throttle runs f right away.
+func (t *DialThrottle) do(ctx context.Context, f func() error) error {
+	if t == nil {
+		return f()
+	}
+	return t.q.do(ctx, nil, t.limit, 0, f)
+}
@@ -0,0 +1,115 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestDialThrottleBounds(t *testing.T) {
+	th := NewDialThrottle(3)
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			th.do(context.Background(), func() error {
+				mu.Lock()
+				if running++; running > peak {
+					peak = running
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if peak != 3 {
+		t.Fatal("expected at most three dials at once, got ", peak)
+	}
+	if st := th.Stats(); st.Limit != 3 || st.Outstanding != 0 || st.Queued != 0 || st.Completed != 12 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestDialThrottleDefault(t *testing.T) {
+	if DefaultDialThrottleLimit() < 1 {
+		t.Fatal("the default limit should allow dials")
+	}
+	if st := (&DialThrottle{}).Stats(); st.Limit != DefaultDialThrottleLimit() {
+		t.Fatalf("expected the default limit, got %d", st.Limit)
+	}
+}
+
+// blockingDialer is a transport.Dialer whose dials wait for their
+// context, telling started, if it has room, when they start.
+type blockingDialer struct {
+	started chan struct{}
+}
+
+func (d blockingDialer) Dial(raddr ma.Multiaddr) (transport.Conn, error) {
+	return d.DialContext(context.Background(), raddr)
+}
+
+func (d blockingDialer) DialContext(ctx context.Context, raddr ma.Multiaddr) (transport.Conn, error) {
+	select {
+	case d.started <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (d blockingDialer) Matches(ma.Multiaddr) bool {
+	return true
+}
+
+func TestDialerThrottle(t *testing.T) {
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	bd := blockingDialer{started: make(chan struct{}, 2)}
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(bd)
+	d.DialThrottle = NewDialThrottle(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := d.Dial(ctx, p1.Addr, p1.ID)
+			errs <- err
+		}()
+	}
+	<-bd.started
+	time.Sleep(10 * time.Millisecond)
+	if st := d.DialThrottle.Stats(); st.Outstanding != 1 || st.Queued != 1 {
+		t.Fatalf("expected one dial waiting for the other, got %+v", st)
+	}
+	select {
+	case <-bd.started:
+		t.Fatal("the second dial shouldn't have reached the transport")
+	default:
+	}
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, context.Canceled) {
+			t.Fatal("expected the dials to be canceled, got ", err)
+		}
+	}
+}
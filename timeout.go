@@ -0,0 +1,45 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// NoTimeout disables a timeout altogether when used for DialTimeout,
+// AcceptTimeout, Dialer.Timeout or a per-call timeout. This is mostly
+// useful when debugging with breakpoints.
+const NoTimeout time.Duration = -1
+
+// defaultTimeout is used when no timeout is configured at all.
+const defaultTimeout = 60 * time.Second
+
+// ErrInvalidTimeout is returned when a configured timeout is negative
+// but not NoTimeout.
+var ErrInvalidTimeout = errors.New("invalid timeout: must be positive, zero (default) or NoTimeout")
+
+// resolveTimeout returns the first of the given timeouts that is set,
+// from most to least specific, or defaultTimeout if none is. A zero
+// timeout means unset.
+func resolveTimeout(timeouts ...time.Duration) (time.Duration, error) {
+	for _, t := range timeouts {
+		switch {
+		case t == 0:
+			continue
+		case t > 0, t == NoTimeout:
+			return t, nil
+		default:
+			return 0, ErrInvalidTimeout
+		}
+	}
+	return defaultTimeout, nil
+}
+
+// withTimeout is context.WithTimeout, except that NoTimeout only makes
+// the context cancelable.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == NoTimeout {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	tcpt "github.com/libp2p/go-tcp-transport"
+	tu "github.com/libp2p/go-testutil"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func TestResolveTimeout(t *testing.T) {
+	cases := []struct {
+		in  []time.Duration
+		out time.Duration
+		err error
+	}{
+		{nil, defaultTimeout, nil},
+		{[]time.Duration{0, 0}, defaultTimeout, nil},
+		{[]time.Duration{0, time.Second, 2 * time.Second}, time.Second, nil},
+		{[]time.Duration{NoTimeout, time.Second}, NoTimeout, nil},
+		{[]time.Duration{0, -5}, 0, ErrInvalidTimeout},
+	}
+
+	for _, c := range cases {
+		out, err := resolveTimeout(c.in...)
+		if out != c.out || err != c.err {
+			t.Errorf("resolveTimeout(%v) = %s, %v; expected %s, %v", c.in, out, err, c.out, c.err)
+		}
+	}
+}
+
+func TestDialWithTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a listener that never answers the handshake.
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nl.Close()
+	raddr, err := manet.FromNetAddr(nl.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+
+	before := time.Now()
+	if _, err := d.DialWithTimeout(ctx, raddr, p1.ID, 100*time.Millisecond); err == nil {
+		t.Fatal("dial should have timed out")
+	}
+	if took := time.Since(before); took > 5*time.Second {
+		t.Fatal("per-call timeout was not applied, took: ", took)
+	}
+
+	d.Timeout = -5 * time.Second
+	if _, err := d.Dial(ctx, raddr, p1.ID); err != ErrInvalidTimeout {
+		t.Fatal("expected an invalid timeout error, got: ", err)
+	}
+
+	// with no timeout, only the context ends the dial.
+	d.Timeout = NoTimeout
+	dctx, dcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer dcancel()
+	if _, err := d.Dial(dctx, raddr, p1.ID); err == nil {
+		t.Fatal("dial should have been canceled")
+	}
+}
+
+func TestListenInvalidTimeout(t *testing.T) {
+	defer func(old time.Duration) {
+		AcceptTimeout = old
+	}(AcceptTimeout)
+	AcceptTimeout = -5 * time.Second
+
+	p := tu.RandPeerNetParamsOrFatal(t)
+	list, err := tcpt.NewTCPTransport().Listen(p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+
+	if _, err := WrapTransportListener(context.Background(), list, p.ID, p.PrivKey); err != ErrInvalidTimeout {
+		t.Fatal("expected an invalid timeout error, got: ", err)
+	}
+}
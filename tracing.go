@@ -0,0 +1,41 @@
+package conn
+
+import (
+	"context"
+)
+
+// Tracer starts the spans of distributed traces. This package doesn't
+// depend on a tracing library: Tracer is meant to be implemented by a
+// small adapter, e.g. over an OpenTelemetry trace.Tracer, whose Start
+// picks up the trace context carried by ctx and returns a ctx carrying
+// the new span.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// End ends the span, recording err if not nil.
+	End(err error)
+}
+
+// GlobalTracer, if set, traces dials and inbound handshakes, with a span
+// for each: "conn.dial" and "conn.accept". Child spans cover their
+// stages: "transport" (dials only), "protect", "multistream" and "secio",
+// prefixed with the parent's name. Dial spans are children of whatever
+// span the ctx passed to Dial carries, and accept spans of the one
+// carried by the ctx the listener was created with.
+var GlobalTracer Tracer
+
+func noopEndSpan(error) {}
+
+// startSpan starts a span with GlobalTracer, if any. The returned
+// function ends it.
+func startSpan(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, func(error)) {
+	t := GlobalTracer
+	if t == nil {
+		return ctx, noopEndSpan
+	}
+	ctx, span := t.Start(ctx, name, attrs)
+	return ctx, span.End
+}
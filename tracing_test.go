@@ -0,0 +1,79 @@
+package conn
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	tu "github.com/libp2p/go-testutil"
+)
+
+type spanKey struct{}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	ended map[string]string // span name -> parent name
+}
+
+type recordedSpan struct {
+	t            *recordingTracer
+	name, parent string
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(string)
+	return context.WithValue(ctx, spanKey{}, name), &recordedSpan{t, name, parent}
+}
+
+func (s *recordedSpan) End(err error) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.ended[s.name] = s.parent
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{ended: make(map[string]string)}
+	GlobalTracer = tracer
+	defer func() { GlobalTracer = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = context.WithValue(ctx, spanKey{}, "app")
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOneSendRecv(t, c, c)
+	c.Close()
+	time.Sleep(10 * time.Millisecond) // let the accept span end
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	for name, parent := range map[string]string{
+		"conn.dial":               "app",
+		"conn.dial.transport":     "conn.dial",
+		"conn.dial.multistream":   "conn.dial",
+		"conn.dial.secio":         "conn.dial",
+		"conn.accept":             "app",
+		"conn.accept.multistream": "conn.accept",
+		"conn.accept.secio":       "conn.accept",
+	} {
+		if got, ok := tracer.ended[name]; !ok || got != parent {
+			t.Errorf("expected span %s, child of %s, got %q (ended: %t)", name, parent, got, ok)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package conn
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// transcriptSize is how many of the first bytes received during protocol
+// selection are kept, to report failures.
+const transcriptSize = 64
+
+// NegotiationError is a failed protocol selection, on a dialed or an
+// accepted conn. It comes wrapped in an Error of kind ErrProtocolNegotiationFailed.
+type NegotiationError struct {
+	Err error
+
+	// Received holds the first bytes the remote sent, up to 64, which
+	// usually tell what it speaks instead.
+	Received []byte
+}
+
+func (e *NegotiationError) Error() string {
+	if len(e.Received) == 0 {
+		return e.Err.Error() + " (nothing received)"
+	}
+	return fmt.Sprintf("%s (received %s)", e.Err, hex.EncodeToString(e.Received))
+}
+
+func (e *NegotiationError) Unwrap() error {
+	return e.Err
+}
+
+// transcriptConn records the first bytes read from a conn.
+type transcriptConn struct {
+	transport.Conn
+
+	mu       sync.Mutex
+	received []byte
+}
+
+func (c *transcriptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	if room := transcriptSize - len(c.received); room > 0 {
+		if room > n {
+			room = n
+		}
+		c.received = append(c.received, b[:room]...)
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *transcriptConn) transcript() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.received...)
+}
@@ -0,0 +1,71 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func TestTranscriptConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	data := bytes.Repeat([]byte("x"), 2*transcriptSize)
+	go b.Write(data)
+
+	rec := &transcriptConn{Conn: pipeConn{a}}
+	if _, err := io.ReadFull(rec, make([]byte, len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.transcript(); !bytes.Equal(got, data[:transcriptSize]) {
+		t.Fatalf("recorded %q", got)
+	}
+
+	err := &NegotiationError{Err: errors.New("bad"), Received: []byte("hi")}
+	if err.Error() != "bad (received 6869)" {
+		t.Fatalf("unexpected message %q", err)
+	}
+}
+
+func TestNegotiationTranscript(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nl.Close()
+	go func() {
+		c, err := nl.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		c.Close()
+	}()
+	addr, err := manet.FromNetAddr(nl.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	_, err = d.Dial(ctx, addr, "")
+	var ne *NegotiationError
+	if !errors.As(err, &ne) {
+		t.Fatalf("expected a negotiation error, got %v", err)
+	}
+	if !strings.HasPrefix(string(ne.Received), "HTTP/1.1 400") {
+		t.Fatalf("recorded %q", ne.Received)
+	}
+}
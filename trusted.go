@@ -0,0 +1,109 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// TrustedTransportTag is the Security of conns over a trusted transport,
+// or a SecureCapable one.
+const TrustedTransportTag = "/trusted-transport"
+
+// SecureCapable is implemented by the conns of transports that secure
+// and authenticate the connection themselves, like QUIC or TLS based
+// ones. Dialers and listeners detect it, and skip protocol selection and
+// the secio handshake rather than encrypting twice. Both sides of a conn
+// share the transport, so they agree on it.
+type SecureCapable interface {
+	transport.Conn
+
+	// RemotePeer is the peer the transport authenticated.
+	RemotePeer() peer.ID
+	// RemotePublicKey is its key, if the transport knows it.
+	RemotePublicKey() ic.PubKey
+}
+
+// TrustedConn is implemented by the conns of transports that guarantee
+// the identity of the remote peer themselves, like a WireGuard tunnel
+// keyed to it. See Dialer.TrustedTransport.
+type TrustedConn = SecureCapable
+
+// securedByTransport reports whether raw skips selection and secio,
+// because its transport is trusted or SecureCapable.
+func securedByTransport(raw transport.Conn, trusted bool) bool {
+	_, secure := raw.(SecureCapable)
+	return trusted || secure
+}
+
+// ErrUntrustedConn is returned when a trusted transport conn doesn't
+// implement TrustedConn.
+var ErrUntrustedConn = errors.New("transport conn doesn't vouch for the remote peer")
+
+// SecurityInfo is implemented by the conns returned by Dial and Accept.
+type SecurityInfo interface {
+	// Security is the security protocol of the conn: SecioTag,
+	// NoEncryptionTag, PlaintextIdentityTag or TrustedTransportTag.
+	Security() string
+}
+
+func (c *singleConn) Security() string {
+	switch {
+	case c.trusted:
+		return TrustedTransportTag
+	case c.identified:
+		return PlaintextIdentityTag
+	}
+	return NoEncryptionTag
+}
+
+func (c *secureConn) Security() string {
+	return SecioTag
+}
+
+// ListenerTrustedTransport is implemented by listeners that can accept
+// conns from a trusted transport.
+type ListenerTrustedTransport interface {
+	// SetTrustedTransport makes the listener take the identity of the
+	// remote peer from the transport conn, which must implement
+	// TrustedConn, like Dialer.TrustedTransport. It must be called
+	// before any call to Accept.
+	SetTrustedTransport(trusted bool)
+}
+
+func (l *listener) SetTrustedTransport(trusted bool) {
+	l.trusted = trusted
+}
+
+// trustedConn returns a conn over raw, as set up by protection and
+// wrappers into conn, to the peer that raw vouches for. If remote isn't
+// empty, that peer must be remote.
+func trustedConn(ctx context.Context, local, remote peer.ID, raw, conn transport.Conn) (*singleConn, error) {
+	tc, ok := raw.(TrustedConn)
+	if !ok {
+		return nil, ErrUntrustedConn
+	}
+	actual := tc.RemotePeer()
+	if actual == "" {
+		return nil, fmt.Errorf("trusted transport conn from %s has no remote peer", raw.RemoteMultiaddr())
+	}
+	if remote != "" && actual != remote {
+		return nil, &MisdialError{
+			Addr:      raw.RemoteMultiaddr(),
+			Expected:  remote,
+			Actual:    actual,
+			ActualKey: tc.RemotePublicKey(),
+			Seen:      time.Now(),
+		}
+	}
+
+	sc := newSingleConn(ctx, local, actual, conn)
+	sc.trusted = true
+	sc.remoteKey = tc.RemotePublicKey()
+	return sc, nil
+}
@@ -0,0 +1,73 @@
+package conn
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	tu "github.com/libp2p/go-testutil"
+)
+
+// tunnelConn is a pipeConn whose transport vouches for the remote peer.
+type tunnelConn struct {
+	pipeConn
+	remote peer.ID
+	key    ic.PubKey
+}
+
+func (c tunnelConn) RemotePeer() peer.ID        { return c.remote }
+func (c tunnelConn) RemotePublicKey() ic.PubKey { return c.key }
+
+func TestTrustedConn(t *testing.T) {
+	ctx := context.Background()
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if _, err := trustedConn(ctx, p1.ID, p2.ID, pipeConn{a}, pipeConn{a}); err != ErrUntrustedConn {
+		t.Fatalf("expected ErrUntrustedConn, got %v", err)
+	}
+
+	tunnel := tunnelConn{pipeConn{a}, p2.ID, p2.PubKey}
+	if _, err := trustedConn(ctx, p1.ID, p1.ID, tunnel, tunnel); err == nil {
+		t.Fatal("expected a misdial")
+	} else if merr, ok := err.(*MisdialError); !ok || merr.Actual != p2.ID {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	c, err := trustedConn(ctx, p1.ID, "", tunnel, tunnel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.RemotePeer() != p2.ID || !c.RemotePublicKey().Equals(p2.PubKey) {
+		t.Fatal("conn doesn't report the identity of the transport")
+	}
+	if c.Security() != TrustedTransportTag {
+		t.Fatalf("unexpected security %s", c.Security())
+	}
+	if res := c.HandshakeResult(); res.Protocol != TrustedTransportTag || res.RemotePeer != p2.ID {
+		t.Fatalf("unexpected handshake result %+v", res)
+	}
+}
+
+func TestSecuredByTransport(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if securedByTransport(pipeConn{a}, false) {
+		t.Fatal("plain conns need secio")
+	}
+	if !securedByTransport(pipeConn{a}, true) {
+		t.Fatal("trusted transports skip secio")
+	}
+	if !securedByTransport(tunnelConn{pipeConn: pipeConn{a}}, false) {
+		t.Fatal("SecureCapable conns skip secio")
+	}
+}
@@ -0,0 +1,158 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// UnixTransport is a transport over unix domain sockets, for /unix
+// multiaddrs. It lets co-located daemons talk to each other without
+// going through the TCP stack; add its dialer with Dialer.AddDialer and
+// wrap its listeners with WrapTransportListener.
+//
+// The conns are secured like any other: the peers are only on the same
+// host, and nothing vouches for their identity. To skip the secio
+// handshake, set Dialer.SecurityProtocols (and ListenerSecurityProtocols)
+// to PlaintextIdentityTag, which still exchanges and checks keys, or to
+// NoEncryptionTag.
+type UnixTransport struct{}
+
+// NewUnixTransport returns a UnixTransport.
+func NewUnixTransport() *UnixTransport {
+	return &UnixTransport{}
+}
+
+var _ transport.Transport = (*UnixTransport)(nil)
+
+// Dialer returns a dialer for /unix addrs. Unix sockets have no use for
+// a local address, so laddr and opts are ignored.
+func (t *UnixTransport) Dialer(laddr ma.Multiaddr, opts ...transport.DialOpt) (transport.Dialer, error) {
+	return &unixDialer{transport: t}, nil
+}
+
+// Listen listens on the socket path of laddr.
+func (t *UnixTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	path, err := unixPath(laddr)
+	if err != nil {
+		return nil, err
+	}
+	nl, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &unixListener{Listener: nl, addr: laddr, transport: t}, nil
+}
+
+// Matches returns whether a is a /unix addr.
+func (t *UnixTransport) Matches(a ma.Multiaddr) bool {
+	return isUnixAddr(a)
+}
+
+func isUnixAddr(a ma.Multiaddr) bool {
+	ps := a.Protocols()
+	return len(ps) == 1 && ps[0].Code == ma.P_UNIX
+}
+
+// unixPath returns the socket path of a /unix addr.
+func unixPath(a ma.Multiaddr) (string, error) {
+	if !isUnixAddr(a) {
+		return "", fmt.Errorf("not a unix socket addr: %s", a)
+	}
+	return a.ValueForProtocol(ma.P_UNIX)
+}
+
+// unixAddr returns the multiaddr of a socket address, or fallback if
+// the socket is unnamed, like the client end of most conns.
+func unixAddr(a net.Addr, fallback ma.Multiaddr) ma.Multiaddr {
+	if a == nil || a.String() == "" || a.String() == "@" {
+		return fallback
+	}
+	m, err := ma.NewMultiaddr("/unix" + a.String())
+	if err != nil {
+		return fallback
+	}
+	return m
+}
+
+type unixDialer struct {
+	transport *UnixTransport
+}
+
+func (d *unixDialer) Dial(raddr ma.Multiaddr) (transport.Conn, error) {
+	return d.DialContext(context.Background(), raddr)
+}
+
+func (d *unixDialer) DialContext(ctx context.Context, raddr ma.Multiaddr) (transport.Conn, error) {
+	path, err := unixPath(raddr)
+	if err != nil {
+		return nil, err
+	}
+	var nd net.Dialer
+	c, err := nd.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// both ends get the socket path: the dialing end is unnamed.
+	return &unixConn{
+		Conn:      c,
+		laddr:     unixAddr(c.LocalAddr(), raddr),
+		raddr:     raddr,
+		transport: d.transport,
+	}, nil
+}
+
+func (d *unixDialer) Matches(a ma.Multiaddr) bool {
+	return isUnixAddr(a)
+}
+
+// unixListener is a transport.Listener over a unix socket.
+type unixListener struct {
+	net.Listener
+	addr      ma.Multiaddr
+	transport transport.Transport
+}
+
+func (l *unixListener) Accept() (transport.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &unixConn{
+		Conn:      c,
+		laddr:     l.addr,
+		raddr:     unixAddr(c.RemoteAddr(), l.addr),
+		transport: l.transport,
+	}, nil
+}
+
+func (l *unixListener) Multiaddr() ma.Multiaddr {
+	return l.addr
+}
+
+// unixConn is a transport.Conn over a unix socket.
+type unixConn struct {
+	net.Conn
+	laddr, raddr ma.Multiaddr
+	transport    transport.Transport
+}
+
+func (c *unixConn) LocalMultiaddr() ma.Multiaddr {
+	return c.laddr
+}
+
+func (c *unixConn) RemoteMultiaddr() ma.Multiaddr {
+	return c.raddr
+}
+
+func (c *unixConn) Transport() transport.Transport {
+	return c.transport
+}
+
+// NetConn returns the socket, for BufferSizes.
+func (c *unixConn) NetConn() net.Conn {
+	return c.Conn
+}
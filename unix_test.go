@@ -0,0 +1,129 @@
+package conn
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tu "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func unixTestAddr(t *testing.T) (ma.Multiaddr, func()) {
+	dir, err := ioutil.TempDir("", "conn-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ma.NewMultiaddr("/unix" + filepath.Join(dir, "p2p.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return a, func() { os.RemoveAll(dir) }
+}
+
+func TestUnixTransportAddrs(t *testing.T) {
+	addr, cleanup := unixTestAddr(t)
+	defer cleanup()
+
+	tpt := NewUnixTransport()
+	if !tpt.Matches(addr) {
+		t.Fatal("transport should match ", addr)
+	}
+	if tpt.Matches(ma.StringCast("/ip4/127.0.0.1/tcp/1234")) {
+		t.Fatal("transport shouldn't match tcp addrs")
+	}
+
+	tl, err := tpt.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+
+	d, err := tpt.Dialer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, err := d.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := tl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	for _, a := range []ma.Multiaddr{c1.LocalMultiaddr(), c1.RemoteMultiaddr(), c2.LocalMultiaddr(), c2.RemoteMultiaddr()} {
+		if !a.Equal(addr) {
+			t.Fatalf("expected %s, got %s", addr, a)
+		}
+	}
+	if c1.Transport() != tpt || c2.Transport() != tpt {
+		t.Fatal("conns should report the unix transport")
+	}
+}
+
+func TestUnixDialListen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, cleanup := unixTestAddr(t)
+	defer cleanup()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	tpt := NewUnixTransport()
+	tl, err := tpt.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1, err := WrapTransportListener(ctx, tl, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	if err := l1.(ListenerSecurityProtocols).SetSecurityProtocols([]string{PlaintextIdentityTag}); err != nil {
+		t.Fatal(err)
+	}
+	go echoListen(ctx, l1)
+
+	td, err := tpt.Dialer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(td)
+	d.SecurityProtocols = []string{PlaintextIdentityTag}
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if s := c.(SecurityInfo).Security(); s != PlaintextIdentityTag {
+		t.Fatal("expected plaintext identity, got ", s)
+	}
+	if !c.RemoteMultiaddr().Equal(addr) || !c.LocalMultiaddr().Equal(addr) {
+		t.Fatalf("unexpected addrs %s -> %s", c.LocalMultiaddr(), c.RemoteMultiaddr())
+	}
+	if c.RemotePeer() != p1.ID {
+		t.Fatal("dialed the wrong peer: ", c.RemotePeer())
+	}
+
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatal("bad echo: ", string(buf))
+	}
+}
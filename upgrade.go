@@ -0,0 +1,120 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	iconn "github.com/libp2p/go-libp2p-interface-conn"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	peer "github.com/libp2p/go-libp2p-peer"
+	transport "github.com/libp2p/go-libp2p-transport"
+)
+
+// UpgradeOutbound sets up raw, a conn to remote the caller established
+// itself, like Dial sets up the conns it dials: protection, protocol
+// selection and the handshake, with the Dialer's settings and timeout.
+// What only applies to dials, like Filters, the Breaker, dial limits and
+// the Pool, doesn't. Private networks use the current Protector, as a
+// single raw conn can't be retried with the RotatedProtectors.
+//
+// raw is closed if the upgrade fails.
+func (d *Dialer) UpgradeOutbound(ctx context.Context, raw transport.Conn, remote peer.ID) (iconn.Conn, error) {
+	timeout, err := resolveTimeout(d.Timeout, DialTimeout)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := d.checkSelfPeer(remote); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	protecs := d.protectorsFor(remote)
+	if protecs[0] == nil && ipnet.ForcePrivateNetwork {
+		raw.Close()
+		return nil, ErrProtectorRequired
+	}
+	raddr := raw.RemoteMultiaddr()
+	scope, err := reserveConn(d.ResourceManager, false, raddr)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	c, err := d.upgrade(ctx, raw, raddr, remote, protecs[0], scope, dialOpts{protecs: protecs[:1]})
+	if err != nil {
+		return nil, d.misdials.annotate(raddr, remote, err)
+	}
+	watchIdle(c, d.IdleTimeout)
+	return c, nil
+}
+
+// ListenerUpgrade is implemented by listeners that can set up conns
+// accepted by the caller.
+type ListenerUpgrade interface {
+	// UpgradeInbound sets up raw, a conn the caller accepted itself,
+	// like the listener sets up the conns of its transport listener:
+	// the address filters and allowlists, protection, protocol selection
+	// and the handshake, within AcceptTimeout, with the listener's
+	// settings. The conn is returned, rather than queued for Accept.
+	// Conns that aren't libp2p go to the foreign handler, if any, and
+	// fail the upgrade with ErrForeignConn.
+	//
+	// raw is closed if the upgrade fails.
+	UpgradeInbound(ctx context.Context, raw transport.Conn) (transport.Conn, error)
+}
+
+func (l *listener) UpgradeInbound(ctx context.Context, raw transport.Conn) (transport.Conn, error) {
+	accepted := time.Now()
+	raddr := raw.RemoteMultiaddr()
+	if !l.addrAllowed(raddr) {
+		raw.Close()
+		l.history.add("acceptFiltered", "", raddr, nil)
+		return nil, &Error{Kind: ErrAddrFiltered, Err: fmt.Errorf("refusing conn from %s", raddr)}
+	}
+	scope, err := reserveConn(l.resources, true, raddr)
+	if err != nil {
+		raw.Close()
+		l.history.add("acceptRefused", "", raddr, err)
+		return nil, err
+	}
+	id := nextConnID()
+
+	ctx, cancel := withTimeout(ctx, l.acceptTimeout)
+	defer cancel()
+
+	type upgraded struct {
+		c   transport.Conn
+		err error
+	}
+	result := make(chan upgraded, 1)
+	go func() {
+		c, err := l.handshake(ctx, raw, id, scope, accepted)
+		result <- upgraded{c, err}
+	}()
+
+	var r upgraded
+	select {
+	case r = <-result:
+	case <-ctx.Done():
+		// makes the handshake bail.
+		raw.Close()
+		l.history.addConn("acceptTimeout", id, "", raddr, ctx.Err())
+		if r := <-result; r.c != nil {
+			r.c.Close()
+		}
+		return nil, handshakeErr(ctx, ctx.Err())
+	}
+	switch {
+	case r.err != nil:
+		l.history.addConn("accept", id, "", raddr, r.err)
+		return nil, r.err
+	case r.c == nil:
+		return nil, &Error{Kind: ErrForeignConn, Err: fmt.Errorf("conn from %s", raddr)}
+	}
+	watchIdle(r.c, l.idleTimeout)
+	l.history.addConn("accept", id, remotePeer(r.c), raddr, nil)
+	return r.c, nil
+}
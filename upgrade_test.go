@@ -0,0 +1,120 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	tcpt "github.com/libp2p/go-tcp-transport"
+	tu "github.com/libp2p/go-testutil"
+)
+
+func TestUpgrade(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+	p3 := tu.RandPeerNetParamsOrFatal(t)
+
+	// the raw conns come from a transport listener of our own, the
+	// listener only upgrades them.
+	raw, err := tcpt.NewTCPTransport().Listen(p1.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	l1, err := Listen(ctx, p3.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	type upgraded struct {
+		c   transport.Conn
+		err error
+	}
+	inbound := make(chan upgraded, 1)
+	go func() {
+		c, err := raw.Accept()
+		if err == nil {
+			c, err = l1.(ListenerUpgrade).UpgradeInbound(ctx, c)
+		}
+		inbound <- upgraded{c, err}
+	}()
+
+	rc, err := dialer(t, p2.Addr).Dial(raw.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	c, err := d.UpgradeOutbound(ctx, rc, p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	in := <-inbound
+	if in.err != nil {
+		t.Fatal(in.err)
+	}
+	defer in.c.Close()
+
+	if c.RemotePeer() != p1.ID || remotePeer(in.c) != p2.ID {
+		t.Fatalf("unexpected peers %s, %s", c.RemotePeer(), remotePeer(in.c))
+	}
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(in.c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatal("bad read: ", string(buf))
+	}
+
+	// upgrading to the wrong peer fails.
+	go func() {
+		c, err := raw.Accept()
+		if err == nil {
+			c, err = l1.(ListenerUpgrade).UpgradeInbound(ctx, c)
+		}
+		inbound <- upgraded{c, err}
+	}()
+	rc, err = dialer(t, p2.Addr).Dial(raw.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.UpgradeOutbound(ctx, rc, p3.ID); err == nil {
+		t.Fatal("expected the upgrade to the wrong peer to fail")
+	}
+	if in := <-inbound; in.c != nil {
+		in.c.Close()
+	}
+
+	// conns that aren't libp2p go to the foreign handler.
+	l1.(ListenerPortSharing).SetForeignHandler(func(c net.Conn) {
+		c.Close()
+	})
+	go func() {
+		c, err := raw.Accept()
+		if err == nil {
+			c, err = l1.(ListenerUpgrade).UpgradeInbound(ctx, c)
+		}
+		inbound <- upgraded{c, err}
+	}()
+	foreign, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer foreign.Close()
+	if _, err := foreign.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if in := <-inbound; !errors.Is(in.err, ErrForeignConn) {
+		t.Fatal("expected the foreign conn to fail the upgrade, got ", in.err)
+	}
+}
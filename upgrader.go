@@ -0,0 +1,540 @@
+package conn
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	ci "github.com/libp2p/go-libp2p-crypto"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	lgbl "github.com/libp2p/go-libp2p-loggables"
+	peer "github.com/libp2p/go-libp2p-peer"
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// UpgradeStage is a custom stage of connection setup, like checking an
+// auth token or recording telemetry. See Upgrader.
+type UpgradeStage interface {
+	// Stage names the stage, in errors, audit records and
+	// HandshakeErrors. It must not be one of the built-in stages.
+	Stage() Stage
+
+	// Upgrade runs the stage on c, and returns the conn the stages after
+	// it, and eventually the caller, get: c, or a wrapper of it. inbound
+	// is set on accepted conns. c is closed if it fails.
+	Upgrade(ctx context.Context, c transport.Conn, inbound bool) (transport.Conn, error)
+}
+
+// NewUpgradeStage returns the UpgradeStage named name that runs f.
+func NewUpgradeStage(name Stage, f func(ctx context.Context, c transport.Conn, inbound bool) (transport.Conn, error)) UpgradeStage {
+	return funcStage{name, f}
+}
+
+type funcStage struct {
+	name Stage
+	f    func(context.Context, transport.Conn, bool) (transport.Conn, error)
+}
+
+func (s funcStage) Stage() Stage { return s.name }
+
+func (s funcStage) Upgrade(ctx context.Context, c transport.Conn, inbound bool) (transport.Conn, error) {
+	return s.f(ctx, c, inbound)
+}
+
+// Upgrader runs connection setup for Dialers and listeners: the built-in
+// stages, protection, security protocol selection and the handshake, and
+// the custom stages it holds among them. Stages inserted after
+// StageProtect get the raw conn once protected and wrapped, before the
+// multistream header is exchanged, and the ones after StageSecure the conn
+// once secured, before it is returned, with its remote known. On dials,
+// those must return conns that still implement iconn.Conn.
+//
+// An Upgrader is constructed once, and shared by Dialers, as
+// Dialer.Upgrader, and listeners, with ListenerUpgrader. Those without
+// one run the built-in stages only. It is safe for concurrent use: custom
+// stages may be inserted, swapped and removed at any time, conns run the
+// ones present when they get to them. The built-in stages can't be
+// swapped or removed: they are configured on the Dialer or listener.
+type Upgrader struct {
+	mu     sync.RWMutex
+	stages map[Stage][]UpgradeStage // by the built-in stage they follow
+}
+
+// NewUpgrader returns an Upgrader with no custom stages.
+func NewUpgrader() *Upgrader {
+	return &Upgrader{stages: make(map[Stage][]UpgradeStage)}
+}
+
+// Insert adds s after the built-in stage after, StageProtect or
+// StageSecure, and the stages inserted there before it.
+func (u *Upgrader) Insert(after Stage, s UpgradeStage) error {
+	if after != StageProtect && after != StageSecure {
+		return fmt.Errorf("can't insert stages after the %s stage", after)
+	}
+	if isBuiltinStage(s.Stage()) {
+		return fmt.Errorf("%s is a built-in stage", s.Stage())
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, _, ok := u.find(s.Stage()); ok {
+		return fmt.Errorf("there is a %s stage already", s.Stage())
+	}
+	u.stages[after] = append(u.stages[after], s)
+	return nil
+}
+
+// Swap replaces the custom stage named like s with s, in place.
+func (u *Upgrader) Swap(s UpgradeStage) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	after, i, ok := u.find(s.Stage())
+	if !ok {
+		return fmt.Errorf("no %s stage", s.Stage())
+	}
+	stages := append([]UpgradeStage(nil), u.stages[after]...)
+	stages[i] = s
+	u.stages[after] = stages
+	return nil
+}
+
+// Remove removes the custom stage named name, and tells whether there
+// was one.
+func (u *Upgrader) Remove(name Stage) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	after, i, ok := u.find(name)
+	if !ok {
+		return false
+	}
+	stages := u.stages[after]
+	u.stages[after] = append(stages[:i:i], stages[i+1:]...)
+	return true
+}
+
+// Stages returns the names of the stages conns go through, built-in ones
+// included, in order, as of now.
+func (u *Upgrader) Stages() []Stage {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	var names []Stage
+	for _, builtin := range []Stage{StageProtect, StagePreamble, StageSelect, StageSecure} {
+		names = append(names, builtin)
+		for _, s := range u.stages[builtin] {
+			names = append(names, s.Stage())
+		}
+	}
+	return names
+}
+
+// find returns the built-in stage the stage named name follows, and its
+// index there. mu must be held.
+func (u *Upgrader) find(name Stage) (Stage, int, bool) {
+	for after, stages := range u.stages {
+		for i, s := range stages {
+			if s.Stage() == name {
+				return after, i, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+func isBuiltinStage(s Stage) bool {
+	switch s {
+	case StagePreamble, StageProtect, StageSelect, StageSecure:
+		return true
+	}
+	return false
+}
+
+// run runs the stages following the built-in stage after on c, setting
+// at to the one running. c is closed if one fails. A nil Upgrader has no
+// stages.
+func (u *Upgrader) run(ctx context.Context, after Stage, c transport.Conn, inbound bool, at *Stage) (transport.Conn, error) {
+	if u == nil {
+		return c, nil
+	}
+	u.mu.RLock()
+	stages := u.stages[after]
+	u.mu.RUnlock()
+
+	for _, s := range stages {
+		*at = s.Stage()
+		next, err := s.Upgrade(ctx, c, inbound)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%s stage: %w", s.Stage(), err)
+		}
+		c = next
+	}
+	return c, nil
+}
+
+// ListenerUpgrader is implemented by listeners that can run custom
+// stages of connection setup.
+type ListenerUpgrader interface {
+	// SetUpgrader makes the listener run the stages of u on the conns it
+	// accepts. u may be shared with Dialers and other listeners. It must
+	// be called before any call to Accept.
+	SetUpgrader(u *Upgrader)
+}
+
+func (l *listener) SetUpgrader(u *Upgrader) {
+	l.upgrader = u
+}
+
+// upgradeParams is how a Dialer or listener sets up a conn: its settings,
+// and what it knows of the conn.
+type upgradeParams struct {
+	// inbound is set on accepted conns, and responder on dialed ones
+	// answering protocol selection, see DialSimOpen.
+	inbound   bool
+	responder bool
+
+	local  peer.ID
+	sk     ci.PrivKey
+	remote peer.ID      // expected, if known
+	raddr  ma.Multiaddr // dialed or accepted from
+
+	// protecs are the private network protectors the conn may be
+	// protected with, none outside of private networks. protect protects
+	// it with one of them, and returns which, and whether fingerprints
+	// were exchanged, which fingerprint is then set to.
+	protecs     []ipnet.Protector
+	protect     func(transport.Conn) (transport.Conn, ipnet.Protector, bool, error)
+	fingerprint bool
+	wrapper     ConnWrapper
+	trusted     bool
+
+	// protos are the security protocols dialed conns propose, and mux
+	// the ones conns answering selection accept.
+	protos     []string
+	mux        *msmux.MultistreamMuxer
+	optimistic bool
+
+	stages          stageTimeouts
+	preambleTimeout time.Duration
+	replays         *replayCache
+	hsPool          *HandshakePool
+	// closing, if set, stops waiting for a worker of hsPool once closed.
+	closing <-chan struct{}
+
+	scope    ResourceScope
+	accepted time.Time
+
+	maxMsg           int
+	buffers          *BufferSizes
+	limiter          *WriteLimiter
+	msgLimiter       *MessageLimiter
+	reporter         BandwidthReporter
+	messageMode      bool
+	batching         *WriteBatching
+	compression      []Compression
+	exchangeObserved bool
+	channelBinding   bool
+
+	logger Logger
+	span   string // prefix of the tracing spans
+
+	// vet checks the remote peer the security protocol proto proved, with
+	// its key if proto has one, or the expected one of insecure conns.
+	vet func(remote peer.ID, key ci.PubKey, proto string) error
+	// selected, if set, is told the security protocol selected.
+	selected func(proto string)
+	// garbage, if set, is told about inbound conns that don't open with
+	// multistream.
+	garbage func(ctx context.Context, conn transport.Conn, err *garbageError)
+}
+
+// initiates tells whether the conn starts the exchanges following the
+// handshake, as the dialing side does.
+func (p *upgradeParams) initiates() bool {
+	return !p.inbound && !p.responder
+}
+
+// configure applies the settings of p to sc, protected with protector.
+func (p *upgradeParams) configure(sc *singleConn, protector ipnet.Protector) {
+	sc.scope = p.scope
+	sc.accepted = p.accepted
+	sc.protector = protector
+	sc.msgFramer.max = p.maxMsg
+	sc.writeChunk = p.buffers.writeChunk()
+	if p.limiter != nil {
+		sc.setWriteLimiter(p.limiter, sc.remote)
+	}
+}
+
+// upgrade sets up raw as p says, going through the built-in stages,
+// protection, the multistream preamble of inbound conns, security
+// protocol selection and the handshake, and the custom stages among
+// them, after protection and the handshake. Conns of trusted and
+// SecureCapable transports skip selection and the handshake. at is set
+// to the stage running. raw is closed if it fails. A nil Upgrader runs
+// the built-in stages only.
+func (u *Upgrader) upgrade(ctx context.Context, raw transport.Conn, p *upgradeParams, at *Stage) (c transport.Conn, err error) {
+	defer func() {
+		if err != nil {
+			raw.Close()
+		}
+	}()
+	lg := p.logger
+	trusted := securedByTransport(raw, p.trusted)
+	protected := len(p.protecs) > 0
+
+	conn := raw
+	var opening *transcriptConn // the raw opening bytes of protected inbound conns
+	var protector ipnet.Protector
+	if protected {
+		*at = StageProtect
+		_, endSpan := startSpan(ctx, p.span+".protect", nil)
+		stage := p.stages.start(StageProtect, raw)
+		if p.inbound && !trusted {
+			opening = &transcriptConn{Conn: conn}
+			conn = opening
+		}
+		pc, protec, fingerprinted, err := p.protect(conn)
+		err = stage.end(err)
+		endSpan(err)
+		if err != nil {
+			if opening != nil && p.garbage != nil {
+				p.garbage(ctx, raw, &garbageError{class: classifyGarbage(opening.transcript()), err: err})
+			}
+			lg.Warningf("protector failed: %s", err)
+			return nil, err
+		}
+		conn = pc
+		protector = protec
+		p.fingerprint = fingerprinted
+	}
+
+	if p.wrapper != nil {
+		conn = p.wrapper(conn)
+	}
+	conn, err = u.run(ctx, StageProtect, conn, p.inbound, at)
+	if err != nil {
+		return nil, err
+	}
+
+	if trusted {
+		return u.upgradeTrusted(ctx, raw, conn, p, protector, at)
+	}
+
+	if p.inbound {
+		*at = StagePreamble
+		hc, err := sendHeader(conn)
+		if err != nil {
+			lg.Debugf("incoming conn: failed to send the multistream header: %s", err)
+			return nil, &Error{Kind: ErrProtocolNegotiationFailed, Err: err}
+		}
+		pc, err := readPreamble(hc, p.preambleTimeout)
+		if err != nil {
+			if ge, ok := err.(*garbageError); ok && p.garbage != nil {
+				// the decrypted opening bytes show garbage as soon as
+				// they don't match the header, but only the raw ones
+				// tell what it is.
+				if opening != nil && ge.class != GarbageSilent {
+					ge.class = classifyGarbage(opening.transcript())
+				}
+				p.garbage(ctx, raw, ge)
+			}
+			lg.Debugf("incoming conn: %s", err)
+			return nil, &Error{Kind: ErrProtocolNegotiationFailed, Err: err}
+		}
+		conn = pc
+	}
+
+	var optimistic *optimisticConn
+	if p.optimistic {
+		optimistic = newOptimisticConn(conn, p.protos[0])
+		conn = optimistic
+	}
+
+	*at = StageSelect
+	_, endSpan := startSpan(ctx, p.span+".multistream", map[string]interface{}{
+		"protocols":  p.protos,
+		"optimistic": optimistic != nil,
+	})
+	type selection struct {
+		proto string
+		err   error
+	}
+	selectResult := make(chan selection, 1)
+	rec := &transcriptConn{Conn: conn}
+	stage := p.stages.start(StageSelect, conn)
+	selectStart := time.Now()
+	go func() {
+		switch {
+		case optimistic != nil:
+			selectResult <- selection{proto: optimistic.proto}
+		case p.mux != nil:
+			proto, _, err := p.mux.Negotiate(rec)
+			selectResult <- selection{proto, err}
+		case len(p.protos) == 1:
+			selectResult <- selection{p.protos[0], msmux.SelectProtoOrFail(p.protos[0], rec)}
+		default:
+			proto, err := msmux.SelectOneOf(p.protos, rec)
+			selectResult <- selection{proto, err}
+		}
+	}()
+	var proto string
+	var selectRTT time.Duration
+	select {
+	case <-ctx.Done():
+		err = handshakeErr(ctx, ctx.Err())
+	case sel := <-selectResult:
+		selectRTT = time.Since(selectStart)
+		proto, err = sel.proto, stage.end(sel.err)
+		if _, timedOut := err.(*StageTimeoutError); err != nil && !timedOut {
+			received := rec.transcript()
+			if !p.inbound {
+				ld := lgbl.Dial("conn", p.local, p.remote, nil, p.raddr)
+				ld["received"] = hex.Dump(received)
+				log.Event(ctx, "connNegotiationFailed", ld)
+			}
+			err = &Error{Kind: ErrProtocolNegotiationFailed, Err: &NegotiationError{Err: err, Received: received}}
+		}
+	}
+	endSpan(err)
+	if err != nil {
+		lg.Debugf("negotiation of the security protocol failed: %s", err)
+		return nil, err
+	}
+	if p.selected != nil {
+		p.selected(proto)
+	}
+
+	secure := proto == SecioTag
+	if secure && p.replays != nil {
+		conn = &replayGuard{Conn: conn, cache: p.replays}
+	}
+	sc := newSingleConn(ctx, p.local, p.remote, conn)
+	p.configure(sc, protector)
+	sc.negotiated = negotiated{security: proto, preamble: preambleOf(true, protected, p.fingerprint)}
+	if optimistic == nil && p.initiates() {
+		// a selection takes a single round trip.
+		sc.AddRTTSample(selectRTT)
+	}
+	sc.passthrough = !protected && p.wrapper == nil && optimistic == nil
+
+	if proto == PlaintextIdentityTag {
+		*at = StageSecure
+		if err := exchangeIdentity(ctx, sc, p.sk); err != nil {
+			sc.Close()
+			return nil, err
+		}
+	}
+	if !secure {
+		if err := p.vet(sc.RemotePeer(), nil, proto); err != nil {
+			sc.Close()
+			return nil, err
+		}
+		if p.limiter != nil {
+			sc.setLimitKey(sc.RemotePeer())
+		}
+		if p.inbound {
+			lg.Warningf("accepted a conn from %s INSECURELY!", p.raddr)
+		} else {
+			lg.Warningf("dialing INSECURELY %s at %s!", p.remote, p.raddr)
+		}
+		if p.exchangeObserved {
+			if err := exchangeObserved(ctx, sc, &sc.observed); err != nil {
+				sc.Close()
+				return nil, err
+			}
+		}
+		sc.reporter = p.reporter
+		return u.run(ctx, StageSecure, sc, p.inbound, at)
+	}
+
+	*at = StageSecure
+	sctx, endSpan := startSpan(ctx, p.span+".secio", nil)
+	var ssc *secureConn
+	err = p.hsPool.do(sctx, p.closing, func() error {
+		stage := p.stages.start(StageSecure, conn)
+		secureStart := time.Now()
+		c, err := newSecureConnLimited(sctx, p.sk, sc, resolveMaxMessageSize(p.maxMsg))
+		if err = stage.end(err); err == nil {
+			sc.AddRTTSample(time.Since(secureStart) / secioRoundTrips)
+		}
+		ssc = c
+		return err
+	})
+	if err != nil {
+		sc.Close()
+		if optimistic != nil && optimistic.negotiationErr() != nil {
+			// the listener refused the selection.
+			err = optimistic.negotiationErr()
+		}
+		err = handshakeErr(ctx, err)
+		endSpan(err)
+		lg.Infof("failed to secure conn %s: %s", sc, err)
+		return nil, err
+	}
+	endSpan(nil)
+
+	if err := p.vet(ssc.RemotePeer(), ssc.RemotePublicKey(), SecioTag); err != nil {
+		ssc.Close()
+		return nil, err
+	}
+	if p.limiter != nil {
+		sc.setLimitKey(ssc.RemotePeer())
+	}
+	if p.channelBinding {
+		if err := exchangeBinding(ctx, ssc, p.initiates()); err != nil {
+			ssc.Close()
+			return nil, err
+		}
+	}
+	if p.exchangeObserved {
+		if err := exchangeObserved(ctx, ssc, &sc.observed); err != nil {
+			ssc.Close()
+			return nil, err
+		}
+	}
+	if len(p.compression) > 0 {
+		if err := negotiateCompression(ssc, compressionFor(p.compression, p.messageMode), p.initiates()); err != nil {
+			ssc.Close()
+			return nil, err
+		}
+	}
+	ssc.messageMode = p.messageMode
+	ssc.writeChunk = p.buffers.writeChunk()
+	ssc.setWriteBatching(p.batching)
+	ssc.reporter = p.reporter
+	if p.msgLimiter != nil {
+		ssc.setMessageLimiter(p.msgLimiter)
+	}
+	return u.run(ctx, StageSecure, ssc, p.inbound, at)
+}
+
+// upgradeTrusted finishes the setup of conn, raw once protected and
+// wrapped, for a trusted or SecureCapable transport: the remote is the
+// one raw vouches for.
+func (u *Upgrader) upgradeTrusted(ctx context.Context, raw, conn transport.Conn, p *upgradeParams, protector ipnet.Protector, at *Stage) (transport.Conn, error) {
+	*at = StageSecure
+	sc, err := trustedConn(ctx, p.local, p.remote, raw, conn)
+	if err != nil {
+		p.logger.Debugf("trusted conn: %s", err)
+		return nil, err
+	}
+	p.configure(sc, protector)
+	if err := p.vet(sc.remote, sc.remoteKey, TrustedTransportTag); err != nil {
+		sc.Close()
+		return nil, err
+	}
+	sc.preamble = preambleOf(false, len(p.protecs) > 0, p.fingerprint)
+	sc.passthrough = len(p.protecs) == 0 && p.wrapper == nil
+	if p.exchangeObserved {
+		if err := exchangeObserved(ctx, sc, &sc.observed); err != nil {
+			sc.Close()
+			return nil, err
+		}
+	}
+	sc.reporter = p.reporter
+	return u.run(ctx, StageSecure, sc, p.inbound, at)
+}
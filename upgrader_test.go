@@ -0,0 +1,170 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	transport "github.com/libp2p/go-libp2p-transport"
+	tu "github.com/libp2p/go-testutil"
+)
+
+func recordingStage(name Stage, ran *[]Stage, err error) UpgradeStage {
+	return NewUpgradeStage(name, func(ctx context.Context, c transport.Conn, inbound bool) (transport.Conn, error) {
+		*ran = append(*ran, name)
+		return c, err
+	})
+}
+
+func TestUpgraderStages(t *testing.T) {
+	var ran []Stage
+	u := NewUpgrader()
+	for _, s := range []struct {
+		after Stage
+		name  Stage
+	}{{StageProtect, "token"}, {StageSecure, "telemetry"}, {StageProtect, "check"}} {
+		if err := u.Insert(s.after, recordingStage(s.name, &ran, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := u.Insert(StageSelect, recordingStage("other", &ran, nil)); err == nil {
+		t.Fatal("stages can't be inserted in the middle of protocol selection")
+	}
+	if err := u.Insert(StageProtect, recordingStage(StageSecure, &ran, nil)); err == nil {
+		t.Fatal("built-in stages can't be inserted")
+	}
+	if err := u.Insert(StageSecure, recordingStage("token", &ran, nil)); err == nil {
+		t.Fatal("stage names should be unique")
+	}
+
+	want := []Stage{StageProtect, "token", "check", StagePreamble, StageSelect, StageSecure, "telemetry"}
+	if got := u.Stages(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected stages %v, got %v", want, got)
+	}
+
+	failed := errors.New("bad token")
+	if err := u.Swap(recordingStage("token", &ran, failed)); err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	defer b.Close()
+	var at Stage
+	if _, err := u.run(context.Background(), StageProtect, pipeConn{a}, true, &at); !errors.Is(err, failed) || at != "token" {
+		t.Fatalf("expected the token stage to fail, got %v at %s", err, at)
+	}
+	if !reflect.DeepEqual(ran, []Stage{"token"}) {
+		t.Fatal("stages after a failed one shouldn't run, ran ", ran)
+	}
+
+	if !u.Remove("token") || u.Remove("token") {
+		t.Fatal("a stage should be removed once")
+	}
+	ran = nil
+	if _, err := u.run(context.Background(), StageProtect, pipeConn{b}, true, &at); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ran, []Stage{"check"}) {
+		t.Fatal("unexpected stages ", ran)
+	}
+
+	// nil Upgraders have no stages.
+	var none *Upgrader
+	if c, err := none.run(context.Background(), StageSecure, pipeConn{b}, false, &at); err != nil || c == nil {
+		t.Fatal(err)
+	}
+}
+
+// tokenStage exchanges a token in the clear, and fails if the remote's
+// isn't the same.
+func tokenStage(token string) UpgradeStage {
+	return NewUpgradeStage("token", func(ctx context.Context, c transport.Conn, inbound bool) (transport.Conn, error) {
+		go c.Write([]byte(token))
+		buf := make([]byte, len(token))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return nil, err
+		}
+		if string(buf) != token {
+			return nil, errors.New("wrong token")
+		}
+		return c, nil
+	})
+}
+
+func TestDialUpgrader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	u := NewUpgrader()
+	if err := u.Insert(StageProtect, tokenStage("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	l1, err := Listen(ctx, p1.Addr, p1.ID, p1.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	l1.(ListenerUpgrader).SetUpgrader(u)
+	go echoListen(ctx, l1)
+
+	d := NewDialer(p2.ID, p2.PrivKey, nil)
+	d.AddDialer(dialer(t, p2.Addr))
+	d.Upgrader = u
+	c, err := d.Dial(ctx, l1.Multiaddr(), p1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	other := NewUpgrader()
+	other.Insert(StageProtect, tokenStage("public"))
+	d.Upgrader = other
+	if _, err := d.Dial(ctx, l1.Multiaddr(), p1.ID); err == nil {
+		t.Fatal("dials with the wrong token should fail")
+	}
+}
+
+// Accepted conns over trusted transports go through the same stages as
+// the others, with the listener's identity.
+func TestUpgraderTrusted(t *testing.T) {
+	p1 := tu.RandPeerNetParamsOrFatal(t)
+	p2 := tu.RandPeerNetParamsOrFatal(t)
+
+	var ran []Stage
+	u := NewUpgrader()
+	if err := u.Insert(StageSecure, recordingStage("check", &ran, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	p := &upgradeParams{
+		inbound: true,
+		local:   p1.ID,
+		sk:      p1.PrivKey,
+		trusted: true,
+		vet:     func(peer.ID, ic.PubKey, string) error { return nil },
+		logger:  log,
+	}
+	var at Stage
+	c, err := u.upgrade(context.Background(), tunnelConn{pipeConn{b}, p2.ID, p2.PubKey}, p, &at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sc := c.(*singleConn)
+	if sc.LocalPeer() != p1.ID || sc.RemotePeer() != p2.ID {
+		t.Fatalf("unexpected peers %s and %s", sc.LocalPeer(), sc.RemotePeer())
+	}
+	if !reflect.DeepEqual(ran, []Stage{"check"}) || at != "check" {
+		t.Fatalf("expected the check stage to run, ran %v, at %s", ran, at)
+	}
+}